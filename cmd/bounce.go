@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -120,6 +121,16 @@ func (a *App) BlocklistBouncedSubscribers(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// GetBounceMailboxes returns the scan statuses and counters of the bounce mailboxes.
+func (a *App) GetBounceMailboxes(c echo.Context) error {
+	// If bounce processing is disabled, no mailboxes are scanned.
+	if a.bounce == nil {
+		return c.JSON(http.StatusOK, okResp{[]bounce.MailboxStatus{}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{a.bounce.Mailboxes()})
+}
+
 // BounceWebhook handles incoming bounce webhook notifications from various providers.
 func (a *App) BounceWebhook(c echo.Context) error {
 	// If bounce processing is disabled, a.bounce will be nil.
@@ -273,6 +284,15 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		}
 		bounces = append(bounces, bs...)
 
+	// Declaratively configured webhooks.
+	case a.bounce.Generic[service] != nil:
+		bs, err := a.bounce.Generic[service].ProcessBounce(c.Request(), rawReq)
+		if err != nil {
+			a.log.Printf("error processing %s notification: %v", service, err)
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+		}
+		bounces = append(bounces, bs...)
+
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("bounces.unknownService"))
 	}
@@ -11,8 +11,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	txttpl "text/template"
 	"time"
 
+	"github.com/gdgvda/cron"
 	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
@@ -32,6 +34,9 @@ type campReq struct {
 	// to the outside world.
 	ListIDs []int `json:"lists"`
 
+	// Similarly, this overrides Campaign.Segments.
+	SegmentIDs []int `json:"segments"`
+
 	MediaIDs []int `json:"media"`
 
 	// This is only relevant to campaign test requests.
@@ -257,9 +262,14 @@ func (a *App) CreateCampaign(c echo.Context) error {
 		return err
 	}
 
-	// Filter lists against the current user's permitted lists.
+	// Filter lists and segments against the current user's permitted lists.
 	user := auth.GetUser(c)
 	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+	segIDs, err := a.filterSegmentsByPerm(user, o.SegmentIDs)
+	if err != nil {
+		return err
+	}
+	o.SegmentIDs = segIDs
 
 	// If the campaign's 'opt-in', prepare a default message.
 	switch o.Type {
@@ -288,7 +298,7 @@ func (a *App) CreateCampaign(c echo.Context) error {
 		o.ArchiveTemplateID = o.TemplateID
 	}
 
-	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.SegmentIDs, o.MediaIDs)
 	if err != nil {
 		return err
 	}
@@ -325,14 +335,19 @@ func (a *App) UpdateCampaign(c echo.Context) error {
 	// Read the incoming params into the existing campaign fields from the DB.
 	// This allows updating of values that have been sent whereas fields
 	// that are not in the request retain the old values.
-	o := campReq{Campaign: cm}
+	o := campReq{Campaign: cm, SegmentIDs: getCampaignSegmentIDs(cm)}
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
 
-	// Filter lists against the current user's permitted lists.
+	// Filter lists and segments against the current user's permitted lists.
 	user := auth.GetUser(c)
 	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+	segIDs, err := a.filterSegmentsByPerm(user, o.SegmentIDs)
+	if err != nil {
+		return err
+	}
+	o.SegmentIDs = segIDs
 
 	if c, err := a.validateCampaignFields(o); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -340,7 +355,7 @@ func (a *App) UpdateCampaign(c echo.Context) error {
 		o = c
 	}
 
-	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
+	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.SegmentIDs, o.MediaIDs)
 	if err != nil {
 		return err
 	}
@@ -413,6 +428,150 @@ func (a *App) UpdateCampaignArchive(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{req})
 }
 
+// GetCampaignVariants handles the retrieval of a campaign's A/B test variants.
+func (a *App) GetCampaignVariants(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := a.core.GetCampaignVariants(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateCampaignVariants handles the modification of a campaign's A/B test options and variants.
+// Setting the sample size to 0 disables the test.
+func (a *App) UpdateCampaignVariants(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	// Retrieve the campaign from the DB.
+	cm, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
+
+	// Variants can't be changed once the sample has started going out.
+	if cm.VariantStatus != models.CampaignVariantStatusNone {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.variantTestStarted"))
+	}
+
+	req := struct {
+		Sample   int                      `json:"variant_sample"`
+		Window   string                   `json:"variant_window"`
+		Metric   string                   `json:"variant_metric"`
+		Variants []models.CampaignVariant `json:"variants"`
+	}{
+		Window: cm.VariantWindow,
+		Metric: cm.VariantMetric,
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := a.validateCampaignVariants(req.Sample, req.Window, req.Metric, req.Variants); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	out, err := a.core.UpdateCampaignVariants(id, req.Sample, req.Window, req.Metric, req.Variants)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignDeliveries handles the retrieval of a campaign's delivery log.
+func (a *App) GetCampaignDeliveries(c echo.Context) error {
+	var (
+		id     = getID(c)
+		status = c.FormValue("status")
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	switch status {
+	case "", models.CampaignDeliveryQueued, models.CampaignDeliverySent,
+		models.CampaignDeliveryFailed, models.CampaignDeliveryDeferred:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "status"))
+	}
+
+	res, total, err := a.core.QueryCampaignDeliveries(id, status, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ResendCampaignDeliveries handles re-sending a campaign's failed messages.
+func (a *App) ResendCampaignDeliveries(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	// Retrieve the campaign from the DB.
+	cm, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	// Messages can only be re-sent once the campaign has stopped.
+	if cm.Status != models.CampaignStatusFinished &&
+		cm.Status != models.CampaignStatusPaused &&
+		cm.Status != models.CampaignStatusCancelled {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantResend"))
+	}
+
+	subs, err := a.core.GetCampaignDeliverySubscribers(id, models.CampaignDeliveryFailed)
+	if err != nil {
+		return err
+	}
+
+	if len(subs) > 0 {
+		if err := a.manager.ResendCampaign(id, subs); err != nil {
+			a.log.Printf("error re-sending campaign: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("campaigns.errorResend", "error", err.Error()))
+		}
+	}
+
+	out := struct {
+		Count int `json:"count"`
+	}{len(subs)}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // DeleteCampaign handles campaign deletion.
 // Only scheduled campaigns that have not started yet can be deleted.
 func (a *App) DeleteCampaign(c echo.Context) error {
@@ -708,17 +867,72 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		c.BodySource.Valid = false
 	}
 
-	// If there's a "send_at" date, it should be in the future.
-	if c.SendAt.Valid {
+	// If there's a "send_at" date, it should be in the future. For recurring
+	// campaigns, it's only the start of the recurrence.
+	if c.SendAt.Valid && strings.TrimSpace(c.Recurrence) == "" {
 		if c.SendAt.Time.Before(time.Now()) {
 			return c, errors.New(a.i18n.T("campaigns.fieldInvalidSendAt"))
 		}
 	}
 
-	if len(c.ListIDs) == 0 {
+	if len(c.ListIDs) == 0 && len(c.SegmentIDs) == 0 {
 		return c, errors.New(a.i18n.T("campaigns.fieldInvalidListIDs"))
 	}
 
+	// Opt-in campaigns are sent to unconfirmed subscribers of lists, which segments don't have.
+	if c.Type == models.CampaignTypeOptin && len(c.SegmentIDs) > 0 {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidOptinSegments"))
+	}
+
+	// Validate the delivery mode.
+	if c.DeliveryMode != models.CampaignDeliveryImmediate &&
+		c.DeliveryMode != models.CampaignDeliveryOptimized &&
+		c.DeliveryMode != models.CampaignDeliveryLocalTime {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidDeliveryMode"))
+	}
+	if c.DeliveryWindow == "" {
+		c.DeliveryWindow = "24h"
+	}
+	if d, err := time.ParseDuration(c.DeliveryWindow); err != nil || d < time.Hour {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidDeliveryWindow"))
+	}
+	if c.DeliveryTime == "" {
+		c.DeliveryTime = "09:00"
+	}
+	if _, err := time.Parse("15:04", c.DeliveryTime); err != nil {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidDeliveryTime"))
+	}
+	if c.DeliveryTimezone == "" {
+		c.DeliveryTimezone = "UTC"
+	}
+	if _, err := time.LoadLocation(c.DeliveryTimezone); err != nil {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidDeliveryTimezone"))
+	}
+
+	// RSS campaigns poll the feed on their recurrence, hourly by default.
+	c.FeedURL = strings.TrimSpace(c.FeedURL)
+	if c.FeedURL != "" {
+		if u, err := url.Parse(c.FeedURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidFeedURL"))
+		}
+		if strings.TrimSpace(c.Recurrence) == "" {
+			c.Recurrence = "@hourly"
+		}
+	}
+
+	// Validate the recurrence cron expression and the optional condition template.
+	c.Recurrence = strings.TrimSpace(c.Recurrence)
+	if c.Recurrence != "" {
+		if _, err := cron.ParseStandard(c.Recurrence); err != nil {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidRecurrence", "error", err.Error()))
+		}
+	}
+	if c.RecurrenceCondition != "" {
+		if _, err := txttpl.New("").Funcs(txttpl.FuncMap(a.manager.GenericTemplateFuncs())).Parse(c.RecurrenceCondition); err != nil {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidRecurrenceCondition", "error", err.Error()))
+		}
+	}
+
 	if !a.manager.HasMessenger(c.Messenger) {
 		// If it's a specific SMTP, but it's no longer available (removed/disabled), fall back to general email messenger.
 		if strings.HasPrefix(c.Messenger, "email-") {
@@ -842,6 +1056,34 @@ func (a *App) checkCampaignPerm(types auth.PermType, id int, c echo.Context) err
 	return nil
 }
 
+// validateCampaignVariants validates A/B test options and variants.
+func (a *App) validateCampaignVariants(sample int, window, metric string, variants []models.CampaignVariant) error {
+	if sample < 0 || sample > 100 {
+		return errors.New(a.i18n.T("campaigns.fieldInvalidVariantSample"))
+	}
+
+	if d, err := time.ParseDuration(window); err != nil || d < time.Minute {
+		return errors.New(a.i18n.T("campaigns.fieldInvalidVariantWindow"))
+	}
+
+	if metric != models.CampaignVariantMetricViews && metric != models.CampaignVariantMetricClicks {
+		return errors.New(a.i18n.T("campaigns.fieldInvalidVariantMetric"))
+	}
+
+	// A test needs at least two variants to compare.
+	if sample > 0 && len(variants) < 2 {
+		return errors.New(a.i18n.T("campaigns.fieldInvalidVariants"))
+	}
+
+	for _, v := range variants {
+		if !strHasLen(v.Name, 1, stdInputMaxLen) || !strHasLen(v.Subject, 1, stdInputMaxLen) {
+			return errors.New(a.i18n.T("campaigns.fieldInvalidVariants"))
+		}
+	}
+
+	return nil
+}
+
 // canEditCampaign returns true if a campaign is in a status where updating
 // its properties is allowed.
 func canEditCampaign(status string) bool {
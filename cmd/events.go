@@ -3,7 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -11,18 +12,28 @@ import (
 
 // EventStream serves an endpoint that never closes and pushes a
 // live event stream (text/event-stream) such as a error messages.
+// Clients that reconnect with the Last-Event-ID header (or the last_event_id
+// query param) receive the events they missed since that event.
 func (a *App) EventStream(c echo.Context) error {
-	hdr := c.Response().Header()
-	hdr.Set(echo.HeaderContentType, "text/event-stream")
-	hdr.Set(echo.HeaderCacheControl, "no-store")
-	hdr.Set(echo.HeaderConnection, "keep-alive")
+	lastID, _ := strconv.ParseInt(c.Request().Header.Get("Last-Event-ID"), 10, 64)
+	if lastID == 0 {
+		lastID, _ = strconv.ParseInt(c.QueryParam("last_event_id"), 10, 64)
+	}
 
 	// Subscribe to the event stream with a random ID.
 	id := fmt.Sprintf("api:%v", time.Now().UnixNano())
-	sub, err := a.events.Subscribe(id)
+	sub, err := a.events.Subscribe(id, c.QueryParam("type"), lastID)
 	if err != nil {
-		log.Fatalf("error subscribing to events: %v", err)
+		a.log.Printf("error subscribing to events: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "events", "error", err.Error()))
 	}
+	defer a.events.Unsubscribe(id)
+
+	hdr := c.Response().Header()
+	hdr.Set(echo.HeaderContentType, "text/event-stream")
+	hdr.Set(echo.HeaderCacheControl, "no-store")
+	hdr.Set(echo.HeaderConnection, "keep-alive")
 
 	ctx := c.Request().Context()
 	for {
@@ -34,14 +45,12 @@ func (a *App) EventStream(c echo.Context) error {
 				continue
 			}
 
-			c.Response().Write([]byte(fmt.Sprintf("retry: 3000\ndata: %s\n\n", b)))
+			c.Response().Write([]byte(fmt.Sprintf("id: %d\nretry: 3000\ndata: %s\n\n", e.ID, b)))
 			c.Response().Flush()
 
 		case <-ctx.Done():
 			// On HTTP connection close, unsubscribe.
-			a.events.Unsubscribe(id)
 			return nil
 		}
 	}
-
 }
@@ -109,6 +109,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PUT("/api/settings", pm(a.UpdateSettings, "settings:manage"))
 		g.PUT("/api/settings/:key", pm(a.UpdateSettingsByKey, "settings:manage"))
 		g.POST("/api/settings/smtp/test", pm(a.TestSMTPSettings, "settings:manage"))
+		g.GET("/api/settings/smtp/health", pm(a.GetSMTPHealth, "settings:get"))
 		g.POST("/api/admin/reload", pm(a.ReloadApp, "settings:manage"))
 		g.GET("/api/logs", pm(a.GetLogs, "settings:get"))
 		g.GET("/api/events", pm(a.EventStream, "settings:get"))
@@ -131,8 +132,18 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.DELETE("/api/subscribers/:id", pm(hasID(a.DeleteSubscriber), "subscribers:manage"))
 		g.DELETE("/api/subscribers", pm(a.DeleteSubscribers, "subscribers:manage"))
 
+		g.GET("/api/suppressions", pm(a.GetSuppressions, "subscribers:get_all"))
+		g.GET("/api/suppressions/export", pm(a.ExportSuppressions, "subscribers:get_all"))
+		g.GET("/api/suppressions/:id", pm(hasID(a.GetSuppression), "subscribers:get_all"))
+		g.POST("/api/suppressions", pm(a.CreateSuppression, "subscribers:manage"))
+		g.POST("/api/suppressions/import", pm(a.ImportSuppressions, "subscribers:import"))
+		g.PUT("/api/suppressions/:id", pm(hasID(a.UpdateSuppression), "subscribers:manage"))
+		g.DELETE("/api/suppressions", pm(a.DeleteSuppressions, "subscribers:manage"))
+		g.DELETE("/api/suppressions/:id", pm(hasID(a.DeleteSuppression), "subscribers:manage"))
+
 		g.GET("/api/bounces", pm(a.GetBounces, "bounces:get"))
 		g.PUT("/api/bounces/blocklist", pm(a.BlocklistBouncedSubscribers, "bounces:manage"))
+		g.GET("/api/bounces/mailboxes", pm(a.GetBounceMailboxes, "bounces:get"))
 		g.GET("/api/bounces/:id", pm(hasID(a.GetBounce), "bounces:get"))
 		g.DELETE("/api/bounces", pm(a.DeleteBounces, "bounces:manage"))
 		g.DELETE("/api/bounces/:id", pm(hasID(a.DeleteBounce), "bounces:manage"))
@@ -158,6 +169,15 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.DELETE("/api/lists", a.DeleteLists)
 		g.DELETE("/api/lists/:id", hasID(a.DeleteList))
 
+		// Individual list permissions are applied to segments within the handlers.
+		g.GET("/api/segments", pm(a.GetSegments, "segments:get"))
+		g.GET("/api/segments/:id", pm(hasID(a.GetSegment), "segments:get"))
+		g.POST("/api/segments", pm(a.CreateSegment, "segments:manage"))
+		g.POST("/api/segments/preview", pm(a.PreviewSegment, "segments:get"))
+		g.POST("/api/segments/:id/count", pm(hasID(a.RefreshSegmentCount), "segments:get"))
+		g.PUT("/api/segments/:id", pm(hasID(a.UpdateSegment), "segments:manage"))
+		g.DELETE("/api/segments/:id", pm(hasID(a.DeleteSegment), "segments:manage"))
+
 		g.GET("/api/campaigns", pm(a.GetCampaigns, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/running/stats", pm(a.GetRunningCampaignStats, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/:id", pm(hasID(a.GetCampaign), "campaigns:get_all", "campaigns:get"))
@@ -172,6 +192,10 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PUT("/api/campaigns/:id", pm(hasID(a.UpdateCampaign), "campaigns:manage_all", "campaigns:manage"))
 		g.PUT("/api/campaigns/:id/status", pm(hasID(a.UpdateCampaignStatus), "campaigns:send"))
 		g.PUT("/api/campaigns/:id/archive", pm(hasID(a.UpdateCampaignArchive), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/variants", pm(hasID(a.GetCampaignVariants), "campaigns:get_all", "campaigns:get"))
+		g.PUT("/api/campaigns/:id/variants", pm(hasID(a.UpdateCampaignVariants), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/deliveries", pm(hasID(a.GetCampaignDeliveries), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/deliveries/resend", pm(hasID(a.ResendCampaignDeliveries), "campaigns:send"))
 		g.DELETE("/api/campaigns", pm(a.DeleteCampaigns, "campaigns:manage", "campaigns:manage_all"))
 		g.DELETE("/api/campaigns/:id", pm(hasID(a.DeleteCampaign), "campaigns:manage_all", "campaigns:manage"))
 
@@ -189,10 +213,25 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PUT("/api/templates/:id/default", pm(hasID(a.TemplateSetDefault), "templates:manage"))
 		g.DELETE("/api/templates/:id", pm(hasID(a.DeleteTemplate), "templates:manage"))
 
+		g.GET("/api/workflows", pm(a.GetWorkflows, "workflows:get"))
+		g.GET("/api/workflows/:id", pm(hasID(a.GetWorkflow), "workflows:get"))
+		g.POST("/api/workflows", pm(a.CreateWorkflow, "workflows:manage"))
+		g.PUT("/api/workflows/:id", pm(hasID(a.UpdateWorkflow), "workflows:manage"))
+		g.DELETE("/api/workflows/:id", pm(hasID(a.DeleteWorkflow), "workflows:manage"))
+
+		g.GET("/api/webhooks", pm(a.GetWebhooks, "webhooks:get"))
+		g.GET("/api/webhooks/:id", pm(hasID(a.GetWebhook), "webhooks:get"))
+		g.GET("/api/webhooks/:id/deliveries", pm(hasID(a.GetWebhookDeliveries), "webhooks:get"))
+		g.POST("/api/webhooks", pm(a.CreateWebhook, "webhooks:manage"))
+		g.POST("/api/webhooks/:id/deliveries/retry", pm(hasID(a.RetryWebhookDeliveries), "webhooks:manage"))
+		g.PUT("/api/webhooks/:id", pm(hasID(a.UpdateWebhook), "webhooks:manage"))
+		g.DELETE("/api/webhooks/:id", pm(hasID(a.DeleteWebhook), "webhooks:manage"))
+
 		g.DELETE("/api/maintenance/subscribers/:type", pm(a.GCSubscribers, "settings:maintain"))
 		g.DELETE("/api/maintenance/analytics/:type", pm(a.GCCampaignAnalytics, "settings:maintain"))
 		g.GET("/api/maintenance/analytics/:type/export", pm(a.ExportCampaignAnalytics, "settings:maintain"))
 		g.DELETE("/api/maintenance/subscriptions/unconfirmed", pm(a.GCSubscriptions, "settings:maintain"))
+		g.POST("/api/maintenance/engagement", pm(a.UpdateEngagement, "settings:maintain"))
 
 		g.POST("/api/tx", pm(a.SendTxMessage, "tx:send"))
 
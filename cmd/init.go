@@ -37,17 +37,22 @@ import (
 	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/events"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/media/providers/filesystem"
 	"github.com/knadh/listmonk/internal/media/providers/s3"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/messenger/emailapi"
 	"github.com/knadh/listmonk/internal/messenger/postback"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/internal/subimporter"
+	wh "github.com/knadh/listmonk/internal/webhooks"
+	"github.com/knadh/listmonk/internal/workflows"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
@@ -96,6 +101,7 @@ type Config struct {
 		AllowExport        bool            `koanf:"allow_export"`
 		AllowWipe          bool            `koanf:"allow_wipe"`
 		RecordOptinIP      bool            `koanf:"record_optin_ip"`
+		SuppressDeleted    bool            `koanf:"suppress_deleted"`
 		UnsubHeader        bool            `koanf:"unsubscribe_header"`
 		Exportable         map[string]bool `koanf:"-"`
 		DomainBlocklist    []string        `koanf:"-"`
@@ -151,6 +157,8 @@ type Config struct {
 	BounceForwardemailEnabled bool
 	BounceLettermintEnabled   bool
 
+	Engagement engagementOpt
+
 	PermissionsRaw json.RawMessage
 	Permissions    map[string]struct{}
 }
@@ -311,7 +319,7 @@ func initFS(appDir, frontendDir, staticDir, i18nDir string) stuffbin.FileSystem
 
 // initDB initializes the main DB connection pool and parse and loads the app's
 // SQL queries into a prepared query map.
-func initDB() *sqlx.DB {
+func initDB() (*sqlx.DB, string) {
 	var c struct {
 		Host        string        `koanf:"host"`
 		Port        int           `koanf:"port"`
@@ -356,7 +364,8 @@ func initDB() *sqlx.DB {
 		parts = append(parts, c.Params)
 	}
 
-	db, err := sqlx.Connect("postgres", strings.Join(parts, " "))
+	dsn := strings.Join(parts, " ")
+	db, err := sqlx.Connect("postgres", dsn)
 	if err != nil {
 		lo.Fatalf("error connecting to DB: %v", err)
 	}
@@ -365,7 +374,7 @@ func initDB() *sqlx.DB {
 	db.SetMaxIdleConns(c.MaxIdle)
 	db.SetConnMaxLifetime(c.MaxLifetime)
 
-	return db.Unsafe()
+	return db.Unsafe(), dsn
 }
 
 func readQueries(dir string, fs stuffbin.FileSystem) goyesql.Queries {
@@ -502,6 +511,9 @@ func initConstConfig(ko *koanf.Koanf) *Config {
 	if err := ko.UnmarshalWithConf("appearance", &c.Appearance, koanf.UnmarshalConf{FlatPaths: true}); err != nil {
 		lo.Fatalf("error loading app.appearance config: %v", err)
 	}
+	if err := ko.Unmarshal("maintenance.engagement", &c.Engagement); err != nil {
+		lo.Fatalf("error loading maintenance.engagement config: %v", err)
+	}
 
 	c.Lang = ko.String("app.lang")
 	c.Privacy.Exportable = koanfmaps.StringSliceToLookupMap(ko.Strings("privacy.exportable"))
@@ -569,6 +581,7 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 		Constants: core.Constants{
 			SendOptinConfirmation: ko.Bool("app.send_optin_confirmation"),
 			CacheSlowQueries:      ko.Bool("app.cache_slow_queries"),
+			SuppressDeleted:       ko.Bool("privacy.suppress_deleted"),
 		},
 		Queries: queries,
 		DB:      db,
@@ -588,7 +601,7 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 }
 
 // initCampaignManager initializes the campaign manager.
-func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlConfig, co *core.Core, md media.Store, i *i18n.I18n, ko *koanf.Koanf) *manager.Manager {
+func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, db *sqlx.DB, u *UrlConfig, co *core.Core, md media.Store, i *i18n.I18n, ko *koanf.Koanf) *manager.Manager {
 	if ko.Bool("passive") {
 		lo.Println("running in passive mode. won't process campaigns.")
 	}
@@ -612,9 +625,11 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 		SlidingWindow:         ko.Bool("app.message_sliding_window"),
 		SlidingWindowDuration: ko.Duration("app.message_sliding_window_duration"),
 		SlidingWindowRate:     ko.Int("app.message_sliding_window_rate"),
+		DomainThrottles:       initDomainThrottles(ko),
+		FeedAllowPrivate:      ko.Bool("security.allow_private_feeds"),
 		ScanInterval:          time.Second * 5,
 		ScanCampaigns:         !ko.Bool("passive"),
-	}, newManagerStore(q, co, md), i, lo)
+	}, newManagerStore(q, db, co, md), i, lo)
 
 	// Attach all messengers to the campaign manager.
 	for _, m := range msgrs {
@@ -624,6 +639,21 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 	return mgr
 }
 
+// initDomainThrottles loads the per-domain send limits.
+func initDomainThrottles(ko *koanf.Koanf) []manager.DomainThrottle {
+	var out []manager.DomainThrottle
+	for _, t := range ko.Slices("app.domain_throttles") {
+		out = append(out, manager.DomainThrottle{
+			Domain:      t.String("domain"),
+			Rate:        t.Int("rate"),
+			Duration:    t.Duration("duration"),
+			Concurrency: t.Int("concurrency"),
+		})
+	}
+
+	return out
+}
+
 // initTxTemplates initializes and compiles the transactional templates and caches them in-memory.
 func initTxTemplates(m *manager.Manager, co *core.Core) {
 	tpls, err := co.GetTemplates(models.TemplateTypeTx, false)
@@ -650,6 +680,7 @@ func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n,
 			UpsertStmt:         q.UpsertSubscriber.Stmt,
 			BlocklistStmt:      q.UpsertBlocklistSubscriber.Stmt,
 			UpdateListDateStmt: q.UpdateListsDate.Stmt,
+			SuppressedStmt:     q.GetSuppressedHashes.Stmt,
 
 			// Hook for triggering admin notifications and refreshing stats materialized
 			// views after a successful import.
@@ -665,12 +696,29 @@ func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n,
 }
 
 // initSMTPMessenger initializes the combined and individual SMTP messengers.
-func initSMTPMessengers() []manager.Messenger {
+// The daily volumes of servers being warmed up are kept in the given store.
+func initSMTPMessengers(vs email.VolumeStore) []manager.Messenger {
 	var (
 		servers = []email.Server{}
+		dkim    = []email.DKIM{}
 		out     = []manager.Messenger{}
 	)
 
+	// Load the DKIM keys for signing messages by From domain.
+	for _, item := range ko.Slices("dkim") {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		var d email.DKIM
+		if err := item.UnmarshalWithConf("", &d, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading DKIM config: %v", err)
+		}
+
+		dkim = append(dkim, d)
+		lo.Printf("loaded DKIM key: %s._domainkey.%s", d.Selector, d.Domain)
+	}
+
 	// Load the config for multiple SMTP servers.
 	for _, item := range ko.Slices("smtp") {
 		if !item.Bool("enabled") {
@@ -689,7 +737,7 @@ func initSMTPMessengers() []manager.Messenger {
 		// If the server has a name, initialize it as a standalone e-mail messenger
 		// allowing campaigns to select individual SMTPs. In the UI and config, it'll appear as `email / $name`.
 		if s.Name != "" {
-			msgr, err := email.New(s.Name, s)
+			msgr, err := email.New(s.Name, dkim, vs, s)
 			if err != nil {
 				lo.Fatalf("error initializing e-mail messenger: %v", err)
 			}
@@ -698,7 +746,7 @@ func initSMTPMessengers() []manager.Messenger {
 	}
 
 	// Initialize the 'email' messenger with all SMTP servers.
-	msgr, err := email.New(email.MessengerName, servers...)
+	msgr, err := email.New(email.MessengerName, dkim, vs, servers...)
 	if err != nil {
 		lo.Fatalf("error initializing e-mail messenger: %v", err)
 	}
@@ -750,6 +798,42 @@ func initPostbackMessengers(ko *koanf.Koanf) []manager.Messenger {
 	return out
 }
 
+// initEmailAPIMessengers initializes and returns all the enabled
+// HTTP e-mail API messenger backends.
+func initEmailAPIMessengers(ko *koanf.Koanf) []manager.Messenger {
+	items := ko.Slices("email_apis")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []manager.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		// Read the e-mail API config.
+		var (
+			name = item.String("name")
+			o    emailapi.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading e-mail API config: %v", err)
+		}
+
+		// Initialize the Messenger.
+		m, err := emailapi.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing e-mail API messenger %s: %v", name, err)
+		}
+		out = append(out, m)
+
+		lo.Printf("loaded e-mail API messenger: %s (%s)", name, o.Provider)
+	}
+
+	return out
+}
+
 // initMediaStore initializes Upload manager with a custom backend.
 func initMediaStore(ko *koanf.Koanf) media.Store {
 	switch provider := ko.String("upload.provider"); provider {
@@ -854,7 +938,20 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 		RecordBounceCB: cb,
 	}
 
-	// For now, only one mailbox is supported.
+	// Declaratively configured webhooks.
+	for _, w := range ko.Slices("bounce.custom_webhooks") {
+		if !w.Bool("enabled") {
+			continue
+		}
+
+		var o webhooks.GenericOpt
+		if err := w.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading bounce webhook config: %v", err)
+		}
+		opt.Generic = append(opt.Generic, o)
+	}
+
+	// Each enabled mailbox is scanned independently.
 	for _, b := range ko.Slices("bounce.mailboxes") {
 		if !b.Bool("enabled") {
 			continue
@@ -865,10 +962,11 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 			lo.Fatalf("error reading bounce mailbox config: %v", err)
 		}
 
-		opt.MailboxType = b.String("type")
-		opt.MailboxEnabled = true
-		opt.Mailbox = boxOpt
-		break
+		opt.Mailboxes = append(opt.Mailboxes, bounce.MailboxOpt{
+			UUID: b.String("uuid"),
+			Type: b.String("type"),
+			Opt:  boxOpt,
+		})
 	}
 
 	// Initialize the bounce manager.
@@ -880,6 +978,58 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 	return b
 }
 
+// initWorkflows initializes the runner that sends the steps of automation workflows.
+func initWorkflows(mgr *manager.Manager, co *core.Core, q *models.Queries, lo *log.Logger, ko *koanf.Koanf) *workflows.Runner {
+	return workflows.New(workflows.Opt{
+		Interval:        time.Minute,
+		BatchSize:       ko.Int("app.batch_size"),
+		FromEmail:       ko.String("app.from_email"),
+		GetTpl:          mgr.GetTpl,
+		TplFuncs:        mgr.GenericTemplateFuncs,
+		MatchSubscriber: co.MatchSubscriber,
+		PushMessage:     mgr.PushMessage,
+	}, &workflows.Queries{
+		CancelWorkflowSubscribers: q.CancelWorkflowSubscribers,
+		NextWorkflowSubscribers:   q.NextWorkflowSubscribers,
+		UpdateWorkflowSubscriber:  q.UpdateWorkflowSubscriber,
+	}, lo)
+}
+
+// initEvents starts the event store that keeps events in the DB and streams them
+// to subscribers across instances.
+func initEvents(ev *events.Events, db *sqlx.DB, dsn string, q *models.Queries) {
+	// Errors in the event store are not logged to the event stream itself.
+	l := log.New(io.MultiWriter(os.Stdout, bufLog), "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+
+	ev.Start(events.Opt{
+		DSN:          dsn,
+		PollInterval: time.Second * 5,
+		Retention:    time.Hour * 24 * 7,
+	}, db, &events.Queries{
+		LockEvents:     q.LockEvents,
+		InsertEvent:    q.InsertEvent,
+		GetEvents:      q.GetEvents,
+		GetLastEventID: q.GetLastEventID,
+		DeleteEvents:   q.DeleteEvents,
+	}, l)
+}
+
+// initWebhooks initializes the runner that sends lifecycle events to outbound webhooks.
+func initWebhooks(q *models.Queries, lo *log.Logger, ko *koanf.Koanf) *wh.Runner {
+	return wh.New(wh.Opt{
+		Interval:    time.Second * 5,
+		BatchSize:   100,
+		Concurrency: ko.Int("app.concurrency"),
+		Timeout:     time.Second * 10,
+		RetryDelay:  time.Second * 30,
+		Retention:   time.Hour * 24 * 30,
+	}, &wh.Queries{
+		NextWebhookDeliveries:   q.NextWebhookDeliveries,
+		UpdateWebhookDelivery:   q.UpdateWebhookDelivery,
+		DeleteWebhookDeliveries: q.DeleteWebhookDeliveries,
+	}, lo)
+}
+
 // initAbout initializes the app's /about API endpoint with the app and system info.
 func initAbout(q *models.Queries, db *sqlx.DB) about {
 	var (
@@ -996,8 +1146,10 @@ func initCaptcha() *captcha.Captcha {
 	return captcha.New(opt)
 }
 
-// initCron initializes cron jobs for slow query cache refresh and database vacuum.
-func initCron(co *core.Core, db *sqlx.DB) {
+// initCron initializes cron jobs for slow query cache refresh, database vacuum,
+// campaign delivery log cleanup, and subscriber engagement scoring. The cleanup and
+// scoring jobs run on one instance at a time when multiple instances share the DB.
+func initCron(co *core.Core, db *sqlx.DB, eng engagementOpt) {
 	c := cron.New(cron.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
 
 	// Slow query cache cron job.
@@ -1036,6 +1188,51 @@ func initCron(co *core.Core, db *sqlx.DB) {
 		}
 	}
 
+	// Campaign delivery log cleanup cron job. It runs on the database maintenance schedule.
+	if days := ko.Int("maintenance.db.delivery_retention_days"); days > 0 {
+		intval := ko.String("maintenance.db.vacuum_cron_interval")
+		if intval == "" {
+			lo.Println("error: invalid cron interval string for campaign delivery log cleanup")
+		} else {
+			_, err := c.Add(intval, func() {
+				runExclusive(db, "delivery_cleanup", lo, func() {
+					RunDeliveryCleanup(co, days, lo)
+				})
+			})
+			if err != nil {
+				lo.Printf("error initializing campaign delivery log cleanup cron: %v", err)
+			} else {
+				lo.Printf("campaign delivery logs older than %d days will be deleted at interval: %s", days, intval)
+			}
+		}
+	}
+
+	// Subscriber engagement scoring cron job.
+	if eng.Enabled {
+		if eng.CronInterval == "" {
+			lo.Println("error: invalid cron interval string for subscriber engagement")
+		} else {
+			_, err := c.Add(eng.CronInterval, func() {
+				runExclusive(db, "engagement", lo, func() {
+					_, _ = RunEngagementScoring(co, eng, lo)
+				})
+			})
+			if err != nil {
+				lo.Printf("error initializing subscriber engagement cron: %v", err)
+			} else {
+				lo.Printf("subscriber engagement cron enabled at interval: %s", eng.CronInterval)
+			}
+		}
+	}
+
+	// Subscribers marked inactive by a sunset policy that's no longer
+	// in effect shouldn't be excluded from campaigns anymore.
+	if !eng.Enabled || !eng.SunsetEnabled {
+		if n, err := co.ResetInactiveSubscribers(); err == nil && n > 0 {
+			lo.Printf("reset %d inactive subscribers as the sunset policy is disabled", n)
+		}
+	}
+
 	if len(c.Entries()) > 0 {
 		c.Start()
 	}
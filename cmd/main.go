@@ -80,6 +80,7 @@ var (
 	ko      = koanf.New(".")
 	fs      stuffbin.FileSystem
 	db      *sqlx.DB
+	dbDSN   string
 	queries *models.Queries
 
 	// Compile-time variables.
@@ -136,7 +137,7 @@ func init() {
 	}
 
 	// Connect to the database.
-	db = initDB()
+	db, dbDSN = initDB()
 
 	// Initialize the embedded filesystem with static assets.
 	fs = initFS(appDir, frontendDir, ko.String("static-dir"), ko.String("i18n-dir"))
@@ -188,6 +189,9 @@ func init() {
 
 	// Prepare queries.
 	queries = prepareQueries(qMap, db, ko)
+
+	// Start the event store that streams events (eg: errors) to clients.
+	initEvents(evStream, db, dbDSN, queries)
 }
 
 func main() {
@@ -209,11 +213,11 @@ func main() {
 		// Crud core.
 		core = initCore(fbOptinNotify, queries, db, i18n, ko)
 
-		// Initialize all messengers, SMTP and postback.
-		msgrs = append(initSMTPMessengers(), initPostbackMessengers(ko)...)
+		// Initialize all messengers, SMTP, postback, and e-mail APIs.
+		msgrs = append(append(initSMTPMessengers(newManagerStore(queries, db, core, media)), initPostbackMessengers(ko)...), initEmailAPIMessengers(ko)...)
 
 		// Campaign manager.
-		mgr = initCampaignManager(msgrs, queries, urlCfg, core, media, i18n, ko)
+		mgr = initCampaignManager(msgrs, queries, db, urlCfg, core, media, i18n, ko)
 
 		// Bulk importer.
 		importer = initImporter(queries, db, core, i18n, ko)
@@ -254,8 +258,17 @@ func main() {
 		go bounce.Run()
 	}
 
+	// Start the runner that sends the steps of automation workflows. Like campaigns,
+	// workflows aren't processed by passive instances.
+	if !ko.Bool("passive") {
+		go initWorkflows(mgr, core, queries, lo, ko).Run()
+	}
+
+	// Start the runner that sends lifecycle events to outbound webhooks.
+	go initWebhooks(queries, lo, ko).Run()
+
 	// Start cronjobs.
-	initCron(core, db)
+	initCron(core, db, cfg.Engagement)
 
 	// Start the campaign manager workers. The campaign batches (fetch from DB, push out
 	// messages) get processed at the specified interval.
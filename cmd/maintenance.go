@@ -8,9 +8,31 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/core"
 	"github.com/labstack/echo/v4"
 )
 
+// Sunset policy actions on inactive subscribers. Inactive subscribers are
+// always excluded from campaigns.
+const (
+	sunsetActionExclude     = "exclude"
+	sunsetActionUnsubscribe = "unsubscribe"
+)
+
+// engagementOpt represents the subscriber engagement scoring and sunset policy settings.
+type engagementOpt struct {
+	Enabled      bool   `koanf:"enabled"`
+	CronInterval string `koanf:"cron_interval"`
+
+	// Views, clicks, and bounces in the last n days count towards the score.
+	WindowDays int `koanf:"window_days"`
+
+	// Subscribers are marked inactive after n campaigns without engagement.
+	SunsetEnabled   bool   `koanf:"sunset_enabled"`
+	SunsetCampaigns int    `koanf:"sunset_campaigns"`
+	SunsetAction    string `koanf:"sunset_action"`
+}
+
 // GCSubscribers garbage collects (deletes) orphaned or blocklisted subscribers.
 func (a *App) GCSubscribers(c echo.Context) error {
 	var (
@@ -159,6 +181,19 @@ func (a *App) ExportCampaignAnalytics(c echo.Context) error {
 	return nil
 }
 
+// UpdateEngagement handles recomputing subscriber engagement scores and applying
+// the sunset policy right away instead of waiting for the scheduled job.
+func (a *App) UpdateEngagement(c echo.Context) error {
+	n, err := RunEngagementScoring(a.core, a.cfg.Engagement, a.log)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Inactive int `json:"inactive"`
+	}{n}})
+}
+
 // RunDBVacuum runs a full VACUUM on the PostgreSQL database.
 // VACUUM reclaims storage occupied by dead tuples and updates planner statistics.
 func RunDBVacuum(db *sqlx.DB, lo *log.Logger) {
@@ -169,3 +204,68 @@ func RunDBVacuum(db *sqlx.DB, lo *log.Logger) {
 	}
 	lo.Println("finished database VACUUM ANALYZE")
 }
+
+// runExclusive runs a maintenance job only if it isn't already running on another
+// instance. Jobs are scheduled on every instance sharing the DB, and a Postgres
+// advisory lock on the job's name is held in a transaction while the job runs.
+func runExclusive(db *sqlx.DB, name string, lo *log.Logger, fn func()) {
+	tx, err := db.Beginx()
+	if err != nil {
+		lo.Printf("error starting %s: %v", name, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var ok bool
+	if err := tx.Get(&ok, "SELECT PG_TRY_ADVISORY_XACT_LOCK(HASHTEXT($1))", "listmonk_"+name); err != nil {
+		lo.Printf("error starting %s: %v", name, err)
+		return
+	}
+	if !ok {
+		lo.Printf("skipping %s as it's running on another instance", name)
+		return
+	}
+
+	fn()
+}
+
+// RunDeliveryCleanup deletes the delivery logs of finished and cancelled campaigns
+// that are older than the given number of days.
+func RunDeliveryCleanup(co *core.Core, days int, lo *log.Logger) {
+	n, err := co.DeleteCampaignDeliveries(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return
+	}
+	lo.Printf("deleted %d campaign delivery log entries older than %d days", n, days)
+}
+
+// RunEngagementScoring recomputes the engagement scores of all subscribers and applies
+// the sunset policy to the ones that are inactive. It returns the number of inactive subscribers.
+func RunEngagementScoring(co *core.Core, opt engagementOpt, lo *log.Logger) (int, error) {
+	lo.Println("updating subscriber engagement")
+
+	if opt.WindowDays <= 0 {
+		opt.WindowDays = 180
+	}
+
+	sunset := 0
+	if opt.SunsetEnabled && opt.SunsetCampaigns > 0 {
+		sunset = opt.SunsetCampaigns
+	}
+
+	n, err := co.UpdateSubscriberEngagement(opt.WindowDays, sunset)
+	if err != nil {
+		return 0, err
+	}
+
+	if sunset > 0 && opt.SunsetAction == sunsetActionUnsubscribe {
+		num, err := co.UnsubscribeInactiveSubscribers()
+		if err != nil {
+			return n, err
+		}
+		lo.Printf("unsubscribed %d subscriptions of inactive subscribers", num)
+	}
+
+	lo.Printf("finished updating subscriber engagement. inactive subscribers: %d", n)
+	return n, nil
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
+	"gopkg.in/volatiletech/null.v6"
 )
 
 // store implements DataSource over the primary
 // database.
 type store struct {
 	queries *models.Queries
+	db      *sqlx.DB
 	core    *core.Core
 	media   media.Store
 }
@@ -22,12 +31,15 @@ type runningCamp struct {
 	CampaignType     string `db:"campaign_type"`
 	LastSubscriberID int    `db:"last_subscriber_id"`
 	MaxSubscriberID  int    `db:"max_subscriber_id"`
+	VariantSample    int    `db:"variant_sample"`
+	VariantStatus    string `db:"variant_status"`
 	ListID           int    `db:"list_id"`
 }
 
-func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
+func newManagerStore(q *models.Queries, db *sqlx.DB, c *core.Core, m media.Store) *store {
 	return &store{
 		queries: q,
+		db:      db,
 		core:    c,
 		media:   m,
 	}
@@ -38,32 +50,203 @@ func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
 // of campaigns that are being processed and updates them in the DB.
 func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error) {
 	var out []*models.Campaign
-	err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts))
-	return out, err
+	if err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts)); err != nil {
+		return nil, err
+	}
+
+	if err := s.updateSegmentAudiences(out); err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	// Campaigns that haven't been started before are being started now. If multiple instances
+	// pick up a campaign, only the one that starts it fires the webhook.
+	ids := make([]int, len(out))
+	for i, c := range out {
+		ids[i] = c.ID
+	}
+
+	var started []int
+	if err := s.queries.StartCampaigns.Select(&started, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, c := range out {
+		if !slices.Contains(started, c.ID) {
+			continue
+		}
+
+		c.StartedAt = null.TimeFrom(now)
+		d := models.NewWebhookCampaign(*c)
+		d.Status = models.CampaignStatusRunning
+		s.core.FireWebhooks(models.WebhookEventCampaignStarted, d)
+	}
+
+	return out, nil
 }
 
 // NextSubscribers retrieves a subset of subscribers of a given campaign.
 // Since batches are processed sequentially, the retrieval is ordered by ID,
 // and every batch takes the last ID of the last batch and fetches the next
-// batch above that.
-func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error) {
-	var camps []runningCamp
-	if err := s.queries.GetRunningCampaign.Select(&camps, campID); err != nil {
+// batch above that. The campaign is locked while the batch is fetched so that
+// multiple instances processing the same campaign get distinct batches.
+func (s *store) NextSubscribers(campID, limit int, instanceID string) ([]models.Subscriber, error) {
+	// Segments are evaluated on every batch.
+	segs, err := s.core.GetCampaignSegments([]int{campID})
+	if err != nil {
 		return nil, err
 	}
 
-	var listIDs []int
+	for {
+		// Resolve the subscribers in the segments first. Segment conditions are arbitrary
+		// expressions and aren't run in the transaction that claims the batch.
+		segIDs, err := s.nextSegmentSubscribers(campID, segs, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		out, moved, err := s.claimSubscribers(campID, limit, instanceID, segIDs, len(segIDs) >= limit)
+		if err != nil || out == nil {
+			return nil, err
+		}
+
+		// Every subscriber in the batch was skipped, for instance, because they were already
+		// in the delivery log. The checkpoint has moved past them. Fetch the next batch.
+		if len(out) == 0 && moved {
+			continue
+		}
+
+		return out, nil
+	}
+}
+
+// nextSegmentSubscribers returns the IDs of the next batch of subscribers in a campaign's
+// segments after its checkpoint in a readonly transaction.
+func (s *store) nextSegmentSubscribers(campID int, segs []models.Segment, limit int) ([]int64, error) {
+	out := []int64{}
+	if len(segs) == 0 {
+		return out, nil
+	}
+
+	exp, args, err := s.core.MakeSegmentsQuery(segs, 2)
+	if err != nil {
+		return nil, err
+	}
+	stmt := strings.ReplaceAll(s.queries.NextCampaignSegmentSubscribers, "%query%", exp)
+
+	tx, err := s.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Select(&out, stmt, append([]any{campID, limit}, args...)...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// claimSubscribers fetches the next batch of subscribers in a campaign's lists and the
+// given subscribers from its segments, moves the campaign's checkpoint, and marks them
+// as queued in the delivery log. If full is true, segIDs is a full batch and the batch
+// doesn't go beyond its last ID. A nil slice is returned if the campaign isn't running.
+// The bool indicates whether the checkpoint moved, which it does even if every subscriber
+// in the batch was skipped.
+func (s *store) claimSubscribers(campID, limit int, instanceID string, segIDs []int64, full bool) ([]models.Subscriber, bool, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var camps []runningCamp
+	if err := tx.Stmtx(s.queries.GetRunningCampaign).Select(&camps, campID); err != nil {
+		return nil, false, err
+	}
+
+	if len(camps) == 0 {
+		return nil, false, nil
+	}
+
+	listIDs := []int{}
 	for _, c := range camps {
-		listIDs = append(listIDs, c.ListID)
+		if c.ListID > 0 {
+			listIDs = append(listIDs, c.ListID)
+		}
 	}
 
-	if len(listIDs) == 0 {
-		return nil, nil
+	if len(listIDs) == 0 && len(segIDs) == 0 {
+		return nil, false, nil
 	}
 
-	var out []models.Subscriber
-	err := s.queries.NextCampaignSubscribers.Select(&out, camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
-	return out, err
+	maxID := camps[0].MaxSubscriberID
+	if full {
+		maxID = min(maxID, int(segIDs[len(segIDs)-1]))
+	}
+
+	out := []models.Subscriber{}
+	if err := tx.Stmtx(s.queries.NextCampaignSubscribers).Select(&out, camps[0].CampaignID, camps[0].CampaignType,
+		camps[0].LastSubscriberID, maxID, pq.Array(listIDs), limit,
+		camps[0].VariantSample, camps[0].VariantStatus, instanceID, pq.Array(segIDs)); err != nil {
+		return nil, false, err
+	}
+
+	// If every subscriber in the batch was skipped, check whether the query moved the checkpoint.
+	moved := false
+	if len(out) == 0 {
+		var after []runningCamp
+		if err := tx.Stmtx(s.queries.GetRunningCampaign).Select(&after, campID); err != nil {
+			return nil, false, err
+		}
+		moved = len(after) > 0 && after[0].LastSubscriberID > camps[0].LastSubscriberID
+	}
+
+	return out, moved, tx.Commit()
+}
+
+// updateSegmentAudiences updates the subscriber counts of campaigns that are sent to
+// segments, which the next-campaigns query only counts across lists.
+func (s *store) updateSegmentAudiences(camps []*models.Campaign) error {
+	if len(camps) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(camps))
+	for i, c := range camps {
+		ids[i] = c.ID
+	}
+
+	segs, err := s.core.GetCampaignSegments(ids)
+	if err != nil {
+		return err
+	}
+
+	campSegs := make(map[int][]models.Segment)
+	for _, sg := range segs {
+		campSegs[sg.CampaignID] = append(campSegs[sg.CampaignID], sg)
+	}
+
+	for _, c := range camps {
+		sg, ok := campSegs[c.ID]
+		if !ok {
+			continue
+		}
+
+		// If the segments can't be evaluated, the campaign fails while fetching subscribers.
+		toSend, _, err := s.core.UpdateCampaignAudience(c.ID, sg)
+		if err != nil {
+			lo.Printf("error counting segment subscribers of campaign (%s): %v", c.Name, err)
+			continue
+		}
+		c.ToSend = toSend
+	}
+
+	return nil
 }
 
 // GetCampaign fetches a campaign from the database.
@@ -75,8 +258,17 @@ func (s *store) GetCampaign(campID int) (*models.Campaign, error) {
 
 // UpdateCampaignStatus updates a campaign's status.
 func (s *store) UpdateCampaignStatus(campID int, status string) error {
-	_, err := s.queries.UpdateCampaignStatus.Exec(campID, status)
-	return err
+	if _, err := s.queries.UpdateCampaignStatus.Exec(campID, status); err != nil {
+		return err
+	}
+
+	if status == models.CampaignStatusFinished {
+		if c, err := s.core.GetCampaign(campID, "", ""); err == nil {
+			s.core.FireWebhooks(models.WebhookEventCampaignFinished, models.NewWebhookCampaign(c))
+		}
+	}
+
+	return nil
 }
 
 // UpdateCampaignCounts updates a campaign's status.
@@ -85,6 +277,188 @@ func (s *store) UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID
 	return err
 }
 
+// GetCampaignVariants fetches the A/B test variants of a campaign.
+func (s *store) GetCampaignVariants(campID int) ([]models.CampaignVariant, error) {
+	var out []models.CampaignVariant
+	err := s.queries.GetCampaignVariants.Select(&out, campID)
+	return out, err
+}
+
+// UpdateCampaignVariantStatus updates the A/B test status of a campaign.
+func (s *store) UpdateCampaignVariantStatus(campID int, status string, endsAt time.Time) error {
+	_, err := s.queries.UpdateCampaignVariantStatus.Exec(campID, status, endsAt)
+	return err
+}
+
+// GetVariantTestCampaigns fetches A/B tested campaigns whose test windows are over.
+func (s *store) GetVariantTestCampaigns() ([]models.Campaign, error) {
+	var out []models.Campaign
+	err := s.queries.GetVariantTestCampaigns.Select(&out)
+	return out, err
+}
+
+// UpdateCampaignVariantWinner sets the winning variant of an A/B tested campaign.
+func (s *store) UpdateCampaignVariantWinner(campID int, winnerID null.Int) error {
+	_, err := s.queries.UpdateCampaignVariantWinner.Exec(campID, winnerID)
+	return err
+}
+
+// ScheduleSubscribers schedules the given subscribers of a campaign for delivery
+// based on the campaign's delivery mode.
+func (s *store) ScheduleSubscribers(c *models.Campaign, subIDs []int) error {
+	if c.DeliveryMode == models.CampaignDeliveryLocalTime {
+		_, err := s.queries.ScheduleCampaignSubscribersLocalTime.Exec(c.ID, pq.Array(subIDs), c.DeliveryTime, c.DeliveryTimezone, models.CampaignTimezoneAttrib)
+		return err
+	}
+
+	window, _ := time.ParseDuration(c.DeliveryWindow)
+	_, err := s.queries.ScheduleCampaignSubscribers.Exec(c.ID, pq.Array(subIDs), window.Seconds(), c.DeliveryTimezone, models.CampaignTimezoneAttrib)
+	return err
+}
+
+// NextScheduledSubscribers retrieves a batch of subscribers of a campaign whose
+// scheduled delivery is due, removing them from the schedule.
+func (s *store) NextScheduledSubscribers(campID, limit int, instanceID string) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	err := s.queries.NextScheduledCampaignSubscribers.Select(&out, campID, limit, instanceID)
+	return out, err
+}
+
+// GetCampaignNextDelivery returns the earliest pending scheduled delivery of a campaign.
+func (s *store) GetCampaignNextDelivery(campID int) (null.Time, error) {
+	var out null.Time
+	err := s.queries.GetCampaignNextDelivery.Get(&out, campID)
+	return out, err
+}
+
+// DeferCampaign sets the time until which a running campaign is not processed.
+func (s *store) DeferCampaign(campID int, until time.Time) error {
+	_, err := s.queries.UpdateCampaignDeliveryNextAt.Exec(campID, until)
+	return err
+}
+
+// GetRecurringCampaigns returns scheduled recurring campaigns whose next occurrence is due.
+func (s *store) GetRecurringCampaigns() ([]*models.Campaign, error) {
+	var out []*models.Campaign
+	err := s.queries.GetRecurringCampaigns.Select(&out)
+	return out, err
+}
+
+// CloneRecurringCampaign clones a recurring campaign into a new campaign scheduled
+// at sendAt with the given feed items, and moves the recurring campaign from its
+// occurrence at prevAt to the next one. If the occurrence has already been cloned,
+// for instance, by another instance, it returns 0.
+func (s *store) CloneRecurringCampaign(campID int, name string, sendAt, prevAt, nextAt time.Time, feed models.Feed, feedLastAt null.Time, feedGUIDs []string) (int, error) {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	if err := s.queries.CloneRecurringCampaign.Get(&newID, campID, uu, name, sendAt, nextAt, feed, feedLastAt, prevAt, pq.Array(feedGUIDs)); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateCampaignRecurrence moves a recurring campaign from its occurrence at prevAt
+// (null if it hasn't been computed yet) to the next one.
+func (s *store) UpdateCampaignRecurrence(campID int, prevAt null.Time, nextAt time.Time) error {
+	_, err := s.queries.UpdateCampaignRecurrenceNextAt.Exec(campID, nextAt, prevAt)
+	return err
+}
+
+// RecordDeliveries upserts the latest delivery statuses of campaign messages.
+func (s *store) RecordDeliveries(d []models.CampaignDelivery) error {
+	var (
+		campIDs    = make([]int, len(d))
+		subIDs     = make([]int, len(d))
+		statuses   = make([]string, len(d))
+		messengers = make([]string, len(d))
+		errs       = make([]string, len(d))
+		instances  = make([]string, len(d))
+		times      = make([]time.Time, len(d))
+	)
+	for i, v := range d {
+		campIDs[i] = v.CampaignID
+		subIDs[i] = v.SubscriberID
+		statuses[i] = v.Status
+		messengers[i] = v.Messenger
+		errs[i] = v.Error
+		instances[i] = v.InstanceID
+		times[i] = v.UpdatedAt
+	}
+
+	_, err := s.queries.RecordCampaignDeliveries.Exec(pq.Array(campIDs), pq.Array(subIDs),
+		pq.Array(statuses), pq.Array(messengers), pq.Array(errs), pq.Array(instances), pq.Array(times))
+	return err
+}
+
+// ClaimSubscribers claims a batch of unacknowledged messages of a campaign that were
+// queued by instances that are no longer processing it, and returns their subscribers.
+func (s *store) ClaimSubscribers(campID int, instanceID string, limit int) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	err := s.queries.ClaimCampaignDeliveries.Select(&out, campID, instanceID, limit)
+	return out, err
+}
+
+// RenewLease acquires or extends an instance's lease on a campaign.
+func (s *store) RenewLease(campID int, instanceID string, ttl time.Duration) error {
+	_, err := s.queries.RenewCampaignLease.Exec(campID, instanceID, ttl.Seconds())
+	return err
+}
+
+// ReleaseLease releases an instance's lease on a campaign.
+func (s *store) ReleaseLease(campID int, instanceID string) error {
+	_, err := s.queries.ReleaseCampaignLease.Exec(campID, instanceID)
+	return err
+}
+
+// HasOtherInstances checks whether instances other than the given one are
+// processing a campaign.
+func (s *store) HasOtherInstances(campID int, instanceID string) (bool, error) {
+	var out bool
+	err := s.queries.CampaignHasOtherInstances.Get(&out, campID, instanceID)
+	return out, err
+}
+
+// ReserveSMTPVolume reserves up to n messages from the daily volume of an SMTP server
+// capped at limit and returns the number of messages reserved.
+func (s *store) ReserveSMTPVolume(server, day string, n, limit int) (int, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var sent int
+	if err := tx.Stmtx(s.queries.LockSMTPVolume).Get(&sent, server, day); err != nil {
+		return 0, err
+	}
+
+	n = min(n, max(limit-sent, 0))
+	if n == 0 {
+		return 0, nil
+	}
+
+	if _, err := tx.Stmtx(s.queries.UpdateSMTPVolume).Exec(server, day, n); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// GetSMTPVolume returns the number of messages reserved from the daily volume of an SMTP server.
+func (s *store) GetSMTPVolume(server, day string) (int, error) {
+	var out int
+	err := s.queries.GetSMTPVolume.Get(&out, server, day)
+	return out, err
+}
+
 // GetAttachment fetches a media attachment blob.
 func (s *store) GetAttachment(mediaID int) (models.Attachment, error) {
 	m, err := s.core.GetMedia(mediaID, "", "", s.media)
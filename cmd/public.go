@@ -573,7 +573,10 @@ func (a *App) LinkRedirect(c echo.Context) error {
 		subUUID = ""
 	}
 
-	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID)
+	// Optional A/B test variant the link was sent in.
+	variantID, _ := strconv.Atoi(c.QueryParam("v"))
+
+	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID, variantID)
 	if err != nil {
 		e := err.(*echo.HTTPError)
 		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
@@ -602,7 +605,10 @@ func (a *App) RegisterCampaignView(c echo.Context) error {
 	// Exclude dummy hits from template previews.
 	campUUID := c.Param("campUUID")
 	if campUUID != dummyUUID && subUUID != dummyUUID {
-		if err := a.core.RegisterCampaignView(campUUID, subUUID); err != nil {
+		// Optional A/B test variant the message was sent in.
+		variantID, _ := strconv.Atoi(c.QueryParam("v"))
+
+		if err := a.core.RegisterCampaignView(campUUID, subUUID, variantID); err != nil {
 			a.log.Printf("error registering campaign view: %s", err)
 		}
 	}
@@ -679,7 +685,7 @@ func (a *App) WipeSubscriberData(c echo.Context) error {
 	}
 
 	subUUID := c.Param("subUUID")
-	if err := a.core.DeleteSubscribers(nil, []string{subUUID}); err != nil {
+	if err := a.core.WipeSubscriber(subUUID); err != nil {
 		a.log.Printf("error wiping subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/conditions"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetSegments handles retrieval of segments. Users without permissions on all
+// lists only get the segments that are restricted to their lists.
+func (a *App) GetSegments(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	hasAllPerm, permittedLists := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	out, err := a.core.GetSegments(hasAllPerm, permittedLists)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSegment handles the retrieval of a segment.
+func (a *App) GetSegment(c echo.Context) error {
+	out, err := a.core.GetSegment(getID(c))
+	if err != nil {
+		return err
+	}
+
+	if err := a.checkSegmentPerm(auth.PermTypeGet, out.GetListIDs(), c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateSegment handles segment creation.
+func (a *App) CreateSegment(c echo.Context) error {
+	var o models.Segment
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := a.validateSegment(o, c)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.CreateSegment(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateSegment handles segment modification.
+func (a *App) UpdateSegment(c echo.Context) error {
+	id := getID(c)
+
+	// The user should have access to the segment's existing lists as well.
+	seg, err := a.core.GetSegment(id)
+	if err != nil {
+		return err
+	}
+	if err := a.checkSegmentPerm(auth.PermTypeManage, seg.GetListIDs(), c); err != nil {
+		return err
+	}
+
+	var o models.Segment
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err = a.validateSegment(o, c)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateSegment(id, o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteSegment handles segment deletion.
+func (a *App) DeleteSegment(c echo.Context) error {
+	id := getID(c)
+
+	seg, err := a.core.GetSegment(id)
+	if err != nil {
+		return err
+	}
+	if err := a.checkSegmentPerm(auth.PermTypeManage, seg.GetListIDs(), c); err != nil {
+		return err
+	}
+
+	if err := a.core.DeleteSegment(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// RefreshSegmentCount handles recounting the subscribers in a segment.
+func (a *App) RefreshSegmentCount(c echo.Context) error {
+	id := getID(c)
+
+	seg, err := a.core.GetSegment(id)
+	if err != nil {
+		return err
+	}
+	if err := a.checkSegmentPerm(auth.PermTypeGet, seg.GetListIDs(), c); err != nil {
+		return err
+	}
+
+	out, err := a.core.RefreshSegmentCount(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// PreviewSegment handles counting the subscribers that match a segment's query,
+// conditions, and lists without saving it, for previewing segments while building them.
+func (a *App) PreviewSegment(c echo.Context) error {
+	var o models.Segment
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := a.prepareSegmentQuery(o, c)
+	if err != nil {
+		return err
+	}
+
+	if err := a.checkSegmentPerm(auth.PermTypeGet, o.GetListIDs(), c); err != nil {
+		return err
+	}
+
+	count, err := a.core.CountSegment(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{count}})
+}
+
+// validateSegment validates segment fields and checks whether the user
+// can create a segment with the given query and lists.
+func (a *App) validateSegment(o models.Segment, c echo.Context) (models.Segment, error) {
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.invalidName"))
+	}
+	if len(o.Description) > stdInputMaxLen {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "description"))
+	}
+
+	o, err := a.prepareSegmentQuery(o, c)
+	if err != nil {
+		return o, err
+	}
+
+	if err := a.checkSegmentPerm(auth.PermTypeManage, o.GetListIDs(), c); err != nil {
+		return o, err
+	}
+
+	return o, nil
+}
+
+// prepareSegmentQuery sanitizes a segment's query, conditions, and lists, and checks
+// whether the user can use the query.
+func (a *App) prepareSegmentQuery(o models.Segment, c echo.Context) (models.Segment, error) {
+	// Required for pq.Array()
+	if o.ListIDs == nil {
+		o.ListIDs = pq.Int64Array{}
+	}
+
+	// Conditions are validated when they're compiled.
+	if cond, err := conditions.Parse(o.Conditions); err != nil {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
+	} else if cond.IsEmpty() {
+		o.Conditions = types.JSONText(`{}`)
+	}
+
+	// Queries are arbitrary SQL expressions and require the subscribers:sql_query permission.
+	// Conditions are compiled into parameterized SQL and don't.
+	user := auth.GetUser(c)
+	o.Query = formatSQLExp(o.Query)
+	if o.Query != "" && !user.HasPerm(auth.PermSubscribersSqlQuery) {
+		return o, echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermSubscribersSqlQuery))
+	}
+
+	return o, nil
+}
+
+// checkSegmentPerm checks if the user has get or manage access to a segment, that is,
+// to all the lists that it's restricted to. Segments that aren't restricted to any
+// list span all subscribers and require access to all lists.
+func (a *App) checkSegmentPerm(types auth.PermType, listIDs []int, c echo.Context) error {
+	user := auth.GetUser(c)
+
+	if len(listIDs) > 0 {
+		return user.HasListPerm(types, listIDs...)
+	}
+
+	if hasAllPerm, _ := user.GetPermittedLists(types); !hasAllPerm {
+		perm := auth.PermListGetAll
+		if types&auth.PermTypeGet == 0 {
+			perm = auth.PermListManageAll
+		}
+
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", perm))
+	}
+
+	return nil
+}
+
+// filterSegmentsByPerm filters the given segment IDs to the ones that the user
+// has access to, for use as campaign audiences.
+func (a *App) filterSegmentsByPerm(user auth.User, ids []int) ([]int, error) {
+	out := []int{}
+	if len(ids) == 0 || !user.HasPerm(auth.PermSegmentsGet) {
+		return out, nil
+	}
+
+	hasAllPerm, permittedLists := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	segs, err := a.core.GetSegments(hasAllPerm, permittedLists)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range segs {
+		if slices.Contains(ids, s.ID) {
+			out = append(out, s.ID)
+		}
+	}
+
+	return out, nil
+}
+
+// getCampaignSegmentIDs returns the IDs of the (undeleted) segments of a campaign.
+func getCampaignSegmentIDs(c models.Campaign) []int {
+	var segs []struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(c.Segments, &segs); err != nil {
+		return nil
+	}
+
+	out := make([]int, 0, len(segs))
+	for _, s := range segs {
+		if s.ID > 0 {
+			out = append(out, s.ID)
+		}
+	}
+
+	return out
+}
@@ -20,7 +20,10 @@ import (
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/messenger/emailapi"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
@@ -68,9 +71,18 @@ func (a *App) GetSettings(c echo.Context) error {
 	for i := range s.BounceBoxes {
 		s.BounceBoxes[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceBoxes[i].Password))
 	}
+	for i := range s.BounceWebhooks {
+		s.BounceWebhooks[i].AuthSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceWebhooks[i].AuthSecret))
+	}
 	for i := range s.Messengers {
 		s.Messengers[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].Password))
 	}
+	for i := range s.EmailAPIs {
+		s.EmailAPIs[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.EmailAPIs[i].Password))
+	}
+	for i := range s.DKIM {
+		s.DKIM[i].PrivateKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.DKIM[i].PrivateKey))
+	}
 
 	s.UploadS3AwsSecretAccessKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadS3AwsSecretAccessKey))
 	s.SendgridKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SendgridKey))
@@ -167,6 +179,63 @@ func (a *App) UpdateSettings(c echo.Context) error {
 			}
 		}
 		set.SMTP[i].FromAddresses = addrs
+
+		// Weighted routing and the warm-up schedule.
+		if s.Weight < 1 {
+			set.SMTP[i].Weight = 1
+		}
+		for _, n := range s.WarmupSchedule {
+			if n < 1 {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "warmup_schedule"))
+			}
+		}
+		if len(s.WarmupSchedule) > 0 {
+			if s.WarmupStart == "" {
+				set.SMTP[i].WarmupStart = time.Now().Format("2006-01-02")
+			} else if _, err := time.Parse("2006-01-02", s.WarmupStart); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "warmup_start"))
+			}
+		}
+	}
+
+	// DKIM keys.
+	domains := map[string]bool{}
+	for i, d := range set.DKIM {
+		// UUID to keep track of private key changes similar to the SMTP logic above.
+		if d.UUID == "" {
+			set.DKIM[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+
+		if d.PrivateKey == "" {
+			for _, c := range cur.DKIM {
+				if d.UUID == c.UUID {
+					set.DKIM[i].PrivateKey = c.PrivateKey
+				}
+			}
+		}
+
+		domain := strings.ToLower(strings.TrimSpace(d.Domain))
+		set.DKIM[i].Domain = domain
+		set.DKIM[i].Selector = strings.TrimSpace(d.Selector)
+		if !d.Enabled {
+			continue
+		}
+
+		if domains[domain] {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("settings.dkim.duplicateDomain", "name", domain))
+		}
+		domains[domain] = true
+
+		err := email.ValidateDKIM(email.DKIM{
+			Domain:           domain,
+			Selector:         set.DKIM[i].Selector,
+			PrivateKey:       set.DKIM[i].PrivateKey,
+			Headers:          d.Headers,
+			Canonicalization: d.Canonicalization,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("settings.dkim.invalid", "name", domain, "error", err.Error()))
+		}
 	}
 
 	// Always remove the trailing slash from the app root URL.
@@ -190,6 +259,27 @@ func (a *App) UpdateSettings(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.bounces.invalidScanInterval"))
 		}
 
+		// IMAP options.
+		if s.Type == "imap" {
+			set.BounceBoxes[i].Folder = strings.TrimSpace(s.Folder)
+			if set.BounceBoxes[i].Folder == "" {
+				set.BounceBoxes[i].Folder = "INBOX"
+			}
+
+			switch s.Action {
+			case "":
+				set.BounceBoxes[i].Action = mailbox.ActionFlag
+			case mailbox.ActionFlag, mailbox.ActionDelete:
+			case mailbox.ActionMove:
+				set.BounceBoxes[i].MoveFolder = strings.TrimSpace(s.MoveFolder)
+				if set.BounceBoxes[i].MoveFolder == "" || set.BounceBoxes[i].MoveFolder == set.BounceBoxes[i].Folder {
+					return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.bounces.invalidMoveFolder"))
+				}
+			default:
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "action"))
+			}
+		}
+
 		// If there's no password coming in from the frontend, copy the existing
 		// password by matching the UUID.
 		if s.Password == "" {
@@ -201,6 +291,69 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		}
 	}
 
+	// Bounce webhooks of other services. Names can't be those of the native webhooks.
+	hookNames := map[string]bool{"ses": true, "azure": true, "sendgrid": true, "postmark": true, "forwardemail": true, "lettermint": true}
+	for i, w := range set.BounceWebhooks {
+		// UUID to keep track of secret changes similar to the SMTP logic above.
+		if w.UUID == "" {
+			set.BounceWebhooks[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+
+		if w.AuthSecret == "" {
+			for _, c := range cur.BounceWebhooks {
+				if w.UUID == c.UUID {
+					set.BounceWebhooks[i].AuthSecret = c.AuthSecret
+				}
+			}
+		}
+
+		name := reAlphaNum.ReplaceAllString(strings.ToLower(w.Name), "")
+		if len(name) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+		}
+		if _, ok := hookNames[name]; ok {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("settings.bounces.duplicateWebhookName", "name", name))
+		}
+		set.BounceWebhooks[i].Name = name
+		hookNames[name] = true
+
+		// Validate the auth scheme, paths, and type map.
+		w = set.BounceWebhooks[i]
+		if _, err := webhooks.NewGeneric(webhooks.GenericOpt{
+			Name:             w.Name,
+			AuthType:         w.AuthType,
+			AuthHeader:       w.AuthHeader,
+			AuthUsername:     w.AuthUsername,
+			AuthSecret:       w.AuthSecret,
+			RecordsPath:      w.RecordsPath,
+			EmailPath:        w.EmailPath,
+			TypePath:         w.TypePath,
+			CampaignUUIDPath: w.CampaignUUIDPath,
+			MetaPath:         w.MetaPath,
+			TypeMap:          w.TypeMap,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("settings.bounces.invalidWebhook", "name", name, "error", err.Error()))
+		}
+	}
+
+	// Bounce actions.
+	for typ, b := range set.BounceActions {
+		switch b.Action {
+		case "none", "unsubscribe", "blocklist", "delete", "suppress":
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", typ))
+		}
+
+		if b.Count < 1 || b.Days < 0 || b.Campaigns < 0 || b.SuppressDays < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", typ))
+		}
+		if b.Action == "suppress" && b.SuppressDays < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("settings.bounces.invalidSuppressDays", "name", typ))
+		}
+	}
+
 	for i, m := range set.Messengers {
 		// UUID to keep track of password changes similar to the SMTP logic above.
 		if m.UUID == "" {
@@ -228,6 +381,66 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		names[name] = true
 	}
 
+	for i, m := range set.EmailAPIs {
+		// UUID to keep track of password changes similar to the SMTP logic above.
+		if m.UUID == "" {
+			set.EmailAPIs[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+
+		if m.Password == "" {
+			for _, c := range cur.EmailAPIs {
+				if m.UUID == c.UUID {
+					set.EmailAPIs[i].Password = c.Password
+				}
+			}
+		}
+
+		name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
+		if _, ok := names[name]; ok {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("settings.duplicateMessengerName", "name", name))
+		}
+		if len(name) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.invalidMessengerName"))
+		}
+
+		set.EmailAPIs[i].Name = name
+		names[name] = true
+
+		// Default durations, as empty values can't be parsed.
+		for _, d := range []struct {
+			name string
+			val  *string
+			def  string
+		}{
+			{"batch_wait", &set.EmailAPIs[i].BatchWait, "100ms"},
+			{"retry_delay", &set.EmailAPIs[i].RetryDelay, "500ms"},
+			{"timeout", &set.EmailAPIs[i].Timeout, "5s"},
+		} {
+			if strings.TrimSpace(*d.val) == "" {
+				*d.val = d.def
+			} else if _, err := time.ParseDuration(*d.val); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", d.name))
+			}
+		}
+
+		if !m.Enabled {
+			continue
+		}
+
+		err := emailapi.Validate(emailapi.Options{
+			Provider: m.Provider,
+			RootURL:  strings.TrimSpace(m.RootURL),
+			Username: m.Username,
+			Password: set.EmailAPIs[i].Password,
+			Region:   m.Region,
+			Template: m.Template,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("settings.emailAPIs.invalid", "name", name, "error", err.Error()))
+		}
+	}
+
 	// S3 password?
 	if set.UploadS3AwsSecretAccessKey == "" {
 		set.UploadS3AwsSecretAccessKey = cur.UploadS3AwsSecretAccessKey
@@ -303,6 +516,19 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 	set.SecurityTrustedURLs = urls
 
+	// Per-domain send limits.
+	for i, t := range set.AppDomainThrottles {
+		set.AppDomainThrottles[i].Domain = strings.TrimSpace(strings.ToLower(t.Domain))
+		if set.AppDomainThrottles[i].Domain == "" || t.Rate < 0 || t.Concurrency < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.performance.invalidDomainThrottle"))
+		}
+		if t.Duration == "" {
+			set.AppDomainThrottles[i].Duration = "1s"
+		} else if d, err := time.ParseDuration(t.Duration); err != nil || d < time.Second {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.performance.invalidDomainThrottle"))
+		}
+	}
+
 	// Validate slow query caching cron.
 	if set.CacheSlowQueries {
 		if _, err := cron.ParseStandard(set.CacheSlowQueriesInterval); err != nil {
@@ -399,7 +625,7 @@ func (a *App) TestSMTPSettings(c echo.Context) error {
 	req.MaxConns = 1
 	req.IdleTimeout = time.Second * 2
 	req.PoolWaitTimeout = time.Second * 2
-	msgr, err := email.New("", req)
+	msgr, err := email.New("", nil, nil, req)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			a.i18n.Ts("globals.messages.errorCreating", "name", "SMTP", "error", err.Error()))
@@ -424,6 +650,23 @@ func (a *App) TestSMTPSettings(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{a.bufLog.Lines()})
 }
 
+// GetSMTPHealth returns the health of the SMTP servers of the e-mail messengers.
+func (a *App) GetSMTPHealth(c echo.Context) error {
+	type msgrHealth struct {
+		Messenger string         `json:"messenger"`
+		Servers   []email.Health `json:"servers"`
+	}
+
+	out := []msgrHealth{}
+	for _, m := range a.messengers {
+		if e, ok := m.(*email.Emailer); ok {
+			out = append(out, msgrHealth{Messenger: e.Name(), Servers: e.Health()})
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 func (a *App) GetAboutInfo(c echo.Context) error {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/utils"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Number of CSV rows that are upserted at a time in suppression imports.
+const suppressionImportBatchSize = 1000
+
+// suppressionReq is a suppression create/import request. Either the plaintext
+// e-mail or domain (value) which is hashed, or its hash, is required.
+type suppressionReq struct {
+	Type      string    `json:"type"`
+	Value     string    `json:"value"`
+	Hash      string    `json:"hash"`
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source"`
+	ExpiresAt null.Time `json:"expires_at"`
+}
+
+// GetSuppressions handles retrieval of suppressions. The `value` param
+// (an e-mail or domain) looks up its suppressions as they're stored hashed.
+func (a *App) GetSuppressions(c echo.Context) error {
+	var (
+		value   = strings.TrimSpace(c.FormValue("value"))
+		typ     = c.FormValue("type")
+		source  = c.FormValue("source")
+		orderBy = c.FormValue("order_by")
+		order   = c.FormValue("order")
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	res, total, err := a.core.QuerySuppressions(value, typ, source, orderBy, order, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// No results.
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.Suppression{}}})
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSuppression handles retrieval of a suppression by ID.
+func (a *App) GetSuppression(c echo.Context) error {
+	out, err := a.core.GetSuppression(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateSuppression handles the creation of a suppression. If the e-mail or
+// domain is already suppressed, its reason, source, and expiry are updated.
+func (a *App) CreateSuppression(c echo.Context) error {
+	var req suppressionReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	s, err := a.validateSuppression(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	out, err := a.core.UpsertSuppression(s)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateSuppression handles the update of a suppression's reason, source, and expiry.
+func (a *App) UpdateSuppression(c echo.Context) error {
+	var req suppressionReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateSuppression(getID(c), models.Suppression{
+		Reason:    strings.TrimSpace(req.Reason),
+		Source:    strings.TrimSpace(req.Source),
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteSuppressions handles the deletion of one or more suppressions, or all of them.
+func (a *App) DeleteSuppressions(c echo.Context) error {
+	all, _ := strconv.ParseBool(c.QueryParam("all"))
+
+	var ids []int
+	if !all {
+		// There are multiple IDs in the query string.
+		res, err := parseStringIDs(c.Request().URL.Query()["id"])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidID", "error", err.Error()))
+		}
+		if len(res) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidID"))
+		}
+
+		ids = res
+	}
+
+	if err := a.core.DeleteSuppressions(ids, all); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// DeleteSuppression handles the deletion of a single suppression.
+func (a *App) DeleteSuppression(c echo.Context) error {
+	if err := a.core.DeleteSuppressions([]int{getID(c)}, false); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// ImportSuppressions handles the bulk import of suppressions from an uploaded CSV
+// file with a header row. The columns are type, value or hash, reason, source,
+// and expires_at (RFC3339), of which only value or hash is required.
+func (a *App) ImportSuppressions(c echo.Context) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	rd := csv.NewReader(src)
+	rd.FieldsPerRecord = -1
+	rd.TrimLeadingSpace = true
+
+	// Map the header columns to their positions.
+	hdr, err := rd.Read()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+	cols := make(map[string]int, len(hdr))
+	for i, h := range hdr {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["value"]; !ok {
+		if _, ok := cols["hash"]; !ok {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.invalidFile", "error", "value or hash column is required"))
+		}
+	}
+
+	col := func(row []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var (
+		batch = make([]models.Suppression, 0, suppressionImportBatchSize)
+		total = 0
+		line  = 1
+	)
+	for {
+		row, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.invalidFile", "error", err.Error()))
+		}
+
+		req := suppressionReq{
+			Type:   col(row, "type"),
+			Value:  col(row, "value"),
+			Hash:   col(row, "hash"),
+			Reason: col(row, "reason"),
+			Source: col(row, "source"),
+		}
+		if v := col(row, "expires_at"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("import.invalidFile", "error", "line "+strconv.Itoa(line)+": "+err.Error()))
+			}
+			req.ExpiresAt = null.TimeFrom(t)
+		}
+
+		s, err := a.validateSuppression(req)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.invalidFile", "error", "line "+strconv.Itoa(line)+": "+err.Error()))
+		}
+		batch = append(batch, s)
+
+		if len(batch) >= suppressionImportBatchSize {
+			if err := a.core.ImportSuppressions(batch); err != nil {
+				return err
+			}
+			total += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := a.core.ImportSuppressions(batch); err != nil {
+			return err
+		}
+		total += len(batch)
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{total}})
+}
+
+// ExportSuppressions handles the streaming export of all suppressions as CSV.
+// As e-mails and domains are stored hashed, only their hashes are exported.
+func (a *App) ExportSuppressions(c echo.Context) error {
+	var (
+		hdr = c.Response().Header()
+		wr  = csv.NewWriter(c.Response())
+	)
+
+	hdr.Set(echo.HeaderContentType, "text/csv")
+	hdr.Set(echo.HeaderContentDisposition, "attachment; filename="+"suppressions.csv")
+	hdr.Set("Content-Transfer-Encoding", "binary")
+	hdr.Set("Cache-Control", "no-cache")
+	wr.Write([]string{"type", "hash", "reason", "source", "expires_at", "created_at"})
+
+	// Iterate in batches until there are no more suppressions to export.
+	for offset := 0; ; offset += a.cfg.DBBatchSize {
+		out, _, err := a.core.QuerySuppressions("", "", "", "id", "asc", offset, a.cfg.DBBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(out) == 0 {
+			break
+		}
+
+		for _, s := range out {
+			exp := ""
+			if s.ExpiresAt.Valid {
+				exp = s.ExpiresAt.Time.Format(time.RFC3339)
+			}
+
+			if err := wr.Write([]string{s.Type, s.Hash, s.Reason, s.Source, exp, s.CreatedAt.Format(time.RFC3339)}); err != nil {
+				a.log.Printf("error streaming CSV export: %v", err)
+				return nil
+			}
+		}
+
+		// Flush CSV to stream after each batch.
+		wr.Flush()
+	}
+
+	return nil
+}
+
+// validateSuppression validates a suppression request and returns the suppression
+// with the hash of its e-mail or domain. If the type isn't given, it's inferred
+// from the value.
+func (a *App) validateSuppression(r suppressionReq) (models.Suppression, error) {
+	r.Type = strings.ToLower(strings.TrimSpace(r.Type))
+	r.Value = strings.ToLower(strings.TrimSpace(r.Value))
+	r.Hash = strings.ToLower(strings.TrimSpace(r.Hash))
+
+	if r.Type == "" && r.Value != "" {
+		if strings.Contains(r.Value, "@") {
+			r.Type = models.SuppressionTypeEmail
+		} else {
+			r.Type = models.SuppressionTypeDomain
+		}
+	}
+
+	switch r.Type {
+	case models.SuppressionTypeEmail:
+		if r.Value != "" {
+			if _, err := utils.SanitizeEmail(r.Value); err != nil {
+				return models.Suppression{}, errors.New(a.i18n.T("subscribers.invalidEmail"))
+			}
+		}
+	case models.SuppressionTypeDomain:
+		if r.Value != "" && (strings.ContainsAny(r.Value, "@ ") || !strings.Contains(r.Value, ".")) {
+			return models.Suppression{}, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "value"))
+		}
+	default:
+		return models.Suppression{}, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	hash := r.Hash
+	if r.Value != "" {
+		hash = utils.HashSuppression(r.Value)
+	} else if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+		return models.Suppression{}, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "hash"))
+	}
+
+	return models.Suppression{
+		Type:      r.Type,
+		Hash:      hash,
+		Reason:    strings.TrimSpace(r.Reason),
+		Source:    strings.TrimSpace(r.Source),
+		ExpiresAt: r.ExpiresAt,
+	}, nil
+}
@@ -128,6 +128,14 @@ func (a *App) SendTxMessage(c echo.Context) error {
 			}
 		}
 
+		// Skip suppressed e-mails and domains.
+		if ok, err := a.core.IsSuppressed(sub.Email); err != nil {
+			return err
+		} else if ok {
+			notFound = append(notFound, fmt.Sprintf("%s: %s", sub.Email, a.i18n.T("subscribers.emailSuppressed")))
+			continue
+		}
+
 		// Render the message.
 		if err := m.Render(sub, tpl, a.manager.GenericTemplateFuncs()); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest,
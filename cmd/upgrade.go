@@ -45,6 +45,7 @@ var migList = []migFunc{
 	{"v6.0.0", migrations.V6_0_0},
 	{"v6.1.0", migrations.V6_1_0},
 	{"v6.2.0", migrations.V6_2_0},
+	{"v7.0.0", migrations.V7_0_0},
 }
 
 // upgrade upgrades the database to the current version by running SQL migration files
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Number of times a failed delivery is retried if max_retries isn't set.
+const defaultWebhookRetries = 5
+
+// GetWebhooks handles retrieval of webhooks.
+func (a *App) GetWebhooks(c echo.Context) error {
+	out, err := a.core.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetWebhook handles the retrieval of a webhook.
+func (a *App) GetWebhook(c echo.Context) error {
+	out, err := a.core.GetWebhook(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateWebhook handles webhook creation.
+func (a *App) CreateWebhook(c echo.Context) error {
+	o := models.Webhook{MaxRetries: defaultWebhookRetries}
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := a.validateWebhook(o)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	out, err := a.core.CreateWebhook(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateWebhook handles webhook modification.
+func (a *App) UpdateWebhook(c echo.Context) error {
+	o := models.Webhook{MaxRetries: defaultWebhookRetries}
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := a.validateWebhook(o)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	out, err := a.core.UpdateWebhook(getID(c), o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteWebhook handles webhook deletion.
+func (a *App) DeleteWebhook(c echo.Context) error {
+	if err := a.core.DeleteWebhook(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// GetWebhookDeliveries handles retrieval of a webhook's delivery log.
+func (a *App) GetWebhookDeliveries(c echo.Context) error {
+	var (
+		id     = getID(c)
+		status = c.FormValue("status")
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	switch status {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliverySuccess, models.WebhookDeliveryFailed:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "status"))
+	}
+
+	res, total, err := a.core.QueryWebhookDeliveries(id, status, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RetryWebhookDeliveries handles re-queueing the given deliveries of a webhook,
+// or all of its failed deliveries if none are given.
+func (a *App) RetryWebhookDeliveries(c echo.Context) error {
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	n, err := a.core.RetryWebhookDeliveries(getID(c), req.IDs)
+	if err != nil {
+		return err
+	}
+
+	out := struct {
+		Count int `json:"count"`
+	}{n}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateWebhook validates webhook fields and fills in defaults.
+func (a *App) validateWebhook(o models.Webhook) (models.Webhook, error) {
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, errors.New(a.i18n.T("campaigns.fieldInvalidName"))
+	}
+
+	o.URL = strings.TrimSpace(o.URL)
+	if u, err := url.Parse(o.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return o, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "url"))
+	}
+
+	if o.Status == "" {
+		o.Status = models.WebhookStatusActive
+	}
+	if o.Status != models.WebhookStatusActive && o.Status != models.WebhookStatusDisabled {
+		return o, errors.New(a.i18n.T("webhooks.fieldInvalidStatus"))
+	}
+
+	if len(o.Events) == 0 {
+		return o, errors.New(a.i18n.T("webhooks.fieldInvalidEvents"))
+	}
+	for _, e := range o.Events {
+		if !inArray(e, models.WebhookEvents) {
+			return o, errors.New(a.i18n.Ts("webhooks.fieldInvalidEvent", "name", e))
+		}
+	}
+
+	if o.MaxRetries < 0 || o.MaxRetries > 20 {
+		return o, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "max_retries"))
+	}
+
+	return o, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetWorkflows handles retrieval of workflows.
+func (a *App) GetWorkflows(c echo.Context) error {
+	out, err := a.core.GetWorkflows()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetWorkflow handles the retrieval of a workflow.
+func (a *App) GetWorkflow(c echo.Context) error {
+	out, err := a.core.GetWorkflow(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateWorkflow handles workflow creation.
+func (a *App) CreateWorkflow(c echo.Context) error {
+	var o models.Workflow
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := a.validateWorkflow(o, c)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.CreateWorkflow(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateWorkflow handles workflow modification.
+func (a *App) UpdateWorkflow(c echo.Context) error {
+	var o models.Workflow
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := a.validateWorkflow(o, c)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateWorkflow(getID(c), o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteWorkflow handles workflow deletion.
+func (a *App) DeleteWorkflow(c echo.Context) error {
+	if err := a.core.DeleteWorkflow(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateWorkflow validates workflow fields, fills in defaults, and checks whether
+// the user can use the steps' conditions.
+func (a *App) validateWorkflow(o models.Workflow, c echo.Context) (models.Workflow, error) {
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.fieldInvalidName"))
+	}
+
+	if o.Status == "" {
+		o.Status = models.WorkflowStatusActive
+	}
+	if o.Status != models.WorkflowStatusActive && o.Status != models.WorkflowStatusDisabled {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("workflows.fieldInvalidStatus"))
+	}
+
+	if o.Trigger == "" {
+		o.Trigger = models.WorkflowTriggerSubscribe
+	}
+	if o.Trigger != models.WorkflowTriggerSubscribe && o.Trigger != models.WorkflowTriggerConfirm {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("workflows.fieldInvalidTrigger"))
+	}
+
+	if o.ListID < 1 {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "list_id"))
+	}
+
+	if len(o.Steps) == 0 {
+		return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("workflows.fieldInvalidSteps"))
+	}
+
+	user := auth.GetUser(c)
+	for n, s := range o.Steps {
+		num := strconv.Itoa(n + 1)
+
+		// An empty delay runs the step immediately.
+		if s.Delay != "" {
+			if d, err := time.ParseDuration(s.Delay); err != nil || d < 0 {
+				return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("workflows.fieldInvalidDelay", "num", num))
+			}
+		}
+
+		if _, err := a.manager.GetTpl(s.TemplateID); err != nil {
+			return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("workflows.fieldInvalidTemplate", "num", num))
+		}
+
+		if s.Messenger == "" {
+			s.Messenger = emailMsgr
+		} else if !a.manager.HasMessenger(s.Messenger) {
+			return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("campaigns.fieldInvalidMessenger", "name", s.Messenger))
+		}
+
+		// Conditions are arbitrary SQL expressions and require the subscribers:sql_query
+		// permission. They're validated by running them.
+		s.Condition = formatSQLExp(s.Condition)
+		if s.Condition != "" {
+			if !user.HasPerm(auth.PermSubscribersSqlQuery) {
+				return o, echo.NewHTTPError(http.StatusForbidden,
+					a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermSubscribersSqlQuery))
+			}
+
+			if _, err := a.core.MatchSubscriber(0, s.Condition); err != nil {
+				return o, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
+			}
+		}
+
+		o.Steps[n] = s
+	}
+
+	return o, nil
+}
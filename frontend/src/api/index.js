@@ -204,6 +204,11 @@ export const blocklistBouncedSubscribers = async () => http.put(
   { loading: models.bounces },
 );
 
+export const getBounceMailboxes = async () => http.get(
+  '/api/bounces/mailboxes',
+  { camelCase: true },
+);
+
 export const createSubscriber = (data) => http.post(
   '/api/subscribers',
   data,
@@ -470,6 +475,12 @@ export const deleteGCSubscriptions = async (beforeDate) => http.delete(
   { loading: models.maintenance, params: { before_date: beforeDate } },
 );
 
+export const updateEngagement = async () => http.post(
+  '/api/maintenance/engagement',
+  {},
+  { loading: models.maintenance },
+);
+
 // Users.
 export const getUsers = () => http.get(
   '/api/users',
@@ -52,6 +52,8 @@ const (
 	PermSubscribersImport     = "subscribers:import"
 	PermSubscribersSqlQuery   = "subscribers:sql_query"
 	PermTxSend                = "tx:send"
+	PermSegmentsGet           = "segments:get"
+	PermSegmentsManage        = "segments:manage"
 	PermCampaignsGet          = "campaigns:get"
 	PermCampaignsGetAll       = "campaigns:get_all"
 	PermCampaignsGetAnalytics = "campaigns:get_analytics"
@@ -61,6 +63,10 @@ const (
 	PermBouncesGet            = "bounces:get"
 	PermBouncesManage         = "bounces:manage"
 	PermWebhooksPostBounce    = "webhooks:post_bounce"
+	PermWorkflowsGet          = "workflows:get"
+	PermWorkflowsManage       = "workflows:manage"
+	PermWebhooksGet           = "webhooks:get"
+	PermWebhooksManage        = "webhooks:manage"
 	PermMediaGet              = "media:get"
 	PermMediaManage           = "media:manage"
 	PermTemplatesGet          = "templates:get"
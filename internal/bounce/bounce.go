@@ -1,8 +1,9 @@
 package bounce
 
 import (
-	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -17,18 +18,32 @@ type Mailbox interface {
 	Scan(limit int, ch chan models.Bounce) error
 }
 
+// Idler is implemented by mailboxes that can wait for new messages to arrive
+// instead of being polled at intervals.
+type Idler interface {
+	Idle(timeout time.Duration) error
+}
+
+// Bounce mailbox scan statuses.
+const (
+	MailboxStatusScanning = "scanning"
+	MailboxStatusWaiting  = "waiting"
+	MailboxStatusError    = "error"
+)
+
+// Max number of messages downloaded from a mailbox in one scan.
+const mailboxScanLimit = 1000
+
 // Opt represents bounce processing options.
 type Opt struct {
-	MailboxEnabled          bool        `json:"mailbox_enabled"`
-	MailboxType             string      `json:"mailbox_type"`
-	Mailbox                 mailbox.Opt `json:"mailbox"`
-	WebhooksEnabled         bool        `json:"webhooks_enabled"`
-	SESEnabled              bool        `json:"ses_enabled"`
-	AzureEnabled            bool        `json:"azure_enabled"`
-	AzureSharedSecret       string      `json:"azure_shared_secret"`
-	AzureSharedSecretHeader string      `json:"azure_shared_secret_header"`
-	SendgridEnabled         bool        `json:"sendgrid_enabled"`
-	SendgridKey             string      `json:"sendgrid_key"`
+	Mailboxes               []MailboxOpt `json:"mailboxes"`
+	WebhooksEnabled         bool         `json:"webhooks_enabled"`
+	SESEnabled              bool         `json:"ses_enabled"`
+	AzureEnabled            bool         `json:"azure_enabled"`
+	AzureSharedSecret       string       `json:"azure_shared_secret"`
+	AzureSharedSecretHeader string       `json:"azure_shared_secret_header"`
+	SendgridEnabled         bool         `json:"sendgrid_enabled"`
+	SendgridKey             string       `json:"sendgrid_key"`
 	Postmark                struct {
 		Enabled  bool
 		Username string
@@ -43,19 +58,58 @@ type Opt struct {
 		Key     string
 	}
 
+	// Declaratively configured webhooks of other services.
+	Generic []webhooks.GenericOpt
+
 	RecordBounceCB func(models.Bounce) error
 }
 
+// MailboxOpt represents the configuration of a bounce mailbox.
+type MailboxOpt struct {
+	UUID string      `json:"uuid"`
+	Type string      `json:"type"`
+	Opt  mailbox.Opt `json:"opt"`
+}
+
+// MailboxStatus represents the scan status and counters of a bounce mailbox.
+type MailboxStatus struct {
+	UUID   string `json:"uuid"`
+	Type   string `json:"type"`
+	Host   string `json:"host"`
+	Folder string `json:"folder"`
+
+	// scanning, waiting, or error (the last scan failed).
+	Status     string     `json:"status"`
+	Error      string     `json:"error"`
+	LastScanAt *time.Time `json:"last_scan_at"`
+	ErrorAt    *time.Time `json:"error_at"`
+
+	// Number of scans, failed scans, and bounces found in the mailbox since the app started.
+	Scans   int `json:"scans"`
+	Errors  int `json:"errors"`
+	Bounces int `json:"bounces"`
+}
+
+// box is a bounce mailbox that's scanned independently of other mailboxes.
+type box struct {
+	mailbox Mailbox
+	opt     MailboxOpt
+
+	status MailboxStatus
+	mut    sync.RWMutex
+}
+
 // Manager handles e-mail bounces.
 type Manager struct {
 	queue        chan models.Bounce
-	mailbox      Mailbox
+	boxes        []*box
 	SES          *webhooks.SES
 	Azure        *webhooks.Azure
 	Sendgrid     *webhooks.Sendgrid
 	Postmark     *webhooks.Postmark
 	Forwardemail *webhooks.Forwardemail
 	Lettermint   *webhooks.Lettermint
+	Generic      map[string]*webhooks.Generic
 	queries      *Queries
 	opt          Opt
 	log          *log.Logger
@@ -76,14 +130,34 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 		log:     lo,
 	}
 
-	// Is there a mailbox?
-	if opt.MailboxEnabled {
-		switch opt.MailboxType {
+	// Initialize mailboxes.
+	for _, o := range opt.Mailboxes {
+		var mb Mailbox
+		switch o.Type {
 		case "pop":
-			m.mailbox = mailbox.NewPOP(opt.Mailbox, lo)
+			mb = mailbox.NewPOP(o.Opt, lo)
+		case "imap":
+			mb = mailbox.NewIMAP(o.Opt, lo)
 		default:
-			return nil, errors.New("unknown bounce mailbox type")
+			return nil, fmt.Errorf("unknown bounce mailbox type: %s", o.Type)
+		}
+
+		folder := ""
+		if o.Type == "imap" {
+			folder = o.Opt.Folder
 		}
+
+		m.boxes = append(m.boxes, &box{
+			mailbox: mb,
+			opt:     o,
+			status: MailboxStatus{
+				UUID:   o.UUID,
+				Type:   o.Type,
+				Host:   o.Opt.Host,
+				Folder: folder,
+				Status: MailboxStatusWaiting,
+			},
+		})
 	}
 
 	if opt.WebhooksEnabled {
@@ -115,6 +189,16 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 		if opt.Lettermint.Enabled {
 			m.Lettermint = webhooks.NewLettermint([]byte(opt.Lettermint.Key))
 		}
+
+		m.Generic = make(map[string]*webhooks.Generic, len(opt.Generic))
+		for _, o := range opt.Generic {
+			g, err := webhooks.NewGeneric(o)
+			if err != nil {
+				lo.Printf("error initializing %s webhooks: %v", o.Name, err)
+				continue
+			}
+			m.Generic[o.Name] = g
+		}
 	}
 
 	return m, nil
@@ -123,8 +207,8 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 // Run is a blocking function that listens for bounce events from webhooks and or mailboxes
 // and executes them on the DB.
 func (m *Manager) Run() {
-	if m.opt.MailboxEnabled {
-		go m.runMailboxScanner()
+	for _, b := range m.boxes {
+		go m.runMailboxScanner(b)
 	}
 
 	for b := range m.queue {
@@ -138,16 +222,82 @@ func (m *Manager) Run() {
 	}
 }
 
-// runMailboxScanner runs a blocking loop that scans the mailbox at given intervals.
-func (m *Manager) runMailboxScanner() {
+// Mailboxes returns the scan statuses of the bounce mailboxes.
+func (m *Manager) Mailboxes() []MailboxStatus {
+	out := make([]MailboxStatus, 0, len(m.boxes))
+	for _, b := range m.boxes {
+		b.mut.RLock()
+		out = append(out, b.status)
+		b.mut.RUnlock()
+	}
+
+	return out
+}
+
+// runMailboxScanner runs a blocking loop that scans a mailbox at its configured interval.
+// If IDLE is enabled and the mailbox supports it, the mailbox is also scanned as soon
+// as new messages arrive.
+func (m *Manager) runMailboxScanner(b *box) {
+	var (
+		interval    = b.opt.Opt.ScanInterval
+		idler, idle = b.mailbox.(Idler)
+	)
+	idle = idle && b.opt.Opt.IDLE
+
 	for {
-		m.log.Printf("scanning bounce mailbox %s", m.opt.Mailbox.Host)
-		if err := m.mailbox.Scan(1000, m.queue); err != nil {
-			m.log.Printf("error scanning bounce mailbox: %v", err)
+		m.scanMailbox(b)
+
+		if idle {
+			err := idler.Idle(interval)
+			if err == nil {
+				continue
+			}
+			m.log.Printf("error waiting on bounce mailbox %s: %v", b.opt.Opt.Host, err)
 		}
 
-		time.Sleep(m.opt.Mailbox.ScanInterval)
+		time.Sleep(interval)
+	}
+}
+
+// scanMailbox scans a mailbox once, queues the bounces found, and updates
+// the mailbox's status.
+func (m *Manager) scanMailbox(b *box) {
+	b.mut.Lock()
+	b.status.Status = MailboxStatusScanning
+	b.mut.Unlock()
+
+	m.log.Printf("scanning bounce mailbox %s", b.opt.Opt.Host)
+
+	ch := make(chan models.Bounce, mailboxScanLimit)
+	err := b.mailbox.Scan(mailboxScanLimit, ch)
+	close(ch)
+
+	n := 0
+	for bn := range ch {
+		m.queue <- bn
+		n++
+	}
+
+	now := time.Now()
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.status.LastScanAt = &now
+	b.status.Scans++
+	b.status.Bounces += n
+
+	if err != nil {
+		m.log.Printf("error scanning bounce mailbox %s: %v", b.opt.Opt.Host, err)
+		b.status.Status = MailboxStatusError
+		b.status.Error = err.Error()
+		b.status.ErrorAt = &now
+		b.status.Errors++
+		return
 	}
+
+	b.status.Status = MailboxStatusWaiting
+	b.status.Error = ""
 }
 
 // Record records a new bounce event given the subscriber's email or UUID.
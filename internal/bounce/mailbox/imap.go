@@ -0,0 +1,515 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Actions that can be performed on scanned messages in an IMAP mailbox.
+const (
+	ActionDelete = "delete"
+	ActionFlag   = "flag"
+	ActionMove   = "move"
+)
+
+const (
+	imapTimeout = time.Minute * 2
+
+	// Servers may drop idle connections after 30 minutes (RFC 2177),
+	// so IDLE is re-issued before that.
+	imapMaxIdle = time.Minute * 25
+
+	// Max size of a literal (eg: a bounce message) that's read from the server.
+	imapMaxLiteral = 10 * 1024 * 1024
+)
+
+var (
+	reIMAPLiteral = regexp.MustCompile(`\{(\d+)\}$`)
+	reIMAPExists  = regexp.MustCompile(`(?i)^\* \d+ EXISTS`)
+)
+
+// IMAP represents an IMAP mailbox.
+type IMAP struct {
+	opt Opt
+	lo  *log.Logger
+
+	// Connection that's kept open between scans when IDLE is enabled.
+	conn *imapConn
+}
+
+// imapConn is a minimal IMAP4rev1 client connection that supports the commands
+// required for scanning bounces.
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	caps map[string]bool
+
+	// Bytes of a line that was partially read when a read deadline expired.
+	partial []byte
+}
+
+// imapResp is an untagged server response line. Literals in the line,
+// for instance, the raw message in a FETCH response, are collected separately.
+type imapResp struct {
+	line     string
+	literals [][]byte
+}
+
+// NewIMAP returns a new instance of the IMAP mailbox client.
+func NewIMAP(opt Opt, lo *log.Logger) *IMAP {
+	if opt.Folder == "" {
+		opt.Folder = "INBOX"
+	}
+	if opt.Action == "" {
+		opt.Action = ActionFlag
+	}
+
+	return &IMAP{
+		opt: opt,
+		lo:  lo,
+	}
+}
+
+// Scan scans the folder and pushes the downloaded messages into the given channel.
+// Depending on the configured action, downloaded messages are deleted, flagged as
+// seen (and are not scanned again), or moved to another folder. If limit > 0,
+// only as many messages are downloaded.
+func (m *IMAP) Scan(limit int, ch chan models.Bounce) error {
+	c, err := m.connect()
+	if err != nil {
+		return err
+	}
+
+	err = m.scan(c, limit, ch)
+	if err != nil || !m.opt.IDLE {
+		m.close()
+	}
+
+	return err
+}
+
+// Idle waits for new messages to arrive in the folder, or for the timeout to
+// elapse, whichever is first. If the server doesn't support the IDLE
+// extension, it waits for the timeout.
+func (m *IMAP) Idle(timeout time.Duration) error {
+	c, err := m.connect()
+	if err != nil {
+		return err
+	}
+
+	if !c.caps["IDLE"] {
+		m.close()
+		time.Sleep(timeout)
+		return nil
+	}
+
+	end := time.Now().Add(timeout)
+	for {
+		wait := min(time.Until(end), imapMaxIdle)
+		if wait <= 0 {
+			return nil
+		}
+
+		found, err := c.idle(wait)
+		if err != nil {
+			m.close()
+			return err
+		}
+
+		if found {
+			return nil
+		}
+	}
+}
+
+func (m *IMAP) scan(c *imapConn, limit int, ch chan models.Bounce) error {
+	// Flagged messages remain in the folder and are skipped in subsequent scans.
+	search := "UID SEARCH UNDELETED"
+	if m.opt.Action == ActionFlag {
+		search = "UID SEARCH UNSEEN UNDELETED"
+	}
+
+	res, err := c.cmd(search)
+	if err != nil {
+		return err
+	}
+
+	var uids []string
+	for _, r := range res {
+		if f := strings.Fields(r.line); len(f) > 2 && strings.EqualFold(f[1], "SEARCH") {
+			uids = append(uids, f[2:]...)
+		}
+	}
+
+	// No messages.
+	if len(uids) == 0 {
+		return nil
+	}
+
+	if limit > 0 && len(uids) > limit {
+		uids = uids[:limit]
+	}
+
+	// Download messages. BODY.PEEK doesn't set the \Seen flag.
+	done := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		res, err := c.cmd("UID FETCH " + uid + " BODY.PEEK[]")
+		if err != nil {
+			return err
+		}
+
+		var b []byte
+		for _, r := range res {
+			if strings.Contains(strings.ToUpper(r.line), "FETCH") && len(r.literals) > 0 {
+				b = r.literals[0]
+				break
+			}
+		}
+		if b == nil {
+			m.lo.Printf("error retrieving bounce message %s: no message body", uid)
+			continue
+		}
+		done = append(done, uid)
+
+		bn, err := parseBounce(b, m.opt.Host)
+		if err != nil {
+			// Delivery notifications that aren't failures are ignored.
+			if err != errNotBounce {
+				m.lo.Printf("error parsing bounce message %s: %v", uid, err)
+			}
+			continue
+		}
+
+		select {
+		case ch <- bn:
+		default:
+		}
+	}
+
+	if len(done) == 0 {
+		return nil
+	}
+
+	// Delete, flag, or move the downloaded messages.
+	set := strings.Join(done, ",")
+	switch m.opt.Action {
+	case ActionFlag:
+		_, err = c.cmd(`UID STORE ` + set + ` +FLAGS.SILENT (\Seen)`)
+		return err
+
+	case ActionMove:
+		if c.caps["MOVE"] {
+			_, err = c.cmd("UID MOVE " + set + " " + imapQuote(m.opt.MoveFolder))
+			return err
+		}
+
+		if _, err := c.cmd("UID COPY " + set + " " + imapQuote(m.opt.MoveFolder)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.cmd(`UID STORE ` + set + ` +FLAGS.SILENT (\Deleted)`); err != nil {
+		return err
+	}
+	_, err = c.cmd("EXPUNGE")
+
+	return err
+}
+
+// connect returns the open connection, or opens a new one, logs in,
+// and selects the folder.
+func (m *IMAP) connect() (*imapConn, error) {
+	if m.conn != nil {
+		return m.conn, nil
+	}
+
+	var (
+		addr = net.JoinHostPort(m.opt.Host, strconv.Itoa(m.opt.Port))
+		d    = &net.Dialer{Timeout: imapTimeout}
+
+		conn net.Conn
+		err  error
+	)
+	if m.opt.TLSEnabled {
+		conn, err = tls.DialWithDialer(d, "tcp", addr, &tls.Config{
+			ServerName:         m.opt.Host,
+			InsecureSkipVerify: m.opt.TLSSkipVerify,
+		})
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+
+	// Greeting.
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	r, err := c.readResp()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(r.line, "* OK") && !strings.HasPrefix(r.line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", r.line)
+	}
+
+	if err := c.login(m.opt); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	// Capabilities may change after authentication.
+	res, err := c.cmd("CAPABILITY")
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	c.caps = make(map[string]bool)
+	for _, r := range res {
+		if f := strings.Fields(r.line); len(f) > 1 && strings.EqualFold(f[1], "CAPABILITY") {
+			for _, cp := range f[2:] {
+				c.caps[strings.ToUpper(cp)] = true
+			}
+		}
+	}
+
+	if _, err := c.cmd("SELECT " + imapQuote(m.opt.Folder)); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	m.conn = c
+	return c, nil
+}
+
+// close logs out and closes the open connection, if any.
+func (m *IMAP) close() {
+	if m.conn == nil {
+		return
+	}
+
+	m.conn.close()
+	m.conn = nil
+}
+
+// login authenticates the connection with the configured auth protocol.
+func (c *imapConn) login(opt Opt) error {
+	switch opt.AuthProtocol {
+	case "none":
+		return nil
+
+	case "plain":
+		resp := base64.StdEncoding.EncodeToString([]byte("\x00" + opt.Username + "\x00" + opt.Password))
+		return c.authenticate("PLAIN", func([]byte) string { return resp })
+
+	case "cram":
+		return c.authenticate("CRAM-MD5", func(challenge []byte) string {
+			h := hmac.New(md5.New, []byte(opt.Password))
+			h.Write(challenge)
+			return base64.StdEncoding.EncodeToString([]byte(opt.Username + " " + hex.EncodeToString(h.Sum(nil))))
+		})
+	}
+
+	_, err := c.cmd("LOGIN " + imapQuote(opt.Username) + " " + imapQuote(opt.Password))
+	return err
+}
+
+// authenticate runs the AUTHENTICATE command with the given SASL mechanism
+// which involves a single challenge-response.
+func (c *imapConn) authenticate(mech string, respond func(challenge []byte) string) error {
+	tag, err := c.send("AUTHENTICATE " + mech)
+	if err != nil {
+		return err
+	}
+
+	r, err := c.readResp()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(r.line, "+") {
+		return c.tagged(tag, r.line)
+	}
+
+	challenge, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(r.line, "+")))
+	if err != nil {
+		return fmt.Errorf("invalid IMAP auth challenge: %v", err)
+	}
+
+	if _, err := io.WriteString(c.conn, respond(challenge)+"\r\n"); err != nil {
+		return err
+	}
+
+	_, err = c.wait(tag)
+	return err
+}
+
+// idle issues the IDLE command and waits for the server to report new messages
+// in the folder, or for the timeout to elapse. It returns true if there are new messages.
+func (c *imapConn) idle(timeout time.Duration) (bool, error) {
+	tag, err := c.send("IDLE")
+	if err != nil {
+		return false, err
+	}
+
+	r, err := c.readResp()
+	if err != nil {
+		return false, err
+	}
+	if !strings.HasPrefix(r.line, "+") {
+		return false, c.tagged(tag, r.line)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+
+	found := false
+	for !found {
+		r, err := c.readResp()
+		if err != nil {
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				break
+			}
+			return false, err
+		}
+
+		found = reIMAPExists.MatchString(r.line)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := io.WriteString(c.conn, "DONE\r\n"); err != nil {
+		return false, err
+	}
+
+	if _, err := c.wait(tag); err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// cmd sends a command and returns its untagged responses. An error is
+// returned if the command doesn't complete with an OK.
+func (c *imapConn) cmd(cmd string) ([]imapResp, error) {
+	tag, err := c.send(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.wait(tag)
+}
+
+// send writes a tagged command to the connection and returns the tag.
+func (c *imapConn) send(cmd string) (string, error) {
+	c.tag++
+	tag := "L" + strconv.Itoa(c.tag)
+
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return "", err
+	}
+
+	return tag, nil
+}
+
+// wait reads responses until the tagged completion response of a command.
+func (c *imapConn) wait(tag string) ([]imapResp, error) {
+	var out []imapResp
+	for {
+		r, err := c.readResp()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(r.line, tag+" ") {
+			return out, c.tagged(tag, r.line)
+		}
+
+		out = append(out, r)
+	}
+}
+
+// tagged returns an error if a tagged completion response isn't an OK.
+func (c *imapConn) tagged(tag, line string) error {
+	status := strings.TrimPrefix(line, tag+" ")
+	if strings.HasPrefix(strings.ToUpper(status), "OK") {
+		return nil
+	}
+
+	return fmt.Errorf("IMAP error: %s", status)
+}
+
+// readResp reads a response line along with the literals in it.
+func (c *imapConn) readResp() (imapResp, error) {
+	var r imapResp
+	for {
+		l, err := c.readLine()
+		if err != nil {
+			return r, err
+		}
+		r.line += l
+
+		// The line continues after a literal.
+		m := reIMAPLiteral.FindStringSubmatch(l)
+		if m == nil {
+			return r, nil
+		}
+
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > imapMaxLiteral {
+			return r, fmt.Errorf("IMAP literal of size %s exceeds the max size of %d bytes", m[1], imapMaxLiteral)
+		}
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return r, err
+		}
+		r.literals = append(r.literals, b)
+	}
+}
+
+// readLine reads a line without the trailing CRLF. If a read deadline expires
+// midway, the partially read line is retained for the next read.
+func (c *imapConn) readLine() (string, error) {
+	b, err := c.r.ReadBytes('\n')
+	if err != nil {
+		c.partial = append(c.partial, b...)
+		return "", err
+	}
+
+	if len(c.partial) > 0 {
+		b = append(c.partial, b...)
+		c.partial = nil
+	}
+
+	return string(bytes.TrimRight(b, "\r\n")), nil
+}
+
+func (c *imapConn) close() {
+	c.cmd("LOGOUT")
+	c.conn.Close()
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
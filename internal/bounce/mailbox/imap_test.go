@@ -0,0 +1,504 @@
+package mailbox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	testIMAPUser = "bounces"
+	testIMAPPass = "secret"
+)
+
+// imapServer is a local stand-in for an IMAP server that implements just the
+// commands that the client uses on an in-memory set of folders.
+type imapServer struct {
+	ln   net.Listener
+	caps string
+
+	mu       sync.Mutex
+	folders  map[string][]*imapMsg
+	uid      int
+	selected []string
+
+	// Signals a message's arrival to connections that are idling.
+	arrived chan struct{}
+}
+
+type imapMsg struct {
+	uid     int
+	body    []byte
+	seen    bool
+	deleted bool
+}
+
+func newIMAPServer(t *testing.T, caps string, folders ...string) *imapServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &imapServer{
+		ln:      ln,
+		caps:    caps,
+		folders: make(map[string][]*imapMsg),
+		arrived: make(chan struct{}, 1),
+	}
+	for _, f := range folders {
+		s.folders[f] = nil
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// opt returns the mailbox options to connect to the server.
+func (s *imapServer) opt() Opt {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return Opt{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: testIMAPUser,
+		Password: testIMAPPass,
+	}
+}
+
+// deliver adds a message to a folder.
+func (s *imapServer) deliver(folder string, body []byte) {
+	s.mu.Lock()
+	s.uid++
+	s.folders[folder] = append(s.folders[folder], &imapMsg{uid: s.uid, body: body})
+	s.mu.Unlock()
+
+	select {
+	case s.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// count returns the number of messages in a folder that aren't deleted,
+// and the number of those that are seen.
+func (s *imapServer) count(folder string) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n, seen int
+	for _, m := range s.folders[folder] {
+		if m.deleted {
+			continue
+		}
+		n++
+		if m.seen {
+			seen++
+		}
+	}
+
+	return n, seen
+}
+
+func (s *imapServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r      = bufio.NewReader(conn)
+		wMut   sync.Mutex
+		folder string
+	)
+	write := func(format string, a ...any) {
+		wMut.Lock()
+		fmt.Fprintf(conn, format+"\r\n", a...)
+		wMut.Unlock()
+	}
+
+	write("* OK IMAP4rev1 ready")
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		tag, cmd, _ := strings.Cut(strings.TrimRight(l, "\r\n"), " ")
+		args := strings.Fields(cmd)
+		if len(args) == 0 {
+			write("%s BAD empty command", tag)
+			continue
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "LOGIN":
+			if len(args) != 3 || unquote(args[1]) != testIMAPUser || unquote(args[2]) != testIMAPPass {
+				write("%s NO [AUTHENTICATIONFAILED] invalid credentials", tag)
+				continue
+			}
+			write("%s OK logged in", tag)
+
+		case "CAPABILITY":
+			write("* CAPABILITY %s", s.caps)
+			write("%s OK", tag)
+
+		case "SELECT":
+			name := unquote(args[1])
+			s.mu.Lock()
+			msgs, ok := s.folders[name]
+			if ok {
+				s.selected = append(s.selected, name)
+			}
+			s.mu.Unlock()
+			if !ok {
+				write("%s NO [NONEXISTENT] unknown folder", tag)
+				continue
+			}
+			folder = name
+			write("* %d EXISTS", len(msgs))
+			write("%s OK [READ-WRITE] selected", tag)
+
+		case "UID":
+			if err := s.uidCmd(folder, args[1:], write); err != nil {
+				write("%s BAD %v", tag, err)
+				continue
+			}
+			write("%s OK", tag)
+
+		case "EXPUNGE":
+			s.mu.Lock()
+			s.folders[folder] = slices.DeleteFunc(s.folders[folder], func(m *imapMsg) bool { return m.deleted })
+			s.mu.Unlock()
+			write("%s OK", tag)
+
+		case "IDLE":
+			write("+ idling")
+
+			stop := make(chan struct{})
+			go func() {
+				select {
+				case <-s.arrived:
+					n, _ := s.count(folder)
+					write("* %d EXISTS", n)
+				case <-stop:
+				}
+			}()
+
+			l, err := r.ReadString('\n')
+			close(stop)
+			if err != nil {
+				return
+			}
+			if strings.TrimSpace(l) != "DONE" {
+				write("%s BAD expected DONE", tag)
+				continue
+			}
+			write("%s OK idle done", tag)
+
+		case "LOGOUT":
+			write("* BYE")
+			write("%s OK", tag)
+			return
+
+		default:
+			write("%s BAD unknown command", tag)
+		}
+	}
+}
+
+// uidCmd runs the UID SEARCH, FETCH, STORE, COPY and MOVE commands on a folder.
+func (s *imapServer) uidCmd(folder string, args []string, write func(string, ...any)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(args) < 2 {
+		return fmt.Errorf("invalid UID command")
+	}
+
+	// Messages in the UID set.
+	var msgs []*imapMsg
+	if !strings.EqualFold(args[0], "SEARCH") {
+		for _, u := range strings.Split(args[1], ",") {
+			uid, _ := strconv.Atoi(u)
+			for _, m := range s.folders[folder] {
+				if m.uid == uid {
+					msgs = append(msgs, m)
+				}
+			}
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "SEARCH":
+		unseen := slices.Contains(args, "UNSEEN")
+		var uids []string
+		for _, m := range s.folders[folder] {
+			if m.deleted || (unseen && m.seen) {
+				continue
+			}
+			uids = append(uids, strconv.Itoa(m.uid))
+		}
+		write("* SEARCH %s", strings.Join(uids, " "))
+
+	case "FETCH":
+		for i, m := range s.folders[folder] {
+			if len(msgs) > 0 && m == msgs[0] {
+				write("* %d FETCH (UID %d BODY[] {%d}\r\n%s)", i+1, m.uid, len(m.body), m.body)
+			}
+		}
+
+	case "STORE":
+		flags := strings.Join(args[2:], " ")
+		for _, m := range msgs {
+			if strings.Contains(flags, `\Seen`) {
+				m.seen = true
+			}
+			if strings.Contains(flags, `\Deleted`) {
+				m.deleted = true
+			}
+		}
+
+	case "COPY", "MOVE":
+		if strings.EqualFold(args[0], "MOVE") && !strings.Contains(s.caps, "MOVE") {
+			return fmt.Errorf("MOVE not supported")
+		}
+
+		dest := unquote(args[2])
+		if _, ok := s.folders[dest]; !ok {
+			return fmt.Errorf("unknown folder %s", dest)
+		}
+		for _, m := range msgs {
+			s.uid++
+			s.folders[dest] = append(s.folders[dest], &imapMsg{uid: s.uid, body: m.body, seen: m.seen})
+		}
+		if strings.EqualFold(args[0], "MOVE") {
+			s.folders[folder] = slices.DeleteFunc(s.folders[folder], func(m *imapMsg) bool { return slices.Contains(msgs, m) })
+		}
+
+	default:
+		return fmt.Errorf("unknown UID command %s", args[0])
+	}
+
+	return nil
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
+
+func readTestMail(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func newTestIMAP(s *imapServer, mod func(*Opt)) *IMAP {
+	opt := s.opt()
+	if mod != nil {
+		mod(&opt)
+	}
+
+	return NewIMAP(opt, log.New(io.Discard, "", 0))
+}
+
+func TestIMAPScanFlag(t *testing.T) {
+	s := newIMAPServer(t, "IMAP4rev1", "INBOX", "Bounces")
+	s.deliver("Bounces", readTestMail(t, "dsn_hard.eml"))
+	s.deliver("Bounces", readTestMail(t, "dsn_delivered.eml"))
+	s.deliver("INBOX", readTestMail(t, "dsn_soft.eml"))
+
+	m := newTestIMAP(s, func(o *Opt) { o.Folder = "Bounces" })
+	ch := make(chan models.Bounce, 10)
+	if err := m.Scan(0, ch); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+
+	// Only the configured folder is scanned, and notifications that aren't failures are skipped.
+	if len(ch) != 1 {
+		t.Fatalf("expected 1 bounce, got %d", len(ch))
+	}
+	if b := <-ch; b.Type != models.BounceTypeHard || b.Email != "nobody@example.org" {
+		t.Errorf("unexpected bounce: %s %s", b.Type, b.Email)
+	}
+	s.mu.Lock()
+	selected := slices.Clone(s.selected)
+	s.mu.Unlock()
+	if !slices.Equal(selected, []string{"Bounces"}) {
+		t.Errorf("expected the Bounces folder to be selected, got %v", selected)
+	}
+
+	// The scanned messages are flagged as seen and left in the folder.
+	if n, seen := s.count("Bounces"); n != 2 || seen != 2 {
+		t.Errorf("expected 2 seen messages in the folder, got %d (%d seen)", n, seen)
+	}
+	if _, seen := s.count("INBOX"); seen != 0 {
+		t.Errorf("expected no seen messages in INBOX, got %d", seen)
+	}
+
+	// Seen messages aren't scanned again.
+	if err := m.Scan(0, ch); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	if len(ch) != 0 {
+		t.Errorf("expected no bounces on rescan, got %d", len(ch))
+	}
+
+	// An unknown folder fails to be selected.
+	m = newTestIMAP(s, func(o *Opt) { o.Folder = "Unknown" })
+	if err := m.Scan(0, ch); err == nil {
+		t.Error("expected an error selecting an unknown folder")
+	}
+
+	// Invalid credentials.
+	m = newTestIMAP(s, func(o *Opt) { o.Password = "wrong" })
+	if err := m.Scan(0, ch); err == nil {
+		t.Error("expected an error logging in with invalid credentials")
+	}
+}
+
+func TestIMAPScanMoveDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		caps   string
+		action string
+		moved  int
+	}{
+		{"move", "IMAP4rev1 MOVE", ActionMove, 2},
+		{"copy and delete without MOVE", "IMAP4rev1", ActionMove, 2},
+		{"delete", "IMAP4rev1", ActionDelete, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newIMAPServer(t, tc.caps, "INBOX", "Processed")
+			s.deliver("INBOX", readTestMail(t, "dsn_hard.eml"))
+			s.deliver("INBOX", readTestMail(t, "arf.eml"))
+
+			m := newTestIMAP(s, func(o *Opt) {
+				o.Action = tc.action
+				o.MoveFolder = "Processed"
+			})
+			ch := make(chan models.Bounce, 10)
+			if err := m.Scan(0, ch); err != nil {
+				t.Fatalf("error scanning: %v", err)
+			}
+
+			if len(ch) != 2 {
+				t.Errorf("expected 2 bounces, got %d", len(ch))
+			}
+			if n, _ := s.count("INBOX"); n != 0 {
+				t.Errorf("expected the scanned messages to be removed from INBOX, got %d", n)
+			}
+			if n, _ := s.count("Processed"); n != tc.moved {
+				t.Errorf("expected %d messages in the move folder, got %d", tc.moved, n)
+			}
+		})
+	}
+}
+
+func TestIMAPScanLimit(t *testing.T) {
+	s := newIMAPServer(t, "IMAP4rev1", "INBOX")
+	for range 3 {
+		s.deliver("INBOX", readTestMail(t, "dsn_hard.eml"))
+	}
+
+	m := newTestIMAP(s, nil)
+	ch := make(chan models.Bounce, 10)
+	if err := m.Scan(2, ch); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	if len(ch) != 2 {
+		t.Errorf("expected 2 bounces, got %d", len(ch))
+	}
+	if _, seen := s.count("INBOX"); seen != 2 {
+		t.Errorf("expected 2 seen messages, got %d", seen)
+	}
+}
+
+func TestIMAPIdle(t *testing.T) {
+	s := newIMAPServer(t, "IMAP4rev1 IDLE", "INBOX")
+	m := newTestIMAP(s, func(o *Opt) { o.IDLE = true })
+	defer m.close()
+
+	// Nothing arrives. Idle returns after the timeout.
+	start := time.Now()
+	if err := m.Idle(200 * time.Millisecond); err != nil {
+		t.Fatalf("error idling: %v", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("expected Idle to wait for the timeout, returned after %s", d)
+	}
+
+	// A message arrives while idling. Idle returns right away.
+	b := readTestMail(t, "dsn_hard.eml")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.deliver("INBOX", b)
+	}()
+
+	start = time.Now()
+	if err := m.Idle(10 * time.Second); err != nil {
+		t.Fatalf("error idling: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected Idle to return on a new message, returned after %s", d)
+	}
+
+	// The connection is kept open and is reused for the scan.
+	conn := m.conn
+	ch := make(chan models.Bounce, 10)
+	if err := m.Scan(0, ch); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	if len(ch) != 1 {
+		t.Errorf("expected 1 bounce, got %d", len(ch))
+	}
+	if m.conn == nil || m.conn != conn {
+		t.Error("expected the idle connection to be kept open and reused")
+	}
+}
+
+func TestIMAPLiteralSize(t *testing.T) {
+	resp := func(s string) *imapConn {
+		return &imapConn{r: bufio.NewReader(strings.NewReader(s))}
+	}
+
+	r, err := resp("* 1 FETCH (UID 1 BODY[] {5}\r\nhello)\r\n").readResp()
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+	if len(r.literals) != 1 || string(r.literals[0]) != "hello" {
+		t.Errorf("expected the literal, got %q", r.literals)
+	}
+
+	// Literals over the max size aren't allocated.
+	for _, n := range []string{strconv.Itoa(imapMaxLiteral + 1), "99999999999999999999"} {
+		if _, err := resp("* 1 FETCH (UID 1 BODY[] {" + n + "}\r\n").readResp(); err == nil {
+			t.Errorf("expected an error reading a literal of size %s", n)
+		}
+	}
+}
@@ -21,6 +21,17 @@ type Opt struct {
 	// Folder is the name of the IMAP folder to scan for e-mails.
 	Folder string `json:"folder"`
 
+	// IDLE, if enabled, waits for new messages on the IMAP folder using the
+	// IMAP IDLE extension instead of polling it at every ScanInterval.
+	IDLE bool `json:"idle"`
+
+	// Action is what's done with the scanned messages on an IMAP mailbox:
+	// delete, flag (mark as read), or move (to MoveFolder).
+	Action string `json:"action"`
+
+	// MoveFolder is the IMAP folder to move scanned messages to.
+	MoveFolder string `json:"move_folder"`
+
 	// Optional TLS settings.
 	TLSEnabled    bool `json:"tls_enabled"`
 	TLSSkipVerify bool `json:"tls_skip_verify"`
@@ -0,0 +1,199 @@
+package mailbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/knadh/listmonk/models"
+)
+
+type bounceHeaders struct {
+	Header string
+	Regexp *regexp.Regexp
+}
+
+type bounceMeta struct {
+	From           string   `json:"from"`
+	Subject        string   `json:"subject"`
+	MessageID      string   `json:"message_id"`
+	DeliveredTo    string   `json:"delivered_to"`
+	Received       []string `json:"received"`
+	ClassifyReason string   `json:"classify_reason"`
+
+	// Delivery status notification or feedback report, if the bounce is one.
+	Report *reportMeta `json:"report,omitempty"`
+}
+
+var (
+	// List of header to look for in the e-mail body, regexp to fall back to if the header is empty.
+	headerLookups = []bounceHeaders{
+		{models.EmailHeaderCampaignUUID, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderCampaignUUID + `:\s+?)([a-z0-9\-]{36})`)},
+		{models.EmailHeaderSubscriberUUID, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderSubscriberUUID + `:\s+?)([a-z0-9\-]{36})`)},
+		{models.EmailHeaderDate, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderDate + `:\s+?)([\w,\,\ ,:,+,-]*(?:\(?:\w*\))?)`)},
+		{models.EmailHeaderFrom, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderFrom + `:\s+?)(.*)`)},
+		{models.EmailHeaderSubject, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderSubject + `:\s+?)(.*)`)},
+		{models.EmailHeaderMessageId, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderMessageId + `:\s+?)(.*)`)},
+		{models.EmailHeaderDeliveredTo, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderDeliveredTo + `:\s+?)(.*)`)},
+	}
+
+	reHdrReceived = regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderReceived + `:\s+?)(.*)`)
+
+	// SMTP status code (5.x.x or 4.x.x) to classify hard/soft bounces.
+	reSMTPStatus = regexp.MustCompile(`(?m)(?i)^(?:Status:\s*)?(?:\d{3}\s+)?([45]\.\d+\.\d+)`)
+
+	// List of (conventional) strings to guess hard bounces.
+	reHardBounce = regexp.MustCompile(`(?i)(NXDOMAIN|user unknown|address not found|mailbox not found|address.*reject|does not exist|` +
+		`invalid recipient|no such user|recipient.*invalid|undeliverable|permanent.*failure|permanent.*error|` +
+		`bad.*address|unknown.*user|account.*disabled|address.*disabled)`)
+)
+
+// parseBounce parses a raw bounce e-mail and returns a bounce with the campaign
+// and subscriber it relates to, its type, and additional metadata.
+func parseBounce(b []byte, source string) (models.Bounce, error) {
+	m, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		return models.Bounce{}, err
+	}
+
+	var (
+		h   = m.Header
+		rep *report
+	)
+
+	// Delivery status notifications and feedback reports have a machine-readable
+	// report and usually, the headers of the original message. Reports that can't
+	// be parsed are treated like other messages.
+	if mt, _, _ := m.Header.ContentType(); mt == "multipart/report" {
+		if r, err := parseReport(m); err == nil {
+			rep = r
+			if r.header != nil {
+				h = *r.header
+			}
+		} else if m, err = message.Read(bytes.NewReader(b)); err != nil {
+			return models.Bounce{}, err
+		}
+	}
+
+	// If this is a multipart message, find the last part.
+	if mr := m.MultipartReader(); rep == nil && mr != nil {
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return models.Bounce{}, fmt.Errorf("error reading multipart message: %v", err)
+			}
+			h = part.Header
+		}
+	}
+
+	// Lookup headers in the e-mail. If a header isn't found, fall back to regexp lookups.
+	hdr := make(map[string]string, 7)
+	for _, l := range headerLookups {
+		v := h.Get(l.Header)
+
+		// Not in the header. Try regexp.
+		if v == "" {
+			if m := l.Regexp.FindAllSubmatch(b, -1); m != nil {
+				v = string(m[len(m)-1][1])
+			}
+		}
+
+		hdr[l.Header] = strings.TrimSpace(v)
+	}
+
+	// Received is a []string header.
+	msgReceived := h.Map()[models.EmailHeaderReceived]
+	if len(msgReceived) == 0 {
+		if u := reHdrReceived.FindAllSubmatch(b, -1); u != nil {
+			for i := range u {
+				msgReceived = append(msgReceived, string(u[i][1]))
+			}
+		}
+	}
+
+	date, _ := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", hdr[models.EmailHeaderDate])
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	// Classify the bounce type based on the report, or failing that, the message content.
+	var (
+		bounceType, bounceReason string
+		email                    string
+		repMeta                  *reportMeta
+	)
+	if rep != nil {
+		bounceType, bounceReason, err = rep.classify()
+		if err != nil {
+			return models.Bounce{}, err
+		}
+
+		// The recipient is used to look up the subscriber if the original
+		// message's headers aren't in the report. Subscriber e-mails are lowercase.
+		email = strings.ToLower(rep.meta.Recipient)
+		repMeta = &rep.meta
+	} else {
+		bounceType, bounceReason = classifyBounce(b)
+	}
+
+	// Additional bounce e-mail metadata.
+	meta, _ := json.Marshal(bounceMeta{
+		From:           hdr[models.EmailHeaderFrom],
+		Subject:        hdr[models.EmailHeaderSubject],
+		MessageID:      hdr[models.EmailHeaderMessageId],
+		DeliveredTo:    hdr[models.EmailHeaderDeliveredTo],
+		Received:       msgReceived,
+		ClassifyReason: bounceReason,
+		Report:         repMeta,
+	})
+
+	return models.Bounce{
+		Type:           bounceType,
+		CampaignUUID:   hdr[models.EmailHeaderCampaignUUID],
+		SubscriberUUID: hdr[models.EmailHeaderSubscriberUUID],
+		Email:          email,
+		Source:         source,
+		CreatedAt:      date,
+		Meta:           meta,
+	}, nil
+}
+
+// classifyBounce analyzes the bounce message content and determines if it's a hard or soft bounce.
+// It checks SMTP status codes, diagnostic headers, and bounce keywords (using string heuristics).
+// soft is the default preference.
+// Returns the bounce type and a classification reason containing context about what matched.
+func classifyBounce(b []byte) (string, string) {
+	if matches := reSMTPStatus.FindAllSubmatch(b, -1); matches != nil {
+		for _, m := range matches {
+			if len(m) >= 2 && len(m[0]) > 1 {
+				// Full status code (e.g., "5.1.1").
+				status := m[1]
+
+				// 5.x.x is hard bounce.
+				if status[0] == '5' {
+					return models.BounceTypeHard, fmt.Sprintf("smtp_status=%s", status)
+				}
+
+				// 4.x.x  is soft bounce.
+				if status[0] == '4' {
+					return models.BounceTypeSoft, fmt.Sprintf("smtp_status=%s", status)
+				}
+			}
+		}
+	}
+
+	// Check for explicit hard bounce keywords.
+	if match := reHardBounce.FindSubmatch(b); match != nil {
+		return models.BounceTypeHard, fmt.Sprintf("body_match=%s", match[1])
+	}
+
+	return models.BounceTypeSoft, "default"
+}
@@ -1,16 +1,8 @@
 package mailbox
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
-	"regexp"
-	"strings"
-	"time"
 
-	"github.com/emersion/go-message"
-	_ "github.com/emersion/go-message/charset"
 	"github.com/knadh/go-pop3"
 	"github.com/knadh/listmonk/models"
 )
@@ -22,43 +14,6 @@ type POP struct {
 	lo     *log.Logger
 }
 
-type bounceHeaders struct {
-	Header string
-	Regexp *regexp.Regexp
-}
-
-type bounceMeta struct {
-	From           string   `json:"from"`
-	Subject        string   `json:"subject"`
-	MessageID      string   `json:"message_id"`
-	DeliveredTo    string   `json:"delivered_to"`
-	Received       []string `json:"received"`
-	ClassifyReason string   `json:"classify_reason"`
-}
-
-var (
-	// List of header to look for in the e-mail body, regexp to fall back to if the header is empty.
-	headerLookups = []bounceHeaders{
-		{models.EmailHeaderCampaignUUID, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderCampaignUUID + `:\s+?)([a-z0-9\-]{36})`)},
-		{models.EmailHeaderSubscriberUUID, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderSubscriberUUID + `:\s+?)([a-z0-9\-]{36})`)},
-		{models.EmailHeaderDate, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderDate + `:\s+?)([\w,\,\ ,:,+,-]*(?:\(?:\w*\))?)`)},
-		{models.EmailHeaderFrom, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderFrom + `:\s+?)(.*)`)},
-		{models.EmailHeaderSubject, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderSubject + `:\s+?)(.*)`)},
-		{models.EmailHeaderMessageId, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderMessageId + `:\s+?)(.*)`)},
-		{models.EmailHeaderDeliveredTo, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderDeliveredTo + `:\s+?)(.*)`)},
-	}
-
-	reHdrReceived = regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderReceived + `:\s+?)(.*)`)
-
-	// SMTP status code (5.x.x or 4.x.x) to classify hard/soft bounces.
-	reSMTPStatus = regexp.MustCompile(`(?m)(?i)^(?:Status:\s*)?(?:\d{3}\s+)?([45]\.\d+\.\d+)`)
-
-	// List of (conventional) strings to guess hard bounces.
-	reHardBounce = regexp.MustCompile(`(?i)(NXDOMAIN|user unknown|address not found|mailbox not found|address.*reject|does not exist|` +
-		`invalid recipient|no such user|recipient.*invalid|undeliverable|permanent.*failure|permanent.*error|` +
-		`bad.*address|unknown.*user|account.*disabled|address.*disabled)`)
-)
-
 // NewPOP returns a new instance of the POP mailbox client.
 func NewPOP(opt Opt, lo *log.Logger) *POP {
 	return &POP{
@@ -114,85 +69,17 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 			continue
 		}
 
-		// Parse the message.
-		m, err := message.Read(b)
+		bn, err := parseBounce(b.Bytes(), p.opt.Host)
 		if err != nil {
-			p.lo.Printf("error parsing bounce message %d: %v", id, err)
-			continue
-		}
-
-		h := m
-
-		// If this is a multipart message, find the last part.
-		if mr := m.MultipartReader(); mr != nil {
-			for {
-				part, err := mr.NextPart()
-				if err == io.EOF {
-					break
-				} else if err != nil {
-					p.lo.Printf("error reading multipart bounce message %d: %v", id, err)
-					continue
-				}
-				h = part
-			}
-		}
-
-		// Reset the "unread portion" pointer of the message buffer.
-		// If you don't do this, you can't read the entire body because the pointer will not point to the beginning.
-		b, _ = c.RetrRaw(id)
-
-		// Lookup headers in the e-mail. If a header isn't found, fall back to regexp lookups.
-		hdr := make(map[string]string, 7)
-		for _, l := range headerLookups {
-			v := h.Header.Get(l.Header)
-
-			// Not in the header. Try regexp.
-			if v == "" {
-				if m := l.Regexp.FindAllSubmatch(b.Bytes(), -1); m != nil {
-					v = string(m[len(m)-1][1])
-				}
+			// Delivery notifications that aren't failures are ignored.
+			if err != errNotBounce {
+				p.lo.Printf("error parsing bounce message %d: %v", id, err)
 			}
-
-			hdr[l.Header] = strings.TrimSpace(v)
-		}
-
-		// Received is a []string header.
-		msgReceived := h.Header.Map()[models.EmailHeaderReceived]
-		if len(msgReceived) == 0 {
-			if u := reHdrReceived.FindAllSubmatch(b.Bytes(), -1); u != nil {
-				for i := range u {
-					msgReceived = append(msgReceived, string(u[i][1]))
-				}
-			}
-		}
-
-		date, _ := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", hdr[models.EmailHeaderDate])
-		if date.IsZero() {
-			date = time.Now()
+			continue
 		}
 
-		// Classify the bounce type based on message content.
-		bounceType, bounceReason := classifyBounce(b.Bytes())
-
-		// Additional bounce e-mail metadata.
-		meta, _ := json.Marshal(bounceMeta{
-			From:           hdr[models.EmailHeaderFrom],
-			Subject:        hdr[models.EmailHeaderSubject],
-			MessageID:      hdr[models.EmailHeaderMessageId],
-			DeliveredTo:    hdr[models.EmailHeaderDeliveredTo],
-			Received:       msgReceived,
-			ClassifyReason: bounceReason,
-		})
-
 		select {
-		case ch <- models.Bounce{
-			Type:           bounceType,
-			CampaignUUID:   hdr[models.EmailHeaderCampaignUUID],
-			SubscriberUUID: hdr[models.EmailHeaderSubscriberUUID],
-			Source:         p.opt.Host,
-			CreatedAt:      date,
-			Meta:           meta,
-		}:
+		case ch <- bn:
 		default:
 		}
 	}
@@ -206,35 +93,3 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 
 	return nil
 }
-
-// classifyBounce analyzes the bounce message content and determines if it's a hard or soft bounce.
-// It checks SMTP status codes, diagnostic headers, and bounce keywords (using string heuristics).
-// soft is the default preference.
-// Returns the bounce type and a classification reason containing context about what matched.
-func classifyBounce(b []byte) (string, string) {
-	if matches := reSMTPStatus.FindAllSubmatch(b, -1); matches != nil {
-		for _, m := range matches {
-			if len(m) >= 2 && len(m[0]) > 1 {
-				// Full status code (e.g., "5.1.1").
-				status := m[1]
-
-				// 5.x.x is hard bounce.
-				if status[0] == '5' {
-					return models.BounceTypeHard, fmt.Sprintf("smtp_status=%s", status)
-				}
-
-				// 4.x.x  is soft bounce.
-				if status[0] == '4' {
-					return models.BounceTypeSoft, fmt.Sprintf("smtp_status=%s", status)
-				}
-			}
-		}
-	}
-
-	// Check for explicit hard bounce keywords.
-	if match := reHardBounce.FindSubmatch(b); match != nil {
-		return models.BounceTypeHard, fmt.Sprintf("body_match=%s", match[1])
-	}
-
-	return models.BounceTypeSoft, "default"
-}
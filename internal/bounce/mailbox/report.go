@@ -0,0 +1,230 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"github.com/knadh/listmonk/models"
+)
+
+// Report types in multipart/report messages.
+const (
+	reportTypeDSN = "delivery-status"
+	reportTypeARF = "feedback-report"
+)
+
+// errNotBounce is returned for delivery status notifications that don't
+// report a failure, for instance, successful delivery notifications.
+var errNotBounce = errors.New("not a bounce")
+
+// Enhanced mail system status code (RFC 3463) in DSN fields.
+var reStatusCode = regexp.MustCompile(`\b([245]\.\d{1,3}\.\d{1,3})\b`)
+
+// report is the machine-readable part of a delivery status notification
+// (RFC 3464) or an ARF feedback report (RFC 5965) along with the headers of
+// the original message that it's about.
+type report struct {
+	meta reportMeta
+
+	// Headers of the original message, if the report includes them.
+	header *message.Header
+}
+
+// reportMeta is the report information that's recorded in a bounce's meta.
+type reportMeta struct {
+	Type      string `json:"type"`
+	Recipient string `json:"recipient"`
+
+	// Delivery status notification fields.
+	Action         string `json:"action,omitempty"`
+	Status         string `json:"status,omitempty"`
+	DiagnosticCode string `json:"diagnostic_code,omitempty"`
+	RemoteMTA      string `json:"remote_mta,omitempty"`
+	ReportingMTA   string `json:"reporting_mta,omitempty"`
+
+	// Feedback report fields.
+	FeedbackType string `json:"feedback_type,omitempty"`
+	UserAgent    string `json:"user_agent,omitempty"`
+	SourceIP     string `json:"source_ip,omitempty"`
+
+	// Raw machine-readable report.
+	Raw string `json:"raw"`
+}
+
+// parseReport parses a multipart/report message.
+func parseReport(m *message.Entity) (*report, error) {
+	mr := m.MultipartReader()
+	if mr == nil {
+		return nil, errors.New("report is not a multipart message")
+	}
+
+	r := &report{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading report: %v", err)
+		}
+
+		mt, _, _ := p.Header.ContentType()
+		switch mt {
+		case "message/delivery-status", "message/global-delivery-status":
+			b, err := io.ReadAll(p.Body)
+			if err != nil {
+				return nil, fmt.Errorf("error reading delivery status: %v", err)
+			}
+			r.parseDSN(b)
+
+		case "message/feedback-report":
+			b, err := io.ReadAll(p.Body)
+			if err != nil {
+				return nil, fmt.Errorf("error reading feedback report: %v", err)
+			}
+			r.parseARF(b)
+
+		case "message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers":
+			hdr, err := textproto.ReadHeader(bufio.NewReader(p.Body))
+			if err != nil && err != io.EOF {
+				continue
+			}
+			r.header = &message.Header{Header: hdr}
+		}
+	}
+
+	if r.meta.Type == "" {
+		return nil, errors.New("no delivery status or feedback report found")
+	}
+
+	return r, nil
+}
+
+// parseDSN parses the fields of a message/delivery-status part which has a block
+// of per-message fields followed by blocks of per-recipient fields. Only the first
+// recipient that the delivery failed (or is delayed) for is considered.
+func (r *report) parseDSN(b []byte) {
+	r.meta.Type = reportTypeDSN
+	r.meta.Raw = string(b)
+
+	var (
+		br   = bufio.NewReader(bytes.NewReader(b))
+		rcpt *textproto.Header
+	)
+	for {
+		h, err := textproto.ReadHeader(br)
+		if h.Len() > 0 {
+			switch {
+			case h.Has("Reporting-MTA"):
+				r.meta.ReportingMTA = reportAddr(h.Get("Reporting-MTA"))
+
+			case h.Has("Final-Recipient") || h.Has("Original-Recipient"):
+				action := strings.ToLower(reportField(h.Get("Action")))
+				if rcpt == nil || (action == "failed" && strings.ToLower(reportField(rcpt.Get("Action"))) != "failed") {
+					rcpt = &h
+				}
+			}
+		}
+
+		// ReadHeader doesn't return an error at EOF.
+		if err != nil {
+			break
+		}
+		if _, err := br.Peek(1); err != nil {
+			break
+		}
+	}
+
+	if rcpt == nil {
+		return
+	}
+
+	// The original recipient is the address the message was sent to, which
+	// may have been forwarded to the final recipient.
+	r.meta.Recipient = reportAddr(rcpt.Get("Original-Recipient"))
+	if r.meta.Recipient == "" {
+		r.meta.Recipient = reportAddr(rcpt.Get("Final-Recipient"))
+	}
+
+	r.meta.Action = strings.ToLower(reportField(rcpt.Get("Action")))
+	r.meta.Status = reportField(rcpt.Get("Status"))
+	r.meta.DiagnosticCode = reportField(rcpt.Get("Diagnostic-Code"))
+	r.meta.RemoteMTA = reportAddr(rcpt.Get("Remote-MTA"))
+}
+
+// parseARF parses the fields of a message/feedback-report part.
+func (r *report) parseARF(b []byte) {
+	r.meta.Type = reportTypeARF
+	r.meta.Raw = string(b)
+
+	h, _ := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(b)))
+	r.meta.FeedbackType = strings.ToLower(reportField(h.Get("Feedback-Type")))
+	r.meta.UserAgent = reportField(h.Get("User-Agent"))
+	r.meta.SourceIP = reportField(h.Get("Source-IP"))
+	r.meta.Recipient = reportAddr(h.Get("Original-Rcpt-To"))
+}
+
+// classify returns the bounce type of the report and the reason for the
+// classification. Feedback reports are complaints. Delivery failures are
+// classified by their enhanced status code, 5.x.x being hard and 4.x.x soft.
+func (r *report) classify() (string, string, error) {
+	if r.meta.Type == reportTypeARF {
+		return models.BounceTypeComplaint, "feedback_type=" + r.meta.FeedbackType, nil
+	}
+
+	switch r.meta.Action {
+	case "delivered", "relayed", "expanded":
+		return "", "", errNotBounce
+	}
+
+	// The Status field is required, but it's often 5.0.0 or missing with the
+	// specific code only in the diagnostic.
+	status := reStatusCode.FindString(r.meta.Status)
+	if status == "" || strings.HasSuffix(status, ".0.0") {
+		if s := reStatusCode.FindString(r.meta.DiagnosticCode); s != "" && (status == "" || s[0] == status[0]) {
+			status = s
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(status, "5"):
+		return models.BounceTypeHard, "dsn_status=" + status, nil
+	case strings.HasPrefix(status, "4"):
+		return models.BounceTypeSoft, "dsn_status=" + status, nil
+	case strings.HasPrefix(status, "2"):
+		return "", "", errNotBounce
+	}
+
+	// No status code. Go by the action.
+	switch r.meta.Action {
+	case "failed":
+		return models.BounceTypeHard, "dsn_action=failed", nil
+	case "delayed":
+		return models.BounceTypeSoft, "dsn_action=delayed", nil
+	}
+
+	typ, reason := classifyBounce([]byte(r.meta.DiagnosticCode))
+	return typ, reason, nil
+}
+
+// reportField returns a report field's value with folded lines unfolded.
+func reportField(v string) string {
+	return strings.Join(strings.Fields(v), " ")
+}
+
+// reportAddr returns the address in a typed report field, for instance,
+// user@example.com from "rfc822; user@example.com".
+func reportAddr(v string) string {
+	v = reportField(v)
+	if _, addr, ok := strings.Cut(v, ";"); ok {
+		v = addr
+	}
+
+	return strings.Trim(strings.TrimSpace(v), "<>")
+}
@@ -0,0 +1,179 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	testCampUUID = "6f5c5e0e-2b6a-4b8a-9d3b-6d7c5a1e9f01"
+	testSource   = "mailbox"
+)
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		file     string
+		typ      string
+		reason   string
+		email    string
+		campUUID string
+		subUUID  string
+		subject  string
+		report   reportMeta
+	}{
+		{
+			file:     "dsn_hard.eml",
+			typ:      models.BounceTypeHard,
+			reason:   "dsn_status=5.1.1",
+			email:    "nobody@example.org",
+			campUUID: testCampUUID,
+			subUUID:  "0c8e7a6b-3f4d-4e2a-8b1c-9d0e1f2a3b4c",
+			subject:  "January newsletter",
+			report: reportMeta{
+				Type:           reportTypeDSN,
+				Recipient:      "nobody@example.org",
+				Action:         "failed",
+				Status:         "5.1.1",
+				DiagnosticCode: "smtp; 550 5.1.1 <nobody@example.org>: Recipient address rejected: User unknown",
+				RemoteMTA:      "mx.example.org",
+				ReportingMTA:   "mx.example.com",
+			},
+		},
+		{
+			file:     "dsn_soft.eml",
+			typ:      models.BounceTypeSoft,
+			reason:   "dsn_status=4.2.2",
+			email:    "full@example.net",
+			campUUID: testCampUUID,
+			subUUID:  "1d9f8b7c-4a5e-4f3b-9c2d-0e1f2a3b4c5d",
+			subject:  "January newsletter",
+			report: reportMeta{
+				Type:           reportTypeDSN,
+				Recipient:      "full@example.net",
+				Action:         "delayed",
+				Status:         "4.2.2",
+				DiagnosticCode: "smtp; 452 4.2.2 Mailbox full",
+				RemoteMTA:      "mx.example.net",
+				ReportingMTA:   "mx.example.com",
+			},
+		},
+		{
+			// The generic 5.0.0 status is refined by the diagnostic code and the
+			// original recipient is preferred over the one it was forwarded to.
+			file:    "dsn_diagnostic.eml",
+			typ:     models.BounceTypeHard,
+			reason:  "dsn_status=5.7.1",
+			email:   "old.address@example.com",
+			subject: "Returned mail: see transcript for details",
+			report: reportMeta{
+				Type:           reportTypeDSN,
+				Recipient:      "Old.Address@Example.com",
+				Action:         "failed",
+				Status:         "5.0.0",
+				DiagnosticCode: "smtp; 550 5.7.1 Message rejected due to local policy",
+				ReportingMTA:   "relay.example.com",
+			},
+		},
+		{
+			// Of multiple recipients, the one that failed is picked.
+			file:    "dsn_multi.eml",
+			typ:     models.BounceTypeHard,
+			reason:  "dsn_status=5.2.1",
+			email:   "gone@example.org",
+			subject: "Delivery status notification",
+			report: reportMeta{
+				Type:           reportTypeDSN,
+				Recipient:      "gone@example.org",
+				Action:         "failed",
+				Status:         "5.2.1",
+				DiagnosticCode: "smtp; 550 5.2.1 Mailbox disabled",
+				ReportingMTA:   "mx.example.com",
+			},
+		},
+		{
+			file:     "arf.eml",
+			typ:      models.BounceTypeComplaint,
+			reason:   "feedback_type=abuse",
+			email:    "complainer@example.net",
+			campUUID: testCampUUID,
+			subUUID:  "2e0a9c8d-5b6f-4a4c-8d3e-1f2a3b4c5d6e",
+			subject:  "January newsletter",
+			report: reportMeta{
+				Type:         reportTypeARF,
+				Recipient:    "Complainer@example.net",
+				FeedbackType: "abuse",
+				UserAgent:    "SomeGenerator/1.0",
+				SourceIP:     "192.0.2.1",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bn, err := parseBounce(b, testSource)
+			if err != nil {
+				t.Fatalf("error parsing bounce: %v", err)
+			}
+
+			if bn.Type != tc.typ {
+				t.Errorf("type: expected %q, got %q", tc.typ, bn.Type)
+			}
+			if bn.Email != tc.email {
+				t.Errorf("email: expected %q, got %q", tc.email, bn.Email)
+			}
+			if bn.CampaignUUID != tc.campUUID {
+				t.Errorf("campaign UUID: expected %q, got %q", tc.campUUID, bn.CampaignUUID)
+			}
+			if bn.SubscriberUUID != tc.subUUID {
+				t.Errorf("subscriber UUID: expected %q, got %q", tc.subUUID, bn.SubscriberUUID)
+			}
+			if bn.Source != testSource {
+				t.Errorf("source: expected %q, got %q", testSource, bn.Source)
+			}
+
+			var meta bounceMeta
+			if err := json.Unmarshal(bn.Meta, &meta); err != nil {
+				t.Fatalf("error unmarshalling meta: %v", err)
+			}
+			if meta.ClassifyReason != tc.reason {
+				t.Errorf("classify reason: expected %q, got %q", tc.reason, meta.ClassifyReason)
+			}
+			if meta.Subject != tc.subject {
+				t.Errorf("subject: expected %q, got %q", tc.subject, meta.Subject)
+			}
+			if meta.Report == nil {
+				t.Fatal("expected a report in the meta")
+			}
+
+			if meta.Report.Raw == "" {
+				t.Error("expected the raw report in the meta")
+			}
+			got := *meta.Report
+			got.Raw = ""
+			if got != tc.report {
+				t.Errorf("report:\nexpected %+v\ngot      %+v", tc.report, got)
+			}
+		})
+	}
+}
+
+func TestParseReportNotBounce(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "dsn_delivered.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseBounce(b, testSource); !errors.Is(err, errNotBounce) {
+		t.Fatalf("expected %v, got %v", errNotBounce, err)
+	}
+}
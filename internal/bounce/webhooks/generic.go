@@ -0,0 +1,325 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Authentication schemes of generic webhooks.
+const (
+	GenericAuthNone   = "none"
+	GenericAuthHMAC   = "hmac"
+	GenericAuthBasic  = "basic"
+	GenericAuthSecret = "secret"
+)
+
+// Default header in which the shared secret is sent.
+const genericSecretHeader = "X-Listmonk-Webhook-Secret"
+
+// GenericOpt represents the declarative definition of a bounce webhook
+// of a service that doesn't have a native handler.
+type GenericOpt struct {
+	// Name of the service in the webhook URL, /webhooks/service/{name}.
+	Name string `json:"name"`
+
+	// AuthType is one of none, hmac (HMAC-SHA256 signature of the body in the
+	// AuthHeader header), basic (basic auth with AuthUsername and AuthSecret),
+	// or secret (AuthSecret as-is in the AuthHeader header).
+	AuthType     string `json:"auth_type"`
+	AuthHeader   string `json:"auth_header"`
+	AuthUsername string `json:"auth_username"`
+	AuthSecret   string `json:"auth_secret"`
+
+	// JSONPath-style paths (eg: $.event-data.recipient, $.events[0].email) to
+	// fields in the payload. RecordsPath is the path to the list of events
+	// if the payload has multiple events. The other paths are relative to an event.
+	RecordsPath      string `json:"records_path"`
+	EmailPath        string `json:"email_path"`
+	TypePath         string `json:"type_path"`
+	CampaignUUIDPath string `json:"campaign_uuid_path"`
+
+	// MetaPath is the path to the value that's recorded as the bounce's meta.
+	// If it's empty, the entire event is recorded.
+	MetaPath string `json:"meta_path"`
+
+	// TypeMap maps values of the type field to bounce types (hard, soft, complaint).
+	// Events with values that aren't in the map are ignored. If it's empty,
+	// the values should be bounce types.
+	TypeMap map[string]string `json:"type_map"`
+}
+
+// Generic handles bounce webhook notifications with field mappings configured
+// in a GenericOpt.
+type Generic struct {
+	opt GenericOpt
+
+	records      jsonPath
+	email        jsonPath
+	typ          jsonPath
+	campaignUUID jsonPath
+	meta         jsonPath
+}
+
+// jsonPath is a parsed JSONPath-style path where every segment is either
+// an object key (string) or an array index (int).
+type jsonPath []any
+
+// NewGeneric returns a new generic webhook handler.
+func NewGeneric(opt GenericOpt) (*Generic, error) {
+	switch opt.AuthType {
+	case "":
+		opt.AuthType = GenericAuthNone
+	case GenericAuthNone, GenericAuthBasic:
+	case GenericAuthHMAC, GenericAuthSecret:
+		if opt.AuthHeader == "" {
+			opt.AuthHeader = genericSecretHeader
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth type: %s", opt.AuthType)
+	}
+
+	if opt.AuthType != GenericAuthNone && opt.AuthSecret == "" {
+		return nil, errors.New("auth secret is not configured")
+	}
+
+	if opt.EmailPath == "" || opt.TypePath == "" {
+		return nil, errors.New("email and type paths are required")
+	}
+
+	for k, v := range opt.TypeMap {
+		if v != models.BounceTypeHard && v != models.BounceTypeSoft && v != models.BounceTypeComplaint {
+			return nil, fmt.Errorf("invalid bounce type for %s: %s", k, v)
+		}
+	}
+
+	g := &Generic{opt: opt}
+	for _, p := range []struct {
+		path string
+		out  *jsonPath
+	}{
+		{opt.RecordsPath, &g.records},
+		{opt.EmailPath, &g.email},
+		{opt.TypePath, &g.typ},
+		{opt.CampaignUUIDPath, &g.campaignUUID},
+		{opt.MetaPath, &g.meta},
+	} {
+		jp, err := parseJSONPath(p.path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %s: %v", p.path, err)
+		}
+		*p.out = jp
+	}
+
+	return g, nil
+}
+
+// ProcessBounce authenticates an incoming webhook request and returns
+// the bounces mapped from the events in its payload.
+func (g *Generic) ProcessBounce(r *http.Request, body []byte) ([]models.Bounce, error) {
+	if err := g.verify(r, body); err != nil {
+		return nil, err
+	}
+
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("error unmarshalling %s notification: %v", g.opt.Name, err)
+	}
+
+	// The payload may have a single event or a list of events.
+	v, ok := g.records.lookup(payload)
+	if !ok {
+		return nil, fmt.Errorf("records not found in %s notification", g.opt.Name)
+	}
+
+	events, ok := v.([]any)
+	if !ok {
+		events = []any{v}
+	}
+
+	out := make([]models.Bounce, 0, len(events))
+	for _, ev := range events {
+		typ := g.bounceType(jsonString(g.typ.lookup(ev)))
+		if typ == "" {
+			continue
+		}
+
+		email := strings.ToLower(strings.TrimSpace(jsonString(g.email.lookup(ev))))
+		if email == "" {
+			continue
+		}
+
+		meta, _ := g.meta.lookup(ev)
+		b, err := json.Marshal(meta)
+		if err != nil {
+			b = []byte("{}")
+		}
+
+		out = append(out, models.Bounce{
+			Email:        email,
+			CampaignUUID: jsonString(g.campaignUUID.lookup(ev)),
+			Type:         typ,
+			Source:       g.opt.Name,
+			Meta:         json.RawMessage(b),
+			CreatedAt:    time.Now(),
+		})
+	}
+
+	return out, nil
+}
+
+// verify authenticates a request with the configured auth scheme.
+func (g *Generic) verify(r *http.Request, body []byte) error {
+	switch g.opt.AuthType {
+	case GenericAuthBasic:
+		user, pwd, ok := r.BasicAuth()
+		if !ok || !secureEqual(user, g.opt.AuthUsername) || !secureEqual(pwd, g.opt.AuthSecret) {
+			return errors.New("invalid credentials")
+		}
+
+	case GenericAuthSecret:
+		if !secureEqual(r.Header.Get(g.opt.AuthHeader), g.opt.AuthSecret) {
+			return errors.New("invalid secret")
+		}
+
+	case GenericAuthHMAC:
+		// The signature may be prefixed with the algorithm, eg: sha256=...
+		sig := strings.TrimSpace(r.Header.Get(g.opt.AuthHeader))
+		sig = strings.TrimPrefix(sig, "sha256=")
+		if sig == "" {
+			return errors.New("missing signature")
+		}
+
+		mac := hmac.New(sha256.New, []byte(g.opt.AuthSecret))
+		mac.Write(body)
+		sum := mac.Sum(nil)
+
+		// Services encode signatures either in hex or base64.
+		if b, err := hex.DecodeString(sig); err == nil && hmac.Equal(b, sum) {
+			return nil
+		}
+		if b, err := base64.StdEncoding.DecodeString(sig); err == nil && hmac.Equal(b, sum) {
+			return nil
+		}
+
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+// bounceType returns the bounce type for a value of the type field,
+// or an empty string if the event isn't a bounce.
+func (g *Generic) bounceType(v string) string {
+	if len(g.opt.TypeMap) > 0 {
+		return g.opt.TypeMap[v]
+	}
+
+	switch v {
+	case models.BounceTypeHard, models.BounceTypeSoft, models.BounceTypeComplaint:
+		return v
+	}
+
+	return ""
+}
+
+// parseJSONPath parses a JSONPath-style path that supports the root ($),
+// dotted keys (.key), quoted keys (['key']), and array indices ([0]).
+// The leading $ is optional and an empty path refers to the root.
+func parseJSONPath(p string) (jsonPath, error) {
+	p = strings.TrimSpace(p)
+	p = strings.TrimPrefix(p, "$")
+
+	var out jsonPath
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, errors.New("unclosed [")
+			}
+
+			seg := p[1:end]
+			if len(seg) >= 2 && (seg[0] == '\'' || seg[0] == '"') && seg[len(seg)-1] == seg[0] {
+				out = append(out, seg[1:len(seg)-1])
+			} else if n, err := strconv.Atoi(seg); err == nil && n >= 0 {
+				out = append(out, n)
+			} else {
+				return nil, fmt.Errorf("invalid segment [%s]", seg)
+			}
+			p = p[end+1:]
+
+		default:
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			out = append(out, p[:end])
+			p = p[end:]
+		}
+	}
+
+	return out, nil
+}
+
+// lookup returns the value at the path in an unmarshalled JSON value.
+func (jp jsonPath) lookup(v any) (any, bool) {
+	for _, seg := range jp {
+		switch s := seg.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[s]; !ok {
+				return nil, false
+			}
+
+		case int:
+			a, ok := v.([]any)
+			if !ok || s >= len(a) {
+				return nil, false
+			}
+			v = a[s]
+		}
+	}
+
+	return v, true
+}
+
+// jsonString returns the string representation of a scalar JSON value.
+func jsonString(v any, ok bool) string {
+	if !ok {
+		return ""
+	}
+
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(s)
+	}
+
+	return ""
+}
+
+// secureEqual compares two strings in constant time.
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
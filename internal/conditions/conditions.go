@@ -0,0 +1,612 @@
+// Package conditions compiles JSON condition trees that describe subscribers,
+// for instance, "subscribers in Bengaluru who opened campaign X in the last
+// 30 days", into parameterized SQL expressions on the subscribers table.
+// Unlike arbitrary SQL queries, values in conditions are never interpolated
+// into the SQL, and the SQL only accesses a fixed set of tables.
+package conditions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Groups.
+const (
+	MatchAll = "all"
+	MatchAny = "any"
+)
+
+// Fields.
+const (
+	FieldEmail     = "email"
+	FieldName      = "name"
+	FieldStatus    = "status"
+	FieldAttribute = "attribute"
+	FieldCreatedAt = "created_at"
+	FieldUpdatedAt = "updated_at"
+	FieldList      = "list"
+	FieldOpened    = "opened"
+	FieldClicked   = "clicked"
+	FieldBounced   = "bounced"
+
+	FieldEngagementScore = "engagement_score"
+	FieldLastEngagedAt   = "last_engaged_at"
+	FieldInactive        = "inactive"
+)
+
+// Operators.
+const (
+	OpEq            = "eq"
+	OpNeq           = "neq"
+	OpGt            = "gt"
+	OpGte           = "gte"
+	OpLt            = "lt"
+	OpLte           = "lte"
+	OpContains      = "contains"
+	OpNotContains   = "not_contains"
+	OpStartsWith    = "starts_with"
+	OpEndsWith      = "ends_with"
+	OpIn            = "in"
+	OpNotIn         = "not_in"
+	OpExists        = "exists"
+	OpNotExists     = "not_exists"
+	OpWithinDays    = "within_days"
+	OpOlderThanDays = "older_than_days"
+)
+
+const (
+	// Max depth of nested groups.
+	maxDepth = 8
+
+	// Max number of conditions in a tree.
+	maxConditions = 100
+)
+
+var (
+	subStatuses    = []string{"enabled", "disabled", "blocklisted"}
+	subscrStatuses = []string{"unconfirmed", "confirmed", "unsubscribed"}
+	bounceTypes    = []string{"soft", "hard", "complaint"}
+
+	// Tables of the activity fields.
+	activityTables = map[string]string{
+		FieldOpened:  "campaign_views",
+		FieldClicked: "link_clicks",
+		FieldBounced: "bounces",
+	}
+)
+
+// Condition is a node in a condition tree. It's either a group of Conditions
+// that are combined by Match, or a single condition on a Field if Field is set.
+type Condition struct {
+	// all (AND) or any (OR). Defaults to all.
+	Match      string      `json:"match,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Not negates the condition or the group.
+	Not bool `json:"not,omitempty"`
+
+	Field    string          `json:"field,omitempty"`
+	Operator string          `json:"operator,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+
+	// Path is the dot separated path of an attribute, eg: "location.city".
+	Path string `json:"path,omitempty"`
+
+	// Status is the optional subscription status of list conditions.
+	Status string `json:"status,omitempty"`
+
+	// Days optionally restricts opened, clicked, and bounced conditions to
+	// activity in the last n days.
+	Days int `json:"days,omitempty"`
+}
+
+// compiler compiles a tree and collects the values of its parameters.
+type compiler struct {
+	args      []any
+	argOffset int
+	count     int
+}
+
+// Parse parses a JSON condition tree. An empty or null tree matches
+// all subscribers.
+func Parse(b []byte) (Condition, error) {
+	var c Condition
+	if b = bytes.TrimSpace(b); len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		return c, nil
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("error parsing conditions: %v", err)
+	}
+
+	return c, nil
+}
+
+// Compile compiles a condition tree into an SQL expression on the subscribers table.
+// Values are not interpolated into the expression but are referenced as positional
+// parameters ($n) starting after argOffset, so that the expression can be used in
+// queries that have their own parameters. The values of the parameters are returned.
+func Compile(c Condition, argOffset int) (string, []any, error) {
+	cm := &compiler{argOffset: argOffset}
+
+	exp, err := cm.compile(c, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return exp, cm.args, nil
+}
+
+// IsEmpty returns true if the tree has no conditions, that is, it matches all subscribers.
+func (c Condition) IsEmpty() bool {
+	if c.Field != "" {
+		return false
+	}
+
+	for _, s := range c.Conditions {
+		if !s.IsEmpty() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cm *compiler) compile(c Condition, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("conditions are nested deeper than %d levels", maxDepth)
+	}
+
+	cm.count++
+	if cm.count > maxConditions {
+		return "", fmt.Errorf("there are more than %d conditions", maxConditions)
+	}
+
+	var (
+		exp string
+		err error
+	)
+	if c.Field == "" {
+		exp, err = cm.compileGroup(c, depth)
+	} else {
+		exp, err = cm.compileField(c)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if c.Not {
+		return "NOT (" + exp + ")", nil
+	}
+
+	return exp, nil
+}
+
+func (cm *compiler) compileGroup(c Condition, depth int) (string, error) {
+	join := " AND "
+	switch c.Match {
+	case MatchAll, "":
+	case MatchAny:
+		join = " OR "
+	default:
+		return "", fmt.Errorf("invalid match '%s'", c.Match)
+	}
+
+	// An empty group matches everything.
+	if len(c.Conditions) == 0 {
+		return "TRUE", nil
+	}
+
+	out := make([]string, 0, len(c.Conditions))
+	for _, s := range c.Conditions {
+		exp, err := cm.compile(s, depth+1)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, "("+exp+")")
+	}
+
+	return strings.Join(out, join), nil
+}
+
+func (cm *compiler) compileField(c Condition) (string, error) {
+	switch c.Field {
+	case FieldEmail:
+		return cm.compileText("LOWER(subscribers.email)", true, c)
+	case FieldName:
+		return cm.compileText("subscribers.name", false, c)
+	case FieldStatus:
+		return cm.compileEnum("subscribers.status", "subscriber_status", subStatuses, c)
+	case FieldAttribute:
+		return cm.compileAttribute(c)
+	case FieldCreatedAt:
+		return cm.compileDate("subscribers.created_at", c)
+	case FieldUpdatedAt:
+		return cm.compileDate("subscribers.updated_at", c)
+	case FieldList:
+		return cm.compileList(c)
+	case FieldOpened, FieldClicked, FieldBounced:
+		return cm.compileActivity(c)
+	case FieldEngagementScore:
+		return cm.compileNumber("COALESCE("+engagementCol("score")+", 0)", c)
+	case FieldLastEngagedAt:
+		return cm.compileDate(engagementCol("last_engaged_at"), c)
+	case FieldInactive:
+		if c.Operator != OpEq {
+			return "", errOperator(c)
+		}
+
+		var v bool
+		if err := decodeValue(c, &v); err != nil {
+			return "", err
+		}
+		return "COALESCE(" + engagementCol("inactive") + ", FALSE) = " + cm.arg(v) + "::BOOLEAN", nil
+	}
+
+	return "", fmt.Errorf("invalid field '%s'", c.Field)
+}
+
+// compileText compiles conditions on a text column. If lower is true, the column
+// is expected to be lowercased and values are lowercased as well.
+func (cm *compiler) compileText(col string, lower bool, c Condition) (string, error) {
+	norm := func(s string) string {
+		if lower {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+
+	switch c.Operator {
+	case OpIn, OpNotIn:
+		var vals []string
+		if err := decodeValue(c, &vals); err != nil {
+			return "", err
+		}
+		for i, v := range vals {
+			vals[i] = norm(v)
+		}
+
+		exp := col + " = ANY(" + cm.arg(pq.Array(vals)) + "::TEXT[])"
+		if c.Operator == OpNotIn {
+			return "NOT (" + exp + ")", nil
+		}
+		return exp, nil
+	}
+
+	var val string
+	if err := decodeValue(c, &val); err != nil {
+		return "", err
+	}
+	val = norm(val)
+
+	switch c.Operator {
+	case OpEq:
+		return col + " = " + cm.arg(val) + "::TEXT", nil
+	case OpNeq:
+		return col + " != " + cm.arg(val) + "::TEXT", nil
+	case OpContains:
+		return col + " ILIKE " + cm.arg("%"+escapeLike(val)+"%") + "::TEXT", nil
+	case OpNotContains:
+		return col + " NOT ILIKE " + cm.arg("%"+escapeLike(val)+"%") + "::TEXT", nil
+	case OpStartsWith:
+		return col + " ILIKE " + cm.arg(escapeLike(val)+"%") + "::TEXT", nil
+	case OpEndsWith:
+		return col + " ILIKE " + cm.arg("%"+escapeLike(val)) + "::TEXT", nil
+	}
+
+	return "", errOperator(c)
+}
+
+// compileEnum compiles conditions on an enum column.
+func (cm *compiler) compileEnum(col, typ string, options []string, c Condition) (string, error) {
+	var vals []string
+	switch c.Operator {
+	case OpEq, OpNeq:
+		var v string
+		if err := decodeValue(c, &v); err != nil {
+			return "", err
+		}
+		vals = []string{v}
+	case OpIn, OpNotIn:
+		if err := decodeValue(c, &vals); err != nil {
+			return "", err
+		}
+	default:
+		return "", errOperator(c)
+	}
+
+	for _, v := range vals {
+		if !slices.Contains(options, v) {
+			return "", fmt.Errorf("invalid value '%s' for field '%s'", v, c.Field)
+		}
+	}
+
+	exp := col + " = ANY(" + cm.arg(pq.Array(vals)) + "::" + typ + "[])"
+	if c.Operator == OpNeq || c.Operator == OpNotIn {
+		return "NOT (" + exp + ")", nil
+	}
+	return exp, nil
+}
+
+// compileAttribute compiles conditions on a value in the subscriber attributes.
+func (cm *compiler) compileAttribute(c Condition) (string, error) {
+	path := strings.Split(c.Path, ".")
+	for _, p := range path {
+		if strings.TrimSpace(p) == "" {
+			return "", fmt.Errorf("invalid attribute path '%s'", c.Path)
+		}
+	}
+
+	// The JSON value and the text value at the path. Parameters are only added when
+	// they're used as Postgres can't infer the types of unused parameters.
+	val := func() string { return "(subscribers.attribs #> " + cm.arg(pq.Array(path)) + "::TEXT[])" }
+	text := func() string { return "(subscribers.attribs #>> " + cm.arg(pq.Array(path)) + "::TEXT[])" }
+
+	switch c.Operator {
+	case OpExists:
+		return val() + " IS NOT NULL", nil
+	case OpNotExists:
+		return val() + " IS NULL", nil
+
+	case OpEq, OpNeq:
+		// Compare JSON values so that strings, numbers, and booleans are all matched by type.
+		v, err := decodeJSON(c)
+		if err != nil {
+			return "", err
+		}
+
+		if c.Operator == OpEq {
+			return val() + " = " + cm.arg(v) + "::JSONB", nil
+		}
+		return val() + " IS DISTINCT FROM " + cm.arg(v) + "::JSONB", nil
+
+	case OpIn, OpNotIn:
+		var vals []json.RawMessage
+		if err := decodeValue(c, &vals); err != nil {
+			return "", err
+		}
+
+		s := make([]string, len(vals))
+		for i, v := range vals {
+			s[i] = string(v)
+		}
+
+		exp := val() + " = ANY(" + cm.arg(pq.Array(s)) + "::JSONB[])"
+		if c.Operator == OpNotIn {
+			return "NOT COALESCE(" + exp + ", FALSE)", nil
+		}
+		return exp, nil
+
+	case OpGt, OpGte, OpLt, OpLte:
+		v, err := decodeJSON(c)
+		if err != nil {
+			return "", err
+		}
+
+		// Numbers are compared numerically and everything else as text, eg: ISO dates.
+		var n float64
+		if err := json.Unmarshal([]byte(v), &n); err == nil {
+			return "(CASE WHEN JSONB_TYPEOF" + val() + " = 'number' THEN " + text() + "::NUMERIC END) " +
+				compOps[c.Operator] + " " + cm.arg(n) + "::NUMERIC", nil
+		}
+
+		var s string
+		if err := json.Unmarshal([]byte(v), &s); err != nil {
+			return "", fmt.Errorf("value of attribute '%s' should be a number or a string", c.Path)
+		}
+		return text() + " " + compOps[c.Operator] + " " + cm.arg(s) + "::TEXT", nil
+
+	case OpContains, OpNotContains, OpStartsWith, OpEndsWith:
+		return cm.compileText(text(), false, c)
+	}
+
+	return "", errOperator(c)
+}
+
+// compileNumber compiles conditions on a numeric column.
+func (cm *compiler) compileNumber(col string, c Condition) (string, error) {
+	var op string
+	switch c.Operator {
+	case OpEq:
+		op = "="
+	case OpNeq:
+		op = "!="
+	case OpGt, OpGte, OpLt, OpLte:
+		op = compOps[c.Operator]
+	default:
+		return "", errOperator(c)
+	}
+
+	var n float64
+	if err := decodeValue(c, &n); err != nil {
+		return "", err
+	}
+
+	return col + " " + op + " " + cm.arg(n) + "::NUMERIC", nil
+}
+
+// compileDate compiles conditions on a timestamp column.
+func (cm *compiler) compileDate(col string, c Condition) (string, error) {
+	switch c.Operator {
+	case OpWithinDays, OpOlderThanDays:
+		var days int
+		if err := decodeValue(c, &days); err != nil {
+			return "", err
+		}
+		if days < 0 {
+			return "", fmt.Errorf("invalid number of days for field '%s'", c.Field)
+		}
+
+		op := ">="
+		if c.Operator == OpOlderThanDays {
+			op = "<"
+		}
+		return col + " " + op + " NOW() - MAKE_INTERVAL(days => " + cm.arg(days) + "::INT)", nil
+
+	case OpGt, OpGte, OpLt, OpLte:
+		var s string
+		if err := decodeValue(c, &s); err != nil {
+			return "", err
+		}
+
+		t, err := parseDate(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid date '%s' for field '%s'", s, c.Field)
+		}
+		return col + " " + compOps[c.Operator] + " " + cm.arg(t) + "::TIMESTAMP WITH TIME ZONE", nil
+	}
+
+	return "", errOperator(c)
+}
+
+// compileList compiles list membership conditions. An empty list of IDs matches any list.
+func (cm *compiler) compileList(c Condition) (string, error) {
+	if c.Operator != OpIn && c.Operator != OpNotIn {
+		return "", errOperator(c)
+	}
+
+	var ids []int
+	if err := decodeValue(c, &ids); err != nil {
+		return "", err
+	}
+
+	exp := "SELECT 1 FROM subscriber_lists WHERE subscriber_lists.subscriber_id = subscribers.id"
+	if len(ids) > 0 {
+		exp += " AND subscriber_lists.list_id = ANY(" + cm.arg(pq.Array(ids)) + "::INT[])"
+	}
+
+	if c.Status != "" {
+		if !slices.Contains(subscrStatuses, c.Status) {
+			return "", fmt.Errorf("invalid subscription status '%s'", c.Status)
+		}
+		exp += " AND subscriber_lists.status = " + cm.arg(c.Status) + "::subscription_status"
+	}
+
+	if c.Operator == OpNotIn {
+		return "NOT EXISTS (" + exp + ")", nil
+	}
+	return "EXISTS (" + exp + ")", nil
+}
+
+// compileActivity compiles conditions on campaign views, link clicks, and bounces.
+// The value is a list of campaign IDs, or for bounces, bounce types. An empty list
+// matches any activity.
+func (cm *compiler) compileActivity(c Condition) (string, error) {
+	if c.Operator != OpIn && c.Operator != OpNotIn {
+		return "", errOperator(c)
+	}
+
+	table := activityTables[c.Field]
+	exp := "SELECT 1 FROM " + table + " WHERE " + table + ".subscriber_id = subscribers.id"
+
+	if c.Field == FieldBounced {
+		var types []string
+		if err := decodeValue(c, &types); err != nil {
+			return "", err
+		}
+		for _, t := range types {
+			if !slices.Contains(bounceTypes, t) {
+				return "", fmt.Errorf("invalid bounce type '%s'", t)
+			}
+		}
+
+		if len(types) > 0 {
+			exp += " AND " + table + ".type = ANY(" + cm.arg(pq.Array(types)) + "::bounce_type[])"
+		}
+	} else {
+		var ids []int
+		if err := decodeValue(c, &ids); err != nil {
+			return "", err
+		}
+
+		if len(ids) > 0 {
+			exp += " AND " + table + ".campaign_id = ANY(" + cm.arg(pq.Array(ids)) + "::INT[])"
+		}
+	}
+
+	if c.Days < 0 {
+		return "", fmt.Errorf("invalid number of days for field '%s'", c.Field)
+	} else if c.Days > 0 {
+		exp += " AND " + table + ".created_at >= NOW() - MAKE_INTERVAL(days => " + cm.arg(c.Days) + "::INT)"
+	}
+
+	if c.Operator == OpNotIn {
+		return "NOT EXISTS (" + exp + ")", nil
+	}
+	return "EXISTS (" + exp + ")", nil
+}
+
+// arg adds a parameter value and returns its positional placeholder.
+func (cm *compiler) arg(v any) string {
+	cm.args = append(cm.args, v)
+	return "$" + strconv.Itoa(cm.argOffset+len(cm.args))
+}
+
+var compOps = map[string]string{
+	OpGt:  ">",
+	OpGte: ">=",
+	OpLt:  "<",
+	OpLte: "<=",
+}
+
+// decodeValue decodes the value of a condition into v.
+func decodeValue(c Condition, v any) error {
+	if len(c.Value) == 0 {
+		// Lists of IDs and types are optional.
+		if _, ok := v.(*[]int); ok {
+			return nil
+		}
+		if _, ok := v.(*[]string); ok && c.Field == FieldBounced {
+			return nil
+		}
+
+		return fmt.Errorf("value is required for field '%s'", c.Field)
+	}
+
+	if err := json.Unmarshal(c.Value, v); err != nil {
+		return fmt.Errorf("invalid value for field '%s': %v", c.Field, err)
+	}
+
+	return nil
+}
+
+// decodeJSON returns the value of a condition as a valid JSON string.
+func decodeJSON(c Condition) (string, error) {
+	if len(c.Value) == 0 || !json.Valid(c.Value) {
+		return "", fmt.Errorf("invalid value for field '%s'", c.Field)
+	}
+
+	return string(c.Value), nil
+}
+
+// parseDate parses a date (YYYY-MM-DD) or a timestamp (RFC3339).
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.DateOnly, s)
+}
+
+// engagementCol returns a subquery that selects a column of the subscriber's
+// engagement, which is NULL if it hasn't been computed.
+func engagementCol(col string) string {
+	return "(SELECT subscriber_engagement." + col + " FROM subscriber_engagement" +
+		" WHERE subscriber_engagement.subscriber_id = subscribers.id)"
+}
+
+// escapeLike escapes LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func errOperator(c Condition) error {
+	return fmt.Errorf("invalid operator '%s' for field '%s'", c.Operator, c.Field)
+}
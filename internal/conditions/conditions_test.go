@@ -0,0 +1,314 @@
+package conditions
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var reParam = regexp.MustCompile(`\$(\d+)`)
+
+func mustParse(t *testing.T, s string) Condition {
+	t.Helper()
+
+	c, err := Parse([]byte(s))
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", s, err)
+	}
+
+	return c
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		cond string
+		exp  string
+		args []any
+	}{
+		{
+			name: "empty",
+			cond: `{}`,
+			exp:  `TRUE`,
+		},
+		{
+			name: "email eq lowercased",
+			cond: `{"field": "email", "operator": "eq", "value": "John@Example.com"}`,
+			exp:  `LOWER(subscribers.email) = $1::TEXT`,
+			args: []any{"john@example.com"},
+		},
+		{
+			name: "name contains escaped",
+			cond: `{"field": "name", "operator": "contains", "value": "50%_off"}`,
+			exp:  `subscribers.name ILIKE $1::TEXT`,
+			args: []any{`%50\%\_off%`},
+		},
+		{
+			name: "name starts and ends with",
+			cond: `{"match": "any", "conditions": [
+				{"field": "name", "operator": "starts_with", "value": "Jo"},
+				{"field": "name", "operator": "ends_with", "value": "hn"}
+			]}`,
+			exp:  `(subscribers.name ILIKE $1::TEXT) OR (subscribers.name ILIKE $2::TEXT)`,
+			args: []any{"Jo%", "%hn"},
+		},
+		{
+			name: "email not in",
+			cond: `{"field": "email", "operator": "not_in", "value": ["A@example.com", "b@example.com"]}`,
+			exp:  `NOT (LOWER(subscribers.email) = ANY($1::TEXT[]))`,
+			args: []any{pq.Array([]string{"a@example.com", "b@example.com"})},
+		},
+		{
+			name: "status neq",
+			cond: `{"field": "status", "operator": "neq", "value": "blocklisted"}`,
+			exp:  `NOT (subscribers.status = ANY($1::subscriber_status[]))`,
+			args: []any{pq.Array([]string{"blocklisted"})},
+		},
+		{
+			name: "attribute eq",
+			cond: `{"field": "attribute", "path": "location.city", "operator": "eq", "value": "Bengaluru"}`,
+			exp:  `(subscribers.attribs #> $1::TEXT[]) = $2::JSONB`,
+			args: []any{pq.Array([]string{"location", "city"}), `"Bengaluru"`},
+		},
+		{
+			name: "attribute exists",
+			cond: `{"field": "attribute", "path": "plan", "operator": "not_exists"}`,
+			exp:  `(subscribers.attribs #> $1::TEXT[]) IS NULL`,
+			args: []any{pq.Array([]string{"plan"})},
+		},
+		{
+			name: "attribute number",
+			cond: `{"field": "attribute", "path": "age", "operator": "gte", "value": 18}`,
+			exp:  `(CASE WHEN JSONB_TYPEOF(subscribers.attribs #> $1::TEXT[]) = 'number' THEN (subscribers.attribs #>> $2::TEXT[])::NUMERIC END) >= $3::NUMERIC`,
+			args: []any{pq.Array([]string{"age"}), pq.Array([]string{"age"}), float64(18)},
+		},
+		{
+			name: "attribute string comparison",
+			cond: `{"field": "attribute", "path": "joined", "operator": "lt", "value": "2024-01-01"}`,
+			exp:  `(subscribers.attribs #>> $1::TEXT[]) < $2::TEXT`,
+			args: []any{pq.Array([]string{"joined"}), "2024-01-01"},
+		},
+		{
+			name: "attribute not in",
+			cond: `{"field": "attribute", "path": "tier", "operator": "not_in", "value": ["gold", 1]}`,
+			exp:  `NOT COALESCE((subscribers.attribs #> $1::TEXT[]) = ANY($2::JSONB[]), FALSE)`,
+			args: []any{pq.Array([]string{"tier"}), pq.Array([]string{`"gold"`, `1`})},
+		},
+		{
+			name: "created within days",
+			cond: `{"field": "created_at", "operator": "within_days", "value": 30}`,
+			exp:  `subscribers.created_at >= NOW() - MAKE_INTERVAL(days => $1::INT)`,
+			args: []any{30},
+		},
+		{
+			name: "updated before date",
+			cond: `{"field": "updated_at", "operator": "lt", "value": "2024-01-02"}`,
+			exp:  `subscribers.updated_at < $1::TIMESTAMP WITH TIME ZONE`,
+			args: []any{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "list with status",
+			cond: `{"field": "list", "operator": "in", "value": [1, 2], "status": "confirmed"}`,
+			exp: `EXISTS (SELECT 1 FROM subscriber_lists WHERE subscriber_lists.subscriber_id = subscribers.id` +
+				` AND subscriber_lists.list_id = ANY($1::INT[]) AND subscriber_lists.status = $2::subscription_status)`,
+			args: []any{pq.Array([]int{1, 2}), "confirmed"},
+		},
+		{
+			name: "not in any list",
+			cond: `{"field": "list", "operator": "not_in"}`,
+			exp:  `NOT EXISTS (SELECT 1 FROM subscriber_lists WHERE subscriber_lists.subscriber_id = subscribers.id)`,
+		},
+		{
+			name: "opened campaign in days",
+			cond: `{"field": "opened", "operator": "in", "value": [7], "days": 30}`,
+			exp: `EXISTS (SELECT 1 FROM campaign_views WHERE campaign_views.subscriber_id = subscribers.id` +
+				` AND campaign_views.campaign_id = ANY($1::INT[]) AND campaign_views.created_at >= NOW() - MAKE_INTERVAL(days => $2::INT))`,
+			args: []any{pq.Array([]int{7}), 30},
+		},
+		{
+			name: "never clicked",
+			cond: `{"field": "clicked", "operator": "not_in"}`,
+			exp:  `NOT EXISTS (SELECT 1 FROM link_clicks WHERE link_clicks.subscriber_id = subscribers.id)`,
+		},
+		{
+			name: "bounced hard",
+			cond: `{"field": "bounced", "operator": "in", "value": ["hard", "complaint"]}`,
+			exp: `EXISTS (SELECT 1 FROM bounces WHERE bounces.subscriber_id = subscribers.id` +
+				` AND bounces.type = ANY($1::bounce_type[]))`,
+			args: []any{pq.Array([]string{"hard", "complaint"})},
+		},
+		{
+			name: "engagement",
+			cond: `{"match": "all", "conditions": [
+				{"field": "engagement_score", "operator": "gt", "value": 0.5},
+				{"field": "inactive", "operator": "eq", "value": false}
+			]}`,
+			exp: `(COALESCE((SELECT subscriber_engagement.score FROM subscriber_engagement WHERE subscriber_engagement.subscriber_id = subscribers.id), 0) > $1::NUMERIC)` +
+				` AND (COALESCE((SELECT subscriber_engagement.inactive FROM subscriber_engagement WHERE subscriber_engagement.subscriber_id = subscribers.id), FALSE) = $2::BOOLEAN)`,
+			args: []any{0.5, false},
+		},
+		{
+			name: "nested and negated",
+			cond: `{"match": "all", "conditions": [
+				{"field": "status", "operator": "eq", "value": "enabled"},
+				{"not": true, "match": "any", "conditions": [
+					{"field": "email", "operator": "ends_with", "value": "@example.com"},
+					{"field": "name", "operator": "eq", "value": "Test"}
+				]}
+			]}`,
+			exp: `(subscribers.status = ANY($1::subscriber_status[]))` +
+				` AND (NOT ((LOWER(subscribers.email) ILIKE $2::TEXT) OR (subscribers.name = $3::TEXT)))`,
+			args: []any{pq.Array([]string{"enabled"}), "%@example.com", "Test"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			exp, args, err := Compile(mustParse(t, tc.cond), 0)
+			if err != nil {
+				t.Fatalf("error compiling: %v", err)
+			}
+			if exp != tc.exp {
+				t.Errorf("expected SQL:\n%s\ngot:\n%s", tc.exp, exp)
+			}
+			if !reflect.DeepEqual(args, tc.args) {
+				t.Errorf("expected args %#v, got %#v", tc.args, args)
+			}
+		})
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cond string
+		err  string
+	}{
+		{"unknown field", `{"field": "password", "operator": "eq", "value": "x"}`, "invalid field 'password'"},
+		{"SQL field", `{"field": "subscribers.id; DROP TABLE subscribers", "operator": "eq", "value": 1}`, "invalid field"},
+		{"unknown operator", `{"field": "email", "operator": "like", "value": "x"}`, "invalid operator 'like' for field 'email'"},
+		{"SQL operator", `{"field": "name", "operator": "= 1 OR 1=1 --", "value": "x"}`, "invalid operator"},
+		{"operator for field", `{"field": "list", "operator": "eq", "value": [1]}`, "invalid operator 'eq' for field 'list'"},
+		{"date operator on text", `{"field": "email", "operator": "within_days", "value": "1"}`, "invalid operator"},
+		{"inactive operator", `{"field": "inactive", "operator": "neq", "value": true}`, "invalid operator"},
+		{"unknown match", `{"match": "none", "conditions": []}`, "invalid match 'none'"},
+		{"nested unknown field", `{"conditions": [{"conditions": [{"field": "id", "operator": "eq", "value": 1}]}]}`, "invalid field 'id'"},
+		{"missing value", `{"field": "email", "operator": "eq"}`, "value is required for field 'email'"},
+		{"wrong value type", `{"field": "created_at", "operator": "within_days", "value": "30"}`, "invalid value for field 'created_at'"},
+		{"negative days", `{"field": "created_at", "operator": "older_than_days", "value": -1}`, "invalid number of days"},
+		{"negative activity days", `{"field": "opened", "operator": "in", "days": -1}`, "invalid number of days"},
+		{"bad date", `{"field": "created_at", "operator": "gt", "value": "yesterday"}`, "invalid date 'yesterday'"},
+		{"unknown status", `{"field": "status", "operator": "in", "value": ["enabled", "deleted"]}`, "invalid value 'deleted'"},
+		{"unknown subscription status", `{"field": "list", "operator": "in", "status": "pending"}`, "invalid subscription status 'pending'"},
+		{"unknown bounce type", `{"field": "bounced", "operator": "in", "value": ["bad"]}`, "invalid bounce type 'bad'"},
+		{"empty attribute path", `{"field": "attribute", "path": "a..b", "operator": "exists"}`, "invalid attribute path 'a..b'"},
+		{"attribute comparison value", `{"field": "attribute", "path": "a", "operator": "gt", "value": true}`, "should be a number or a string"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			exp, args, err := Compile(mustParse(t, tc.cond), 0)
+			if err == nil {
+				t.Fatalf("expected an error, got %s %v", exp, args)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	// Nesting deeper than the max depth.
+	c := Condition{Field: FieldEmail, Operator: OpEq, Value: []byte(`"a@example.com"`)}
+	for range maxDepth + 1 {
+		c = Condition{Conditions: []Condition{c}}
+	}
+	if _, _, err := Compile(c, 0); err == nil {
+		t.Error("expected an error on deep nesting")
+	}
+
+	// More than the max number of conditions.
+	c = Condition{Match: MatchAny}
+	for range maxConditions {
+		c.Conditions = append(c.Conditions, Condition{Field: FieldEmail, Operator: OpEq, Value: []byte(`"a@example.com"`)})
+	}
+	if _, _, err := Compile(c, 0); err == nil {
+		t.Error("expected an error on too many conditions")
+	}
+	c.Conditions = c.Conditions[:maxConditions-1]
+	if _, _, err := Compile(c, 0); err != nil {
+		t.Errorf("expected %d conditions to compile, got %v", maxConditions, err)
+	}
+}
+
+func TestCompileParameterized(t *testing.T) {
+	// Values that would break out of the SQL if they were interpolated.
+	inj := `x'); DROP TABLE subscribers; --`
+
+	c := mustParse(t, `{"match": "any", "conditions": [
+		{"field": "email", "operator": "eq", "value": "`+inj+`"},
+		{"field": "name", "operator": "contains", "value": "`+inj+`"},
+		{"field": "name", "operator": "in", "value": ["`+inj+`"]},
+		{"field": "attribute", "path": "`+inj+`", "operator": "eq", "value": "`+inj+`"},
+		{"field": "attribute", "path": "a", "operator": "gt", "value": "`+inj+`"},
+		{"field": "list", "operator": "in", "value": [1], "status": "confirmed"},
+		{"field": "opened", "operator": "in", "value": [1], "days": 7}
+	]}`)
+
+	// Placeholders start after the offset so that the expression can be
+	// used in queries with their own parameters.
+	const offset = 3
+	exp, args, err := Compile(c, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{inj, "DROP", "'", ";", "--"} {
+		if strings.Contains(exp, s) {
+			t.Errorf("expected %q to not be in the SQL: %s", s, exp)
+		}
+	}
+
+	// Every value is a parameter and every parameter is used once, in order.
+	matches := reParam.FindAllStringSubmatch(exp, -1)
+	if len(matches) != len(args) {
+		t.Fatalf("expected %d placeholders, got %d: %s", len(args), len(matches), exp)
+	}
+	for i, m := range matches {
+		if n, _ := strconv.Atoi(m[1]); n != offset+i+1 {
+			t.Errorf("expected placeholder $%d, got $%d", offset+i+1, n)
+		}
+	}
+
+	// The values are passed as-is.
+	if args[0] != strings.ToLower(inj) || !reflect.DeepEqual(args[2], pq.Array([]string{inj})) {
+		t.Errorf("unexpected args: %#v", args[:3])
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"", "  ", "null", "{}", `{"match": "all"}`} {
+		c, err := Parse([]byte(s))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+		if !c.IsEmpty() {
+			t.Errorf("%q: expected an empty tree", s)
+		}
+	}
+
+	if _, err := Parse([]byte(`{"field": 1}`)); err == nil {
+		t.Error("expected an error on invalid JSON")
+	}
+
+	c := mustParse(t, `{"conditions": [{"conditions": []}, {"field": "email", "operator": "eq", "value": "a"}]}`)
+	if c.IsEmpty() {
+		t.Error("expected a tree with a condition to not be empty")
+	}
+}
@@ -71,7 +71,11 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		b.Meta,
 		b.CreatedAt,
 		action.Count,
-		action.Action)
+		action.Action,
+		action.Days,
+		action.Campaigns,
+		action.SuppressDays,
+		c.consts.SuppressDeleted)
 
 	if err != nil {
 		// Ignore the error if it complained of no subscriber.
@@ -81,9 +85,12 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		}
 
 		c.log.Printf("error recording bounce: %v", err)
+		return err
 	}
 
-	return err
+	c.FireWebhooks(models.WebhookEventBounceRecorded, b)
+
+	return nil
 }
 
 // BlocklistBouncedSubscribers blocklists all bounced subscribers.
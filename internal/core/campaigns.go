@@ -2,6 +2,7 @@ package core
 
 import (
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -160,7 +161,12 @@ func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, e
 }
 
 // CreateCampaign creates a new campaign.
-func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
+func (c *Core) CreateCampaign(o models.Campaign, listIDs, segmentIDs []int, mediaIDs []int) (models.Campaign, error) {
+	// Required for pq.Array()
+	if segmentIDs == nil {
+		segmentIDs = []int{}
+	}
+
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -192,6 +198,14 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
 		o.BodySource,
+		o.DeliveryMode,
+		o.DeliveryWindow,
+		o.DeliveryTime,
+		o.DeliveryTimezone,
+		o.Recurrence,
+		o.RecurrenceCondition,
+		o.FeedURL,
+		pq.Array(segmentIDs),
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noSubs"))
@@ -211,7 +225,12 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 }
 
 // UpdateCampaign updates a campaign.
-func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
+func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs, segmentIDs []int, mediaIDs []int) (models.Campaign, error) {
+	// Required for pq.Array()
+	if segmentIDs == nil {
+		segmentIDs = []int{}
+	}
+
 	_, err := c.q.UpdateCampaign.Exec(id,
 		o.Name,
 		o.Subject,
@@ -231,7 +250,15 @@ func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
-		o.BodySource)
+		o.BodySource,
+		o.DeliveryMode,
+		o.DeliveryWindow,
+		o.DeliveryTime,
+		o.DeliveryTimezone,
+		o.Recurrence,
+		o.RecurrenceCondition,
+		o.FeedURL,
+		pq.Array(segmentIDs))
 	if err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -314,6 +341,81 @@ func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta model
 	return nil
 }
 
+// GetCampaignVariants retrieves the A/B test variants of a campaign along with their view and click counts.
+func (c *Core) GetCampaignVariants(id int) ([]models.CampaignVariant, error) {
+	out := []models.CampaignVariant{}
+	if err := c.q.GetCampaignVariants.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign variants: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.variants}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// UpdateCampaignVariants updates a campaign's A/B test options and replaces its variants.
+// Variants with an ID are updated, new ones are created, and existing ones that
+// are not in the given list are deleted.
+func (c *Core) UpdateCampaignVariants(id, sample int, window, metric string, variants []models.CampaignVariant) ([]models.CampaignVariant, error) {
+	b, err := json.Marshal(variants)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("globals.messages.invalidData"))
+	}
+
+	if _, err := c.q.UpdateCampaignVariants.Exec(id, sample, window, metric, b); err != nil {
+		c.log.Printf("error updating campaign variants: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{campaigns.variants}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetCampaignVariants(id)
+}
+
+// QueryCampaignDeliveries returns the paginated delivery log of a campaign, optionally
+// filtered by status, along with the total count.
+func (c *Core) QueryCampaignDeliveries(id int, status string, offset, limit int) ([]models.CampaignDelivery, int, error) {
+	out := []models.CampaignDelivery{}
+	if err := c.q.QueryCampaignDeliveries.Select(&out, id, status, offset, limit); err != nil {
+		c.log.Printf("error fetching campaign deliveries: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.deliveries}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// GetCampaignDeliverySubscribers returns the subscribers of a campaign with the given
+// delivery status who are still subscribed to the campaign's lists.
+func (c *Core) GetCampaignDeliverySubscribers(id int, status string) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	if err := c.q.GetCampaignDeliverySubscribers.Select(&out, id, status); err != nil {
+		c.log.Printf("error fetching campaign delivery subscribers: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// DeleteCampaignDeliveries deletes the delivery logs of finished and cancelled
+// campaigns that haven't been updated since the given date.
+func (c *Core) DeleteCampaignDeliveries(beforeDate time.Time) (int, error) {
+	res, err := c.q.DeleteCampaignDeliveries.Exec(beforeDate)
+	if err != nil {
+		c.log.Printf("error deleting campaign deliveries: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{campaigns.deliveries}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 // DeleteCampaign deletes a campaign.
 func (c *Core) DeleteCampaign(id int) error {
 	res, err := c.q.DeleteCampaign.Exec(id)
@@ -422,8 +524,9 @@ func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate st
 }
 
 // RegisterCampaignView registers a subscriber's view on a campaign.
-func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
-	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID); err != nil {
+// variantID is the optional A/B test variant the view is attributed to.
+func (c *Core) RegisterCampaignView(campUUID, subUUID string, variantID int) error {
+	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID, variantID); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "campaign_id" {
 			return nil
 		}
@@ -446,9 +549,10 @@ func (c *Core) GetLinkURL(linkUUID string) (string, error) {
 }
 
 // RegisterCampaignLinkClick registers a subscriber's link click on a campaign.
-func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (string, error) {
+// variantID is the optional A/B test variant the click is attributed to.
+func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string, variantID int) (string, error) {
 	var url string
-	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID); err != nil {
+	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID, variantID); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "link_id" {
 			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
 		}
@@ -457,6 +561,13 @@ func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (st
 		return "", echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
 	}
 
+	c.FireWebhooks(models.WebhookEventLinkClicked, models.WebhookLinkClick{
+		URL:            url,
+		LinkUUID:       linkUUID,
+		CampaignUUID:   campUUID,
+		SubscriberUUID: subUUID,
+	})
+
 	return url, nil
 }
 
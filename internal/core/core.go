@@ -43,10 +43,17 @@ type Core struct {
 type Constants struct {
 	SendOptinConfirmation bool
 	BounceActions         map[string]struct {
-		Count  int
-		Action string
+		Count        int
+		Action       string
+		Days         int
+		Campaigns    int
+		SuppressDays int `koanf:"suppress_days"`
 	}
 	CacheSlowQueries bool
+
+	// Add the hashed e-mails of subscribers who wipe their data or are
+	// deleted for bounces to the suppression list.
+	SuppressDeleted bool
 }
 
 // Hooks contains external function hooks that are required by the core package.
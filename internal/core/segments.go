@@ -0,0 +1,264 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/conditions"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetSegments retrieves all segments. If getAll is false, only the segments that
+// are restricted to the permitted lists are returned.
+func (c *Core) GetSegments(getAll bool, permittedLists []int) ([]models.Segment, error) {
+	if permittedLists == nil {
+		permittedLists = []int{}
+	}
+
+	out := []models.Segment{}
+	if err := c.q.GetSegments.Select(&out, 0, getAll, pq.Array(permittedLists)); err != nil {
+		c.log.Printf("error fetching segments: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.segments}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetSegment retrieves a given segment.
+func (c *Core) GetSegment(id int) (models.Segment, error) {
+	var out []models.Segment
+	if err := c.q.GetSegments.Select(&out, id, true, pq.Array([]int{})); err != nil {
+		c.log.Printf("error fetching segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Segment{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.segment}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateSegment creates a new segment. The segment's query is validated by
+// counting its subscribers, and the count is cached.
+func (c *Core) CreateSegment(s models.Segment) (models.Segment, error) {
+	count, err := c.CountSegment(s)
+	if err != nil {
+		return models.Segment{}, err
+	}
+
+	var newID int
+	if err := c.q.CreateSegment.Get(&newID, s.Name, s.Description, s.Query, s.Conditions, s.ListIDs); err != nil {
+		c.log.Printf("error creating segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if _, err := c.q.UpdateSegmentCount.Exec(newID, count); err != nil {
+		c.log.Printf("error updating segment count: %v", err)
+	}
+
+	return c.GetSegment(newID)
+}
+
+// UpdateSegment updates a given segment and refreshes its cached count.
+func (c *Core) UpdateSegment(id int, s models.Segment) (models.Segment, error) {
+	count, err := c.CountSegment(s)
+	if err != nil {
+		return models.Segment{}, err
+	}
+
+	res, err := c.q.UpdateSegment.Exec(id, s.Name, s.Description, s.Query, s.Conditions, s.ListIDs)
+	if err != nil {
+		c.log.Printf("error updating segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Segment{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.segment}"))
+	}
+
+	if _, err := c.q.UpdateSegmentCount.Exec(id, count); err != nil {
+		c.log.Printf("error updating segment count: %v", err)
+	}
+
+	return c.GetSegment(id)
+}
+
+// RefreshSegmentCount recounts the subscribers in a segment and caches the count.
+func (c *Core) RefreshSegmentCount(id int) (models.Segment, error) {
+	s, err := c.GetSegment(id)
+	if err != nil {
+		return models.Segment{}, err
+	}
+
+	count, err := c.CountSegment(s)
+	if err != nil {
+		return models.Segment{}, err
+	}
+
+	if _, err := c.q.UpdateSegmentCount.Exec(id, count); err != nil {
+		c.log.Printf("error updating segment count: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSegment(id)
+}
+
+// DeleteSegment deletes a given segment. Campaigns that target the segment
+// retain its name, but are no longer sent to it.
+func (c *Core) DeleteSegment(id int) error {
+	if _, err := c.q.DeleteSegment.Exec(id); err != nil {
+		c.log.Printf("error deleting segment: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetCampaignSegments retrieves the segments of the given campaigns.
+func (c *Core) GetCampaignSegments(campIDs []int) ([]models.Segment, error) {
+	var out []models.Segment
+	if err := c.q.GetCampaignSegments.Select(&out, pq.Array(campIDs)); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// UpdateCampaignAudience counts the subscribers of a campaign across its lists and
+// the given segments, and updates the campaign's to_send count and max subscriber ID,
+// which are otherwise only computed from its lists.
+func (c *Core) UpdateCampaignAudience(campID int, segs []models.Segment) (int, int, error) {
+	exp, args, err := c.MakeSegmentsQuery(segs, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	stmt := strings.ReplaceAll(c.q.CountCampaignAudience, "%query%", exp)
+
+	// Segment queries are arbitrary expressions. Run them in a readonly transaction.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var res struct {
+		ToSend          int `db:"to_send"`
+		MaxSubscriberID int `db:"max_subscriber_id"`
+	}
+	if err := tx.Get(&res, stmt, append([]any{campID}, args...)...); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := c.q.UpdateCampaignAudience.Exec(campID, res.ToSend, res.MaxSubscriberID); err != nil {
+		return 0, 0, err
+	}
+
+	return res.ToSend, res.MaxSubscriberID, nil
+}
+
+// MakeSegmentsQuery returns an SQL expression on the subscribers table that matches
+// subscribers in any of the given segments, or FALSE if there are none. The parameters
+// of the expression start after nArgs, which is the number of parameters in the query
+// that the expression is used in, and their values are returned.
+func (c *Core) MakeSegmentsQuery(segs []models.Segment, nArgs int) (string, []any, error) {
+	if len(segs) == 0 {
+		return "FALSE", nil, nil
+	}
+
+	var (
+		conds = make([]string, 0, len(segs))
+		args  []any
+	)
+	for _, s := range segs {
+		exp, a, err := c.makeSegmentQuery(s, nArgs+len(args))
+		if err != nil {
+			return "", nil, err
+		}
+
+		conds = append(conds, exp)
+		args = append(args, a...)
+	}
+
+	return strings.Join(conds, " OR "), args, nil
+}
+
+// makeSegmentQuery returns an SQL expression on the subscribers table that matches
+// subscribers in a segment along with the values of the parameters in its conditions.
+func (c *Core) makeSegmentQuery(s models.Segment, nArgs int) (string, []any, error) {
+	cond, err := conditions.Parse(s.Conditions)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// List IDs are ints and are safe to be interpolated as an array literal.
+	ids := make([]string, len(s.ListIDs))
+	for i, id := range s.ListIDs {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+
+	query := strings.TrimSpace(s.Query)
+	if query == "" {
+		query = "TRUE"
+	}
+
+	// Compile the condition tree and combine it with the query.
+	var args []any
+	if !cond.IsEmpty() {
+		exp, a, err := conditions.Compile(cond, nArgs)
+		if err != nil {
+			return "", nil, err
+		}
+
+		query = "(" + query + ") AND (" + exp + ")"
+		args = a
+	}
+
+	stmt := strings.ReplaceAll(c.q.SegmentQueryTpl, "%list_ids%", "{"+strings.Join(ids, ",")+"}")
+	return strings.ReplaceAll(stmt, "%query%", query), args, nil
+}
+
+// CountSegment counts the subscribers in a segment in a readonly transaction after
+// validating the tables that its query and conditions access.
+func (c *Core) CountSegment(s models.Segment) (int, error) {
+	exp, args, err := c.makeSegmentQuery(s, 0)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
+	}
+	stmt := strings.ReplaceAll(c.q.CountSegmentSubscribers, "%query%", exp)
+
+	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, args...); err != nil {
+		c.log.Printf("error validating segment query: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing segment query: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	total := 0
+	if err := tx.Get(&total, stmt, args...); err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
+	return total, nil
+}
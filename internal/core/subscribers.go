@@ -11,6 +11,7 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
@@ -18,15 +19,16 @@ import (
 
 var (
 	allowedSubQueryTables = map[string]struct{}{
-		"subscribers":      {},
-		"lists":            {},
-		"subscriber_lists": {},
-		"campaigns":        {},
-		"campaign_lists":   {},
-		"campaign_views":   {},
-		"links":            {},
-		"link_clicks":      {},
-		"bounces":          {},
+		"subscribers":           {},
+		"lists":                 {},
+		"subscriber_lists":      {},
+		"campaigns":             {},
+		"campaign_lists":        {},
+		"campaign_views":        {},
+		"links":                 {},
+		"link_clicks":           {},
+		"bounces":               {},
+		"subscriber_engagement": {},
 	}
 )
 
@@ -300,6 +302,13 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 	}
 	sub.UUID = uu.String()
 
+	// Suppressed e-mails and domains can't be subscribed.
+	if ok, err := c.IsSuppressed(sub.Email); err != nil {
+		return models.Subscriber{}, false, err
+	} else if ok {
+		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("subscribers.emailSuppressed"))
+	}
+
 	subStatus := models.SubscriptionStatusUnconfirmed
 	if preconfirm {
 		subStatus = models.SubscriptionStatusConfirmed
@@ -353,6 +362,17 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 		hasOptin = num > 0
 	}
 
+	// Start any workflows triggered by the subscription.
+	c.startWorkflows(out.ID, models.WorkflowTriggerSubscribe, listIDs, listUUIDs)
+	if preconfirm {
+		c.startWorkflows(out.ID, models.WorkflowTriggerConfirm, listIDs, listUUIDs)
+	}
+
+	// If the subscriber already existed, the id will be empty.
+	if sub.ID > 0 {
+		c.FireWebhooks(models.WebhookEventSubscriberCreated, out)
+	}
+
 	return out, hasOptin, nil
 }
 
@@ -387,6 +407,8 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 		return models.Subscriber{}, err
 	}
 
+	c.FireWebhooks(models.WebhookEventSubscriberUpdated, out)
+
 	return out, nil
 }
 
@@ -443,6 +465,16 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		hasOptin = num > 0
 	}
 
+	// Start any workflows triggered by the lists that were (re)subscribed to.
+	if len(listIDs) > 0 || len(listUUIDs) > 0 {
+		c.startWorkflows(out.ID, models.WorkflowTriggerSubscribe, listIDs, listUUIDs)
+		if preconfirm {
+			c.startWorkflows(out.ID, models.WorkflowTriggerConfirm, listIDs, listUUIDs)
+		}
+	}
+
+	c.FireWebhooks(models.WebhookEventSubscriberUpdated, out)
+
 	return out, hasOptin, nil
 }
 
@@ -470,6 +502,33 @@ func (c *Core) BlocklistSubscribersByQuery(searchStr, queryExp string, listIDs [
 
 // DeleteSubscribers deletes the given list of subscribers.
 func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
+	_, err := c.deleteSubscribers(subIDs, subUUIDs)
+	return err
+}
+
+// WipeSubscriber deletes a subscriber who has requested their data to be wiped.
+// If SuppressDeleted is on, the hash of their e-mail is added to the suppression
+// list so that they aren't subscribed or imported again.
+func (c *Core) WipeSubscriber(subUUID string) error {
+	subs, err := c.deleteSubscribers(nil, []string{subUUID})
+	if err != nil || !c.consts.SuppressDeleted {
+		return err
+	}
+
+	for _, s := range subs {
+		if _, err := c.q.UpsertSuppression.Exec(models.SuppressionTypeEmail, utils.HashSuppression(s.Email),
+			"Wiped by the subscriber", "wipe", nil); err != nil {
+			c.log.Printf("error suppressing wiped subscriber: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.suppression}", "error", pqErrMsg(err)))
+		}
+	}
+
+	return nil
+}
+
+// deleteSubscribers deletes the given list of subscribers and returns them.
+func (c *Core) deleteSubscribers(subIDs []int, subUUIDs []string) ([]models.Subscriber, error) {
 	if subIDs == nil {
 		subIDs = []int{}
 	}
@@ -477,13 +536,18 @@ func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
 		subUUIDs = []string{}
 	}
 
-	if _, err := c.q.DeleteSubscribers.Exec(pq.Array(subIDs), pq.Array(subUUIDs)); err != nil {
+	var subs []models.Subscriber
+	if err := c.q.DeleteSubscribers.Select(&subs, pq.Array(subIDs), pq.Array(subUUIDs)); err != nil {
 		c.log.Printf("error deleting subscribers: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
-	return nil
+	for _, s := range subs {
+		c.FireWebhooks(models.WebhookEventSubscriberDeleted, s)
+	}
+
+	return subs, nil
 }
 
 // DeleteSubscribersByQuery deletes subscribers by a given arbitrary query expression.
@@ -506,6 +570,16 @@ func (c *Core) UnsubscribeByCampaign(subUUID, campUUID string, blocklist bool) e
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
+	if c.hasWebhooks(models.WebhookEventSubscriptionUnsubscribed) {
+		if sub, err := c.GetSubscriber(0, subUUID, ""); err == nil {
+			c.FireWebhooks(models.WebhookEventSubscriptionUnsubscribed, models.WebhookSubscription{
+				Subscriber:   sub,
+				CampaignUUID: campUUID,
+				Blocklisted:  blocklist,
+			})
+		}
+	}
+
 	return nil
 }
 
@@ -521,6 +595,16 @@ func (c *Core) ConfirmOptionSubscription(subUUID string, listUUIDs []string, met
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
+	// Start any workflows triggered by the confirmation.
+	if sub, err := c.GetSubscriber(0, subUUID, ""); err == nil {
+		c.startWorkflows(sub.ID, models.WorkflowTriggerConfirm, nil, listUUIDs)
+
+		c.FireWebhooks(models.WebhookEventSubscriptionConfirmed, models.WebhookSubscription{
+			Subscriber: sub,
+			ListUUIDs:  listUUIDs,
+		})
+	}
+
 	return nil
 }
 
@@ -566,6 +650,48 @@ func (c *Core) DeleteBlocklistedSubscribers() (int, error) {
 	return int(n), nil
 }
 
+// UpdateSubscriberEngagement recomputes the engagement scores of all subscribers from their
+// views, clicks, and bounces in the last windowDays, and marks subscribers who haven't engaged
+// with the last sunsetCampaigns campaigns as inactive (0 disables it). It returns the number
+// of inactive subscribers.
+func (c *Core) UpdateSubscriberEngagement(windowDays, sunsetCampaigns int) (int, error) {
+	var n int
+	if err := c.q.UpdateSubscriberEngagement.Get(&n, windowDays, sunsetCampaigns); err != nil {
+		c.log.Printf("error updating subscriber engagement: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// ResetInactiveSubscribers clears the inactive status of all subscribers.
+func (c *Core) ResetInactiveSubscribers() (int, error) {
+	res, err := c.q.ResetInactiveSubscribers.Exec()
+	if err != nil {
+		c.log.Printf("error resetting inactive subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// UnsubscribeInactiveSubscribers unsubscribes inactive subscribers from all their lists
+// and returns the number of subscriptions that were unsubscribed.
+func (c *Core) UnsubscribeInactiveSubscribers() (int, error) {
+	res, err := c.q.UnsubscribeInactiveSubscribers.Exec()
+	if err != nil {
+		c.log.Printf("error unsubscribing inactive subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs []int) (int, error) {
 	// If there's no condition, it's a "get all" call which can probably be optionally pulled from cache.
 	if queryExp == "" {
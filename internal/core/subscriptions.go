@@ -87,6 +87,21 @@ func (c *Core) UnsubscribeLists(subIDs, listIDs []int, listUUIDs []string) error
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
 	}
 
+	if c.hasWebhooks(models.WebhookEventSubscriptionUnsubscribed) {
+		for _, id := range subIDs {
+			sub, err := c.GetSubscriber(id, "", "")
+			if err != nil {
+				continue
+			}
+
+			c.FireWebhooks(models.WebhookEventSubscriptionUnsubscribed, models.WebhookSubscription{
+				Subscriber: sub,
+				ListIDs:    listIDs,
+				ListUUIDs:  listUUIDs,
+			})
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/utils"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+var suppressionQuerySortFields = []string{"id", "type", "source", "expires_at", "created_at", "updated_at"}
+
+// QuerySuppressions retrieves paginated suppressions based on the given params.
+// If value (an e-mail or domain) is given, only its suppressions are returned.
+// It also returns the total number of suppression records in the DB.
+func (c *Core) QuerySuppressions(value, typ, source, orderBy, order string, offset, limit int) ([]models.Suppression, int, error) {
+	if !strSliceContains(orderBy, suppressionQuerySortFields) {
+		orderBy = "created_at"
+	}
+	if order != SortAsc && order != SortDesc {
+		order = SortDesc
+	}
+
+	hashes := []string{}
+	if value != "" {
+		hashes = append(hashes, utils.HashSuppression(value))
+	}
+
+	out := []models.Suppression{}
+	stmt := strings.ReplaceAll(c.q.QuerySuppressions, "%order%", orderBy+" "+order)
+	if err := c.db.Select(&out, stmt, 0, pq.Array(hashes), typ, source, offset, limit); err != nil {
+		c.log.Printf("error fetching suppressions: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.suppressions}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// GetSuppression retrieves a suppression by its ID.
+func (c *Core) GetSuppression(id int) (models.Suppression, error) {
+	var out []models.Suppression
+	stmt := strings.ReplaceAll(c.q.QuerySuppressions, "%order%", "id "+SortAsc)
+	if err := c.db.Select(&out, stmt, id, pq.Array([]string{}), "", "", 0, 1); err != nil {
+		c.log.Printf("error fetching suppression: %v", err)
+		return models.Suppression{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.suppression}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Suppression{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.suppression}"))
+	}
+
+	return out[0], nil
+}
+
+// UpsertSuppression inserts a suppression or updates the reason, source, and expiry
+// of an existing suppression with the same type and hash.
+func (c *Core) UpsertSuppression(s models.Suppression) (models.Suppression, error) {
+	var id int
+	if err := c.q.UpsertSuppression.Get(&id, s.Type, s.Hash, s.Reason, s.Source, s.ExpiresAt); err != nil {
+		c.log.Printf("error inserting suppression: %v", err)
+		return models.Suppression{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.suppression}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSuppression(id)
+}
+
+// ImportSuppressions upserts a batch of suppressions.
+func (c *Core) ImportSuppressions(sups []models.Suppression) error {
+	var (
+		types   = make([]string, len(sups))
+		hashes  = make([]string, len(sups))
+		reasons = make([]string, len(sups))
+		sources = make([]string, len(sups))
+		expiry  = make([]string, len(sups))
+	)
+	for i, s := range sups {
+		types[i], hashes[i], reasons[i], sources[i] = s.Type, s.Hash, s.Reason, s.Source
+		if s.ExpiresAt.Valid {
+			expiry[i] = s.ExpiresAt.Time.Format(time.RFC3339)
+		}
+	}
+
+	if _, err := c.q.ImportSuppressions.Exec(pq.Array(types), pq.Array(hashes), pq.Array(reasons),
+		pq.Array(sources), pq.Array(expiry)); err != nil {
+		c.log.Printf("error importing suppressions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.suppressions}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// UpdateSuppression updates the reason, source, and expiry of a suppression.
+func (c *Core) UpdateSuppression(id int, s models.Suppression) (models.Suppression, error) {
+	res, err := c.q.UpdateSuppression.Exec(id, s.Reason, s.Source, s.ExpiresAt)
+	if err != nil {
+		c.log.Printf("error updating suppression: %v", err)
+		return models.Suppression{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.suppression}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Suppression{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.suppression}"))
+	}
+
+	return c.GetSuppression(id)
+}
+
+// DeleteSuppressions deletes multiple suppressions, or all of them.
+func (c *Core) DeleteSuppressions(ids []int, all bool) error {
+	if _, err := c.q.DeleteSuppressions.Exec(pq.Array(ids), all); err != nil {
+		c.log.Printf("error deleting suppressions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.suppressions}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// IsSuppressed checks whether an e-mail or its domain has an active suppression.
+func (c *Core) IsSuppressed(email string) (bool, error) {
+	var (
+		out      bool
+		em, doms = utils.SuppressionHashes(email)
+	)
+	if err := c.q.IsSuppressed.Get(&out, em, pq.Array(doms)); err != nil {
+		c.log.Printf("error checking suppression: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.suppression}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetWebhooks retrieves all webhooks.
+func (c *Core) GetWebhooks() ([]models.Webhook, error) {
+	out := []models.Webhook{}
+	if err := c.q.GetWebhooks.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching webhooks: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.webhooks}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetWebhook retrieves a given webhook.
+func (c *Core) GetWebhook(id int) (models.Webhook, error) {
+	var out []models.Webhook
+	if err := c.q.GetWebhooks.Select(&out, id); err != nil {
+		c.log.Printf("error fetching webhook: %v", err)
+		return models.Webhook{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.webhook}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Webhook{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.webhook}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateWebhook creates a new webhook.
+func (c *Core) CreateWebhook(w models.Webhook) (models.Webhook, error) {
+	var newID int
+	if err := c.q.CreateWebhook.Get(&newID, w.Name, w.URL, w.Status, w.Events, w.Secret, w.MaxRetries); err != nil {
+		c.log.Printf("error creating webhook: %v", err)
+		return models.Webhook{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.webhook}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetWebhook(newID)
+}
+
+// UpdateWebhook updates a given webhook. An empty secret retains the existing one.
+func (c *Core) UpdateWebhook(id int, w models.Webhook) (models.Webhook, error) {
+	res, err := c.q.UpdateWebhook.Exec(id, w.Name, w.URL, w.Status, w.Events, w.Secret, w.MaxRetries)
+	if err != nil {
+		c.log.Printf("error updating webhook: %v", err)
+		return models.Webhook{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.webhook}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Webhook{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.webhook}"))
+	}
+
+	return c.GetWebhook(id)
+}
+
+// DeleteWebhook deletes a given webhook along with its deliveries.
+func (c *Core) DeleteWebhook(id int) error {
+	if _, err := c.q.DeleteWebhook.Exec(id); err != nil {
+		c.log.Printf("error deleting webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.webhook}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// QueryWebhookDeliveries retrieves the paginated delivery log of a webhook,
+// optionally filtered by status.
+func (c *Core) QueryWebhookDeliveries(id int, status string, offset, limit int) ([]models.WebhookDelivery, int, error) {
+	out := []models.WebhookDelivery{}
+	if err := c.q.QueryWebhookDeliveries.Select(&out, id, status, offset, limit); err != nil {
+		c.log.Printf("error fetching webhook deliveries: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.deliveries}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// RetryWebhookDeliveries re-queues the given deliveries of a webhook, or all
+// of its failed deliveries if no IDs are given, and returns the number of
+// deliveries that were re-queued.
+func (c *Core) RetryWebhookDeliveries(id int, ids []int64) (int, error) {
+	if ids == nil {
+		ids = []int64{}
+	}
+
+	res, err := c.q.RetryWebhookDeliveries.Exec(id, pq.Array(ids))
+	if err != nil {
+		c.log.Printf("error retrying webhook deliveries: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{campaigns.deliveries}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// FireWebhooks queues the delivery of an event to all active webhooks that are
+// subscribed to it. Deliveries are sent asynchronously by the webhook runner.
+// Errors are only logged as webhooks should not block the operations that fire them.
+func (c *Core) FireWebhooks(event string, data any) {
+	b, err := json.Marshal(models.WebhookEvent{
+		Event:     event,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		c.log.Printf("error marshalling webhook event %s: %v", event, err)
+		return
+	}
+
+	if _, err := c.q.QueueWebhookEvent.Exec(event, json.RawMessage(b)); err != nil {
+		c.log.Printf("error queueing webhook event %s: %v", event, err)
+	}
+}
+
+// hasWebhooks checks whether any active webhook is subscribed to an event. It's used
+// to skip fetching the data of events that'd otherwise be thrown away.
+func (c *Core) hasWebhooks(event string) bool {
+	var ok bool
+	if err := c.q.HasWebhooks.Get(&ok, event); err != nil {
+		c.log.Printf("error checking webhooks for %s: %v", event, err)
+		return false
+	}
+
+	return ok
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetWorkflows retrieves all workflows.
+func (c *Core) GetWorkflows() ([]models.Workflow, error) {
+	out := []models.Workflow{}
+	if err := c.q.GetWorkflows.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching workflows: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.workflows}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetWorkflow retrieves a given workflow.
+func (c *Core) GetWorkflow(id int) (models.Workflow, error) {
+	var out []models.Workflow
+	if err := c.q.GetWorkflows.Select(&out, id); err != nil {
+		c.log.Printf("error fetching workflow: %v", err)
+		return models.Workflow{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.workflow}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Workflow{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.workflow}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateWorkflow creates a new workflow.
+func (c *Core) CreateWorkflow(w models.Workflow) (models.Workflow, error) {
+	var newID int
+	if err := c.q.CreateWorkflow.Get(&newID, w.Name, w.Status, w.Trigger, w.ListID, w.Steps); err != nil {
+		c.log.Printf("error creating workflow: %v", err)
+		return models.Workflow{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.workflow}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetWorkflow(newID)
+}
+
+// UpdateWorkflow updates a given workflow.
+func (c *Core) UpdateWorkflow(id int, w models.Workflow) (models.Workflow, error) {
+	res, err := c.q.UpdateWorkflow.Exec(id, w.Name, w.Status, w.Trigger, w.ListID, w.Steps)
+	if err != nil {
+		c.log.Printf("error updating workflow: %v", err)
+		return models.Workflow{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.workflow}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Workflow{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.workflow}"))
+	}
+
+	return c.GetWorkflow(id)
+}
+
+// DeleteWorkflow deletes a given workflow.
+func (c *Core) DeleteWorkflow(id int) error {
+	if _, err := c.q.DeleteWorkflow.Exec(id); err != nil {
+		c.log.Printf("error deleting workflow: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.workflow}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// MatchSubscriber checks whether the given subscriber matches an arbitrary
+// SQL expression on the subscribers table.
+func (c *Core) MatchSubscriber(subID int, query string) (bool, error) {
+	stmt := strings.ReplaceAll(c.q.MatchSubscriber, "%query%", query)
+
+	// Validate the tables used in the query.
+	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, subID); err != nil {
+		return false, err
+	}
+
+	// Run the arbitrary query in a readonly transaction.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var ok bool
+	if err := tx.Get(&ok, stmt, subID); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// startWorkflows enrolls a subscriber into all active workflows with the given
+// trigger on the given lists (or all of the subscriber's lists if none are given).
+// Errors are only logged as workflows should not block subscriptions.
+func (c *Core) startWorkflows(subID int, trigger string, listIDs []int, listUUIDs []string) {
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+	if listUUIDs == nil {
+		listUUIDs = []string{}
+	}
+
+	var wfs []models.Workflow
+	if err := c.q.GetTriggerWorkflows.Select(&wfs, subID, trigger, pq.Array(listIDs), pq.Array(listUUIDs)); err != nil {
+		c.log.Printf("error fetching workflows for subscriber %d: %v", subID, err)
+		return
+	}
+
+	for _, w := range wfs {
+		if len(w.Steps) == 0 {
+			continue
+		}
+
+		// The first step is run after its delay from the trigger.
+		d, _ := time.ParseDuration(w.Steps[0].Delay)
+		if _, err := c.q.EnrollWorkflowSubscriber.Exec(w.ID, subID, time.Now().Add(d)); err != nil {
+			c.log.Printf("error enrolling subscriber %d into workflow %d: %v", subID, w.ID, err)
+		}
+	}
+}
@@ -1,78 +1,309 @@
 // Package events implements a simple event broadcasting mechanism
 // for usage in broadcasting error messages, postbacks etc. various
-// channels.
+// channels. Events are stored in a Postgres outbox with monotonically
+// increasing IDs so that subscribers can resume from the last event they
+// received, and are broadcast across instances with LISTEN/NOTIFY.
 package events
 
 import (
 	"bytes"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 const (
 	TypeError = "error"
+
+	// Postgres channel on which the IDs of new events are notified.
+	// This should match the channel in the insert-event query.
+	notifyChannel = "listmonk_events"
+
+	// Max number of events fetched from the DB at a time.
+	batchSize = 500
+
+	// Max number of events that are queued in memory by the error writer
+	// waiting to be written to the DB.
+	queueSize = 1000
+
+	// Frequency at which old events are deleted.
+	cleanupInterval = time.Hour
 )
 
 // Event represents a single event in the system.
 type Event struct {
-	ID       string   `json:"id"`
-	Type     string   `json:"type"`
-	Message  string   `json:"message"`
-	Data     any      `json:"data"`
-	Channels []string `json:"-"`
+	ID        int64           `db:"id" json:"id"`
+	Type      string          `db:"type" json:"type"`
+	Message   string          `db:"message" json:"message"`
+	Data      json.RawMessage `db:"data" json:"data"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+// Opt represents the event store options.
+type Opt struct {
+	// DSN is the Postgres connection string for listening to notifications
+	// of new events. If it's empty, the outbox is only polled.
+	DSN string
+
+	// PollInterval is the frequency at which the outbox is checked for new
+	// events irrespective of notifications, for instance, when the listener
+	// connection is down.
+	PollInterval time.Duration
+
+	// Retention is the duration after which events are deleted. 0 retains them forever.
+	Retention time.Duration
+}
+
+// Queries contains the queries.
+type Queries struct {
+	LockEvents     *sqlx.Stmt
+	InsertEvent    *sqlx.Stmt
+	GetEvents      *sqlx.Stmt
+	GetLastEventID *sqlx.Stmt
+	DeleteEvents   *sqlx.Stmt
 }
 
 type Events struct {
-	subs map[string]chan Event
+	opt Opt
+	db  *sqlx.DB
+	q   *Queries
+	log *log.Logger
+
+	subs map[string]*sub
 	sync.RWMutex
+
+	// Events written by the error writer (which can't block) are queued here
+	// and written to the DB asynchronously once the store is started.
+	queue chan Event
 }
 
-// New returns a new instance of Events.
+// sub is a subscriber that reads events from the outbox from its
+// last received event onwards.
+type sub struct {
+	ch chan Event
+
+	// wake is signalled when there are new events.
+	wake chan struct{}
+	done chan struct{}
+}
+
+var errNotStarted = errors.New("event store is not started")
+
+// New returns a new instance of Events. Events can be published and
+// subscribed to only after the store is started with Start().
 func New() *Events {
 	return &Events{
-		subs: make(map[string]chan Event),
+		subs:  make(map[string]*sub),
+		queue: make(chan Event, queueSize),
+	}
+}
+
+// Start starts the event store on the DB and starts listening for new events.
+// lo should not write to ErrWriter() as errors in writing events would
+// generate more events.
+func (ev *Events) Start(opt Opt, db *sqlx.DB, q *Queries, lo *log.Logger) {
+	if opt.PollInterval <= 0 {
+		opt.PollInterval = time.Second * 5
 	}
+
+	ev.Lock()
+	ev.opt = opt
+	ev.db = db
+	ev.q = q
+	ev.log = lo
+	ev.Unlock()
+
+	go ev.flush()
+	go ev.listen()
 }
 
-// Subscribe returns a channel to which the given event `types` are streamed.
-// id is the unique identifier for the caller. A caller can only register
-// for subscription once.
-func (ev *Events) Subscribe(id string) (chan Event, error) {
+// Subscribe returns a channel to which events of the given type (or all events
+// if it's empty) after the event lastID are streamed. If lastID is 0, only events
+// published after subscribing are streamed. id is the unique identifier for the
+// caller. A caller can only register for subscription once.
+func (ev *Events) Subscribe(id, typ string, lastID int64) (chan Event, error) {
+	if ev.q == nil {
+		return nil, errNotStarted
+	}
+
+	if lastID <= 0 {
+		if err := ev.q.GetLastEventID.Get(&lastID); err != nil {
+			return nil, err
+		}
+	}
+
 	ev.Lock()
 	defer ev.Unlock()
 
-	if ch, ok := ev.subs[id]; ok {
-		return ch, nil
+	if s, ok := ev.subs[id]; ok {
+		return s.ch, nil
 	}
 
-	ch := make(chan Event, 100)
-	ev.subs[id] = ch
+	s := &sub{
+		ch:   make(chan Event, 100),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	ev.subs[id] = s
 
-	return ch, nil
+	go ev.stream(s, typ, lastID)
+
+	return s.ch, nil
 }
 
 // Unsubscribe unsubscribes a subscriber (obviously).
 func (ev *Events) Unsubscribe(id string) {
 	ev.Lock()
 	defer ev.Unlock()
-	delete(ev.subs, id)
+
+	if s, ok := ev.subs[id]; ok {
+		close(s.done)
+		delete(ev.subs, id)
+	}
 }
 
-// Publish publishes an event to all subscribers.
+// Publish writes an event to the outbox and notifies all subscribers
+// across instances.
 func (ev *Events) Publish(e Event) error {
-	ev.Lock()
-	defer ev.Unlock()
+	if ev.q == nil {
+		return errNotStarted
+	}
+
+	// Inserts are serialized so that events are committed in the order of
+	// their IDs. Otherwise, a subscriber that has read a newer event may
+	// never see an older one that was committed after it.
+	tx, err := ev.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Stmtx(ev.q.LockEvents).Exec(); err != nil {
+		return err
+	}
+
+	var data any
+	if len(e.Data) > 0 {
+		data = e.Data
+	}
+	if _, err := tx.Stmtx(ev.q.InsertEvent).Exec(e.Type, e.Message, data); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Local subscribers needn't wait for the notification.
+	ev.wakeAll()
+
+	return nil
+}
+
+// stream streams events after lastID to a subscriber, fetching new ones
+// every time it's woken up, until it unsubscribes. A slow subscriber
+// simply lags behind instead of losing events.
+func (ev *Events) stream(s *sub, typ string, lastID int64) {
+	for {
+		for {
+			var out []Event
+			if err := ev.q.GetEvents.Select(&out, lastID, typ, batchSize); err != nil {
+				ev.log.Printf("error fetching events: %v", err)
+				break
+			}
+
+			for _, e := range out {
+				select {
+				case s.ch <- e:
+					lastID = e.ID
+				case <-s.done:
+					return
+				}
+			}
+
+			if len(out) < batchSize {
+				break
+			}
+		}
 
-	for _, ch := range ev.subs {
 		select {
-		case ch <- e:
-		default:
-			return fmt.Errorf("event queue full for type: %s", e.Type)
+		case <-s.wake:
+		case <-s.done:
+			return
 		}
 	}
+}
 
-	return nil
+// listen wakes up all subscribers when new events are notified by any instance,
+// and periodically as a fallback. It also deletes old events.
+func (ev *Events) listen() {
+	var notify <-chan *pq.Notification
+	if ev.opt.DSN != "" {
+		l := pq.NewListener(ev.opt.DSN, time.Second*2, time.Minute, func(t pq.ListenerEventType, err error) {
+			if err != nil {
+				ev.log.Printf("error in events listener: %v", err)
+			}
+		})
+		if err := l.Listen(notifyChannel); err != nil {
+			ev.log.Printf("error listening for events: %v", err)
+		}
+		notify = l.Notify
+	}
+
+	var (
+		poll    = time.NewTicker(ev.opt.PollInterval)
+		cleanup = time.NewTicker(cleanupInterval)
+	)
+	defer poll.Stop()
+	defer cleanup.Stop()
+
+	for {
+		select {
+		// A nil notification is sent after the listener reconnects,
+		// which may have missed notifications.
+		case <-notify:
+			ev.wakeAll()
+
+		case <-poll.C:
+			ev.wakeAll()
+
+		case <-cleanup.C:
+			if ev.opt.Retention <= 0 {
+				continue
+			}
+			if _, err := ev.q.DeleteEvents.Exec(time.Now().Add(-ev.opt.Retention)); err != nil {
+				ev.log.Printf("error deleting old events: %v", err)
+			}
+		}
+	}
+}
+
+// flush writes events queued by the error writer to the DB.
+func (ev *Events) flush() {
+	for e := range ev.queue {
+		if err := ev.Publish(e); err != nil {
+			ev.log.Printf("error publishing event: %v", err)
+		}
+	}
+}
+
+// wakeAll signals all subscribers to fetch new events.
+func (ev *Events) wakeAll() {
+	ev.RLock()
+	defer ev.RUnlock()
+
+	for _, s := range ev.subs {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
 }
 
 // This implements an io.Writer specifically for receiving error messages
@@ -84,13 +315,15 @@ type wri struct {
 func (w *wri) Write(b []byte) (n int, err error) {
 	// Only broadcast error messages.
 	if !bytes.Contains(b, []byte("error")) {
-		return 0, nil
+		return len(b), nil
 	}
 
-	w.ev.Publish(Event{
-		Type:    TypeError,
-		Message: string(b),
-	})
+	// Logging should never block. If the queue is full (eg: the DB is down),
+	// the message is dropped.
+	select {
+	case w.ev.queue <- Event{Type: TypeError, Message: string(b)}:
+	default:
+	}
 
 	return len(b), nil
 }
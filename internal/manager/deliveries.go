@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Interval at which the delivery log is flushed to the DB.
+const deliveryFlushInterval = time.Second
+
+// deliveryKey uniquely identifies a campaign message to a subscriber in the delivery log.
+type deliveryKey struct {
+	campID int
+	subID  int
+}
+
+// recordDelivery queues the status of a campaign message to be written to the delivery log.
+// Test messages and other messages that aren't part of a campaign run aren't logged.
+func (m *Manager) recordDelivery(msg CampaignMessage, status string, err error) {
+	if !msg.logDelivery {
+		return
+	}
+
+	d := models.CampaignDelivery{
+		CampaignID:   msg.Campaign.ID,
+		SubscriberID: msg.Subscriber.ID,
+		Status:       status,
+		Messenger:    msg.Campaign.Messenger,
+		InstanceID:   msg.leaseID(m.cfg.InstanceID),
+		UpdatedAt:    time.Now(),
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	m.deliveryQ <- d
+}
+
+// ackDelivery queues the result of pushing a campaign message to the messenger to be
+// written to the delivery log. Acks are written in batches along with other statuses and
+// are flushed before a campaign's progress is recorded when its processing ends. If the
+// instance dies before they're written, the messages are sent again when they're picked up.
+func (m *Manager) ackDelivery(msg CampaignMessage, err error) {
+	status := models.CampaignDeliverySent
+	if err != nil {
+		status = models.CampaignDeliveryFailed
+	}
+
+	m.recordDelivery(msg, status, err)
+}
+
+// leaseID returns the ID of the lease of the campaign run that the message belongs
+// to, or the given default for messages that aren't part of a campaign run.
+func (msg CampaignMessage) leaseID(def string) string {
+	if msg.pipe == nil {
+		return def
+	}
+
+	return msg.pipe.leaseID
+}
+
+// flushDeliveries is a blocking function that collects delivery statuses and
+// periodically writes them to the DB in batches. As only the latest status of
+// a message is logged, multiple statuses of a message in a batch are collapsed.
+func (m *Manager) flushDeliveries() {
+	t := time.NewTicker(deliveryFlushInterval)
+	defer t.Stop()
+
+	var (
+		batch = make([]models.CampaignDelivery, 0, m.cfg.BatchSize)
+		index = make(map[deliveryKey]int, m.cfg.BatchSize)
+	)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := m.store.RecordDeliveries(batch); err != nil {
+			m.log.Printf("error recording campaign deliveries: %v", err)
+		}
+
+		batch = batch[:0]
+		clear(index)
+	}
+
+	add := func(d models.CampaignDelivery) {
+		k := deliveryKey{d.CampaignID, d.SubscriberID}
+		if i, ok := index[k]; ok {
+			batch[i] = d
+			return
+		}
+
+		index[k] = len(batch)
+		batch = append(batch, d)
+		if len(batch) >= m.cfg.BatchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case d := <-m.deliveryQ:
+			add(d)
+
+		case done := <-m.flushQ:
+			// Write out all the statuses that have been queued so far.
+			for drained := false; !drained; {
+				select {
+				case d := <-m.deliveryQ:
+					add(d)
+				default:
+					drained = true
+				}
+			}
+			flush()
+			close(done)
+
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+// flushDeliveryLog writes all the delivery statuses queued so far to the DB.
+func (m *Manager) flushDeliveryLog() {
+	done := make(chan struct{})
+	m.flushQ <- done
+	<-done
+}
+
+// ResendCampaign re-sends a campaign's messages to the given subscribers, for
+// instance, to the ones whose messages had failed. The campaign shouldn't be running.
+// Messages are queued in the background and their statuses are recorded in the delivery log.
+func (m *Manager) ResendCampaign(campID int, subs []models.Subscriber) error {
+	m.pipesMut.RLock()
+	_, ok := m.pipes[campID]
+	m.pipesMut.RUnlock()
+	if ok {
+		return errors.New("campaign is running")
+	}
+
+	c, err := m.store.GetCampaign(campID)
+	if err != nil {
+		return fmt.Errorf("error fetching campaign: %v", err)
+	}
+
+	if _, ok := m.messengers[c.Messenger]; !ok {
+		return fmt.Errorf("unknown messenger %s on campaign %s", c.Messenger, c.Name)
+	}
+
+	if err := m.LoadInlineImages(c); err != nil {
+		return err
+	}
+	if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+		return err
+	}
+	if err := m.attachMedia(c); err != nil {
+		return err
+	}
+
+	variants, err := m.loadVariants(c)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for _, s := range subs {
+			m.waitDeferred()
+
+			camp := pickVariant(c, variants, s)
+
+			msg, err := m.NewCampaignMessage(camp, s)
+			msg.logDelivery = true
+			if err != nil {
+				m.log.Printf("error rendering message (%s) (%s): %v", camp.Name, s.Email, err)
+				m.recordDelivery(msg, models.CampaignDeliveryFailed, err)
+				continue
+			}
+
+			m.recordDelivery(msg, models.CampaignDeliveryQueued, nil)
+			m.campMsgQ <- msg
+		}
+
+		m.log.Printf("re-queued %d messages of campaign (%s)", len(subs), c.Name)
+	}()
+
+	return nil
+}
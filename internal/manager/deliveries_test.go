@@ -0,0 +1,309 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// memStore is an in-memory Store that models a single campaign's checkpoint,
+// delivery log and leases the way the DB queries do.
+type memStore struct {
+	mu sync.Mutex
+
+	camp       models.Campaign
+	subs       []models.Subscriber
+	lastSubID  int
+	sent       int
+	deliveries map[int]models.CampaignDelivery
+	leases     map[string]bool
+}
+
+func newMemStore(c models.Campaign, numSubs int) *memStore {
+	s := &memStore{
+		camp:       c,
+		deliveries: make(map[int]models.CampaignDelivery),
+		leases:     make(map[string]bool),
+	}
+	for i := 1; i <= numSubs; i++ {
+		s.subs = append(s.subs, models.Subscriber{
+			Base:  models.Base{ID: i},
+			UUID:  fmt.Sprintf("sub-%d", i),
+			Email: fmt.Sprintf("sub%d@example.com", i),
+		})
+	}
+
+	return s
+}
+
+func (s *memStore) NextSubscribers(campID, limit int, instanceID string) ([]models.Subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.camp.Status != models.CampaignStatusRunning {
+		return nil, nil
+	}
+
+	var out []models.Subscriber
+	for _, sub := range s.subs {
+		if sub.ID <= s.lastSubID || len(out) >= limit {
+			continue
+		}
+
+		// Subscribers who are already in the delivery log aren't returned (ON CONFLICT DO NOTHING).
+		s.lastSubID = sub.ID
+		if _, ok := s.deliveries[sub.ID]; ok {
+			continue
+		}
+
+		out = append(out, sub)
+		s.deliveries[sub.ID] = models.CampaignDelivery{
+			CampaignID:   campID,
+			SubscriberID: sub.ID,
+			Status:       models.CampaignDeliveryQueued,
+			InstanceID:   instanceID,
+			UpdatedAt:    time.Now(),
+		}
+	}
+
+	return out, nil
+}
+
+func (s *memStore) ClaimSubscribers(campID int, instanceID string, limit int) ([]models.Subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []models.Subscriber
+	for _, sub := range s.subs {
+		d, ok := s.deliveries[sub.ID]
+		if !ok || len(out) >= limit || s.leases[d.InstanceID] {
+			continue
+		}
+		if d.Status != models.CampaignDeliveryQueued && d.Status != models.CampaignDeliveryDeferred {
+			continue
+		}
+
+		d.InstanceID = instanceID
+		d.UpdatedAt = time.Now()
+		s.deliveries[sub.ID] = d
+		out = append(out, sub)
+	}
+
+	return out, nil
+}
+
+func (s *memStore) RecordDeliveries(ds []models.CampaignDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range ds {
+		// A stale status doesn't overwrite a newer one.
+		if cur, ok := s.deliveries[d.SubscriberID]; ok && cur.Status != models.CampaignDeliveryQueued && cur.UpdatedAt.After(d.UpdatedAt) {
+			continue
+		}
+		s.deliveries[d.SubscriberID] = d
+	}
+
+	return nil
+}
+
+func (s *memStore) GetCampaign(campID int) (*models.Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.camp
+	return &c, nil
+}
+
+func (s *memStore) UpdateCampaignStatus(campID int, status string) error {
+	s.mu.Lock()
+	s.camp.Status = status
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memStore) UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error {
+	s.mu.Lock()
+	s.sent += sent
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memStore) RenewLease(campID int, instanceID string, ttl time.Duration) error {
+	s.mu.Lock()
+	s.leases[instanceID] = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memStore) ReleaseLease(campID int, instanceID string) error {
+	s.mu.Lock()
+	delete(s.leases, instanceID)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memStore) HasOtherInstances(campID int, instanceID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.leases {
+		if id != instanceID {
+			return true, nil
+		}
+	}
+	for _, d := range s.deliveries {
+		if d.Status == models.CampaignDeliveryQueued && d.InstanceID != instanceID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *memStore) NextCampaigns([]int64, []int64) ([]*models.Campaign, error) { return nil, nil }
+func (s *memStore) GetAttachment(int) (models.Attachment, error)               { return models.Attachment{}, nil }
+func (s *memStore) GetInlineAttachmentByFilename(string) (models.Attachment, string, error) {
+	return models.Attachment{}, "", nil
+}
+func (s *memStore) GetCampaignVariants(int) ([]models.CampaignVariant, error) { return nil, nil }
+func (s *memStore) UpdateCampaignVariantStatus(int, string, time.Time) error  { return nil }
+func (s *memStore) GetVariantTestCampaigns() ([]models.Campaign, error)       { return nil, nil }
+func (s *memStore) UpdateCampaignVariantWinner(int, null.Int) error           { return nil }
+func (s *memStore) ScheduleSubscribers(*models.Campaign, []int) error         { return nil }
+func (s *memStore) NextScheduledSubscribers(int, int, string) ([]models.Subscriber, error) {
+	return nil, nil
+}
+func (s *memStore) GetCampaignNextDelivery(int) (null.Time, error)           { return null.Time{}, nil }
+func (s *memStore) DeferCampaign(int, time.Time) error                       { return nil }
+func (s *memStore) GetRecurringCampaigns() ([]*models.Campaign, error)       { return nil, nil }
+func (s *memStore) UpdateCampaignRecurrence(int, null.Time, time.Time) error { return nil }
+func (s *memStore) CloneRecurringCampaign(int, string, time.Time, time.Time, time.Time, models.Feed, null.Time, []string) (int, error) {
+	return 0, nil
+}
+func (s *memStore) CreateLink(url string) (string, error) { return url, nil }
+func (s *memStore) BlocklistSubscriber(int64) error       { return nil }
+func (s *memStore) DeleteSubscriber(int64) error          { return nil }
+
+// crashMessenger records the messages pushed to every subscriber and crashes after
+// crashAt messages have been pushed, stopping the campaign on its manager.
+type crashMessenger struct {
+	mu      sync.Mutex
+	pushed  map[int]int
+	n       int
+	crashAt int
+	m       *Manager
+}
+
+func (c *crashMessenger) Name() string { return "email" }
+
+func (c *crashMessenger) Push(msg models.Message) error {
+	c.mu.Lock()
+	c.pushed[msg.Subscriber.ID]++
+	c.n++
+	crash := c.n == c.crashAt
+	c.mu.Unlock()
+
+	if crash {
+		c.m.StopCampaign(msg.Campaign.ID)
+	}
+
+	return nil
+}
+
+func (c *crashMessenger) Flush() error { return nil }
+func (c *crashMessenger) Close() error { return nil }
+
+// runCampaign processes the store's campaign on a new manager until it's done.
+func runCampaign(t *testing.T, st *memStore, msgr *crashMessenger) {
+	t.Helper()
+
+	m := New(Config{
+		BatchSize:   10,
+		Concurrency: 2,
+		MessageRate: 1000,
+		UnsubURL:    "http://localhost/unsub/%s/%s",
+	}, st, nil, log.New(io.Discard, "", 0))
+	m.fnNotify = func(string, any) error { return nil }
+	msgr.m = m
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+	go m.Run()
+
+	c, _ := st.GetCampaign(1)
+	p, err := m.newPipe(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nextPipes <- p
+
+	for deadline := time.Now().Add(10 * time.Second); m.HasRunningCampaigns(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the campaign to be processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliveryExactlyOnce(t *testing.T) {
+	const numSubs = 95
+
+	st := newMemStore(models.Campaign{
+		Base:        models.Base{ID: 1},
+		UUID:        "camp-1",
+		Name:        "test",
+		Subject:     "Hello",
+		Body:        "Hello {{ .Subscriber.Email }}",
+		ContentType: models.CampaignContentTypePlain,
+		Messenger:   "email",
+		Status:      models.CampaignStatusRunning,
+	}, numSubs)
+
+	// The first instance's messenger crashes mid-stream, stopping the campaign there.
+	first := &crashMessenger{pushed: make(map[int]int), crashAt: 37}
+	runCampaign(t, st, first)
+
+	if first.n >= numSubs {
+		t.Fatalf("expected the first run to stop mid-stream, but it pushed %d messages", first.n)
+	}
+
+	// The messages acked before the crash must be in the delivery log.
+	st.mu.Lock()
+	numSent := 0
+	for _, d := range st.deliveries {
+		if d.Status == models.CampaignDeliverySent {
+			numSent++
+		}
+	}
+	st.mu.Unlock()
+	if numSent != first.n {
+		t.Fatalf("expected %d sent messages in the delivery log after the crash, got %d", first.n, numSent)
+	}
+
+	// Another instance picks the campaign up and finishes it.
+	second := &crashMessenger{pushed: make(map[int]int)}
+	runCampaign(t, st, second)
+
+	for _, s := range st.subs {
+		if n := first.pushed[s.ID] + second.pushed[s.ID]; n != 1 {
+			t.Errorf("subscriber %d: expected 1 message, got %d", s.ID, n)
+		}
+		if d := st.deliveries[s.ID]; d.Status != models.CampaignDeliverySent {
+			t.Errorf("subscriber %d: expected delivery status %s, got %s", s.ID, models.CampaignDeliverySent, d.Status)
+		}
+	}
+
+	if st.sent != numSubs {
+		t.Errorf("expected sent count %d, got %d", numSubs, st.sent)
+	}
+	if st.camp.Status != models.CampaignStatusFinished {
+		t.Errorf("expected campaign status %s, got %s", models.CampaignStatusFinished, st.camp.Status)
+	}
+}
@@ -0,0 +1,273 @@
+package manager
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	feedTimeout = time.Second * 30
+
+	// Max size of a feed document that's read.
+	feedMaxSize = 10 * 1024 * 1024
+
+	// Max number of redirects that are followed when fetching a feed.
+	feedMaxRedirects = 3
+)
+
+// rawFeed represents an RSS 2.0, RSS 1.0 (RDF), or Atom feed document.
+type rawFeed struct {
+	XMLName xml.Name
+
+	// RSS 2.0.
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+
+	// RSS 1.0 has items at the root.
+	Items []rssItem `xml:"item"`
+
+	// Atom.
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Author    string `xml:"author>name"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+var (
+	errFeedAddr = errors.New("feed URL resolves to a private, loopback, or link-local address")
+
+	feedDateLayouts = []string{
+		time.RFC1123Z,
+		time.RFC1123,
+		time.RFC3339,
+		time.RFC822Z,
+		time.RFC822,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+		"2 Jan 2006 15:04:05 -0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+)
+
+// newFeedClient returns an HTTP client for fetching feeds. As feed URLs are
+// user supplied, unless allowPrivate is set, connections to private, loopback,
+// and link-local addresses (eg: cloud metadata endpoints) are refused. The check
+// is on the resolved IP that's dialed, which covers redirects and DNS rebinding.
+func newFeedClient(allowPrivate bool) *http.Client {
+	d := &net.Dialer{Timeout: feedTimeout}
+	if !allowPrivate {
+		d.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errFeedAddr
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: feedTimeout,
+
+		// Proxies aren't used as the dialed address would be the proxy's.
+		Transport: &http.Transport{
+			DialContext:         d.DialContext,
+			TLSHandshakeTimeout: feedTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > feedMaxRedirects {
+				return fmt.Errorf("feed exceeded %d redirects", feedMaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("feed redirected to unsupported URL: %s", req.URL)
+			}
+			return nil
+		},
+	}
+}
+
+// isPublicIP checks whether an IP is a public unicast address.
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+// fetchFeed fetches and parses the RSS/Atom feed at the given URL.
+func fetchFeed(c *http.Client, u string) (models.Feed, error) {
+	if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") {
+		return models.Feed{}, fmt.Errorf("invalid feed URL: %s", u)
+	}
+
+	resp, err := c.Get(u)
+	if err != nil {
+		return models.Feed{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Feed{}, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, feedMaxSize))
+	if err != nil {
+		return models.Feed{}, err
+	}
+
+	return parseFeed(b)
+}
+
+// parseFeed parses an RSS/Atom feed document into feed items.
+func parseFeed(b []byte) (models.Feed, error) {
+	var raw rawFeed
+	if err := xml.Unmarshal(b, &raw); err != nil {
+		return models.Feed{}, fmt.Errorf("error parsing feed: %v", err)
+	}
+
+	out := models.Feed{Items: []models.FeedItem{}}
+	for _, i := range append(raw.Channel.Items, raw.Items...) {
+		item := models.FeedItem{
+			GUID:        strings.TrimSpace(i.GUID),
+			Title:       strings.TrimSpace(i.Title),
+			URL:         strings.TrimSpace(i.Link),
+			Description: strings.TrimSpace(i.Description),
+			Content:     strings.TrimSpace(i.Content),
+			Author:      strings.TrimSpace(i.Author),
+			PublishedAt: parseFeedDate(i.PubDate, i.Date),
+		}
+		if item.Author == "" {
+			item.Author = strings.TrimSpace(i.Creator)
+		}
+		item.GUID = itemGUID(item)
+
+		out.Items = append(out.Items, item)
+	}
+
+	for _, e := range raw.Entries {
+		item := models.FeedItem{
+			GUID:        strings.TrimSpace(e.ID),
+			Title:       strings.TrimSpace(e.Title),
+			Description: strings.TrimSpace(e.Summary),
+			Content:     strings.TrimSpace(e.Content),
+			Author:      strings.TrimSpace(e.Author),
+			PublishedAt: parseFeedDate(e.Published, e.Updated),
+		}
+
+		// Pick the alternate (default) link of the entry.
+		for _, l := range e.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				item.URL = strings.TrimSpace(l.Href)
+				break
+			}
+		}
+		item.GUID = itemGUID(item)
+
+		out.Items = append(out.Items, item)
+	}
+
+	return out, nil
+}
+
+// itemGUID returns the ID by which a feed item is tracked. Items without a GUID
+// are tracked by their URL, or failing that, their title.
+func itemGUID(i models.FeedItem) string {
+	switch {
+	case i.GUID != "":
+		return i.GUID
+	case i.URL != "":
+		return i.URL
+	}
+
+	return i.Title
+}
+
+// parseFeedDate parses the first valid date from the given feed date strings.
+func parseFeedDate(dates ...string) time.Time {
+	for _, d := range dates {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+
+		for _, l := range feedDateLayouts {
+			if t, err := time.Parse(l, d); err == nil {
+				return t
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// newFeedItems returns the items in a feed that haven't been sent before, that is, whose
+// GUIDs aren't in sent, and the publish time of the latest of them, if any. Items published
+// before the given time (the start of the recurrence) are not new. Items without a date are
+// tracked by their GUIDs alone.
+func newFeedItems(f models.Feed, since time.Time, sent []string) (models.Feed, time.Time) {
+	var (
+		out    = models.Feed{Items: []models.FeedItem{}}
+		latest time.Time
+	)
+	for _, i := range f.Items {
+		if slices.Contains(sent, i.GUID) {
+			continue
+		}
+		if !i.PublishedAt.IsZero() && !i.PublishedAt.After(since) {
+			continue
+		}
+
+		out.Items = append(out.Items, i)
+		if i.PublishedAt.After(latest) {
+			latest = i.PublishedAt
+		}
+	}
+
+	return out, latest
+}
+
+// feedItemGUIDs returns the GUIDs of the items in a feed.
+func feedItemGUIDs(f models.Feed) []string {
+	out := make([]string, 0, len(f.Items))
+	for _, i := range f.Items {
+		out = append(out, i.GUID)
+	}
+
+	return out
+}
@@ -0,0 +1,255 @@
+package manager
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const testRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+	<title>Blog</title>
+	<item>
+		<guid>post-3</guid>
+		<title>Third post</title>
+		<link>https://example.com/3</link>
+		<description>Third</description>
+		<pubDate>Wed, 03 Jan 2024 10:00:00 +0000</pubDate>
+	</item>
+	<item>
+		<title>Second post</title>
+		<link>https://example.com/2</link>
+		<dc:creator>Jane</dc:creator>
+		<dc:date>2024-01-02T10:00:00Z</dc:date>
+	</item>
+	<item>
+		<guid>post-undated</guid>
+		<title>Undated post</title>
+	</item>
+	<item>
+		<guid>post-1</guid>
+		<title>First post</title>
+		<pubDate>Mon, 01 Jan 2024 10:00:00 +0000</pubDate>
+	</item>
+</channel>
+</rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Blog</title>
+	<entry>
+		<id>urn:uuid:1</id>
+		<title>Atom post</title>
+		<link rel="self" href="https://example.com/self"/>
+		<link href="https://example.com/atom"/>
+		<summary>Summary</summary>
+		<author><name>John</name></author>
+		<updated>2024-01-05T10:00:00Z</updated>
+	</entry>
+</feed>`
+
+// The test server is on a loopback address.
+var testFeedClient = newFeedClient(true)
+
+func newFeedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rss", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(testRSS))
+	})
+	mux.HandleFunc("/atom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(testAtom))
+	})
+	mux.HandleFunc("/invalid", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<rss><channel>"))
+	})
+
+	// Redirects n times to /rss.
+	mux.HandleFunc("/redirect/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n <= 1 {
+			http.Redirect(w, r, "/rss", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/redirect-file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestFetchFeed(t *testing.T) {
+	srv := newFeedServer(t)
+
+	f, err := fetchFeed(testFeedClient, srv.URL+"/rss")
+	if err != nil {
+		t.Fatalf("error fetching RSS feed: %v", err)
+	}
+
+	exp := []models.FeedItem{
+		{GUID: "post-3", Title: "Third post", URL: "https://example.com/3", Description: "Third",
+			PublishedAt: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)},
+		{GUID: "https://example.com/2", Title: "Second post", URL: "https://example.com/2", Author: "Jane",
+			PublishedAt: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{GUID: "post-undated", Title: "Undated post"},
+		{GUID: "post-1", Title: "First post", PublishedAt: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	if len(f.Items) != len(exp) {
+		t.Fatalf("expected %d items, got %d", len(exp), len(f.Items))
+	}
+	for n, e := range exp {
+		got := f.Items[n]
+		if !got.PublishedAt.Equal(e.PublishedAt) {
+			t.Errorf("item %d: expected date %v, got %v", n, e.PublishedAt, got.PublishedAt)
+		}
+		got.PublishedAt, e.PublishedAt = time.Time{}, time.Time{}
+		if got != e {
+			t.Errorf("item %d: expected %+v, got %+v", n, e, got)
+		}
+	}
+
+	f, err = fetchFeed(testFeedClient, srv.URL+"/atom")
+	if err != nil {
+		t.Fatalf("error fetching Atom feed: %v", err)
+	}
+	if len(f.Items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(f.Items))
+	}
+	if i := f.Items[0]; i.GUID != "urn:uuid:1" || i.URL != "https://example.com/atom" || i.Author != "John" || i.Description != "Summary" {
+		t.Errorf("unexpected Atom item: %+v", i)
+	}
+
+	if _, err := fetchFeed(testFeedClient, srv.URL+"/missing"); err == nil {
+		t.Error("expected error on non-OK response")
+	}
+	if _, err := fetchFeed(testFeedClient, srv.URL+"/invalid"); err == nil {
+		t.Error("expected error on invalid feed")
+	}
+}
+
+func TestFetchFeedAddrs(t *testing.T) {
+	srv := newFeedServer(t)
+
+	// Feeds on private addresses are refused unless they're allowed.
+	if _, err := fetchFeed(newFeedClient(false), srv.URL+"/rss"); !errors.Is(err, errFeedAddr) {
+		t.Errorf("expected %v fetching a loopback feed, got %v", errFeedAddr, err)
+	}
+
+	// Redirects are followed up to the limit.
+	if _, err := fetchFeed(testFeedClient, srv.URL+"/redirect/"+strconv.Itoa(feedMaxRedirects)); err != nil {
+		t.Errorf("expected %d redirects to be followed, got %v", feedMaxRedirects, err)
+	}
+	if _, err := fetchFeed(testFeedClient, srv.URL+"/redirect/"+strconv.Itoa(feedMaxRedirects+1)); err == nil {
+		t.Errorf("expected an error on more than %d redirects", feedMaxRedirects)
+	}
+
+	// Only http(s) URLs are fetched.
+	for _, u := range []string{"file:///etc/passwd", "gopher://example.com", srv.URL + "/redirect-file"} {
+		if _, err := fetchFeed(testFeedClient, u); err == nil {
+			t.Errorf("expected an error fetching %s", u)
+		}
+	}
+
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tc := range tests {
+		if got := isPublicIP(net.ParseIP(tc.ip)); got != tc.public {
+			t.Errorf("isPublicIP(%s): expected %v, got %v", tc.ip, tc.public, got)
+		}
+	}
+}
+
+func TestNewFeedItems(t *testing.T) {
+	srv := newFeedServer(t)
+
+	f, err := fetchFeed(testFeedClient, srv.URL+"/rss")
+	if err != nil {
+		t.Fatalf("error fetching feed: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		since  time.Time
+		sent   []string
+		guids  []string
+		latest time.Time
+	}{
+		{
+			name:   "first send",
+			guids:  []string{"post-3", "https://example.com/2", "post-undated", "post-1"},
+			latest: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "published before the start",
+			since:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			guids:  []string{"post-3", "https://example.com/2", "post-undated"},
+			latest: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "new undated item",
+			sent:  []string{"post-3", "https://example.com/2", "post-1"},
+			guids: []string{"post-undated"},
+		},
+		{
+			name:   "new dated item",
+			sent:   []string{"https://example.com/2", "post-undated", "post-1"},
+			guids:  []string{"post-3"},
+			latest: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "nothing new",
+			sent: feedItemGUIDs(f),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, latest := newFeedItems(f, c.since, c.sent)
+
+			guids := feedItemGUIDs(out)
+			if len(guids) != len(c.guids) {
+				t.Fatalf("expected items %v, got %v", c.guids, guids)
+			}
+			for n := range guids {
+				if guids[n] != c.guids[n] {
+					t.Fatalf("expected items %v, got %v", c.guids, guids)
+				}
+			}
+
+			if !latest.Equal(c.latest) {
+				t.Errorf("expected latest %v, got %v", c.latest, latest)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+const (
+	// Duration for which an instance's lease on a campaign is valid unless renewed.
+	// Unacknowledged messages of an instance whose lease has expired are picked up
+	// by other instances.
+	leaseTTL = time.Minute
+
+	// Interval at which leases are renewed.
+	leaseRenewInterval = leaseTTL / 4
+)
+
+// newInstanceID returns a random ID prefixed with the hostname for identifying
+// an instance among multiple instances processing campaigns.
+func newInstanceID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "listmonk"
+	}
+
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// renewLeases is a blocking function that periodically extends the leases of
+// the instance on the campaigns it's currently processing.
+func (m *Manager) renewLeases() {
+	t := time.NewTicker(leaseRenewInterval)
+	defer t.Stop()
+
+	for range t.C {
+		m.pipesMut.RLock()
+		pipes := make([]*pipe, 0, len(m.pipes))
+		for _, p := range m.pipes {
+			pipes = append(pipes, p)
+		}
+		m.pipesMut.RUnlock()
+
+		for _, p := range pipes {
+			if err := m.store.RenewLease(p.camp.ID, p.leaseID, leaseTTL); err != nil {
+				m.log.Printf("error renewing lease on campaign (%s): %v", p.camp.Name, err)
+			}
+		}
+	}
+}
@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net/http"
 	"net/textproto"
 	"net/url"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"maps"
@@ -23,6 +26,7 @@ import (
 	"github.com/knadh/listmonk/models"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	"gopkg.in/volatiletech/null.v6"
 )
 
 // attribInlineEmbed is the HTML attrib used to mark <img> tags whose source
@@ -48,12 +52,28 @@ const (
 // that provides subscriber and campaign records.
 type Store interface {
 	NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error)
-	NextSubscribers(campID, limit int) ([]models.Subscriber, error)
+	NextSubscribers(campID, limit int, instanceID string) ([]models.Subscriber, error)
 	GetCampaign(campID int) (*models.Campaign, error)
 	GetAttachment(mediaID int) (models.Attachment, error)
 	GetInlineAttachmentByFilename(filename string) (models.Attachment, string, error)
 	UpdateCampaignStatus(campID int, status string) error
 	UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error
+	GetCampaignVariants(campID int) ([]models.CampaignVariant, error)
+	UpdateCampaignVariantStatus(campID int, status string, endsAt time.Time) error
+	GetVariantTestCampaigns() ([]models.Campaign, error)
+	UpdateCampaignVariantWinner(campID int, winnerID null.Int) error
+	ScheduleSubscribers(c *models.Campaign, subIDs []int) error
+	NextScheduledSubscribers(campID, limit int, instanceID string) ([]models.Subscriber, error)
+	GetCampaignNextDelivery(campID int) (null.Time, error)
+	DeferCampaign(campID int, until time.Time) error
+	GetRecurringCampaigns() ([]*models.Campaign, error)
+	CloneRecurringCampaign(campID int, name string, sendAt, prevAt, nextAt time.Time, feed models.Feed, feedLastAt null.Time, feedGUIDs []string) (int, error)
+	UpdateCampaignRecurrence(campID int, prevAt null.Time, nextAt time.Time) error
+	RecordDeliveries(d []models.CampaignDelivery) error
+	ClaimSubscribers(campID int, instanceID string, limit int) ([]models.Subscriber, error)
+	RenewLease(campID int, instanceID string, ttl time.Duration) error
+	ReleaseLease(campID int, instanceID string) error
+	HasOtherInstances(campID int, instanceID string) (bool, error)
 	CreateLink(url string) (string, error)
 	BlocklistSubscriber(id int64) error
 	DeleteSubscriber(id int64) error
@@ -106,6 +126,28 @@ type Manager struct {
 	slidingCount int
 	slidingStart time.Time
 
+	// Number of campaign runs (pipes) on this instance, used to identify leases.
+	numPipes atomic.Int64
+
+	// Per-domain send limits. Messages to throttled domains are deferred
+	// and re-queued without blocking messages to other domains. Once there are
+	// maxDeferred messages, campaigns wait on deferredCond before queuing further messages.
+	throttle     *throttler
+	deferred     []deferredMsg
+	deferredMut  sync.Mutex
+	deferredCond *sync.Cond
+	maxDeferred  int
+
+	// Statuses of campaign messages to be written to the delivery log, and
+	// requests to write out the queued statuses right away.
+	deliveryQ chan models.CampaignDelivery
+	flushQ    chan chan struct{}
+
+	// IDs of recurring campaigns whose feeds are being fetched.
+	feeds      map[int]bool
+	feedsMut   sync.Mutex
+	feedClient *http.Client
+
 	tplFuncs template.FuncMap
 }
 
@@ -115,6 +157,9 @@ type CampaignMessage struct {
 	Campaign   *models.Campaign
 	Subscriber models.Subscriber
 
+	// Feed has the new items of RSS campaigns.
+	Feed models.Feed
+
 	from     string
 	to       string
 	subject  string
@@ -123,6 +168,12 @@ type CampaignMessage struct {
 	unsubURL string
 	headers  models.Headers
 
+	// Whether the message's status is recorded in the campaign delivery log.
+	logDelivery bool
+
+	// Whether the message has been deferred due to throttling.
+	deferred bool
+
 	pipe *pipe
 }
 
@@ -136,6 +187,7 @@ type Config struct {
 	SlidingWindow         bool
 	SlidingWindowDuration time.Duration
 	SlidingWindowRate     int
+	DomainThrottles       []DomainThrottle
 	RequeueOnError        bool
 	FromEmail             string
 	IndividualTracking    bool
@@ -149,15 +201,24 @@ type Config struct {
 	RootURL               string
 	UnsubHeader           bool
 
+	// Allow the feeds of RSS campaigns to be fetched from private, loopback,
+	// and link-local addresses.
+	FeedAllowPrivate bool
+
 	// Interval to scan the DB for active campaign checkpoints.
 	ScanInterval time.Duration
 
 	// ScanCampaigns indicates whether this instance of manager will scan the DB
 	// for active campaigns and process them.
 	// This can be used to run multiple instances of listmonk
-	// (exposed to the internet, private etc.) where only one does campaign
+	// (exposed to the internet, private etc.) where only some do campaign
 	// processing while the others handle other kinds of traffic.
+	// Multiple instances that scan campaigns share the processing of running campaigns.
 	ScanCampaigns bool
+
+	// InstanceID uniquely identifies this instance among multiple instances
+	// processing campaigns. A random one is generated if it's empty.
+	InstanceID string
 }
 
 var pushTimeout = time.Second * 3
@@ -173,6 +234,9 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 	if cfg.MessageRate < 1 {
 		cfg.MessageRate = 1
 	}
+	if cfg.InstanceID == "" {
+		cfg.InstanceID = newInstanceID()
+	}
 
 	m := &Manager{
 		cfg:   cfg,
@@ -190,7 +254,14 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 		campMsgQ:     make(chan CampaignMessage, cfg.Concurrency*cfg.MessageRate*2),
 		msgQ:         make(chan models.Message, cfg.Concurrency*cfg.MessageRate*2),
 		slidingStart: time.Now(),
-	}
+		throttle:     newThrottler(cfg.DomainThrottles),
+		maxDeferred:  cfg.BatchSize,
+		deliveryQ:    make(chan models.CampaignDelivery, cfg.BatchSize*2),
+		flushQ:       make(chan chan struct{}),
+		feeds:        make(map[int]bool),
+		feedClient:   newFeedClient(cfg.FeedAllowPrivate),
+	}
+	m.deferredCond = sync.NewCond(&m.deferredMut)
 	m.tplFuncs = m.makeGnericFuncMap()
 
 	return m
@@ -283,6 +354,9 @@ func (m *Manager) Run() {
 		// Periodically scan campaigns and push running campaigns to nextPipes
 		// to fetch subscribers from the campaign.
 		go m.scanCampaigns(m.cfg.ScanInterval)
+
+		// Keep the leases on campaigns being processed alive.
+		go m.renewLeases()
 	}
 
 	// Spawn N message workers.
@@ -290,6 +364,12 @@ func (m *Manager) Run() {
 		go m.worker()
 	}
 
+	// Write message statuses to the delivery log.
+	go m.flushDeliveries()
+
+	// Re-queue deferred messages.
+	go m.requeueDeferred()
+
 	// Indefinitely wait on the pipe queue to fetch the next set of subscribers
 	// for any active campaigns.
 	for p := range m.nextPipes {
@@ -380,7 +460,7 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 				subUUID = dummyUUID
 			}
 
-			return m.trackLink(url, msg.Campaign.UUID, subUUID)
+			return m.trackLink(url, msg.Campaign.UUID, subUUID, msg.Campaign.VariantID)
 		},
 		"TrackView": func(msg *CampaignMessage) template.HTML {
 			if m.cfg.DisableTracking {
@@ -395,7 +475,7 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 			// Use a 1x1 invisible tracking pixel with explicit dimensions and CSS
 			// visibility properties for better anti-spam filter compatibility.
 			return template.HTML(fmt.Sprintf(`<img src="%s" width="1" height="1" style="display:none;max-height:0;max-width:0;opacity:0" alt="">`,
-				fmt.Sprintf(m.cfg.ViewTrackURL, msg.Campaign.UUID, subUUID)))
+				withVariant(fmt.Sprintf(m.cfg.ViewTrackURL, msg.Campaign.UUID, subUUID), msg.Campaign.VariantID)))
 		},
 		"UnsubscribeURL": func(msg *CampaignMessage) string {
 			return msg.unsubURL
@@ -452,6 +532,14 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 
 	// Periodically scan the data source for campaigns to process.
 	for range t.C {
+		// Clone recurring campaigns whose occurrences are due into new scheduled
+		// campaigns that are picked up below.
+		m.scheduleRecurringCampaigns()
+
+		// Pick winners for A/B tests that are over so that the remainder of
+		// those campaigns are picked up.
+		m.pickVariantWinners()
+
 		ids, counts := m.getCurrentCampaigns()
 		campaigns, err := m.store.NextCampaigns(ids, counts)
 		if err != nil {
@@ -504,6 +592,18 @@ func (m *Manager) worker() {
 				continue
 			}
 
+			// If the recipient's domain is throttled, defer the message and move on
+			// to the next one.
+			domain := emailDomain(msg.to)
+			if ok, wait := m.throttle.acquire(domain); !ok {
+				if !msg.deferred {
+					m.recordDelivery(msg, models.CampaignDeliveryDeferred, nil)
+					msg.deferred = true
+				}
+				m.deferMessage(msg, wait)
+				continue
+			}
+
 			// Pause on hitting the message rate.
 			if numMsg >= m.cfg.MessageRate {
 				time.Sleep(time.Second)
@@ -546,9 +646,24 @@ func (m *Manager) worker() {
 
 			// Push the message to the messenger.
 			err := m.messengers[msg.Campaign.Messenger].Push(out)
+			m.throttle.release(domain)
+
+			// The messenger can't send the message right now, for instance, if it has
+			// reached its sending limit. Instead of holding on to the message, defer the
+			// campaign. The message is left unacknowledged in the delivery log and is
+			// picked up along with the rest of the campaign when it's resumed.
+			var dErr *models.DeferError
+			if errors.As(err, &dErr) && msg.pipe != nil {
+				m.recordDelivery(msg, models.CampaignDeliveryDeferred, err)
+				msg.pipe.Defer(time.Now().Add(dErr.RetryAfter))
+				msg.pipe.wg.Done()
+				continue
+			}
+
 			if err != nil {
 				m.log.Printf("error sending message in campaign %s: subscriber %d: %v", msg.Campaign.Name, msg.Subscriber.ID, err)
 			}
+			m.ackDelivery(msg, err)
 
 			// Increment the send rate or the error counter if there was an error.
 			if msg.pipe != nil {
@@ -560,10 +675,6 @@ func (m *Manager) worker() {
 					// and stops the campaign if the error count exceeds the threshold.
 					msg.pipe.OnError()
 				} else {
-					id := uint64(msg.Subscriber.ID)
-					if id > msg.pipe.lastID.Load() {
-						msg.pipe.lastID.Store(uint64(msg.Subscriber.ID))
-					}
 					msg.pipe.rate.Incr(1)
 					msg.pipe.sent.Add(1)
 				}
@@ -608,7 +719,7 @@ func (m *Manager) getCurrentCampaigns() ([]int64, []int64) {
 
 // trackLink register a URL and return its UUID to be used in message templates
 // for tracking links.
-func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+func (m *Manager) trackLink(url, campUUID, subUUID string, variantID int) string {
 	if m.cfg.DisableTracking {
 		return url
 	}
@@ -618,7 +729,7 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 	m.linksMut.RLock()
 	if uu, ok := m.links[url]; ok {
 		m.linksMut.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+		return withVariant(fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID), variantID)
 	}
 	m.linksMut.RUnlock()
 
@@ -635,7 +746,26 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 	m.links[url] = uu
 	m.linksMut.Unlock()
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return withVariant(fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID), variantID)
+}
+
+// withVariant adds the A/B test variant ID, if any, to a tracking URL
+// so that views and clicks can be attributed to the variant.
+func withVariant(u string, variantID int) string {
+	if variantID < 1 {
+		return u
+	}
+
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	q := p.Query()
+	q.Set("v", strconv.Itoa(variantID))
+	p.RawQuery = q.Encode()
+
+	return p.String()
 }
 
 // sendNotif sends a notification to registered admin e-mails.
@@ -14,6 +14,7 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (C
 	msg := CampaignMessage{
 		Campaign:   c,
 		Subscriber: s,
+		Feed:       c.Feed,
 
 		subject:  c.Subject,
 		from:     c.FromEmail,
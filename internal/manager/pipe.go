@@ -1,25 +1,41 @@
 package manager
 
 import (
+	"crypto/md5"
+	"encoding/binary"
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/knadh/listmonk/models"
 	"github.com/paulbellamy/ratecounter"
+	"gopkg.in/volatiletech/null.v6"
 )
 
 type pipe struct {
-	camp       *models.Campaign
+	camp *models.Campaign
+
+	// A/B test variants (copies of camp) that subscribers are split across.
+	variants []*models.Campaign
+
 	rate       *ratecounter.RateCounter
 	wg         *sync.WaitGroup
 	sent       atomic.Int64
-	lastID     atomic.Uint64
 	errors     atomic.Uint64
 	stopped    atomic.Bool
 	withErrors atomic.Bool
 
+	// Unix time until which the campaign is deferred, for instance, when the
+	// messenger has reached its sending limit.
+	deferUntil atomic.Int64
+
+	// ID of the pipe's lease on the campaign. Every run of a campaign on an instance
+	// has its own lease so that messages left unacknowledged by an earlier run on the
+	// same instance (eg: before a pause) can be picked up.
+	leaseID string
+
 	m *Manager
 }
 
@@ -46,12 +62,27 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 		return nil, err
 	}
 
+	// Load and compile A/B test variants.
+	variants, err := m.loadVariants(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Acquire a lease on the campaign. Other instances processing the campaign leave
+	// the messages queued by this run alone while the lease is alive.
+	leaseID := fmt.Sprintf("%s-%d", m.cfg.InstanceID, m.numPipes.Add(1))
+	if err := m.store.RenewLease(c.ID, leaseID, leaseTTL); err != nil {
+		return nil, fmt.Errorf("error acquiring lease on campaign %s: %v", c.Name, err)
+	}
+
 	// Add the campaign to the active map.
 	p := &pipe{
-		camp: c,
-		rate: ratecounter.NewRateCounter(time.Minute),
-		wg:   &sync.WaitGroup{},
-		m:    m,
+		camp:     c,
+		variants: variants,
+		rate:     ratecounter.NewRateCounter(time.Minute),
+		wg:       &sync.WaitGroup{},
+		leaseID:  leaseID,
+		m:        m,
 	}
 
 	// Increment the waitgroup so that Wait() blocks immediately. This is necessary
@@ -74,13 +105,70 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 	return p, nil
 }
 
+// loadVariants returns compiled copies of the campaign for each of its A/B test variants
+// while the test is on. Once a winner has been picked, it returns just the winner.
+func (m *Manager) loadVariants(c *models.Campaign) ([]*models.Campaign, error) {
+	if !c.HasVariants() {
+		return nil, nil
+	}
+
+	// A decided test without a winner (no variants) falls back to the campaign itself.
+	if c.VariantStatus == models.CampaignVariantStatusDecided && !c.VariantWinnerID.Valid {
+		return nil, nil
+	}
+
+	vars, err := m.store.GetCampaignVariants(c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching variants for campaign %s: %v", c.Name, err)
+	}
+
+	var out []*models.Campaign
+	for _, v := range vars {
+		if c.VariantStatus == models.CampaignVariantStatusDecided && v.ID != int(c.VariantWinnerID.Int) {
+			continue
+		}
+
+		vc := c.ApplyVariant(v)
+		if err := vc.CompileTemplate(m.TemplateFuncs(vc)); err != nil {
+			return nil, fmt.Errorf("error compiling variant %s: %v", v.Name, err)
+		}
+		out = append(out, vc)
+	}
+
+	return out, nil
+}
+
 // NextSubscribers processes the next batch of subscribers in a given campaign.
 // It returns a bool indicating whether any subscribers were processed
 // in the current batch or not. A false indicates that all subscribers
 // have been processed, or that a campaign has been paused or cancelled.
 func (p *pipe) NextSubscribers() (bool, error) {
+	// The pipe has been stopped, for instance, when the campaign was deferred.
+	if p.stopped.Load() {
+		return false, nil
+	}
+
+	// Before moving on from the checkpoint, re-send messages of the campaign that were
+	// queued but never acknowledged by instances that are no longer processing it,
+	// for instance, when the campaign was paused or an instance crashed mid-batch.
+	subs, err := p.m.store.ClaimSubscribers(p.camp.ID, p.leaseID, p.m.cfg.BatchSize)
+	if err != nil {
+		return false, fmt.Errorf("error claiming pending campaign subscribers (%s): %v", p.camp.Name, err)
+	}
+
+	if len(subs) > 0 {
+		p.m.log.Printf("resuming %d unacknowledged messages of campaign (%s)", len(subs), p.camp.Name)
+		p.push(subs)
+		return true, nil
+	}
+
+	// Campaigns with scheduled delivery are sent from the schedule.
+	if p.camp.HasScheduledDelivery() {
+		return p.nextScheduledSubscribers()
+	}
+
 	// Fetch the next batch of subscribers from a 'running' campaign.
-	subs, err := p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize)
+	subs, err = p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize, p.leaseID)
 	if err != nil {
 		return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", p.camp.Name, err)
 	}
@@ -91,6 +179,50 @@ func (p *pipe) NextSubscribers() (bool, error) {
 		return false, nil
 	}
 
+	p.push(subs)
+	return true, nil
+}
+
+// nextScheduledSubscribers processes the next batch of subscribers in a campaign
+// with scheduled delivery. First, all the subscribers in the campaign are scheduled
+// batch by batch, advancing the same last_subscriber_id checkpoint that regular
+// campaigns use. Then, batches of subscribers whose delivery is due are sent.
+func (p *pipe) nextScheduledSubscribers() (bool, error) {
+	subs, err := p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize, p.leaseID)
+	if err != nil {
+		return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", p.camp.Name, err)
+	}
+
+	if len(subs) > 0 {
+		ids := make([]int, len(subs))
+		for i, s := range subs {
+			ids[i] = s.ID
+		}
+
+		if err := p.m.store.ScheduleSubscribers(p.camp, ids); err != nil {
+			return false, fmt.Errorf("error scheduling campaign subscribers (%s): %v", p.camp.Name, err)
+		}
+
+		return true, nil
+	}
+
+	// All subscribers have been scheduled. Fetch the ones that are due.
+	subs, err = p.m.store.NextScheduledSubscribers(p.camp.ID, p.m.cfg.BatchSize, p.leaseID)
+	if err != nil {
+		return false, fmt.Errorf("error fetching scheduled campaign subscribers (%s): %v", p.camp.Name, err)
+	}
+
+	// Nothing is due. cleanup() defers the campaign if there are deliveries pending.
+	if len(subs) == 0 {
+		return false, nil
+	}
+
+	p.push(subs)
+	return true, nil
+}
+
+// push renders and pushes messages for the given subscribers to the message queue.
+func (p *pipe) push(subs []models.Subscriber) {
 	// Is there a sliding window limit configured?
 	hasSliding := p.m.cfg.SlidingWindow &&
 		p.m.cfg.SlidingWindowRate > 0 &&
@@ -98,6 +230,9 @@ func (p *pipe) NextSubscribers() (bool, error) {
 
 	// Push messages.
 	for _, s := range subs {
+		// Wait for deferred messages to drain before rendering more.
+		p.m.waitDeferred()
+
 		msg, err := p.newMessage(s)
 		if err != nil {
 			p.m.log.Printf("error rendering message (%s) (%s): %v", p.camp.Name, s.Email, err)
@@ -134,8 +269,6 @@ func (p *pipe) NextSubscribers() (bool, error) {
 			}
 		}
 	}
-
-	return true, nil
 }
 
 // OnError keeps track of the number of errors that occur while sending messages
@@ -171,21 +304,101 @@ func (p *pipe) Stop(withErrors bool) {
 	p.stopped.Store(true)
 }
 
+// Defer stops processing the campaign and defers it until the given time. Messages
+// that are yet to be sent are left unacknowledged in the delivery log and are picked
+// up when the campaign is processed again.
+func (p *pipe) Defer(until time.Time) {
+	if p.stopped.Load() {
+		return
+	}
+
+	p.deferUntil.Store(until.Unix())
+	p.Stop(false)
+}
+
 // newMessage returns a campaign message while internally incrementing the
 // number of messages in the pipe wait group so that the status of every
 // message can be atomically tracked.
 func (p *pipe) newMessage(s models.Subscriber) (CampaignMessage, error) {
-	msg, err := p.m.NewCampaignMessage(p.camp, s)
+	msg, err := p.m.NewCampaignMessage(pickVariant(p.camp, p.variants, s), s)
 	if err != nil {
 		return msg, err
 	}
 
 	msg.pipe = p
+	msg.logDelivery = true
 	p.wg.Add(1)
 
 	return msg, nil
 }
 
+// pickVariant returns the A/B test variant of a campaign that a subscriber gets,
+// or the campaign itself if there are no variants.
+func pickVariant(c *models.Campaign, variants []*models.Campaign, s models.Subscriber) *models.Campaign {
+	if len(variants) == 0 {
+		return c
+	}
+
+	// Split the sample evenly across the variants. The sample itself is picked by
+	// the first 32 bits of the hash in the DB, so the next 32 bits are used to spread it.
+	h := variantHash(c.ID, s.UUID)
+	return variants[binary.BigEndian.Uint32(h[4:8])%uint32(len(variants))]
+}
+
+// variantHash returns the hash of a subscriber in an A/B tested campaign that picks
+// the sample (in next-campaign-subscribers) and the variant the subscriber gets.
+// Hashing the campaign ID along with the UUID gives every campaign a different sample.
+func variantHash(campID int, subUUID string) [md5.Size]byte {
+	return md5.Sum([]byte(strconv.Itoa(campID) + ":" + subUUID))
+}
+
+// pickVariantWinners picks the variant with the highest views or clicks (variant_metric)
+// as the winner of every A/B tested campaign whose test window is over.
+func (m *Manager) pickVariantWinners() {
+	camps, err := m.store.GetVariantTestCampaigns()
+	if err != nil {
+		m.log.Printf("error fetching campaign variant tests: %v", err)
+		return
+	}
+
+	for _, c := range camps {
+		vars, err := m.store.GetCampaignVariants(c.ID)
+		if err != nil {
+			m.log.Printf("error fetching variants for campaign %s: %v", c.Name, err)
+			continue
+		}
+
+		winner := pickWinner(c.VariantMetric, vars)
+		if err := m.store.UpdateCampaignVariantWinner(c.ID, winner); err != nil {
+			m.log.Printf("error updating variant winner for campaign %s: %v", c.Name, err)
+			continue
+		}
+
+		m.log.Printf("picked variant %v as the winner of campaign %s", winner.Int, c.Name)
+	}
+}
+
+// pickWinner returns the ID of the variant with the highest score on the given metric.
+// Ties go to the older variant. An invalid ID is returned if there are no variants.
+func pickWinner(metric string, vars []models.CampaignVariant) null.Int {
+	var (
+		out  null.Int
+		best = -1
+	)
+	for _, v := range vars {
+		score := v.Views
+		if metric == models.CampaignVariantMetricClicks {
+			score = v.Clicks
+		}
+
+		if score > best || (score == best && v.ID < out.Int) {
+			out, best = null.IntFrom(v.ID), score
+		}
+	}
+
+	return out
+}
+
 // cleanup finishes the campaign and updates the campaign status in the DB
 // and also triggers a notification to the admin. This only triggers once
 // a pipe's wg counter is fully exhausted, draining all messages in its queue.
@@ -196,11 +409,23 @@ func (p *pipe) cleanup() {
 		p.m.pipesMut.Unlock()
 	}()
 
-	// Update campaign's 'sent count.
-	if err := p.m.store.UpdateCampaignCounts(p.camp.ID, 0, int(p.sent.Load()), int(p.lastID.Load())); err != nil {
+	// Write out the statuses of the campaign's messages before its progress is recorded
+	// so that the messages that have been sent aren't picked up again.
+	p.m.flushDeliveryLog()
+
+	// Update campaign's 'sent count. The checkpoint isn't rewound to the last sent message as
+	// other instances may be processing the campaign. Messages that were fetched but not sent
+	// are tracked in the delivery log and are picked up when the campaign is processed again.
+	if err := p.m.store.UpdateCampaignCounts(p.camp.ID, 0, int(p.sent.Load()), 0); err != nil {
 		p.m.log.Printf("error updating campaign counts (%s): %v", p.camp.Name, err)
 	}
 
+	// Release the instance's lease on the campaign so that its unacknowledged messages,
+	// if any, can be picked up right away.
+	if err := p.m.store.ReleaseLease(p.camp.ID, p.leaseID); err != nil {
+		p.m.log.Printf("error releasing campaign lease (%s): %v", p.camp.Name, err)
+	}
+
 	// The campaign was auto-paused due to errors.
 	if p.withErrors.Load() {
 		if err := p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusPaused); err != nil {
@@ -213,12 +438,33 @@ func (p *pipe) cleanup() {
 		return
 	}
 
+	// The campaign was deferred. It's picked up again once the time's up.
+	if until := p.deferUntil.Load(); until > 0 {
+		t := time.Unix(until, 0)
+		if err := p.m.store.DeferCampaign(p.camp.ID, t); err != nil {
+			p.m.log.Printf("error deferring campaign (%s): %v", p.camp.Name, err)
+		} else {
+			p.m.log.Printf("campaign (%s) deferred until %s", p.camp.Name, t.Format(time.RFC3339))
+		}
+		return
+	}
+
 	// The campaign was manually stopped (pause, cancel).
 	if p.stopped.Load() {
 		p.m.log.Printf("stop processing campaign (%s)", p.camp.Name)
 		return
 	}
 
+	// This instance has run out of subscribers, but other instances may still be processing
+	// the campaign. The last one to finish moves the campaign on to its next state.
+	if ok, err := p.m.store.HasOtherInstances(p.camp.ID, p.leaseID); err != nil {
+		p.m.log.Printf("error checking campaign (%s) instances: %v", p.camp.Name, err)
+		return
+	} else if ok {
+		p.m.log.Printf("finish processing campaign (%s) on this instance. other instances are still processing it", p.camp.Name)
+		return
+	}
+
 	// Campaign wasn't manually stopped and subscribers were naturally exhausted.
 	// Fetch the up-to-date campaign status from the DB.
 	c, err := p.m.store.GetCampaign(p.camp.ID)
@@ -227,6 +473,36 @@ func (p *pipe) cleanup() {
 		return
 	}
 
+	// Deliveries of the campaign are scheduled for later. Defer the campaign until the next one is due.
+	if c.HasScheduledDelivery() && c.Status == models.CampaignStatusRunning {
+		next, err := p.m.store.GetCampaignNextDelivery(p.camp.ID)
+		if err != nil {
+			p.m.log.Printf("error fetching next delivery of campaign (%s): %v", p.camp.Name, err)
+			return
+		}
+
+		if next.Valid {
+			if err := p.m.store.DeferCampaign(p.camp.ID, next.Time); err != nil {
+				p.m.log.Printf("error deferring campaign (%s): %v", p.camp.Name, err)
+			} else {
+				p.m.log.Printf("campaign (%s) deferred until next scheduled delivery at %s", p.camp.Name, next.Time.Format(time.RFC3339))
+			}
+			return
+		}
+	}
+
+	// An A/B test's sample has been exhausted. The campaign waits for the test window
+	// to end and the winner to be picked before the remainder is processed.
+	if c.VariantStatus == models.CampaignVariantStatusTesting && c.Status == models.CampaignStatusRunning {
+		d, _ := time.ParseDuration(c.VariantWindow)
+		if err := p.m.store.UpdateCampaignVariantStatus(p.camp.ID, models.CampaignVariantStatusWaiting, time.Now().Add(d)); err != nil {
+			p.m.log.Printf("error updating campaign (%s) variant status: %v", p.camp.Name, err)
+		} else {
+			p.m.log.Printf("campaign (%s) A/B test sample sent. waiting %s for results", p.camp.Name, d)
+		}
+		return
+	}
+
 	// If a running campaign has exhausted subscribers, it's finished.
 	if c.Status == models.CampaignStatusRunning || c.Status == models.CampaignStatusScheduled {
 		c.Status = models.CampaignStatusFinished
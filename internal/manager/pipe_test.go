@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// inSample mirrors the A/B test sampling in next-campaign-subscribers:
+// MOD(('x' || SUBSTR(MD5(campaign_id || ':' || uuid), 1, 8))::BIT(32)::BIGINT, 100) < sample.
+func inSample(campID int, subUUID string, sample int) bool {
+	h := variantHash(campID, subUUID)
+	return binary.BigEndian.Uint32(h[:4])%100 < uint32(sample)
+}
+
+func testSubs(n int) []models.Subscriber {
+	out := make([]models.Subscriber, n)
+	for i := range out {
+		out[i] = models.Subscriber{
+			Base: models.Base{ID: i + 1},
+			UUID: fmt.Sprintf("%08x-54e7-4f3a-8c1b-%012x", i*7919, i),
+		}
+	}
+	return out
+}
+
+func testVariants(c *models.Campaign, n int) []*models.Campaign {
+	out := make([]*models.Campaign, n)
+	for i := range out {
+		out[i] = c.ApplyVariant(models.CampaignVariant{ID: i + 1})
+	}
+	return out
+}
+
+// within checks that n is within 5% of exp.
+func within(n, exp int) bool {
+	return math.Abs(float64(n-exp)) <= float64(exp)*0.05
+}
+
+func TestSampling(t *testing.T) {
+	const numSubs = 100000
+	subs := testSubs(numSubs)
+
+	for _, sample := range []int{10, 25, 50} {
+		var n int
+		for _, s := range subs {
+			if inSample(1, s.UUID, sample) {
+				n++
+			}
+		}
+
+		if exp := numSubs * sample / 100; !within(n, exp) {
+			t.Errorf("sample %d%%: expected ~%d subscribers, got %d", sample, exp, n)
+		}
+	}
+
+	// Every campaign gets a different sample. Independent 10% samples overlap by ~1%.
+	var both int
+	for _, s := range subs {
+		if inSample(1, s.UUID, 10) && inSample(2, s.UUID, 10) {
+			both++
+		}
+	}
+	if both > numSubs/50 {
+		t.Errorf("expected ~%d subscribers in the samples of both campaigns, got %d", numSubs/100, both)
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	var (
+		c    = &models.Campaign{Base: models.Base{ID: 1}}
+		subs = testSubs(100000)
+	)
+
+	// Campaigns without variants are sent as-is.
+	if got := pickVariant(c, nil, subs[0]); got != c {
+		t.Errorf("expected the campaign without variants, got %+v", got)
+	}
+
+	for _, n := range []int{2, 3, 5} {
+		var (
+			vars   = testVariants(c, n)
+			all    = map[int]int{}
+			sample = map[int]int{}
+			total  int
+		)
+		for _, s := range subs {
+			v := pickVariant(c, vars, s)
+			all[v.VariantID]++
+
+			// The split within the sample is independent of the sample.
+			if inSample(c.ID, s.UUID, 10) {
+				sample[v.VariantID]++
+				total++
+			}
+
+			// A subscriber always gets the same variant, for instance, when a message is resent.
+			if again := pickVariant(c, vars, s); again != v {
+				t.Fatalf("expected subscriber %d to get the same variant", s.ID)
+			}
+		}
+
+		if len(all) != n {
+			t.Fatalf("%d variants: expected every variant to be picked, got %v", n, all)
+		}
+		for id, cnt := range all {
+			if exp := len(subs) / n; !within(cnt, exp) {
+				t.Errorf("%d variants: expected ~%d subscribers for variant %d, got %d", n, exp, id, cnt)
+			}
+			if exp := total / n; math.Abs(float64(sample[id]-exp)) > float64(exp)*0.1 {
+				t.Errorf("%d variants: expected ~%d subscribers of the sample for variant %d, got %d", n, exp, id, sample[id])
+			}
+		}
+	}
+}
+
+func TestPickWinner(t *testing.T) {
+	vars := []models.CampaignVariant{
+		{ID: 1, Views: 10, Clicks: 3},
+		{ID: 2, Views: 25, Clicks: 1},
+		{ID: 3, Views: 25, Clicks: 3},
+	}
+
+	tests := []struct {
+		name   string
+		metric string
+		vars   []models.CampaignVariant
+		exp    null.Int
+	}{
+		{"views", models.CampaignVariantMetricViews, vars, null.IntFrom(2)},
+		{"clicks", models.CampaignVariantMetricClicks, vars, null.IntFrom(1)},
+		{"ties go to the older variant", models.CampaignVariantMetricViews, []models.CampaignVariant{vars[2], vars[1]}, null.IntFrom(2)},
+		{"no engagement", models.CampaignVariantMetricClicks, []models.CampaignVariant{{ID: 4}, {ID: 5}}, null.IntFrom(4)},
+		{"no variants", models.CampaignVariantMetricViews, nil, null.Int{}},
+	}
+
+	for _, tc := range tests {
+		if got := pickWinner(tc.metric, tc.vars); got != tc.exp {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.exp, got)
+		}
+	}
+}
+
+func TestWithVariant(t *testing.T) {
+	tests := []struct {
+		url string
+		id  int
+		exp string
+	}{
+		{"https://example.com/link/a/b/c", 0, "https://example.com/link/a/b/c"},
+		{"https://example.com/link/a/b/c", 3, "https://example.com/link/a/b/c?v=3"},
+		{"https://example.com/campaign/a/b/px.png?x=1", 3, "https://example.com/campaign/a/b/px.png?v=3&x=1"},
+		{"https://example.com/link/a/b/c?v=1", 2, "https://example.com/link/a/b/c?v=2"},
+	}
+
+	for _, tc := range tests {
+		if got := withVariant(tc.url, tc.id); got != tc.exp {
+			t.Errorf("withVariant(%q, %d): expected %q, got %q", tc.url, tc.id, tc.exp, got)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	txttpl "text/template"
+	"time"
+
+	"github.com/gdgvda/cron"
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// scheduleRecurringCampaigns clones recurring campaigns whose occurrences are due
+// into new scheduled campaigns and moves them to their next occurrence.
+func (m *Manager) scheduleRecurringCampaigns() {
+	camps, err := m.store.GetRecurringCampaigns()
+	if err != nil {
+		m.log.Printf("error fetching recurring campaigns: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, c := range camps {
+		sch, err := cron.ParseStandard(c.Recurrence)
+		if err != nil {
+			m.log.Printf("invalid recurrence on campaign (%s): %v", c.Name, err)
+			continue
+		}
+
+		// The next occurrence is computed after every change to the recurrence. The
+		// first occurrence is after the campaign's send_at.
+		if !c.RecurrenceNextAt.Valid {
+			from := now
+			if c.SendAt.Valid && c.SendAt.Time.After(now) {
+				from = c.SendAt.Time
+			}
+
+			if err := m.store.UpdateCampaignRecurrence(c.ID, c.RecurrenceNextAt, sch.Next(from)); err != nil {
+				m.log.Printf("error updating campaign (%s) recurrence: %v", c.Name, err)
+			}
+			continue
+		}
+
+		// Occurrences missed (eg: during downtime) are not sent more than once.
+		next := sch.Next(now)
+
+		// Feeds are fetched in the background so that slow feeds don't hold up campaign
+		// processing. A campaign's feed is fetched only once at a time. Until it's done,
+		// the occurrence stays due and is skipped on subsequent scans.
+		if c.FeedURL != "" {
+			if !m.startFeedFetch(c.ID) {
+				continue
+			}
+
+			go func(c *models.Campaign) {
+				defer m.endFeedFetch(c.ID)
+				m.scheduleOccurrence(c, now, next)
+			}(c)
+			continue
+		}
+
+		m.scheduleOccurrence(c, now, next)
+	}
+}
+
+// scheduleOccurrence clones a recurring campaign's due occurrence into a new campaign
+// scheduled at now, if the campaign's feed has new items and its condition matches,
+// and moves it to its next occurrence.
+func (m *Manager) scheduleOccurrence(c *models.Campaign, now, next time.Time) {
+	// For RSS campaigns, send only if the feed has new items since the last send.
+	var (
+		feed       models.Feed
+		feedLastAt null.Time
+		feedGUIDs  []string
+	)
+	if c.FeedURL != "" {
+		f, err := fetchFeed(m.feedClient, c.FeedURL)
+		if err != nil {
+			// Retry on the next occurrence.
+			m.log.Printf("error fetching feed for campaign (%s): %v", c.Name, err)
+			m.skipOccurrence(c, next)
+			return
+		}
+
+		var latest time.Time
+		feed, latest = newFeedItems(f, c.SendAt.Time, c.FeedGUIDs)
+		if len(feed.Items) == 0 {
+			m.skipOccurrence(c, next)
+			return
+		}
+
+		if !latest.IsZero() {
+			feedLastAt = null.TimeFrom(latest)
+		}
+		feedGUIDs = feedItemGUIDs(f)
+		c.Feed = feed
+	}
+
+	ok, err := m.matchRecurrenceCondition(c)
+	if err != nil {
+		m.log.Printf("error rendering campaign (%s) recurrence condition: %v", c.Name, err)
+	}
+	if !ok {
+		m.log.Printf("skipping recurring campaign (%s) occurrence as the condition is false", c.Name)
+		m.skipOccurrence(c, next)
+		return
+	}
+
+	name := fmt.Sprintf("%s (%s)", c.Name, c.RecurrenceNextAt.Time.Format("2006-01-02"))
+	id, err := m.store.CloneRecurringCampaign(c.ID, name, now, c.RecurrenceNextAt.Time, next, feed, feedLastAt, feedGUIDs)
+	if err != nil {
+		m.log.Printf("error cloning recurring campaign (%s): %v", c.Name, err)
+		return
+	}
+	if id == 0 {
+		m.log.Printf("recurring campaign (%s) occurrence has already been scheduled", c.Name)
+		return
+	}
+	m.log.Printf("scheduled recurring campaign (%s)", name)
+}
+
+// skipOccurrence moves a recurring campaign to its next occurrence without sending it.
+func (m *Manager) skipOccurrence(c *models.Campaign, next time.Time) {
+	if err := m.store.UpdateCampaignRecurrence(c.ID, c.RecurrenceNextAt, next); err != nil {
+		m.log.Printf("error updating campaign (%s) recurrence: %v", c.Name, err)
+	}
+}
+
+// startFeedFetch marks a campaign's feed as being fetched. It returns false
+// if the feed is already being fetched.
+func (m *Manager) startFeedFetch(campID int) bool {
+	m.feedsMut.Lock()
+	defer m.feedsMut.Unlock()
+
+	if m.feeds[campID] {
+		return false
+	}
+	m.feeds[campID] = true
+
+	return true
+}
+
+// endFeedFetch marks a campaign's feed fetch as done.
+func (m *Manager) endFeedFetch(campID int) {
+	m.feedsMut.Lock()
+	delete(m.feeds, campID)
+	m.feedsMut.Unlock()
+}
+
+// matchRecurrenceCondition renders a recurring campaign's condition template and
+// checks whether it's true, that is, not empty, `false`, or `0`. A campaign
+// without a condition always matches. For RSS campaigns, the new feed items
+// are available as .Feed.Items.
+func (m *Manager) matchRecurrenceCondition(c *models.Campaign) (bool, error) {
+	if strings.TrimSpace(c.RecurrenceCondition) == "" {
+		return true, nil
+	}
+
+	tpl, err := txttpl.New(models.BaseTpl).Funcs(txttpl.FuncMap(m.GenericTemplateFuncs())).Parse(c.RecurrenceCondition)
+	if err != nil {
+		return false, err
+	}
+
+	data := struct {
+		Campaign *models.Campaign
+		Feed     models.Feed
+	}{c, c.Feed}
+
+	var b bytes.Buffer
+	if err := tpl.ExecuteTemplate(&b, models.BaseTpl, data); err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(b.String())) {
+	case "", "false", "0":
+		return false, nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval at which deferred messages are checked and re-queued.
+const deferInterval = time.Millisecond * 100
+
+// DomainThrottle represents the send limits for messages to a recipient domain.
+// Rate messages are sent per Duration, evenly spaced out, with at most Concurrency
+// messages being pushed at any given time. A zero value disables a limit.
+// Limits are tracked in memory and apply to each instance separately.
+type DomainThrottle struct {
+	Domain      string
+	Rate        int
+	Duration    time.Duration
+	Concurrency int
+}
+
+// throttler keeps track of the per-domain send rates and concurrency.
+type throttler struct {
+	limits map[string]DomainThrottle
+
+	// Time at which the next message can be sent to a domain.
+	next     map[string]time.Time
+	inflight map[string]int
+	sync.Mutex
+}
+
+// deferredMsg is a campaign message to a throttled domain that's
+// re-queued after a delay.
+type deferredMsg struct {
+	msg CampaignMessage
+	at  time.Time
+}
+
+func newThrottler(limits []DomainThrottle) *throttler {
+	t := &throttler{
+		limits:   make(map[string]DomainThrottle, len(limits)),
+		next:     make(map[string]time.Time),
+		inflight: make(map[string]int),
+	}
+
+	for _, l := range limits {
+		d := strings.ToLower(strings.TrimSpace(l.Domain))
+		if d == "" || (l.Rate < 1 && l.Concurrency < 1) {
+			continue
+		}
+		if l.Duration <= 0 {
+			l.Duration = time.Second
+		}
+		t.limits[d] = l
+	}
+
+	return t
+}
+
+// acquire checks whether a message can be sent to the given domain right away and
+// if yes, reserves a slot that should be released after the message is pushed.
+// If not, it returns the duration to wait before trying again.
+func (t *throttler) acquire(domain string) (bool, time.Duration) {
+	l, ok := t.limits[domain]
+	if !ok {
+		return true, 0
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if l.Concurrency > 0 && t.inflight[domain] >= l.Concurrency {
+		return false, deferInterval
+	}
+
+	now := time.Now()
+	if l.Rate > 0 {
+		if next := t.next[domain]; now.Before(next) {
+			return false, next.Sub(now)
+		}
+		t.next[domain] = now.Add(l.Duration / time.Duration(l.Rate))
+	}
+
+	t.inflight[domain]++
+	return true, 0
+}
+
+// release releases a domain slot reserved by acquire().
+func (t *throttler) release(domain string) {
+	if _, ok := t.limits[domain]; !ok {
+		return
+	}
+
+	t.Lock()
+	if t.inflight[domain] > 0 {
+		t.inflight[domain]--
+	}
+	t.Unlock()
+}
+
+// deferMessage holds a campaign message to a throttled domain to be re-queued
+// after the given duration.
+func (m *Manager) deferMessage(msg CampaignMessage, wait time.Duration) {
+	m.deferredMut.Lock()
+	m.deferred = append(m.deferred, deferredMsg{msg: msg, at: time.Now().Add(wait)})
+	m.deferredMut.Unlock()
+}
+
+// waitDeferred blocks while the number of deferred messages is at the limit so that
+// messages to throttled domains don't pile up in memory while campaigns are processed.
+func (m *Manager) waitDeferred() {
+	m.deferredMut.Lock()
+	for len(m.deferred) >= m.maxDeferred {
+		m.deferredCond.Wait()
+	}
+	m.deferredMut.Unlock()
+}
+
+// requeueDeferred is a blocking function that periodically pushes deferred
+// messages whose wait is over back into the campaign message queue.
+func (m *Manager) requeueDeferred() {
+	t := time.NewTicker(deferInterval)
+	defer t.Stop()
+
+	for range t.C {
+		now := time.Now()
+
+		var due []CampaignMessage
+		m.deferredMut.Lock()
+		n := 0
+		for _, d := range m.deferred {
+			if now.Before(d.at) {
+				m.deferred[n] = d
+				n++
+				continue
+			}
+			due = append(due, d.msg)
+		}
+		m.deferred = m.deferred[:n]
+		m.deferredMut.Unlock()
+
+		// Wake up the campaigns waiting on the limit.
+		if len(due) > 0 {
+			m.deferredCond.Broadcast()
+		}
+
+		for _, msg := range due {
+			m.campMsgQ <- msg
+		}
+	}
+}
+
+// emailDomain returns the lowercased domain of an e-mail address.
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimRight(email[i+1:], "> "))
+}
@@ -0,0 +1,385 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIM canonicalization algorithms.
+const (
+	DKIMCanonSimple  = "simple"
+	DKIMCanonRelaxed = "relaxed"
+)
+
+// Maximum length of a line in the folded DKIM-Signature header.
+const dkimLineLen = 72
+
+// DKIMDefaultHeaders is the list of headers that are signed if a key doesn't
+// specify any. Headers that are absent in a message are not signed.
+var DKIMDefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-Id",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIM represents a DKIM signing key for a From domain.
+type DKIM struct {
+	Domain     string `json:"domain"`
+	Selector   string `json:"selector"`
+	PrivateKey string `json:"private_key"`
+
+	// Headers is the list of headers to sign.
+	Headers []string `json:"headers"`
+
+	// Canonicalization is the header/body canonicalization, eg: relaxed/simple.
+	Canonicalization string `json:"canonicalization"`
+}
+
+// dkimSigner signs messages with a DKIM key.
+type dkimSigner struct {
+	domain   string
+	selector string
+	headers  []string
+
+	hdrCanon  string
+	bodyCanon string
+
+	algo string
+	key  crypto.Signer
+}
+
+// newDKIMSigner parses and validates a DKIM key and returns a signer.
+func newDKIMSigner(d DKIM) (*dkimSigner, error) {
+	s := &dkimSigner{
+		domain:   strings.ToLower(strings.TrimSpace(d.Domain)),
+		selector: strings.TrimSpace(d.Selector),
+		headers:  d.Headers,
+	}
+	if s.domain == "" || s.selector == "" {
+		return nil, errors.New("domain and selector are required")
+	}
+	if len(s.headers) == 0 {
+		s.headers = DKIMDefaultHeaders
+	}
+
+	// The From header has to be signed.
+	hasFrom := false
+	for _, h := range s.headers {
+		if strings.EqualFold(strings.TrimSpace(h), "From") {
+			hasFrom = true
+			break
+		}
+	}
+	if !hasFrom {
+		return nil, errors.New("signed headers should include From")
+	}
+
+	hc, bc, err := parseDKIMCanonicalization(d.Canonicalization)
+	if err != nil {
+		return nil, err
+	}
+	s.hdrCanon, s.bodyCanon = hc, bc
+
+	key, err := parseDKIMKey(d.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+	s.key = key
+
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		s.algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		s.algo = "ed25519-sha256"
+	}
+
+	return s, nil
+}
+
+// ValidateDKIM validates a DKIM key's settings.
+func ValidateDKIM(d DKIM) error {
+	_, err := newDKIMSigner(d)
+	return err
+}
+
+// parseDKIMCanonicalization parses a DKIM canonicalization (c=) value into its header
+// and body algorithms. An empty value is relaxed/relaxed and a single value
+// applies to the header with the body being simple as per RFC 6376.
+func parseDKIMCanonicalization(c string) (string, string, error) {
+	c = strings.ToLower(strings.TrimSpace(c))
+	if c == "" {
+		return DKIMCanonRelaxed, DKIMCanonRelaxed, nil
+	}
+
+	hc, bc, ok := strings.Cut(c, "/")
+	if !ok {
+		bc = DKIMCanonSimple
+	}
+	for _, v := range []string{hc, bc} {
+		if v != DKIMCanonSimple && v != DKIMCanonRelaxed {
+			return "", "", fmt.Errorf("unknown canonicalization '%s'", c)
+		}
+	}
+
+	return hc, bc, nil
+}
+
+// parseDKIMKey parses a PEM encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS #8) private key.
+func parseDKIMKey(s string) (crypto.Signer, error) {
+	b, _ := pem.Decode([]byte(strings.TrimSpace(s)))
+	if b == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var (
+		k   any
+		err error
+	)
+	if b.Type == "RSA PRIVATE KEY" {
+		k, err = x509.ParsePKCS1PrivateKey(b.Bytes)
+	} else {
+		k, err = x509.ParsePKCS8PrivateKey(b.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := k.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 1024 {
+			return nil, errors.New("RSA keys should be at least 1024 bits")
+		}
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+
+	return nil, errors.New("only RSA and Ed25519 keys are supported")
+}
+
+// sign signs a raw message and returns it with the DKIM-Signature header prepended.
+func (s *dkimSigner) sign(msg []byte) ([]byte, error) {
+	// The signature is over the message as it goes on the wire with CRLF line endings.
+	msg = toCRLF(msg)
+
+	hdr, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, errors.New("invalid message: no header and body separator")
+	}
+	hdr = append(hdr, "\r\n"...)
+
+	// Body hash.
+	bh := sha256.Sum256(canonBody(body, s.bodyCanon))
+
+	// Pick the headers to sign from the bottom up as per RFC 6376 5.4.2.
+	var (
+		fields = splitHeader(hdr)
+		used   = make([]bool, len(fields))
+		names  = make([]string, 0, len(s.headers))
+		signed = make([]string, 0, len(s.headers))
+	)
+	for _, h := range s.headers {
+		h = strings.TrimSpace(h)
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fieldName(fields[i]), h) {
+				continue
+			}
+			used[i] = true
+			names = append(names, strings.ToLower(h))
+			signed = append(signed, fields[i])
+			break
+		}
+	}
+
+	// DKIM-Signature header with an empty b= that's signed along with the headers.
+	var sig strings.Builder
+	sig.WriteString("DKIM-Signature: v=1; a=" + s.algo + "; c=" + s.hdrCanon + "/" + s.bodyCanon + ";\r\n")
+	sig.WriteString(" d=" + s.domain + "; s=" + s.selector + "; t=" + strconv.FormatInt(time.Now().Unix(), 10) + ";\r\n")
+	sig.WriteString(fold(" h="+strings.Join(names, ":")+";", ":") + "\r\n")
+	sig.WriteString(" bh=" + base64.StdEncoding.EncodeToString(bh[:]) + ";\r\n")
+	sig.WriteString(" b=")
+
+	h := sha256.New()
+	for _, f := range signed {
+		h.Write([]byte(canonHeader(f, s.hdrCanon)))
+	}
+	c := canonHeader(sig.String(), s.hdrCanon)
+	h.Write([]byte(strings.TrimSuffix(c, "\r\n")))
+	sum := h.Sum(nil)
+
+	var (
+		b   []byte
+		err error
+	)
+	switch key := s.key.(type) {
+	case ed25519.PrivateKey:
+		// Ed25519 signs the hash and not the data itself as per RFC 8463.
+		b = ed25519.Sign(key, sum)
+	default:
+		b, err = s.key.Sign(rand.Reader, sum, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The value is folded along with the " b=" that's already been written.
+	sig.WriteString(strings.TrimPrefix(fold(" b="+base64.StdEncoding.EncodeToString(b), ""), " b="))
+	sig.WriteString("\r\n")
+
+	out := make([]byte, 0, sig.Len()+len(msg))
+	out = append(out, sig.String()...)
+	return append(out, msg...), nil
+}
+
+// splitHeader splits a raw header block into fields including their
+// folded continuation lines and trailing CRLF.
+func splitHeader(hdr []byte) []string {
+	var out []string
+	for _, ln := range strings.SplitAfter(string(hdr), "\r\n") {
+		if ln == "" {
+			continue
+		}
+		if (ln[0] == ' ' || ln[0] == '\t') && len(out) > 0 {
+			out[len(out)-1] += ln
+			continue
+		}
+		out = append(out, ln)
+	}
+
+	return out
+}
+
+// fieldName returns the name of a raw header field.
+func fieldName(f string) string {
+	name, _, _ := strings.Cut(f, ":")
+	return strings.TrimSpace(name)
+}
+
+// canonHeader canonicalizes a raw header field as per RFC 6376 3.4.
+func canonHeader(f, canon string) string {
+	if canon == DKIMCanonSimple {
+		return f
+	}
+
+	name, val, _ := strings.Cut(f, ":")
+	val = strings.ReplaceAll(val, "\r\n", "")
+	val = strings.Join(strings.FieldsFunc(val, isWSP), " ")
+
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + val + "\r\n"
+}
+
+// canonBody canonicalizes a message body as per RFC 6376 3.4.
+func canonBody(body []byte, canon string) []byte {
+	if canon == DKIMCanonRelaxed {
+		out := make([]byte, 0, len(body))
+		for _, ln := range bytes.SplitAfter(body, []byte("\r\n")) {
+			crlf := bytes.HasSuffix(ln, []byte("\r\n"))
+
+			// Reduce whitespace sequences to a single space and drop them at the end of lines.
+			ln = bytes.TrimRight(bytes.TrimSuffix(ln, []byte("\r\n")), " \t")
+			for i, c := range ln {
+				if isWSP(rune(c)) {
+					if i > 0 && isWSP(rune(ln[i-1])) {
+						continue
+					}
+					c = ' '
+				}
+				out = append(out, c)
+			}
+			if crlf {
+				out = append(out, "\r\n"...)
+			}
+		}
+		body = out
+	}
+
+	// Remove the empty lines at the end of the body.
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+
+	if len(body) == 0 {
+		// An empty body is a single CRLF in simple canonicalization.
+		if canon == DKIMCanonSimple {
+			return []byte("\r\n")
+		}
+		return body
+	}
+	if !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(body, "\r\n"...)
+	}
+
+	return body
+}
+
+// toCRLF converts bare LF line endings to CRLF.
+func toCRLF(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) || bytes.Count(b, []byte("\n")) == bytes.Count(b, []byte("\r\n")) {
+		return b
+	}
+
+	out := make([]byte, 0, len(b)+len(b)/50)
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
+
+// fold folds a header value into lines of dkimLineLen, breaking
+// after sep, or anywhere if sep is empty.
+func fold(s, sep string) string {
+	var (
+		out strings.Builder
+		n   = 0
+	)
+	for len(s) > 0 {
+		if n >= dkimLineLen {
+			out.WriteString("\r\n ")
+			n = 1
+		}
+
+		i := len(s)
+		if i > dkimLineLen-n {
+			i = dkimLineLen - n
+			if sep != "" {
+				j := strings.LastIndex(s[:i], sep)
+				if j < 0 {
+					// No separator within the line. Break at the next one.
+					if j = strings.Index(s, sep); j < 0 {
+						j = len(s) - len(sep)
+					}
+				}
+				i = j + len(sep)
+			}
+		}
+
+		out.WriteString(s[:i])
+		n += i
+		if len(s) > i {
+			n = dkimLineLen
+		}
+		s = s[i:]
+	}
+
+	return out.String()
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
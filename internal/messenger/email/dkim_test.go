@@ -0,0 +1,338 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testDKIMMsg = "From: News <news@example.com>\r\n" +
+	"To: sub@example.org\r\n" +
+	"Subject:  Hello   there \r\n" +
+	"\tfolded\r\n" +
+	"Date: Tue, 02 Jan 2024 10:00:00 +0000\r\n" +
+	"Message-Id: <1@example.com>\r\n" +
+	"X-Unsigned: not signed\r\n" +
+	"\r\n" +
+	"Line one  with   spaces \r\n" +
+	"Line two\t\r\n" +
+	"\r\n" +
+	"\r\n"
+
+var (
+	reDKIMSigB = regexp.MustCompile(`(\sb=)[^;]*`)
+	reDKIMWSP  = regexp.MustCompile(`[ \t]+`)
+)
+
+// verifyDKIM verifies the DKIM-Signature at the top of a signed message with the
+// public key the way a receiving server does as per RFC 6376 and RFC 8463.
+func verifyDKIM(msg []byte, pub crypto.PublicKey) error {
+	hdr, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return errors.New("no header and body separator")
+	}
+
+	// Header fields with their continuation lines.
+	var fields []string
+	for _, ln := range strings.Split(string(hdr), "\r\n") {
+		if strings.HasPrefix(ln, " ") || strings.HasPrefix(ln, "\t") {
+			fields[len(fields)-1] += "\r\n" + ln
+			continue
+		}
+		fields = append(fields, ln)
+	}
+	for i := range fields {
+		fields[i] += "\r\n"
+	}
+
+	if !strings.HasPrefix(fields[0], "DKIM-Signature:") {
+		return errors.New("no DKIM-Signature header")
+	}
+	sigField := fields[0]
+	fields = fields[1:]
+
+	// Signature tags.
+	tags := map[string]string{}
+	_, val, _ := strings.Cut(sigField, ":")
+	for _, t := range strings.Split(val, ";") {
+		k, v, ok := strings.Cut(t, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(v), "")
+	}
+
+	hc, bc, _ := strings.Cut(tags["c"], "/")
+	if bc == "" {
+		bc = DKIMCanonSimple
+	}
+
+	// Body hash.
+	bh := sha256.Sum256(testCanonBody(string(body), bc))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	// Signed headers, picked from the bottom up, followed by the signature header without b=.
+	var (
+		h    = sha256.New()
+		used = make([]bool, len(fields))
+	)
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			n, _, _ := strings.Cut(fields[i], ":")
+			if used[i] || !strings.EqualFold(strings.TrimSpace(n), name) {
+				continue
+			}
+			used[i] = true
+			h.Write([]byte(testCanonHeader(fields[i], hc)))
+			break
+		}
+	}
+	h.Write([]byte(strings.TrimSuffix(testCanonHeader(reDKIMSigB.ReplaceAllString(sigField, "$1"), hc), "\r\n")))
+	sum := h.Sum(nil)
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		return rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, sum, sig)
+	case "ed25519-sha256":
+		if !ed25519.Verify(pub.(ed25519.PublicKey), sum, sig) {
+			return errors.New("ed25519 verification failed")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown algorithm %s", tags["a"])
+}
+
+func testCanonHeader(f, canon string) string {
+	if canon == DKIMCanonSimple {
+		return f
+	}
+
+	name, val, _ := strings.Cut(f, ":")
+	val = strings.ReplaceAll(val, "\r\n", "")
+	val = strings.TrimSpace(reDKIMWSP.ReplaceAllString(val, " "))
+
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + val + "\r\n"
+}
+
+func testCanonBody(body, canon string) []byte {
+	lines := strings.Split(body, "\r\n")
+	if canon == DKIMCanonRelaxed {
+		for i, ln := range lines {
+			lines[i] = strings.TrimRight(reDKIMWSP.ReplaceAllString(ln, " "), " ")
+		}
+	}
+
+	// Drop the trailing empty lines.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canon == DKIMCanonSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// testDKIMKeys returns PEM encoded RSA and Ed25519 keys with their public keys.
+func testDKIMKeys(t *testing.T) map[string]struct {
+	pem string
+	pub crypto.PublicKey
+} {
+	t.Helper()
+
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rk)})
+
+	epub, ek, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(ek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+
+	return map[string]struct {
+		pem string
+		pub crypto.PublicKey
+	}{
+		"rsa-sha256":     {string(rsaPEM), &rk.PublicKey},
+		"ed25519-sha256": {string(edPEM), epub},
+	}
+}
+
+func TestDKIMSignVerify(t *testing.T) {
+	keys := testDKIMKeys(t)
+
+	for algo, k := range keys {
+		for _, canon := range []string{"", "simple/simple", "relaxed/simple", "simple/relaxed"} {
+			t.Run(algo+" "+canon, func(t *testing.T) {
+				s, err := newDKIMSigner(DKIM{
+					Domain:           "Example.com",
+					Selector:         "news",
+					PrivateKey:       k.pem,
+					Canonicalization: canon,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if s.algo != algo {
+					t.Fatalf("expected algorithm %s, got %s", algo, s.algo)
+				}
+
+				out, err := s.sign([]byte(testDKIMMsg))
+				if err != nil {
+					t.Fatalf("error signing: %v", err)
+				}
+				if err := verifyDKIM(out, k.pub); err != nil {
+					t.Fatalf("error verifying: %v\n%s", err, out)
+				}
+
+				sig, _, _ := strings.Cut(string(out), "\r\nFrom:")
+				if !strings.Contains(sig, " d=example.com; s=news;") {
+					t.Errorf("expected the domain and selector in the signature, got %s", sig)
+				}
+				if strings.Contains(sig, "x-unsigned") {
+					t.Errorf("expected headers that aren't in the list to not be signed, got %s", sig)
+				}
+				for _, ln := range strings.Split(sig, "\r\n") {
+					if len(ln) > dkimLineLen+1 {
+						t.Errorf("expected the signature to be folded, got a line of %d chars: %q", len(ln), ln)
+					}
+				}
+
+				// Tampering with the body or a signed header fails verification.
+				if err := verifyDKIM(bytes.Replace(out, []byte("Line two"), []byte("Line 2"), 1), k.pub); err == nil {
+					t.Error("expected a tampered body to fail verification")
+				}
+				if err := verifyDKIM(bytes.Replace(out, []byte("Hello"), []byte("Hi"), 1), k.pub); err == nil {
+					t.Error("expected a tampered header to fail verification")
+				}
+
+				// Changes to unsigned headers don't.
+				if err := verifyDKIM(bytes.Replace(out, []byte("not signed"), []byte("changed"), 1), k.pub); err != nil {
+					t.Errorf("expected a change to an unsigned header to pass verification: %v", err)
+				}
+
+				// Relaxed canonicalization tolerates whitespace changes in transit.
+				spaced := bytes.Replace(out, []byte("Subject:  Hello   there"), []byte("Subject: Hello there"), 1)
+				if err := verifyDKIM(spaced, k.pub); (err == nil) != (s.hdrCanon == DKIMCanonRelaxed) {
+					t.Errorf("header whitespace change with %s canonicalization: got %v", s.hdrCanon, err)
+				}
+			})
+		}
+	}
+}
+
+func TestDKIMSignEmail(t *testing.T) {
+	keys := testDKIMKeys(t)
+
+	e, err := New("email", []DKIM{
+		{Domain: "example.com", Selector: "rsa", PrivateKey: keys["rsa-sha256"].pem},
+		{Domain: "mail.example.net", Selector: "ed", PrivateKey: keys["ed25519-sha256"].pem},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from string
+		algo string
+	}{
+		{"News <news@example.com>", "rsa-sha256"},
+		// Subdomains are signed with the parent domain's key.
+		{"news@lists.example.com", "rsa-sha256"},
+		{"news@mail.example.net", "ed25519-sha256"},
+		{"news@example.net", ""},
+		{"news@example.org", ""},
+	}
+
+	for _, tc := range tests {
+		s := e.getSigner(tc.from)
+		if tc.algo == "" {
+			if s != nil {
+				t.Errorf("%s: expected no signer, got %s", tc.from, s.domain)
+			}
+			continue
+		}
+		if s == nil {
+			t.Fatalf("%s: expected a signer", tc.from)
+		}
+
+		// Sign a rendered message, which has bare LF line endings in its body.
+		msg := testMessage(tc.from, "sub@example.org")
+		msg.Body = []byte("Hello\nthere\n")
+		em := makeEmail(nil, msg, nil)
+		b, err := em.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := s.sign(b)
+		if err != nil {
+			t.Fatalf("%s: error signing: %v", tc.from, err)
+		}
+		if err := verifyDKIM(out, keys[tc.algo].pub); err != nil {
+			t.Errorf("%s: error verifying: %v\n%s", tc.from, err, out)
+		}
+	}
+}
+
+func TestDKIMInvalid(t *testing.T) {
+	keys := testDKIMKeys(t)
+
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(ek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+
+	tests := []struct {
+		name string
+		d    DKIM
+	}{
+		{"no selector", DKIM{Domain: "example.com", PrivateKey: keys["rsa-sha256"].pem}},
+		{"no From", DKIM{Domain: "example.com", Selector: "s", PrivateKey: keys["rsa-sha256"].pem, Headers: []string{"Subject"}}},
+		{"unknown canonicalization", DKIM{Domain: "example.com", Selector: "s", PrivateKey: keys["rsa-sha256"].pem, Canonicalization: "relaxed/strict"}},
+		{"not PEM", DKIM{Domain: "example.com", Selector: "s", PrivateKey: "key"}},
+		{"ECDSA key", DKIM{Domain: "example.com", Selector: "s", PrivateKey: ecPEM}},
+	}
+
+	for _, tc := range tests {
+		if err := ValidateDKIM(tc.d); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
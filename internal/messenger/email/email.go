@@ -2,12 +2,14 @@ package email
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net/mail"
 	"net/smtp"
 	"net/textproto"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
@@ -25,7 +27,9 @@ const (
 
 // Server represents an SMTP server's credentials.
 type Server struct {
-	// Name is a unique identifier for the server.
+	// UUID identifies the server in the settings. Name is a unique identifier
+	// for the server.
+	UUID          string            `json:"uuid"`
 	Name          string            `json:"name"`
 	Username      string            `json:"username"`
 	Password      string            `json:"password"`
@@ -35,12 +39,22 @@ type Server struct {
 	EmailHeaders  map[string]string `json:"email_headers"`
 	FromAddresses []string          `json:"from_addresses"`
 
+	// Weight is the share of messages routed to the server relative to the
+	// other servers. WarmupSchedule is the optional daily limit on each day
+	// from WarmupStart (YYYY-MM-DD) to ramp up the volume of a new server.
+	Weight         int    `json:"weight"`
+	WarmupSchedule []int  `json:"warmup_schedule"`
+	WarmupStart    string `json:"warmup_start"`
+
 	// Rest of the options are embedded directly from the smtppool lib.
 	// The JSON tag is for config unmarshal to work.
 	//lint:ignore SA5008 ,squash is needed by koanf/mapstructure config unmarshal.
 	smtppool.Opt `json:",squash"`
 
-	pool *smtppool.Pool
+	pool   *smtppool.Pool
+	raw    *rawPool
+	health *health
+	volume *volume
 }
 
 // Emailer is the SMTP e-mail messenger.
@@ -51,8 +65,20 @@ type Emailer struct {
 	// or a domain set per SMTPs server). An empty key holds all servers
 	// and is the fallback round-robin when there's no match (old behaviour).
 	pools map[string][]*Server
+
+	// dkim holds the DKIM signers indexed by domain.
+	dkim map[string]*dkimSigner
+
+	// stop signals the health probes of servers that are down to exit.
+	stop     chan bool
+	stopOnce sync.Once
 }
 
+var (
+	errNoServers  = errors.New("no healthy SMTP servers available")
+	errDailyLimit = errors.New("daily sending limit of SMTP servers reached")
+)
+
 // NormalizeAddr normalizes an e-mail address (strip spaces, lowercase).
 func NormalizeAddr(s string) string {
 	return strings.ToLower(strings.TrimSpace(s))
@@ -60,11 +86,23 @@ func NormalizeAddr(s string) string {
 
 // New returns an SMTP e-mail Messenger backend with the given SMTP servers.
 // Group indicates whether the messenger represents a group of SMTP servers (1 or more)
-// that are used as a round-robin pool, or a single server.
-func New(name string, servers ...Server) (*Emailer, error) {
+// that are used as a round-robin pool, or a single server. Messages from the
+// domains that have DKIM keys are signed. The daily volumes of servers with warm-up
+// schedules are kept in the VolumeStore, if it's not nil.
+func New(name string, dkim []DKIM, vs VolumeStore, servers ...Server) (*Emailer, error) {
 	e := &Emailer{
 		name:  name,
 		pools: make(map[string][]*Server),
+		dkim:  make(map[string]*dkimSigner, len(dkim)),
+		stop:  make(chan bool),
+	}
+
+	for _, d := range dkim {
+		s, err := newDKIMSigner(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DKIM key for '%s': %v", d.Domain, err)
+		}
+		e.dkim[s.domain] = s
 	}
 
 	for _, srv := range servers {
@@ -103,12 +141,26 @@ func New(name string, servers ...Server) (*Emailer, error) {
 			}
 		}
 
+		key := s.UUID
+		if key == "" {
+			key = fmt.Sprintf("%s@%s:%d", s.Username, s.Host, s.Port)
+		}
+		vol, err := newVolume(s.WarmupSchedule, s.WarmupStart, vs, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warm-up start date '%s'", s.WarmupStart)
+		}
+		s.volume = vol
+
 		pool, err := smtppool.New(s.Opt)
 		if err != nil {
 			return nil, err
 		}
 
 		s.pool = pool
+		if len(e.dkim) > 0 {
+			s.raw = newRawPool(&s)
+		}
+		s.health = &health{Health: Health{Name: s.Name, Host: s.Host, Port: s.Port, Status: HealthUp}}
 
 		// Add to the global list (empty key) and to each from-address
 		// bucket. Duplicate keys across servers are fine and get round-robin'd.
@@ -128,35 +180,124 @@ func (e *Emailer) Name() string {
 	return e.name
 }
 
-// Push pushes a message to the server.
+// Push pushes a message to the server. If a server fails, the message is
+// retried on the other healthy servers.
 func (e *Emailer) Push(m models.Message) error {
-	// Pick the from-address-routed pool if there is one, else default
-	// to the full pool (empty key) for roundrobin.
-	pool := e.pools[""]
-	if len(e.pools) > 1 {
-		if srvs := e.getPool(m.From); srvs != nil {
-			pool = srvs
-		}
+	srvs := e.getServers(m.From)
+	if len(srvs) == 0 {
+		return errNoServers
 	}
-	srv := pool[rand.Intn(len(pool))]
-
-	// Are there attachments?
-	var files []smtppool.Attachment
-	if m.Attachments != nil {
-		files = make([]smtppool.Attachment, 0, len(m.Attachments))
-		for _, f := range m.Attachments {
-			a := smtppool.Attachment{
-				Filename:    f.Name,
-				Header:      f.Header,
-				Content:     make([]byte, len(f.Content)),
-				HTMLRelated: f.IsInline,
+
+	var (
+		files  = makeAttachments(m)
+		err    error
+		capped bool
+	)
+	for _, srv := range srvs {
+		// The server has reached its daily limit.
+		if !srv.volume.take() {
+			capped = true
+			continue
+		}
+
+		em := makeEmail(srv.EmailHeaders, m, files)
+		if sg := e.getSigner(m.From); sg != nil {
+			// Sign the rendered message and send it as-is.
+			msg, sErr := em.Bytes()
+			if sErr == nil {
+				msg, sErr = sg.sign(msg)
+			}
+			if sErr != nil {
+				srv.volume.release()
+				return sErr
 			}
-			copy(a.Content, f.Content)
-			files = append(files, a)
+
+			err = srv.raw.send(em, msg)
+		} else {
+			err = srv.pool.Send(em)
+		}
+		if err == nil {
+			srv.health.ok()
+			return nil
+		}
+		srv.volume.release()
+
+		// Take the server out of rotation if it's failing and probe it until it's back.
+		if srv.health.fail(err) {
+			go srv.probe(e.stop)
+		}
+
+		// Errors with the message or its recipients aren't retried on other servers.
+		if !isServerErr(err) {
+			return err
+		}
+	}
+
+	// All the servers that could've sent the message have reached their daily
+	// limits. The message should be sent the next day.
+	if err == nil && capped {
+		return &models.DeferError{
+			RetryAfter: untilTomorrow(time.Now()),
+			Err:        errDailyLimit,
 		}
 	}
 
-	// Create the email.
+	return err
+}
+
+// Health returns the health of the SMTP servers.
+func (e *Emailer) Health() []Health {
+	out := make([]Health, 0, len(e.pools[""]))
+	for _, s := range e.pools[""] {
+		h := s.health.get()
+		h.SentToday, h.DailyLimit = s.volume.get()
+		out = append(out, h)
+	}
+
+	return out
+}
+
+// Render renders a message into a raw MIME e-mail with the given additional
+// headers and returns it along with its envelope sender and recipients.
+func Render(m models.Message, headers map[string]string) (string, []string, []byte, error) {
+	em := makeEmail(headers, m, makeAttachments(m))
+
+	from, rcpts, err := envelope(em)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	b, err := em.Bytes()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return from, rcpts, b, nil
+}
+
+// makeAttachments returns copies of a message's attachments.
+func makeAttachments(m models.Message) []smtppool.Attachment {
+	if m.Attachments == nil {
+		return nil
+	}
+
+	files := make([]smtppool.Attachment, 0, len(m.Attachments))
+	for _, f := range m.Attachments {
+		a := smtppool.Attachment{
+			Filename:    f.Name,
+			Header:      f.Header,
+			Content:     make([]byte, len(f.Content)),
+			HTMLRelated: f.IsInline,
+		}
+		copy(a.Content, f.Content)
+		files = append(files, a)
+	}
+
+	return files
+}
+
+// makeEmail creates the e-mail for a message with the given server level headers.
+func makeEmail(headers map[string]string, m models.Message, files []smtppool.Attachment) smtppool.Email {
 	em := smtppool.Email{
 		From:        m.From,
 		To:          m.To,
@@ -167,7 +308,7 @@ func (e *Emailer) Push(m models.Message) error {
 	em.Headers = textproto.MIMEHeader{}
 
 	// Attach SMTP level headers.
-	for k, v := range srv.EmailHeaders {
+	for k, v := range headers {
 		em.Headers.Set(k, v)
 	}
 
@@ -220,7 +361,7 @@ func (e *Emailer) Push(m models.Message) error {
 		}
 	}
 
-	return srv.pool.Send(em)
+	return em
 }
 
 // Flush flushes the message queue to the server.
@@ -230,12 +371,50 @@ func (e *Emailer) Flush() error {
 
 // Close closes the SMTP pools.
 func (e *Emailer) Close() error {
+	e.stopOnce.Do(func() { close(e.stop) })
 	for _, s := range e.pools[""] {
 		s.pool.Close()
+		if s.raw != nil {
+			s.raw.close()
+		}
 	}
 	return nil
 }
 
+// getServers returns the healthy servers to try for a message in order. The
+// servers in the pool routed by the From address (or all servers if there's no
+// route) are in weighted random order, followed by the other healthy servers
+// as failover.
+func (e *Emailer) getServers(from string) []*Server {
+	// Pick the from-address-routed pool if there is one, else default
+	// to the full pool (empty key) for roundrobin.
+	pool := e.pools[""]
+	if len(e.pools) > 1 {
+		if srvs := e.getPool(from); srvs != nil {
+			pool = srvs
+		}
+	}
+
+	var (
+		out  = make([]*Server, 0, len(e.pools[""]))
+		seen = make(map[*Server]bool, len(e.pools[""]))
+	)
+	for _, p := range [][]*Server{pool, e.pools[""]} {
+		for _, s := range weightedOrder(p) {
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+
+			if s.health.isUp() {
+				out = append(out, s)
+			}
+		}
+	}
+
+	return out
+}
+
 // getPool returns the pool of servers configured to handle the given From
 // header, matched by full e-mail and then by domain.
 // Returns nil if no mapping matches.
@@ -255,3 +434,29 @@ func (e *Emailer) getPool(from string) []*Server {
 
 	return nil
 }
+
+// getSigner returns the DKIM signer for the domain of the From address or its
+// closest parent domain. Returns nil if there's none.
+func (e *Emailer) getSigner(from string) *dkimSigner {
+	if len(e.dkim) == 0 {
+		return nil
+	}
+
+	addr := utils.ParseEmailAddress(from)
+	_, domain, ok := strings.Cut(addr, "@")
+	if !ok {
+		return nil
+	}
+
+	for {
+		if s, ok := e.dkim[domain]; ok {
+			return s
+		}
+
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok || !strings.Contains(parent, ".") {
+			return nil
+		}
+		domain = parent
+	}
+}
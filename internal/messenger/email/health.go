@@ -0,0 +1,259 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knadh/smtppool/v2"
+)
+
+// Health states of SMTP servers.
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+const (
+	// Number of consecutive server failures after which a server is
+	// taken out of rotation.
+	healthFailThreshold = 3
+
+	// Maximum interval at which a server that's down is probed.
+	healthProbeMaxInterval = time.Minute * 5
+
+	// Timeout for probe connections if the server has no wait timeout.
+	healthProbeTimeout = time.Second * 10
+)
+
+// Interval at which a server that's down is probed. It doubles after
+// every failed probe up to healthProbeMaxInterval.
+var healthProbeInterval = time.Second * 15
+
+// Health represents the health of an SMTP server.
+type Health struct {
+	Name        string     `json:"name"`
+	Host        string     `json:"host"`
+	Port        int        `json:"port"`
+	Status      string     `json:"status"`
+	Failures    int        `json:"failures"`
+	Sent        int64      `json:"sent"`
+	Errors      int64      `json:"errors"`
+	LastError   string     `json:"last_error"`
+	LastErrorAt *time.Time `json:"last_error_at"`
+	DownSince   *time.Time `json:"down_since"`
+	NextProbeAt *time.Time `json:"next_probe_at"`
+
+	// Messages sent today and the day's limit as per the warm-up schedule.
+	SentToday  int `json:"sent_today"`
+	DailyLimit int `json:"daily_limit"`
+}
+
+// health tracks the health of a server and works as a circuit breaker. After
+// healthFailThreshold consecutive server failures, the server is marked down
+// and is probed in the background until it's reachable again.
+type health struct {
+	sync.Mutex
+	Health
+
+	probing bool
+}
+
+// ok records a successful send.
+func (h *health) ok() {
+	h.Lock()
+	h.Sent++
+	h.Failures = 0
+	h.Unlock()
+}
+
+// fail records a failed send and returns true if the server was marked down.
+func (h *health) fail(err error) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	h.Errors++
+	h.LastError = err.Error()
+	h.LastErrorAt = &now
+
+	if !isServerErr(err) {
+		return false
+	}
+
+	h.Failures++
+	if h.Status == HealthDown || h.Failures < healthFailThreshold {
+		return false
+	}
+
+	h.Status = HealthDown
+	h.DownSince = &now
+	return true
+}
+
+// isUp returns true if the server is in rotation.
+func (h *health) isUp() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.Status == HealthUp
+}
+
+// get returns a copy of the health.
+func (h *health) get() Health {
+	h.Lock()
+	defer h.Unlock()
+	return h.Health
+}
+
+// isServerErr returns true if an error is because of the server (connection,
+// TLS, auth, service unavailable) rather than the message or its recipients.
+func isServerErr(err error) bool {
+	var tErr *textproto.Error
+	if !errors.As(err, &tErr) {
+		return true
+	}
+
+	switch tErr.Code {
+	case 421, 454, 530, 534, 535:
+		return true
+	}
+
+	return false
+}
+
+// probe runs in the background while a server is down and periodically
+// checks if it accepts connections, and puts it back in rotation when it does.
+func (s *Server) probe(stop chan bool) {
+	s.health.Lock()
+	if s.health.probing {
+		s.health.Unlock()
+		return
+	}
+	s.health.probing = true
+	s.health.Unlock()
+
+	wait := healthProbeInterval
+	for {
+		next := time.Now().Add(wait)
+		s.health.Lock()
+		s.health.NextProbeAt = &next
+		s.health.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		err := s.dial()
+
+		s.health.Lock()
+		if err == nil {
+			s.health.Status = HealthUp
+			s.health.Failures = 0
+			s.health.DownSince = nil
+			s.health.NextProbeAt = nil
+			s.health.probing = false
+			s.health.Unlock()
+			return
+		}
+
+		now := time.Now()
+		s.health.LastError = err.Error()
+		s.health.LastErrorAt = &now
+		s.health.Unlock()
+
+		wait *= 2
+		if wait > healthProbeMaxInterval {
+			wait = healthProbeMaxInterval
+		}
+	}
+}
+
+// dial connects and authenticates to the SMTP server the same way
+// the pool does and closes the connection.
+func (s *Server) dial() error {
+	c, _, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Noop(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// connect opens a new connection to the SMTP server and authenticates the
+// same way the pool does. The underlying connection is returned with a
+// deadline set to the wait timeout, which the caller can extend or clear.
+func (s *Server) connect() (*smtp.Client, net.Conn, error) {
+	var (
+		netCon  net.Conn
+		err     error
+		addr    = net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+		timeout = s.PoolWaitTimeout
+	)
+	if timeout <= 0 {
+		timeout = healthProbeTimeout
+	}
+
+	if s.SSL == smtppool.SSLTLS {
+		netCon, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, s.TLSConfig)
+	} else {
+		netCon, err = net.DialTimeout("tcp", addr, timeout)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	netCon.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(netCon, s.Host)
+	if err != nil {
+		netCon.Close()
+		return nil, nil, err
+	}
+
+	if err := s.handshake(c); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+
+	return c, netCon, nil
+}
+
+// handshake sends HELO, upgrades to STARTTLS, and authenticates as configured.
+func (s *Server) handshake(c *smtp.Client) error {
+	if s.HelloHostname != "" {
+		if err := c.Hello(s.HelloHostname); err != nil {
+			return err
+		}
+	}
+
+	if s.SSL == smtppool.SSLSTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP STARTTLS extension not found")
+		}
+		if err := c.StartTLS(s.TLSConfig); err != nil {
+			return err
+		}
+	}
+
+	if s.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("SMTP AUTH extension not found")
+		}
+		if err := c.Auth(s.Auth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package email
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/smtppool/v2"
+)
+
+func TestMain(m *testing.M) {
+	// Probe servers that are down quickly.
+	healthProbeInterval = 50 * time.Millisecond
+
+	os.Exit(m.Run())
+}
+
+// smtpServer is a local stand-in for an SMTP server that accepts messages,
+// or turns connections away when it's down.
+type smtpServer struct {
+	ln net.Listener
+
+	down       atomic.Bool
+	conns      atomic.Int64
+	rejectRcpt string
+
+	mu   sync.Mutex
+	msgs [][]byte
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// server returns the configuration of a server that connects to the stand-in.
+func (s *smtpServer) server(name string, from ...string) Server {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return Server{
+		Name:          name,
+		AuthProtocol:  "none",
+		TLSType:       "none",
+		FromAddresses: from,
+		Opt: smtppool.Opt{
+			Host:            addr.IP.String(),
+			Port:            addr.Port,
+			MaxConns:        1,
+			IdleTimeout:     time.Second * 10,
+			PoolWaitTimeout: time.Second,
+		},
+	}
+}
+
+// received returns the messages the server has received.
+func (s *smtpServer) received() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.msgs
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.conns.Add(1)
+
+	tp := textproto.NewConn(conn)
+	if s.down.Load() {
+		tp.PrintfLine("421 4.3.2 Service not available")
+		return
+	}
+
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		l, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, _, _ := strings.Cut(strings.ToUpper(l), " ")
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+
+		case "RCPT":
+			if s.rejectRcpt != "" && strings.Contains(l, s.rejectRcpt) {
+				tp.PrintfLine("550 5.1.1 User unknown")
+				continue
+			}
+			tp.PrintfLine("250 OK")
+
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.msgs = append(s.msgs, b)
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK queued")
+
+		case "MAIL", "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func testMessage(from, to string) models.Message {
+	return models.Message{
+		From:        from,
+		To:          []string{to},
+		Subject:     "Hello",
+		ContentType: "plain",
+		Body:        []byte("Hello there"),
+	}
+}
+
+// serverHealth returns the health of the named server.
+func serverHealth(e *Emailer, name string) Health {
+	for _, h := range e.Health() {
+		if h.Name == name {
+			return h
+		}
+	}
+
+	return Health{}
+}
+
+func TestHealthFailover(t *testing.T) {
+	var (
+		primary = newSMTPServer(t)
+		backup  = newSMTPServer(t)
+	)
+	primary.down.Store(true)
+
+	// Messages from the address are routed to the primary first and fail over to the backup.
+	e, err := New("email", nil, nil, primary.server("primary", "news@example.com"), backup.server("backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// The primary fails and the messages are sent via the backup.
+	for i := range healthFailThreshold {
+		if err := e.Push(testMessage("news@example.com", "sub@example.org")); err != nil {
+			t.Fatalf("push %d: expected failover to the backup, got %v", i, err)
+		}
+	}
+	if n := len(backup.received()); n != healthFailThreshold {
+		t.Fatalf("expected %d messages on the backup, got %d", healthFailThreshold, n)
+	}
+
+	// The circuit is open. The primary is out of rotation and isn't tried.
+	h := serverHealth(e, "primary")
+	if h.Status != HealthDown || h.Failures != healthFailThreshold || h.DownSince == nil || h.LastError == "" {
+		t.Fatalf("expected the primary to be down after %d failures, got %+v", healthFailThreshold, h)
+	}
+	if h := serverHealth(e, "backup"); h.Status != HealthUp || h.Sent != int64(healthFailThreshold) {
+		t.Fatalf("expected the backup to be up with %d sent, got %+v", healthFailThreshold, h)
+	}
+
+	// Wait for a probe to fail so that the primary's connections are steady.
+	deadline := time.Now().Add(5 * time.Second)
+	for primary.conns.Load() <= int64(healthFailThreshold) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the primary to be probed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := e.Push(testMessage("news@example.com", "sub@example.org")); err != nil {
+		t.Fatalf("expected the message to be sent via the backup, got %v", err)
+	}
+	if n := len(backup.received()); n != healthFailThreshold+1 {
+		t.Fatalf("expected %d messages on the backup, got %d", healthFailThreshold+1, n)
+	}
+
+	// The primary recovers. The probe puts it back in rotation.
+	primary.down.Store(false)
+	deadline = time.Now().Add(5 * time.Second)
+	for serverHealth(e, "primary").Status != HealthUp {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the primary to recover")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if h := serverHealth(e, "primary"); h.Failures != 0 || h.DownSince != nil || h.NextProbeAt != nil {
+		t.Errorf("expected the primary's failures to be reset on recovery, got %+v", h)
+	}
+
+	if err := e.Push(testMessage("news@example.com", "sub@example.org")); err != nil {
+		t.Fatalf("expected the message to be sent via the primary, got %v", err)
+	}
+	if n := len(primary.received()); n != 1 {
+		t.Errorf("expected 1 message on the recovered primary, got %d", n)
+	}
+}
+
+func TestHealthAllDown(t *testing.T) {
+	s := newSMTPServer(t)
+	s.down.Store(true)
+
+	e, err := New("email", nil, nil, s.server("only"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	for range healthFailThreshold {
+		if err := e.Push(testMessage("news@example.com", "sub@example.org")); err == nil {
+			t.Fatal("expected an error sending via a server that's down")
+		}
+	}
+
+	// The only server is out of rotation.
+	if err := e.Push(testMessage("news@example.com", "sub@example.org")); !errors.Is(err, errNoServers) {
+		t.Errorf("expected %v, got %v", errNoServers, err)
+	}
+}
+
+func TestHealthRecipientError(t *testing.T) {
+	var (
+		primary = newSMTPServer(t)
+		backup  = newSMTPServer(t)
+	)
+	primary.rejectRcpt = "gone@example.org"
+
+	e, err := New("email", nil, nil, primary.server("primary", "news@example.com"), backup.server("backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// Recipient errors are returned right away. They don't count towards the
+	// server's failures and the message isn't retried on the other servers.
+	for range healthFailThreshold + 1 {
+		if err := e.Push(testMessage("news@example.com", "gone@example.org")); err == nil {
+			t.Fatal("expected a recipient error")
+		}
+	}
+
+	if h := serverHealth(e, "primary"); h.Status != HealthUp || h.Failures != 0 || h.Errors != int64(healthFailThreshold+1) {
+		t.Errorf("expected the primary to be up with no failures, got %+v", h)
+	}
+	if n := len(backup.received()); n != 0 {
+		t.Errorf("expected no messages on the backup, got %d", n)
+	}
+}
+
+func TestIsServerErr(t *testing.T) {
+	tests := []struct {
+		err error
+		exp bool
+	}{
+		{errors.New("dial tcp: connection refused"), true},
+		{&textproto.Error{Code: 421, Msg: "Service not available"}, true},
+		{&textproto.Error{Code: 535, Msg: "Authentication failed"}, true},
+		{&textproto.Error{Code: 550, Msg: "User unknown"}, false},
+		{&textproto.Error{Code: 552, Msg: "Message too large"}, false},
+	}
+
+	for _, tc := range tests {
+		if got := isServerErr(tc.err); got != tc.exp {
+			t.Errorf("%v: expected %v, got %v", tc.err, tc.exp, got)
+		}
+	}
+}
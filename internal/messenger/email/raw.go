@@ -0,0 +1,214 @@
+package email
+
+import (
+	"errors"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/knadh/smtppool/v2"
+)
+
+var errPoolTimeout = errors.New("timed out waiting for a free SMTP connection")
+
+// rawPool is a pool of SMTP connections to a server for sending messages that
+// are already rendered. smtppool renders messages on every send with new
+// MIME boundaries, so signed (DKIM) messages whose bytes can't change after
+// signing are sent via this pool.
+type rawPool struct {
+	srv *Server
+
+	// Idle connections.
+	conns chan *rawConn
+
+	// Slots for open connections, limited to the server's max connections.
+	slots chan struct{}
+}
+
+// rawConn is an SMTP connection in the raw pool.
+type rawConn struct {
+	c            *smtp.Client
+	lastActivity time.Time
+}
+
+func newRawPool(srv *Server) *rawPool {
+	n := srv.MaxConns
+	if n < 1 {
+		n = 1
+	}
+
+	return &rawPool{
+		srv:   srv,
+		conns: make(chan *rawConn, n),
+		slots: make(chan struct{}, n),
+	}
+}
+
+// send sends a rendered message, retrying on a new connection as per the
+// server's retry options if the connection fails.
+func (p *rawPool) send(em smtppool.Email, msg []byte) error {
+	from, rcpts, err := envelope(em)
+	if err != nil {
+		return err
+	}
+
+	tries := p.srv.MaxMessageRetries
+	if tries < 1 {
+		tries = 1
+	}
+
+	stale := false
+	for i := 0; i < tries; i++ {
+		if i > 0 && p.srv.MsgRetryDelay > 0 {
+			time.Sleep(p.srv.MsgRetryDelay)
+		}
+
+		var cn *rawConn
+		cn, err = p.borrow()
+		if err != nil {
+			continue
+		}
+
+		if err = cn.send(from, rcpts, msg); err == nil {
+			p.put(cn)
+			return nil
+		}
+		p.discard(cn)
+
+		// Errors with the message or its recipients (5xx) aren't retried.
+		var tErr *textproto.Error
+		if errors.As(err, &tErr) {
+			if tErr.Code >= 500 {
+				return err
+			}
+			continue
+		}
+
+		// An idle connection may have been closed by the server. Retry once
+		// on a new connection without counting it as an attempt.
+		if !cn.lastActivity.IsZero() && !stale {
+			stale = true
+			i--
+		}
+	}
+
+	return err
+}
+
+// borrow returns an idle connection or opens a new one if there's a free slot,
+// waiting up to the server's wait timeout for either.
+func (p *rawPool) borrow() (*rawConn, error) {
+	wait := p.srv.PoolWaitTimeout
+	if wait <= 0 {
+		wait = healthProbeTimeout
+	}
+	tm := time.NewTimer(wait)
+	defer tm.Stop()
+
+	for {
+		select {
+		case cn := <-p.conns:
+			// Close stale connections.
+			if p.srv.IdleTimeout > 0 && time.Since(cn.lastActivity) > p.srv.IdleTimeout {
+				p.discard(cn)
+				continue
+			}
+			return cn, nil
+
+		case p.slots <- struct{}{}:
+			c, netCon, err := p.srv.connect()
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			netCon.SetDeadline(time.Time{})
+			return &rawConn{c: c}, nil
+
+		case <-tm.C:
+			return nil, errPoolTimeout
+		}
+	}
+}
+
+// put returns a connection to the idle pool.
+func (p *rawPool) put(cn *rawConn) {
+	cn.lastActivity = time.Now()
+	select {
+	case p.conns <- cn:
+	default:
+		p.discard(cn)
+	}
+}
+
+// discard closes a connection and frees its slot.
+func (p *rawPool) discard(cn *rawConn) {
+	cn.c.Close()
+	<-p.slots
+}
+
+// close closes all idle connections.
+func (p *rawPool) close() {
+	for {
+		select {
+		case cn := <-p.conns:
+			cn.c.Quit()
+			p.discard(cn)
+		default:
+			return
+		}
+	}
+}
+
+// send writes a rendered message to the connection.
+func (cn *rawConn) send(from string, rcpts []string, msg []byte) error {
+	if err := cn.c.Mail(from); err != nil {
+		return err
+	}
+
+	for _, r := range rcpts {
+		if err := cn.c.Rcpt(r); err != nil {
+			return err
+		}
+	}
+
+	w, err := cn.c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// envelope returns the SMTP envelope sender and recipients of an e-mail
+// the same way smtppool picks them.
+func envelope(em smtppool.Email) (string, []string, error) {
+	sender := em.Sender
+	if sender == "" {
+		sender = em.From
+	}
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var rcpts []string
+	for _, l := range [][]string{em.To, em.Cc, em.Bcc} {
+		for _, a := range l {
+			addr, err := mail.ParseAddress(a)
+			if err != nil {
+				return "", nil, err
+			}
+			rcpts = append(rcpts, addr.Address)
+		}
+	}
+	if len(rcpts) == 0 {
+		return "", nil, errors.New("no recipients")
+	}
+
+	return from.Address, rcpts, nil
+}
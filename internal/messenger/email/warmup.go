@@ -0,0 +1,214 @@
+package email
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Date format of the warm-up start date.
+	warmupDateFormat = "2006-01-02"
+
+	// Number of messages reserved at a time from a server's daily volume in
+	// the VolumeStore. Messages reserved but not sent by the end of the day
+	// (or before a restart) are lost, which errs on the side of sending less.
+	volumeReserveSize = 20
+)
+
+// VolumeStore keeps track of the number of messages sent by SMTP servers every
+// day so that warm-up limits hold across restarts and multiple instances.
+type VolumeStore interface {
+	// ReserveSMTPVolume reserves up to n messages from a server's volume on a day
+	// (YYYY-MM-DD) capped at limit and returns the number of messages reserved.
+	ReserveSMTPVolume(server, day string, n, limit int) (int, error)
+
+	// GetSMTPVolume returns the number of messages reserved from a server's volume on a day.
+	GetSMTPVolume(server, day string) (int, error)
+}
+
+// volume keeps track of the number of messages sent by a server in a day
+// and caps it as per the server's warm-up schedule.
+type volume struct {
+	sync.Mutex
+
+	// schedule is the daily limit on each day from the start date. Once
+	// the schedule is over, there's no limit.
+	schedule []int
+	start    time.Time
+
+	// The day's count is kept in the store under key. Without a store,
+	// it's only counted in memory.
+	store VolumeStore
+	key   string
+
+	day  string
+	sent int
+
+	// Number of messages reserved from the store that are yet to be sent.
+	avail int
+}
+
+func newVolume(schedule []int, start string, store VolumeStore, key string) (*volume, error) {
+	v := &volume{schedule: schedule, store: store, key: key}
+	if len(schedule) == 0 {
+		return v, nil
+	}
+
+	if start == "" {
+		v.start = today(time.Now())
+		return v, nil
+	}
+
+	t, err := time.ParseInLocation(warmupDateFormat, start, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	v.start = t
+
+	return v, nil
+}
+
+// limit returns the number of messages that can be sent on the given day.
+// 0 means there's no limit.
+func (v *volume) limit(now time.Time) int {
+	if len(v.schedule) == 0 {
+		return 0
+	}
+
+	// Before the warm-up starts, the first day's limit applies.
+	n := int(today(now).Sub(v.start).Hours() / 24)
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(v.schedule) {
+		return 0
+	}
+
+	return v.schedule[n]
+}
+
+// take reserves a message from the day's volume. It returns false if the
+// day's limit has been reached.
+func (v *volume) take() bool {
+	v.Lock()
+	defer v.Unlock()
+
+	now := time.Now()
+	if d := now.Format(warmupDateFormat); d != v.day {
+		v.day = d
+		v.sent = 0
+		v.avail = 0
+	}
+
+	l := v.limit(now)
+	if l == 0 {
+		v.sent++
+		return true
+	}
+
+	if v.store != nil && v.avail == 0 {
+		// If the store is unavailable, fall back to the in-memory count.
+		n, err := v.store.ReserveSMTPVolume(v.key, v.day, volumeReserveSize, l)
+		if err != nil {
+			if v.sent >= l {
+				return false
+			}
+			v.sent++
+			return true
+		}
+		v.avail = n
+	}
+
+	if v.store != nil {
+		if v.avail == 0 {
+			return false
+		}
+		v.avail--
+	} else if v.sent >= l {
+		return false
+	}
+	v.sent++
+
+	return true
+}
+
+// release returns a message that wasn't sent to the day's volume.
+func (v *volume) release() {
+	v.Lock()
+	if v.sent > 0 {
+		v.sent--
+		if v.store != nil && v.limit(time.Now()) > 0 {
+			v.avail++
+		}
+	}
+	v.Unlock()
+}
+
+// get returns the number of messages sent today and the day's limit.
+func (v *volume) get() (int, int) {
+	v.Lock()
+	defer v.Unlock()
+
+	now := time.Now()
+	l := v.limit(now)
+
+	// The day's count across instances is in the store. Messages reserved by
+	// this instance that are yet to be sent aren't counted.
+	if v.store != nil && l > 0 {
+		if n, err := v.store.GetSMTPVolume(v.key, now.Format(warmupDateFormat)); err == nil {
+			if now.Format(warmupDateFormat) == v.day {
+				n -= v.avail
+			}
+			return max(n, 0), l
+		}
+	}
+
+	if now.Format(warmupDateFormat) != v.day {
+		return 0, l
+	}
+
+	return v.sent, l
+}
+
+// weightedOrder returns the servers in a random order where servers with a
+// higher weight are more likely to come first (weighted random sampling
+// without replacement). Servers without a weight have a weight of 1.
+func weightedOrder(srvs []*Server) []*Server {
+	type item struct {
+		srv *Server
+		key float64
+	}
+
+	items := make([]item, len(srvs))
+	for i, s := range srvs {
+		w := s.Weight
+		if w < 1 {
+			w = 1
+		}
+		items[i] = item{srv: s, key: math.Pow(rand.Float64(), 1/float64(w))}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key > items[j].key
+	})
+
+	out := make([]*Server, len(items))
+	for i, it := range items {
+		out[i] = it.srv
+	}
+
+	return out
+}
+
+// today returns the start of the day of the given time.
+func today(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// untilTomorrow returns the duration until the start of the next day.
+func untilTomorrow(t time.Time) time.Duration {
+	return today(t).AddDate(0, 0, 1).Sub(t)
+}
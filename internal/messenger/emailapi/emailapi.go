@@ -0,0 +1,371 @@
+// Package emailapi is a messenger that sends e-mails over HTTP e-mail APIs
+// instead of SMTP, eg: Amazon SES or a generic JSON API like Postmark.
+package emailapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Supported providers.
+const (
+	ProviderSES  = "ses"
+	ProviderJSON = "json"
+)
+
+const (
+	// Default max number of messages sent in a single API call.
+	defaultBatchSize = 1
+
+	// Default duration to wait for a batch to fill up.
+	defaultBatchWait = time.Millisecond * 100
+
+	// Default and max backoff between retries.
+	defaultRetryDelay = time.Millisecond * 500
+	maxRetryDelay     = time.Second * 30
+)
+
+// Options represents an e-mail API messenger's options.
+type Options struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+
+	// RootURL is the API endpoint. For SES, it defaults to the
+	// region's endpoint.
+	RootURL string `json:"root_url"`
+
+	// Username and Password are the AWS access key ID and secret for SES.
+	// For JSON APIs, Password is sent in AuthHeader if it's set, or as
+	// BasicAuth along with Username.
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	AuthHeader string `json:"auth_header"`
+
+	// Region and ConfigSet are the SES region and the optional configuration set.
+	Region    string `json:"region"`
+	ConfigSet string `json:"config_set"`
+
+	// Template is the Go template that renders the JSON request body
+	// for a batch of messages in JSON APIs.
+	Template string `json:"template"`
+
+	// EmailHeaders are headers added to all e-mails.
+	EmailHeaders map[string]string `json:"email_headers"`
+
+	// BatchSize is the max number of messages sent in an API call. A batch
+	// is sent when it's full or after BatchWait, whichever is first.
+	BatchSize int           `json:"batch_size"`
+	BatchWait time.Duration `json:"batch_wait"`
+
+	// Rate is the max number of API calls per second (0 for no limit).
+	Rate int `json:"rate"`
+
+	MaxConns   int           `json:"max_conns"`
+	Retries    int           `json:"max_msg_retries"`
+	RetryDelay time.Duration `json:"retry_delay"`
+	Timeout    time.Duration `json:"timeout"`
+}
+
+// provider prepares the HTTP request for sending a batch of messages via an API.
+type provider interface {
+	// maxBatchSize returns the max number of messages that can be sent in an
+	// API call. 0 means there's no limit.
+	maxBatchSize() int
+
+	// request returns the HTTP request for a batch of messages. It's called
+	// on every try as requests may be signed with the time.
+	request(msgs []models.Message) (*http.Request, error)
+}
+
+// job is a message waiting in a batch to be sent.
+type job struct {
+	msg models.Message
+	err chan error
+}
+
+// API is the e-mail API messenger.
+type API struct {
+	o    Options
+	c    *http.Client
+	prov provider
+
+	queue chan job
+	limit *limiter
+
+	stop     chan bool
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// HTTPError is a non-2xx response from the API.
+type HTTPError struct {
+	Code int
+	Body string
+}
+
+var errClosed = errors.New("e-mail API messenger is closed")
+
+// New returns a new instance of the e-mail API messenger.
+func New(o Options) (*API, error) {
+	if o.MaxConns < 1 {
+		o.MaxConns = 1
+	}
+	if o.Retries < 1 {
+		o.Retries = 1
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = defaultRetryDelay
+	}
+	if o.BatchSize < 1 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.BatchWait <= 0 {
+		o.BatchWait = defaultBatchWait
+	}
+
+	prov, err := newProvider(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := prov.maxBatchSize(); n > 0 && o.BatchSize > n {
+		o.BatchSize = n
+	}
+
+	a := &API{
+		o:    o,
+		prov: prov,
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+		queue: make(chan job, o.MaxConns*o.BatchSize),
+		limit: newLimiter(o.Rate),
+		stop:  make(chan bool),
+	}
+
+	// Each worker sends one batch (API call) at a time.
+	for range o.MaxConns {
+		a.wg.Add(1)
+		go a.worker()
+	}
+
+	return a, nil
+}
+
+// Validate validates the provider options.
+func Validate(o Options) error {
+	_, err := newProvider(o)
+	return err
+}
+
+func newProvider(o Options) (provider, error) {
+	switch o.Provider {
+	case ProviderSES:
+		return newSES(o)
+	case ProviderJSON:
+		return newJSON(o)
+	}
+
+	return nil, fmt.Errorf("unknown e-mail API provider '%s'", o.Provider)
+}
+
+// Name returns the messenger's name.
+func (a *API) Name() string {
+	return a.o.Name
+}
+
+// Push adds a message to a batch and waits until the batch is sent.
+func (a *API) Push(m models.Message) error {
+	j := job{msg: m, err: make(chan error, 1)}
+
+	select {
+	case a.queue <- j:
+	case <-a.stop:
+		return errClosed
+	}
+
+	select {
+	case err := <-j.err:
+		return err
+	case <-a.stop:
+		return errClosed
+	}
+}
+
+// Flush flushes the message queue to the server.
+func (a *API) Flush() error {
+	return nil
+}
+
+// Close stops the workers and closes idle HTTP connections.
+func (a *API) Close() error {
+	a.stopOnce.Do(func() { close(a.stop) })
+	a.wg.Wait()
+	a.c.CloseIdleConnections()
+
+	return nil
+}
+
+// worker picks up messages from the queue, batches them, and sends them.
+func (a *API) worker() {
+	defer a.wg.Done()
+
+	batch := make([]job, 0, a.o.BatchSize)
+	for {
+		// Wait for the first message of the batch.
+		select {
+		case j := <-a.queue:
+			batch = append(batch, j)
+		case <-a.stop:
+			return
+		}
+
+		// Wait for the batch to fill up.
+		tm := time.NewTimer(a.o.BatchWait)
+	loop:
+		for len(batch) < a.o.BatchSize {
+			select {
+			case j := <-a.queue:
+				batch = append(batch, j)
+			case <-tm.C:
+				break loop
+			}
+		}
+		tm.Stop()
+
+		err := a.send(batch)
+		for _, j := range batch {
+			j.err <- err
+		}
+		batch = batch[:0]
+	}
+}
+
+// send sends a batch of messages in an API call, retrying on network
+// errors, 429s, and 5xx errors with exponential backoff.
+func (a *API) send(batch []job) error {
+	msgs := make([]models.Message, len(batch))
+	for i, j := range batch {
+		msgs[i] = j.msg
+	}
+
+	var (
+		err  error
+		wait = a.o.RetryDelay
+	)
+	for i := 0; i < a.o.Retries; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+			if wait > maxRetryDelay {
+				wait = maxRetryDelay
+			}
+		}
+
+		var req *http.Request
+		req, err = a.prov.request(msgs)
+		if err != nil {
+			return err
+		}
+
+		a.limit.wait()
+
+		var retryAfter time.Duration
+		retryAfter, err = a.exec(req)
+		if err == nil {
+			return nil
+		}
+
+		var hErr *HTTPError
+		if errors.As(err, &hErr) && hErr.Code != http.StatusTooManyRequests && hErr.Code < 500 {
+			return err
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+	}
+
+	return err
+}
+
+// exec executes an HTTP request and returns the wait duration in the
+// Retry-After header of the response, if any.
+func (a *API) exec(req *http.Request) (time.Duration, error) {
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := a.c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// Drain and close the body to let the Transport reuse the connection
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	if r.StatusCode >= 200 && r.StatusCode < 300 {
+		return 0, nil
+	}
+
+	var retryAfter time.Duration
+	if n, err := strconv.Atoi(r.Header.Get("Retry-After")); err == nil && n > 0 {
+		retryAfter = time.Duration(n) * time.Second
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+	return retryAfter, &HTTPError{Code: r.StatusCode, Body: string(bytes.TrimSpace(b))}
+}
+
+// Error returns the error message.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("non-OK response from e-mail API: %d: %s", e.Code, e.Body)
+}
+
+// limiter limits the number of calls per second by spacing them out evenly.
+type limiter struct {
+	sync.Mutex
+
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(rate int) *limiter {
+	l := &limiter{}
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+	}
+
+	return l
+}
+
+// wait blocks until the next call is allowed.
+func (l *limiter) wait() {
+	if l.interval == 0 {
+		return
+	}
+
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	t := l.next
+	l.next = l.next.Add(l.interval)
+	l.Unlock()
+
+	time.Sleep(t.Sub(now))
+}
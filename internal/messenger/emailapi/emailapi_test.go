@@ -0,0 +1,279 @@
+package emailapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// apiResp is a scripted response from the test API.
+type apiResp struct {
+	code       int
+	body       string
+	retryAfter string
+}
+
+// apiReq is a request received by the test API.
+type apiReq struct {
+	method string
+	path   string
+	host   string
+	header http.Header
+	body   []byte
+}
+
+// apiServer is a local stand-in for an e-mail API that records requests and
+// replies with the scripted responses in order, and 200 once they run out.
+type apiServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	resps []apiResp
+	reqs  []apiReq
+}
+
+func newAPIServer(t *testing.T, resps ...apiResp) *apiServer {
+	t.Helper()
+
+	s := &apiServer{resps: resps}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.reqs = append(s.reqs, apiReq{method: r.Method, path: r.URL.Path, host: r.Host, header: r.Header.Clone(), body: b})
+		res := apiResp{code: http.StatusOK, body: `{"ok":true}`}
+		if len(s.resps) > 0 {
+			res, s.resps = s.resps[0], s.resps[1:]
+		}
+		s.mu.Unlock()
+
+		if res.retryAfter != "" {
+			w.Header().Set("Retry-After", res.retryAfter)
+		}
+		w.WriteHeader(res.code)
+		w.Write([]byte(res.body))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// requests returns the requests the server has received.
+func (s *apiServer) requests() []apiReq {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reqs
+}
+
+func testMessage(to string) models.Message {
+	return models.Message{
+		From:        "News <news@example.com>",
+		To:          []string{to},
+		Subject:     "Hello",
+		ContentType: "html",
+		Body:        []byte("<p>Hello there</p>"),
+		AltBody:     []byte("Hello there"),
+		Subscriber:  models.Subscriber{UUID: "sub-uuid", Email: to, Name: "Sub"},
+	}
+}
+
+func newTestAPI(t *testing.T, o Options) *API {
+	t.Helper()
+
+	a, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+
+	return a
+}
+
+func TestSendRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		resps []apiResp
+		reqs  int
+		code  int
+	}{
+		{"ok", nil, 1, 0},
+		{"5xx retried", []apiResp{{code: 500}, {code: 503}}, 3, 0},
+		{"429 retried", []apiResp{{code: 429, retryAfter: "0"}}, 2, 0},
+		{"4xx not retried", []apiResp{{code: 400, body: ` {"error":"invalid from"} `}}, 1, 400},
+		{"401 not retried", []apiResp{{code: 401, body: "unauthorized"}}, 1, 401},
+		{"retries exhausted", []apiResp{{code: 502}, {code: 502}, {code: 502}, {code: 502}}, 3, 502},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newAPIServer(t, tc.resps...)
+			a := newTestAPI(t, Options{
+				Name:       "api",
+				Provider:   ProviderJSON,
+				RootURL:    s.URL,
+				Retries:    3,
+				RetryDelay: time.Millisecond,
+			})
+
+			err := a.Push(testMessage("sub@example.org"))
+			if n := len(s.requests()); n != tc.reqs {
+				t.Errorf("expected %d requests, got %d", tc.reqs, n)
+			}
+
+			if tc.code == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var hErr *HTTPError
+			if !errors.As(err, &hErr) {
+				t.Fatalf("expected an HTTPError, got %v", err)
+			}
+			if hErr.Code != tc.code {
+				t.Errorf("expected code %d, got %d", tc.code, hErr.Code)
+			}
+			if body := tc.resps[len(tc.resps)-1].body; hErr.Body != strings.TrimSpace(body) {
+				t.Errorf("expected body %q, got %q", strings.TrimSpace(body), hErr.Body)
+			}
+		})
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	s := newAPIServer(t, apiResp{code: 429, retryAfter: "1"})
+	a := newTestAPI(t, Options{
+		Provider:   ProviderJSON,
+		RootURL:    s.URL,
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	})
+
+	// The wait is raised to the Retry-After duration of the response.
+	start := time.Now()
+	if err := a.Push(testMessage("sub@example.org")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After, waited %v", d)
+	}
+}
+
+func TestSendNetworkError(t *testing.T) {
+	s := newAPIServer(t)
+	url := s.URL
+	s.Close()
+
+	a := newTestAPI(t, Options{
+		Provider:   ProviderJSON,
+		RootURL:    url,
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	})
+
+	err := a.Push(testMessage("sub@example.org"))
+	if err == nil {
+		t.Fatal("expected an error sending to a closed server")
+	}
+	var hErr *HTTPError
+	if errors.As(err, &hErr) {
+		t.Errorf("expected a network error, got %v", err)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{
+		Provider:  ProviderJSON,
+		RootURL:   s.URL,
+		BatchSize: 3,
+		BatchWait: time.Second * 5,
+	})
+
+	// A full batch is sent in a single call without waiting for BatchWait.
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 3)
+	)
+	for _, to := range []string{"a@example.org", "b@example.org", "c@example.org"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- a.Push(testMessage(to))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	reqs := s.requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+	if n := strings.Count(string(reqs[0].body), `"subject":"Hello"`); n != 3 {
+		t.Errorf("expected 3 messages in the request, got %d: %s", n, reqs[0].body)
+	}
+
+	// A partial batch is sent after BatchWait.
+	b := newTestAPI(t, Options{
+		Provider:  ProviderJSON,
+		RootURL:   s.URL,
+		BatchSize: 3,
+		BatchWait: time.Millisecond * 10,
+	})
+	if err := b.Push(testMessage("d@example.org")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := len(s.requests()); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{Provider: ProviderJSON, RootURL: s.URL})
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Push(testMessage("sub@example.org")); !errors.Is(err, errClosed) {
+		t.Errorf("expected %v, got %v", errClosed, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		o    Options
+		ok   bool
+	}{
+		{"json", Options{Provider: ProviderJSON, RootURL: "http://localhost"}, true},
+		{"json template", Options{Provider: ProviderJSON, RootURL: "http://localhost", Template: `{{ json .Messages }}`}, true},
+		{"json no URL", Options{Provider: ProviderJSON}, false},
+		{"json bad template", Options{Provider: ProviderJSON, RootURL: "http://localhost", Template: `{{ .Messages`}, false},
+		{"ses", Options{Provider: ProviderSES, Region: "us-east-1", Username: "key", Password: "secret"}, true},
+		{"ses no region", Options{Provider: ProviderSES, Username: "key", Password: "secret"}, false},
+		{"ses no secret", Options{Provider: ProviderSES, Region: "us-east-1", Username: "key"}, false},
+		{"ses bad URL", Options{Provider: ProviderSES, Region: "us-east-1", Username: "key", Password: "secret", RootURL: "http://[::1"}, false},
+		{"unknown provider", Options{Provider: "smtp", RootURL: "http://localhost"}, false},
+	}
+
+	for _, tc := range tests {
+		if err := Validate(tc.o); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tc.name, tc.ok, err)
+		}
+	}
+}
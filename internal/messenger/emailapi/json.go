@@ -0,0 +1,170 @@
+package emailapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// jsonAPI sends messages to a generic JSON API. The request body is rendered
+// from a template, which makes it possible to send to providers like Postmark.
+type jsonAPI struct {
+	o       Options
+	tpl     *template.Template
+	authStr string
+}
+
+// tplData is the data that's passed to the request body template.
+type tplData struct {
+	Messages []tplMessage `json:"messages"`
+}
+
+type tplMessage struct {
+	From        string            `json:"from"`
+	To          []string          `json:"to"`
+	Subject     string            `json:"subject"`
+	ContentType string            `json:"content_type"`
+	Body        string            `json:"body"`
+	AltBody     string            `json:"alt_body"`
+	Headers     map[string]string `json:"headers"`
+	Attachments []tplAttachment   `json:"attachments"`
+
+	Subscriber models.Subscriber `json:"subscriber"`
+	Campaign   *tplCampaign      `json:"campaign"`
+}
+
+type tplAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Inline      bool   `json:"inline"`
+
+	// Base64 encoded content.
+	Content string `json:"content"`
+}
+
+type tplCampaign struct {
+	UUID string   `json:"uuid"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func newJSON(o Options) (*jsonAPI, error) {
+	if o.RootURL == "" {
+		return nil, errors.New("API URL is required")
+	}
+
+	j := &jsonAPI{o: o}
+
+	// Without a template, the data is posted as-is.
+	if strings.TrimSpace(o.Template) != "" {
+		tpl, err := template.New("body").Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(o.Template)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling template: %v", err)
+		}
+		j.tpl = tpl
+	}
+
+	if o.AuthHeader == "" && o.Username != "" && o.Password != "" {
+		j.authStr = "Basic " + base64.StdEncoding.EncodeToString([]byte(o.Username+":"+o.Password))
+	}
+
+	return j, nil
+}
+
+// maxBatchSize returns 0 as the batch size is up to the template and the API.
+func (j *jsonAPI) maxBatchSize() int {
+	return 0
+}
+
+// request returns the request with the body rendered for the batch of messages.
+func (j *jsonAPI) request(msgs []models.Message) (*http.Request, error) {
+	data := tplData{Messages: make([]tplMessage, 0, len(msgs))}
+	for _, m := range msgs {
+		data.Messages = append(data.Messages, j.makeMessage(m))
+	}
+
+	var (
+		body []byte
+		err  error
+	)
+	if j.tpl != nil {
+		var b bytes.Buffer
+		if err := j.tpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("error rendering template: %v", err)
+		}
+		body = b.Bytes()
+	} else {
+		body, err = json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, j.o.RootURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	switch {
+	case j.o.AuthHeader != "":
+		req.Header.Set(j.o.AuthHeader, j.o.Password)
+	case j.authStr != "":
+		req.Header.Set("Authorization", j.authStr)
+	}
+
+	return req, nil
+}
+
+func (j *jsonAPI) makeMessage(m models.Message) tplMessage {
+	out := tplMessage{
+		From:        m.From,
+		To:          m.To,
+		Subject:     m.Subject,
+		ContentType: m.ContentType,
+		Body:        string(m.Body),
+		AltBody:     string(m.AltBody),
+		Headers:     make(map[string]string, len(j.o.EmailHeaders)+len(m.Headers)),
+		Attachments: make([]tplAttachment, 0, len(m.Attachments)),
+		Subscriber:  m.Subscriber,
+	}
+
+	for k, v := range j.o.EmailHeaders {
+		out.Headers[k] = v
+	}
+	for k, v := range m.Headers {
+		out.Headers[k] = v[0]
+	}
+
+	for _, a := range m.Attachments {
+		out.Attachments = append(out.Attachments, tplAttachment{
+			Name:        a.Name,
+			ContentType: a.Header.Get("Content-Type"),
+			Inline:      a.IsInline,
+			Content:     base64.StdEncoding.EncodeToString(a.Content),
+		})
+	}
+
+	if m.Campaign != nil {
+		out.Campaign = &tplCampaign{
+			UUID: m.Campaign.UUID,
+			Name: m.Campaign.Name,
+			Tags: m.Campaign.Tags,
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,149 @@
+package emailapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/textproto"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+func TestJSONRequest(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{
+		Provider:     ProviderJSON,
+		RootURL:      s.URL + "/email/batch",
+		Username:     "user",
+		Password:     "pass",
+		EmailHeaders: map[string]string{"X-Global": "1", "X-Override": "global"},
+	})
+
+	m := testMessage("sub@example.org")
+	m.Headers = textproto.MIMEHeader{"X-Override": {"message"}}
+	m.Campaign = &models.Campaign{UUID: "camp-uuid", Name: "January", Tags: []string{"news"}}
+	m.Attachments = []models.Attachment{{
+		Name:     "logo.png",
+		Header:   textproto.MIMEHeader{"Content-Type": {"image/png"}},
+		Content:  []byte("png"),
+		IsInline: true,
+	}}
+	if err := a.Push(m); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := s.requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+	r := reqs[0]
+
+	if r.method != "POST" || r.path != "/email/batch" {
+		t.Errorf("expected POST /email/batch, got %s %s", r.method, r.path)
+	}
+	for k, v := range map[string]string{
+		"Content-Type":  "application/json",
+		"Accept":        "application/json",
+		"User-Agent":    "listmonk",
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")),
+	} {
+		if got := r.header.Get(k); got != v {
+			t.Errorf("header %s: expected %q, got %q", k, v, got)
+		}
+	}
+
+	// Without a template, the messages are posted as-is.
+	var data tplData
+	if err := json.Unmarshal(r.body, &data); err != nil {
+		t.Fatalf("error unmarshalling body: %v: %s", err, r.body)
+	}
+	if len(data.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(data.Messages))
+	}
+	got := data.Messages[0]
+
+	if got.From != m.From || len(got.To) != 1 || got.To[0] != "sub@example.org" || got.Subject != "Hello" ||
+		got.ContentType != "html" || got.Body != "<p>Hello there</p>" || got.AltBody != "Hello there" {
+		t.Errorf("unexpected message: %+v", got)
+	}
+	if got.Subscriber.UUID != "sub-uuid" || got.Subscriber.Email != "sub@example.org" {
+		t.Errorf("unexpected subscriber: %+v", got.Subscriber)
+	}
+	if got.Campaign == nil || got.Campaign.UUID != "camp-uuid" || got.Campaign.Name != "January" ||
+		len(got.Campaign.Tags) != 1 || got.Campaign.Tags[0] != "news" {
+		t.Errorf("unexpected campaign: %+v", got.Campaign)
+	}
+
+	// Message headers override the global ones.
+	if got.Headers["X-Global"] != "1" || got.Headers["X-Override"] != "message" {
+		t.Errorf("unexpected headers: %v", got.Headers)
+	}
+
+	exp := tplAttachment{Name: "logo.png", ContentType: "image/png", Inline: true, Content: base64.StdEncoding.EncodeToString([]byte("png"))}
+	if len(got.Attachments) != 1 || got.Attachments[0] != exp {
+		t.Errorf("expected attachment %+v, got %+v", exp, got.Attachments)
+	}
+}
+
+func TestJSONTemplate(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{
+		Provider:   ProviderJSON,
+		RootURL:    s.URL,
+		AuthHeader: "X-Postmark-Server-Token",
+		Password:   "token",
+		Template: `[{{ range $i, $m := .Messages }}{{ if $i }},{{ end }}` +
+			`{"From": {{ json $m.From }}, "To": {{ json (index $m.To 0) }}, "Subject": {{ json $m.Subject }}, "HtmlBody": {{ json $m.Body }}}` +
+			`{{ end }}]`,
+	})
+
+	m := testMessage("sub@example.org")
+	m.Subject = `Say "hi"`
+	if err := a.Push(m); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.requests()[0]
+	if got := r.header.Get("X-Postmark-Server-Token"); got != "token" {
+		t.Errorf("expected the token in the auth header, got %q", got)
+	}
+	if got := r.header.Get("Authorization"); got != "" {
+		t.Errorf("expected no Authorization header with an auth header, got %q", got)
+	}
+
+	var out []map[string]string
+	if err := json.Unmarshal(r.body, &out); err != nil {
+		t.Fatalf("error unmarshalling body: %v: %s", err, r.body)
+	}
+	exp := map[string]string{
+		"From":     "News <news@example.com>",
+		"To":       "sub@example.org",
+		"Subject":  `Say "hi"`,
+		"HtmlBody": "<p>Hello there</p>",
+	}
+	if len(out) != 1 || len(out[0]) != len(exp) {
+		t.Fatalf("unexpected body: %s", r.body)
+	}
+	for k, v := range exp {
+		if out[0][k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, out[0][k])
+		}
+	}
+}
+
+func TestJSONTemplateError(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{
+		Provider: ProviderJSON,
+		RootURL:  s.URL,
+		Template: `{{ index (index .Messages 0).To 5 }}`,
+	})
+
+	// Rendering errors are returned without calling the API.
+	if err := a.Push(testMessage("sub@example.org")); err == nil {
+		t.Fatal("expected a template error")
+	}
+	if n := len(s.requests()); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+}
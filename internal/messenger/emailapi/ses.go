@@ -0,0 +1,150 @@
+package emailapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	sesService  = "ses"
+	sesEndpoint = "https://email.%s.amazonaws.com/v2/email/outbound-emails"
+)
+
+// ses sends raw e-mails with the Amazon SES v2 SendEmail API.
+// https://docs.aws.amazon.com/ses/latest/APIReference-V2/API_SendEmail.html
+type ses struct {
+	o   Options
+	url *url.URL
+}
+
+type sesReq struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Raw struct {
+			Data []byte `json:"Data"`
+		} `json:"Raw"`
+	} `json:"Content"`
+	ConfigurationSetName string `json:"ConfigurationSetName,omitempty"`
+}
+
+func newSES(o Options) (*ses, error) {
+	if o.Region == "" || o.Username == "" || o.Password == "" {
+		return nil, errors.New("SES region, access key, and secret are required")
+	}
+
+	rURL := o.RootURL
+	if rURL == "" {
+		rURL = fmt.Sprintf(sesEndpoint, o.Region)
+	}
+	u, err := url.Parse(rURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SES URL: %v", err)
+	}
+
+	return &ses{o: o, url: u}, nil
+}
+
+// maxBatchSize returns 1 as SendEmail sends a single raw message.
+func (s *ses) maxBatchSize() int {
+	return 1
+}
+
+// request returns the signed SendEmail request for the message.
+func (s *ses) request(msgs []models.Message) (*http.Request, error) {
+	from, rcpts, raw, err := email.Render(msgs[0], s.o.EmailHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	var r sesReq
+	r.FromEmailAddress = from
+	r.Destination.ToAddresses = rcpts
+	r.Content.Raw.Data = raw
+	r.ConfigurationSetName = s.o.ConfigSet
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, body, time.Now().UTC())
+
+	return req, nil
+}
+
+// sign signs a request with AWS Signature Version 4.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (s *ses) sign(req *http.Request, body []byte, now time.Time) {
+	var (
+		amzDate = now.Format("20060102T150405Z")
+		date    = now.Format("20060102")
+		scope   = date + "/" + s.o.Region + "/" + sesService + "/aws4_request"
+	)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	var (
+		signed = "content-type;host;x-amz-date"
+		canon  = strings.Join([]string{
+			req.Method,
+			path,
+			req.URL.RawQuery,
+			"content-type:" + req.Header.Get("Content-Type"),
+			"host:" + req.URL.Host,
+			"x-amz-date:" + amzDate,
+			"",
+			signed,
+			sha256Hex(body),
+		}, "\n")
+
+		toSign = strings.Join([]string{
+			"AWS4-HMAC-SHA256",
+			amzDate,
+			scope,
+			sha256Hex([]byte(canon)),
+		}, "\n")
+	)
+
+	key := hmacSHA256([]byte("AWS4"+s.o.Password), date)
+	key = hmacSHA256(key, s.o.Region)
+	key = hmacSHA256(key, sesService)
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.o.Username, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,193 @@
+package emailapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// verifySigV4 verifies the AWS Signature Version 4 of a received request
+// the way AWS does, from the signed headers listed in the request.
+func verifySigV4(t *testing.T, r apiReq, region, key, secret string) {
+	t.Helper()
+
+	auth := r.header.Get("Authorization")
+	algo, params, _ := strings.Cut(auth, " ")
+	if algo != "AWS4-HMAC-SHA256" {
+		t.Fatalf("expected AWS4-HMAC-SHA256, got %q", auth)
+	}
+	p := map[string]string{}
+	for _, kv := range strings.Split(params, ", ") {
+		k, v, _ := strings.Cut(kv, "=")
+		p[k] = v
+	}
+
+	var (
+		amzDate = r.header.Get("X-Amz-Date")
+		scope   = amzDate[:8] + "/" + region + "/ses/aws4_request"
+	)
+	if p["Credential"] != key+"/"+scope {
+		t.Fatalf("expected credential %s/%s, got %s", key, scope, p["Credential"])
+	}
+
+	canon := []string{r.method, r.path, ""}
+	for _, h := range strings.Split(p["SignedHeaders"], ";") {
+		v := r.header.Get(h)
+		if h == "host" {
+			v = r.host
+		}
+		canon = append(canon, h+":"+v)
+	}
+	bh := sha256.Sum256(r.body)
+	canon = append(canon, "", p["SignedHeaders"], hex.EncodeToString(bh[:]))
+	ch := sha256.Sum256([]byte(strings.Join(canon, "\n")))
+
+	mac := func(k []byte, s string) []byte {
+		h := hmac.New(sha256.New, k)
+		h.Write([]byte(s))
+		return h.Sum(nil)
+	}
+	k := mac(mac(mac(mac([]byte("AWS4"+secret), amzDate[:8]), region), "ses"), "aws4_request")
+	sig := mac(k, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+hex.EncodeToString(ch[:]))
+
+	if p["Signature"] != hex.EncodeToString(sig) {
+		t.Errorf("signature mismatch: expected %x, got %s", sig, p["Signature"])
+	}
+}
+
+func TestSESRequest(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{
+		Provider:     ProviderSES,
+		RootURL:      s.URL + "/v2/email/outbound-emails",
+		Region:       "eu-west-1",
+		Username:     "AKIDEXAMPLE",
+		Password:     "secret",
+		ConfigSet:    "listmonk",
+		EmailHeaders: map[string]string{"X-Global": "1"},
+		BatchSize:    10,
+	})
+
+	if a.o.BatchSize != 1 {
+		t.Errorf("expected the batch size to be capped to 1, got %d", a.o.BatchSize)
+	}
+
+	m := testMessage("sub@example.org")
+	m.To = append(m.To, "cc@example.org")
+	if err := a.Push(m); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := s.requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+	r := reqs[0]
+
+	if r.method != http.MethodPost || r.path != "/v2/email/outbound-emails" {
+		t.Errorf("expected POST /v2/email/outbound-emails, got %s %s", r.method, r.path)
+	}
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected application/json, got %q", got)
+	}
+	if _, err := time.Parse("20060102T150405Z", r.header.Get("X-Amz-Date")); err != nil {
+		t.Errorf("invalid X-Amz-Date: %v", err)
+	}
+	verifySigV4(t, r, "eu-west-1", "AKIDEXAMPLE", "secret")
+
+	var body sesReq
+	if err := json.Unmarshal(r.body, &body); err != nil {
+		t.Fatalf("error unmarshalling body: %v: %s", err, r.body)
+	}
+	if body.FromEmailAddress != "news@example.com" {
+		t.Errorf("expected the from address, got %q", body.FromEmailAddress)
+	}
+	if to := body.Destination.ToAddresses; len(to) != 2 || to[0] != "sub@example.org" || to[1] != "cc@example.org" {
+		t.Errorf("unexpected destination: %v", to)
+	}
+	if body.ConfigurationSetName != "listmonk" {
+		t.Errorf("expected the configuration set, got %q", body.ConfigurationSetName)
+	}
+
+	raw := string(body.Content.Raw.Data)
+	for _, s := range []string{"Subject: Hello", "X-Global: 1", "Hello there"} {
+		if !strings.Contains(raw, s) {
+			t.Errorf("expected %q in the raw message:\n%s", s, raw)
+		}
+	}
+}
+
+func TestSESSignature(t *testing.T) {
+	s, err := newSES(Options{Provider: ProviderSES, Region: "us-east-1", Username: "key", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.url.String(); got != "https://email.us-east-1.amazonaws.com/v2/email/outbound-emails" {
+		t.Errorf("expected the region's endpoint, got %s", got)
+	}
+
+	req, err := s.request([]models.Message{testMessage("sub@example.org")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing is deterministic for the time and the body.
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	body := []byte(`{}`)
+	s.sign(req, body, now)
+	sig := req.Header.Get("Authorization")
+	if req.Header.Get("X-Amz-Date") != "20240102T100000Z" {
+		t.Errorf("expected the date, got %q", req.Header.Get("X-Amz-Date"))
+	}
+	if !strings.HasPrefix(sig, "AWS4-HMAC-SHA256 Credential=key/20240102/us-east-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=") {
+		t.Errorf("unexpected Authorization header: %s", sig)
+	}
+
+	s.sign(req, body, now)
+	if got := req.Header.Get("Authorization"); got != sig {
+		t.Errorf("expected the same signature, got %s and %s", sig, got)
+	}
+
+	// Changes to the body, time, or secret change the signature.
+	s.sign(req, []byte(`{"a":1}`), now)
+	if req.Header.Get("Authorization") == sig {
+		t.Error("expected the signature to change with the body")
+	}
+	s.sign(req, body, now.Add(time.Second))
+	if req.Header.Get("Authorization") == sig {
+		t.Error("expected the signature to change with the time")
+	}
+	s.o.Password = "other"
+	s.sign(req, body, now)
+	if req.Header.Get("Authorization") == sig {
+		t.Error("expected the signature to change with the secret")
+	}
+}
+
+func TestSESRenderError(t *testing.T) {
+	s := newAPIServer(t)
+	a := newTestAPI(t, Options{
+		Provider: ProviderSES,
+		RootURL:  s.URL,
+		Region:   "us-east-1",
+		Username: "key",
+		Password: "secret",
+	})
+
+	// Messages that can't be rendered aren't sent.
+	m := testMessage("sub@example.org")
+	m.From = "not an address"
+	if err := a.Push(m); err == nil {
+		t.Fatal("expected an error rendering the message")
+	}
+	if n := len(s.requests()); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+}
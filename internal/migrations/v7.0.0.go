@@ -0,0 +1,343 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+func V7_0_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	// A/B split testing: campaign variants, test options on campaigns, and
+	// per-variant attribution of views and clicks.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_variants (
+			id           SERIAL PRIMARY KEY,
+			campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			name         TEXT NOT NULL,
+			subject      TEXT NOT NULL,
+			body         TEXT NOT NULL DEFAULT '',
+			altbody      TEXT NULL,
+			created_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_variants_camp_id ON campaign_variants(campaign_id);
+
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variant_sample INT NOT NULL DEFAULT 0;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variant_window TEXT NOT NULL DEFAULT '4h';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variant_metric TEXT NOT NULL DEFAULT 'views';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variant_status TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variant_winner_id INT NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variant_test_ends_at TIMESTAMP WITH TIME ZONE NULL;
+
+		ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS variant_id INTEGER NULL REFERENCES campaign_variants(id) ON DELETE SET NULL ON UPDATE CASCADE;
+		CREATE INDEX IF NOT EXISTS idx_views_variant_id ON campaign_views(variant_id);
+
+		ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS variant_id INTEGER NULL REFERENCES campaign_variants(id) ON DELETE SET NULL ON UPDATE CASCADE;
+		CREATE INDEX IF NOT EXISTS idx_clicks_variant_id ON link_clicks(variant_id);
+	`); err != nil {
+		return err
+	}
+
+	// Scheduled delivery (send-time optimization, local time): campaign delivery modes
+	// and per-subscriber delivery schedules.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivery_mode TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivery_window TEXT NOT NULL DEFAULT '24h';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivery_time TEXT NOT NULL DEFAULT '09:00';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivery_timezone TEXT NOT NULL DEFAULT 'UTC';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivery_next_at TIMESTAMP WITH TIME ZONE NULL;
+
+		CREATE TABLE IF NOT EXISTS campaign_schedules (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			send_at          TIMESTAMP WITH TIME ZONE NOT NULL,
+
+			PRIMARY KEY (campaign_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_schedules_send_at ON campaign_schedules(campaign_id, send_at);
+	`); err != nil {
+		return err
+	}
+
+	// Automation workflows (drip sequences) and subscribers' progress in them.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS workflows (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			status           TEXT NOT NULL DEFAULT 'active',
+			trigger          TEXT NOT NULL DEFAULT 'subscribe',
+			list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			steps            JSONB NOT NULL DEFAULT '[]',
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_workflows_list_id ON workflows(list_id);
+
+		CREATE TABLE IF NOT EXISTS workflow_subscribers (
+			workflow_id      INTEGER NOT NULL REFERENCES workflows(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			step             INT NOT NULL DEFAULT 0,
+			status           TEXT NOT NULL DEFAULT 'active',
+			next_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			attempts         INT NOT NULL DEFAULT 0,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+			PRIMARY KEY (workflow_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_workflow_subs_next_at ON workflow_subscribers(next_at) WHERE status = 'active';
+
+		-- Insert new default super admin permissions.
+		UPDATE roles SET permissions = permissions || '{workflows:get}' WHERE id = 1 AND NOT permissions @> '{workflows:get}';
+		UPDATE roles SET permissions = permissions || '{workflows:manage}' WHERE id = 1 AND NOT permissions @> '{workflows:manage}';
+	`); err != nil {
+		return err
+	}
+
+	// Recurring campaigns.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS recurrence TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS recurrence_condition TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS recurrence_next_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS recurrence_parent_id INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE;
+	`); err != nil {
+		return err
+	}
+
+	// RSS-to-email campaigns.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS feed_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS feed_last_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS feed_guids TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS feed JSONB NOT NULL DEFAULT '{}';
+		INSERT INTO settings (key, value) VALUES ('security.allow_private_feeds', 'false') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Per-domain send limits.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('app.domain_throttles', '[]') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Campaign delivery log.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_deliveries (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			status           TEXT NOT NULL DEFAULT 'queued',
+			messenger        TEXT NOT NULL DEFAULT '',
+			error            TEXT NOT NULL DEFAULT '',
+			attempts         INTEGER NOT NULL DEFAULT 0,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE (campaign_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_deliveries_status ON campaign_deliveries(campaign_id, status);
+		CREATE INDEX IF NOT EXISTS idx_camp_deliveries_subscriber_id ON campaign_deliveries(subscriber_id);
+
+		UPDATE settings SET value = value || '{"delivery_retention_days": 90}'
+			WHERE key = 'maintenance.db' AND NOT value ? 'delivery_retention_days';
+	`); err != nil {
+		return err
+	}
+
+	// Multi-instance campaign processing.
+	if _, err := db.Exec(`
+		ALTER TABLE campaign_deliveries ADD COLUMN IF NOT EXISTS instance_id TEXT NOT NULL DEFAULT '';
+
+		CREATE TABLE IF NOT EXISTS campaign_leases (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			instance_id      TEXT NOT NULL,
+			expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+
+			PRIMARY KEY (campaign_id, instance_id)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Declarative bounce webhooks.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('bounce.custom_webhooks', '[]') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Time-windowed bounce rules and suppression.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriber_suppressions (
+			subscriber_id    INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			suppressed_until TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Global suppression list.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS suppressions (
+			id               SERIAL PRIMARY KEY,
+
+			-- SHA-256 hash of the lowercased e-mail or domain.
+			type             TEXT NOT NULL CHECK (type IN ('email', 'domain')),
+			hash             TEXT NOT NULL,
+			reason           TEXT NOT NULL DEFAULT '',
+			source           TEXT NOT NULL DEFAULT '',
+			expires_at       TIMESTAMP WITH TIME ZONE NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE (type, hash)
+		);
+		INSERT INTO settings (key, value) VALUES ('privacy.suppress_deleted', 'false') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Daily volumes of SMTP servers with warm-up schedules.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS smtp_volumes (
+			server           TEXT NOT NULL,
+			day              DATE NOT NULL,
+			sent             INT NOT NULL DEFAULT 0,
+
+			PRIMARY KEY (server, day)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// DKIM keys for signing e-mails.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('dkim', '[]') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// HTTP e-mail API messengers.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('email_apis', '[]') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Outbound webhooks for lifecycle events and their delivery queue / log.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			url              TEXT NOT NULL,
+			status           TEXT NOT NULL DEFAULT 'active',
+			events           TEXT[] NOT NULL DEFAULT '{}',
+			secret           TEXT NOT NULL DEFAULT '',
+			max_retries      INT NOT NULL DEFAULT 5,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id               BIGSERIAL PRIMARY KEY,
+			webhook_id       INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			event            TEXT NOT NULL,
+			payload          JSONB NOT NULL DEFAULT '{}',
+			status           TEXT NOT NULL DEFAULT 'pending',
+			attempts         INT NOT NULL DEFAULT 0,
+			response_code    INT NULL,
+			error            TEXT NOT NULL DEFAULT '',
+			next_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, status);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_at ON webhook_deliveries(next_at) WHERE status = 'pending';
+
+		-- Insert new default super admin permissions.
+		UPDATE roles SET permissions = permissions || '{webhooks:get}' WHERE id = 1 AND NOT permissions @> '{webhooks:get}';
+		UPDATE roles SET permissions = permissions || '{webhooks:manage}' WHERE id = 1 AND NOT permissions @> '{webhooks:manage}';
+	`); err != nil {
+		return err
+	}
+
+	// Outbox of events that are streamed to clients.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id               BIGSERIAL PRIMARY KEY,
+			type             TEXT NOT NULL,
+			message          TEXT NOT NULL DEFAULT '',
+			data             JSONB NOT NULL DEFAULT 'null',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_type ON events(type, id);
+		CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Segments (saved subscriber queries) and their use as campaign audiences.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS segments (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			description      TEXT NOT NULL DEFAULT '',
+			query            TEXT NOT NULL DEFAULT '',
+			list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+			subscriber_count INT NOT NULL DEFAULT 0,
+			counted_at       TIMESTAMP WITH TIME ZONE NULL,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_segments_name ON segments(name);
+
+		CREATE TABLE IF NOT EXISTS campaign_segments (
+			id           BIGSERIAL PRIMARY KEY,
+			campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			segment_id   INTEGER NULL REFERENCES segments(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			segment_name TEXT NOT NULL DEFAULT ''
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS campaign_segments_campaign_id_segment_id_idx ON campaign_segments (campaign_id, segment_id);
+		CREATE INDEX IF NOT EXISTS idx_camp_segments_segment_id ON campaign_segments(segment_id);
+
+		-- Insert new default super admin permissions.
+		UPDATE roles SET permissions = permissions || '{segments:get}' WHERE id = 1 AND NOT permissions @> '{segments:get}';
+		UPDATE roles SET permissions = permissions || '{segments:manage}' WHERE id = 1 AND NOT permissions @> '{segments:manage}';
+	`); err != nil {
+		return err
+	}
+
+	// Condition trees on segments.
+	if _, err := db.Exec(`
+		ALTER TABLE segments ADD COLUMN IF NOT EXISTS conditions JSONB NOT NULL DEFAULT '{}';
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber engagement scores and sunset policies.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriber_engagement (
+			subscriber_id       INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			score               INT NOT NULL DEFAULT 0,
+			last_engaged_at     TIMESTAMP WITH TIME ZONE NULL,
+			unengaged_campaigns INT NOT NULL DEFAULT 0,
+			inactive            BOOLEAN NOT NULL DEFAULT false,
+			inactive_since      TIMESTAMP WITH TIME ZONE NULL,
+			updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sub_engagement_inactive ON subscriber_engagement(subscriber_id) WHERE inactive = true;
+
+		INSERT INTO settings (key, value) VALUES ('maintenance.engagement',
+			'{"enabled": false, "cron_interval": "0 4 * * *", "window_days": 180, "sunset_enabled": false, "sunset_campaigns": 10, "sunset_action": "exclude"}')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	return nil
+}
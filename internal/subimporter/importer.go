@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 
@@ -72,6 +73,7 @@ type Options struct {
 	UpsertStmt         *sql.Stmt
 	BlocklistStmt      *sql.Stmt
 	UpdateListDateStmt *sql.Stmt
+	SuppressedStmt     *sql.Stmt
 	PostCB             func(subject string, data any) error
 
 	DomainBlocklist []string
@@ -273,37 +275,76 @@ func (im *Importer) sendNotif(status string) error {
 // invoked as a goroutine.
 func (s *Session) Start() {
 	var (
-		tx    *sql.Tx
-		stmt  *sql.Stmt
-		err   error
+		batch = make([]SubReq, 0, commitBatchSize)
 		total = 0
-		cur   = 0
 	)
 
 	listIDs := make([]int, len(s.opt.ListIDs))
 	copy(listIDs, s.opt.ListIDs)
 
 	for sub := range s.subQueue {
-		if cur == 0 {
-			// New transaction batch.
-			tx, err = s.im.db.Begin()
-			if err != nil {
-				s.log.Printf("error creating DB transaction: %v", err)
-				continue
-			}
+		batch = append(batch, sub)
+		if len(batch) < commitBatchSize {
+			continue
+		}
 
-			if s.opt.Mode == ModeSubscribe {
-				stmt = tx.Stmt(s.im.opt.UpsertStmt)
-			} else {
-				stmt = tx.Stmt(s.im.opt.BlocklistStmt)
-			}
+		// Batch size is met. Commit.
+		n, err := s.importBatch(batch, listIDs)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		total += n
+		s.im.incrementImportCount(n)
+		s.log.Printf("imported %d", total)
+		batch = batch[:0]
+	}
+
+	// Queue's closed. Commit the records that are left.
+	if len(batch) > 0 {
+		n, err := s.importBatch(batch, listIDs)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.im.incrementImportCount(n)
+	}
+
+	s.im.setStatus(StatusFinished)
+	s.log.Printf("imported finished")
+	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
+		s.log.Printf("error updating lists date: %v", err)
+	}
+
+	s.im.sendNotif(StatusFinished)
+}
+
+// importBatch inserts a batch of subscribers in a single transaction and returns
+// the number of subscribers that were inserted. Suppressed e-mails and domains
+// in the batch aren't subscribed.
+func (s *Session) importBatch(batch []SubReq, listIDs []int) (int, error) {
+	tx, err := s.im.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error creating DB transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var stmt *sql.Stmt
+	if s.opt.Mode == ModeSubscribe {
+		batch, err = s.skipSuppressed(tx, batch)
+		if err != nil {
+			return 0, err
 		}
+		stmt = tx.Stmt(s.im.opt.UpsertStmt)
+	} else {
+		stmt = tx.Stmt(s.im.opt.BlocklistStmt)
+	}
 
+	for _, sub := range batch {
 		uu, err := uuid.NewV4()
 		if err != nil {
-			s.log.Printf("error generating UUID: %v", err)
-			tx.Rollback()
-			break
+			return 0, fmt.Errorf("error generating UUID: %v", err)
 		}
 
 		if s.opt.Mode == ModeSubscribe {
@@ -312,55 +353,65 @@ func (s *Session) Start() {
 			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs)
 		}
 		if err != nil {
-			s.log.Printf("error executing insert: %v", err)
-			tx.Rollback()
-			break
+			return 0, fmt.Errorf("error executing insert: %v", err)
 		}
-		cur++
-		total++
+	}
 
-		// Batch size is met. Commit.
-		if cur%commitBatchSize == 0 {
-			if err := tx.Commit(); err != nil {
-				tx.Rollback()
-				s.log.Printf("error committing to DB: %v", err)
-			} else {
-				s.im.incrementImportCount(cur)
-				s.log.Printf("imported %d", total)
-			}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing to DB: %v", err)
+	}
 
-			cur = 0
-		}
+	return len(batch), nil
+}
+
+// skipSuppressed returns the subscribers in a batch whose e-mails and domains
+// don't have active suppressions, looking up the whole batch at once.
+func (s *Session) skipSuppressed(tx *sql.Tx, batch []SubReq) ([]SubReq, error) {
+	var (
+		emails = make([]string, len(batch))
+		doms   = make([][]string, len(batch))
+		all    []string
+	)
+	for i, sub := range batch {
+		emails[i], doms[i] = utils.SuppressionHashes(sub.Email)
+		all = append(all, doms[i]...)
 	}
 
-	// Queue's closed and there's nothing left to commit.
-	if cur == 0 {
-		s.im.setStatus(StatusFinished)
-		s.log.Printf("imported finished")
-		if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-			s.log.Printf("error updating lists date: %v", err)
-		}
-		s.im.sendNotif(StatusFinished)
-		return
+	rows, err := tx.Stmt(s.im.opt.SuppressedStmt).Query(pq.Array(emails), pq.Array(all))
+	if err != nil {
+		return nil, fmt.Errorf("error checking suppressions: %v", err)
 	}
+	defer rows.Close()
 
-	// Queue's closed and there are records left to commit.
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		s.im.setStatus(StatusFailed)
-		s.log.Printf("error committing to DB: %v", err)
-		s.im.sendNotif(StatusFailed)
-		return
+	suppressed := make(map[string]bool)
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("error checking suppressions: %v", err)
+		}
+		suppressed[h] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error checking suppressions: %v", err)
 	}
 
-	s.im.incrementImportCount(cur)
-	s.im.setStatus(StatusFinished)
-	s.log.Printf("imported finished")
-	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-		s.log.Printf("error updating lists date: %v", err)
+	out := batch[:0]
+	for i, sub := range batch {
+		if suppressed[emails[i]] || slices.ContainsFunc(doms[i], func(h string) bool { return suppressed[h] }) {
+			s.log.Printf("skipping suppressed e-mail '%s'", sub.Email)
+			continue
+		}
+		out = append(out, sub)
 	}
 
-	s.im.sendNotif(StatusFinished)
+	return out, nil
+}
+
+// fail logs an error and marks the import as failed.
+func (s *Session) fail(err error) {
+	s.log.Printf("%v", err)
+	s.im.setStatus(StatusFailed)
+	s.im.sendNotif(StatusFailed)
 }
 
 // Stop stops an active import session.
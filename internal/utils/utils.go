@@ -2,6 +2,8 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/mail"
 	"net/url"
@@ -76,3 +78,30 @@ func SanitizeURI(u string) string {
 
 	return path.Clean(p.Path)
 }
+
+// HashSuppression returns the hex SHA-256 hash of a lowercased and trimmed
+// e-mail or domain as stored in the suppression list.
+func HashSuppression(v string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(v))))
+	return hex.EncodeToString(h[:])
+}
+
+// SuppressionHashes returns the suppression hash of an e-mail and the hashes of
+// its domain and parent domains, eg: mail.example.com and example.com, that
+// suppress the e-mail.
+func SuppressionHashes(email string) (string, []string) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return HashSuppression(email), []string{}
+	}
+
+	doms := []string{HashSuppression(domain)}
+	for strings.Count(domain, ".") > 1 {
+		_, domain, _ = strings.Cut(domain, ".")
+		doms = append(doms, HashSuppression(domain))
+	}
+
+	return HashSuppression(email), doms
+}
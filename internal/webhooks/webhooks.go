@@ -0,0 +1,271 @@
+// Package webhooks sends queued lifecycle events (eg: subscriber.created)
+// to outbound webhooks, signing the payloads and retrying failed deliveries
+// with exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// Max backoff between retries.
+	maxRetryDelay = time.Hour * 6
+
+	// Frequency at which old deliveries are cleaned up.
+	cleanupInterval = time.Hour
+
+	// Max length of the response body that's recorded on failures.
+	maxErrLen = 1024
+)
+
+// Opt represents webhook runner options.
+type Opt struct {
+	// Interval is the frequency at which due deliveries are picked up.
+	Interval    time.Duration
+	BatchSize   int
+	Concurrency int
+	Timeout     time.Duration
+
+	// RetryDelay is the backoff after the first failed attempt that's
+	// doubled on every subsequent attempt.
+	RetryDelay time.Duration
+
+	// Retention is the duration after which finished (delivered or failed)
+	// deliveries are deleted from the log. 0 retains them forever.
+	Retention time.Duration
+}
+
+// Queries contains the queries.
+type Queries struct {
+	NextWebhookDeliveries   *sqlx.Stmt
+	UpdateWebhookDelivery   *sqlx.Stmt
+	DeleteWebhookDeliveries *sqlx.Stmt
+}
+
+// Runner sends due webhook deliveries.
+type Runner struct {
+	opt     Opt
+	queries *Queries
+	c       *http.Client
+	log     *log.Logger
+}
+
+// New returns a new instance of the webhook runner.
+func New(opt Opt, q *Queries, lo *log.Logger) *Runner {
+	if opt.BatchSize < 1 {
+		opt.BatchSize = 100
+	}
+	if opt.Concurrency < 1 {
+		opt.Concurrency = 1
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = time.Second * 10
+	}
+	if opt.RetryDelay <= 0 {
+		opt.RetryDelay = time.Second * 30
+	}
+
+	return &Runner{
+		opt:     opt,
+		queries: q,
+		c: &http.Client{
+			Timeout: opt.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   opt.Concurrency,
+				ResponseHeaderTimeout: opt.Timeout,
+			},
+		},
+		log: lo,
+	}
+}
+
+// Run is a blocking function that periodically sends due deliveries.
+func (r *Runner) Run() {
+	t := time.NewTicker(r.opt.Interval)
+	defer t.Stop()
+
+	// Deliveries that are picked up are locked for the duration of the request
+	// (with some leeway) before they can be picked up again.
+	lease := int((r.opt.Timeout + time.Minute).Seconds())
+
+	lastCleanup := time.Now()
+	for range t.C {
+		for {
+			var jobs []models.WebhookDeliveryJob
+			if err := r.queries.NextWebhookDeliveries.Select(&jobs, r.opt.BatchSize, lease); err != nil {
+				r.log.Printf("error fetching webhook deliveries: %v", err)
+				break
+			}
+
+			if len(jobs) == 0 {
+				break
+			}
+
+			r.process(jobs)
+
+			if len(jobs) < r.opt.BatchSize {
+				break
+			}
+		}
+
+		if r.opt.Retention > 0 && time.Since(lastCleanup) > cleanupInterval {
+			lastCleanup = time.Now()
+			if _, err := r.queries.DeleteWebhookDeliveries.Exec(time.Now().Add(-r.opt.Retention)); err != nil {
+				r.log.Printf("error deleting old webhook deliveries: %v", err)
+			}
+		}
+	}
+}
+
+// process sends a batch of deliveries concurrently and records the results.
+func (r *Runner) process(jobs []models.WebhookDeliveryJob) {
+	var (
+		ch = make(chan models.WebhookDeliveryJob)
+		wg sync.WaitGroup
+	)
+
+	for range r.opt.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				code, retryAfter, err := r.send(j)
+				r.record(j, r.result(j, code, retryAfter, err))
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		ch <- j
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// send posts a delivery's payload to its webhook and returns the response
+// code and the wait duration in the Retry-After header of the response, if any.
+func (r *Runner) send(j models.WebhookDeliveryJob) (int, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, j.URL, bytes.NewReader(j.Payload))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "listmonk")
+	req.Header.Set("X-Listmonk-Event", j.Event)
+	req.Header.Set("X-Listmonk-Delivery", strconv.FormatInt(j.ID, 10))
+	req.Header.Set("X-Listmonk-Timestamp", ts)
+	if j.Secret != "" {
+		req.Header.Set("X-Listmonk-Signature", "sha256="+sign(j.Secret, ts, j.Payload))
+	}
+
+	resp, err := r.c.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		// Drain and close the body to let the Transport reuse the connection
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+
+	var retryAfter time.Duration
+	if n, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && n > 0 {
+		retryAfter = time.Duration(n) * time.Second
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrLen))
+	return resp.StatusCode, retryAfter, fmt.Errorf("non-OK response: %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+}
+
+// result is the outcome of a delivery attempt that's recorded in the log.
+type result struct {
+	status string
+	code   null.Int
+	err    string
+	nextAt time.Time
+}
+
+// result returns the outcome of a delivery attempt. Failed deliveries are retried
+// with exponential backoff until the webhook's max retries are exhausted.
+func (r *Runner) result(j models.WebhookDeliveryJob, code int, retryAfter time.Duration, err error) result {
+	res := result{
+		status: models.WebhookDeliverySuccess,
+		nextAt: time.Now(),
+	}
+	if code > 0 {
+		res.code = null.IntFrom(code)
+	}
+
+	if err == nil {
+		return res
+	}
+
+	res.err = err.Error()
+	if len(res.err) > maxErrLen {
+		res.err = res.err[:maxErrLen]
+	}
+
+	if j.Attempts+1 > j.MaxRetries {
+		res.status = models.WebhookDeliveryFailed
+		return res
+	}
+
+	res.status = models.WebhookDeliveryPending
+	wait := r.backoff(j.Attempts + 1)
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	res.nextAt = res.nextAt.Add(wait)
+
+	return res
+}
+
+// record records the outcome of a delivery attempt.
+func (r *Runner) record(j models.WebhookDeliveryJob, res result) {
+	if _, err := r.queries.UpdateWebhookDelivery.Exec(j.ID, res.status, res.code, res.err, res.nextAt); err != nil {
+		r.log.Printf("error updating webhook delivery %d: %v", j.ID, err)
+	}
+}
+
+// backoff returns the wait before the next attempt after n failed attempts.
+func (r *Runner) backoff(n int) time.Duration {
+	d := r.opt.RetryDelay
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+
+	return d
+}
+
+// sign returns the hex encoded HMAC-SHA256 signature of a payload that's sent
+// with the given timestamp: HMAC(secret, timestamp + "." + payload).
+func sign(secret, timestamp string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
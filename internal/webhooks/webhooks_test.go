@@ -0,0 +1,351 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const testPayload = `{"event":"subscriber.created","data":{"id":1}}`
+
+// receiver is a local stand-in for a webhook endpoint that verifies the
+// signatures of deliveries and replies with the scripted status codes in
+// order, and 200 once they run out.
+type receiver struct {
+	*httptest.Server
+
+	secret     string
+	retryAfter string
+
+	mu    sync.Mutex
+	codes []int
+	reqs  []*http.Request
+	valid []bool
+}
+
+func newReceiver(t *testing.T, secret string, codes ...int) *receiver {
+	t.Helper()
+
+	rc := &receiver{secret: secret, codes: codes}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		// Verify the signature the way a receiver would.
+		mac := hmac.New(sha256.New, []byte(rc.secret))
+		mac.Write([]byte(r.Header.Get("X-Listmonk-Timestamp") + "." + string(b)))
+		valid := hmac.Equal([]byte(r.Header.Get("X-Listmonk-Signature")), []byte("sha256="+hex.EncodeToString(mac.Sum(nil))))
+
+		rc.mu.Lock()
+		rc.reqs = append(rc.reqs, r)
+		rc.valid = append(rc.valid, valid)
+		code := http.StatusOK
+		if len(rc.codes) > 0 {
+			code, rc.codes = rc.codes[0], rc.codes[1:]
+		}
+		rc.mu.Unlock()
+
+		if code >= 300 && rc.retryAfter != "" {
+			w.Header().Set("Retry-After", rc.retryAfter)
+		}
+		w.WriteHeader(code)
+		if code >= 300 {
+			w.Write([]byte(" upstream error\n"))
+		}
+	}))
+	t.Cleanup(rc.Close)
+
+	return rc
+}
+
+func newTestRunner(opt Opt) *Runner {
+	return New(opt, nil, log.New(io.Discard, "", 0))
+}
+
+func testJob(url, secret string, maxRetries int) models.WebhookDeliveryJob {
+	return models.WebhookDeliveryJob{
+		WebhookDelivery: models.WebhookDelivery{
+			ID:      42,
+			Event:   "subscriber.created",
+			Payload: []byte(testPayload),
+			Status:  models.WebhookDeliveryPending,
+		},
+		URL:        url,
+		Secret:     secret,
+		MaxRetries: maxRetries,
+	}
+}
+
+// deliver sends a delivery until it's no longer pending, applying the outcome
+// of every attempt to it the way the log is updated, and returns the outcomes.
+func deliver(t *testing.T, r *Runner, j models.WebhookDeliveryJob) []result {
+	t.Helper()
+
+	var out []result
+	for j.Status == models.WebhookDeliveryPending {
+		if len(out) > 20 {
+			t.Fatal("delivery didn't finish")
+		}
+
+		code, retryAfter, err := r.send(j)
+		res := r.result(j, code, retryAfter, err)
+		out = append(out, res)
+
+		j.Status = res.status
+		j.Attempts++
+	}
+
+	return out
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256("secret", "1700000000." + payload).
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + testPayload))
+	exp := hex.EncodeToString(mac.Sum(nil))
+
+	if got := sign("secret", "1700000000", []byte(testPayload)); got != exp {
+		t.Errorf("expected %s, got %s", exp, got)
+	}
+	if sign("other", "1700000000", []byte(testPayload)) == exp {
+		t.Error("expected the signature to change with the secret")
+	}
+	if sign("secret", "1700000001", []byte(testPayload)) == exp {
+		t.Error("expected the signature to change with the timestamp")
+	}
+	if sign("secret", "1700000000", []byte(testPayload+" ")) == exp {
+		t.Error("expected the signature to change with the payload")
+	}
+}
+
+func TestSend(t *testing.T) {
+	rc := newReceiver(t, "s3cret")
+	r := newTestRunner(Opt{})
+
+	code, retryAfter, err := r.send(testJob(rc.URL, "s3cret", 3))
+	if err != nil || code != http.StatusOK || retryAfter != 0 {
+		t.Fatalf("expected 200, got %d, %v, %v", code, retryAfter, err)
+	}
+
+	if len(rc.reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rc.reqs))
+	}
+	req := rc.reqs[0]
+	if req.Method != http.MethodPost {
+		t.Errorf("expected POST, got %s", req.Method)
+	}
+	for k, v := range map[string]string{
+		"Content-Type":        "application/json",
+		"User-Agent":          "listmonk",
+		"X-Listmonk-Event":    "subscriber.created",
+		"X-Listmonk-Delivery": "42",
+	} {
+		if got := req.Header.Get(k); got != v {
+			t.Errorf("header %s: expected %q, got %q", k, v, got)
+		}
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get("X-Listmonk-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("expected a current timestamp, got %q", req.Header.Get("X-Listmonk-Timestamp"))
+	}
+	if !rc.valid[0] {
+		t.Errorf("expected a valid signature, got %q", req.Header.Get("X-Listmonk-Signature"))
+	}
+
+	// Without a secret, the payload isn't signed.
+	if _, _, err := r.send(testJob(rc.URL, "", 3)); err != nil {
+		t.Fatal(err)
+	}
+	if sig := rc.reqs[1].Header.Get("X-Listmonk-Signature"); sig != "" {
+		t.Errorf("expected no signature without a secret, got %q", sig)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	rc := newReceiver(t, "", http.StatusServiceUnavailable)
+	rc.retryAfter = "120"
+	r := newTestRunner(Opt{})
+
+	code, retryAfter, err := r.send(testJob(rc.URL, "", 3))
+	if code != http.StatusServiceUnavailable || retryAfter != time.Minute*2 {
+		t.Errorf("expected 503 with a 2m Retry-After, got %d, %v", code, retryAfter)
+	}
+	if err == nil || err.Error() != "non-OK response: 503: upstream error" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Connection errors have no response code.
+	rc.Close()
+	code, _, err = r.send(testJob(rc.URL, "", 3))
+	if err == nil || code != 0 {
+		t.Errorf("expected a connection error without a code, got %d, %v", code, err)
+	}
+}
+
+func TestDeliveryStates(t *testing.T) {
+	const delay = time.Second * 10
+
+	tests := []struct {
+		name       string
+		codes      []int
+		maxRetries int
+		statuses   []string
+	}{
+		{"success", nil, 3, []string{models.WebhookDeliverySuccess}},
+		{"retried until success", []int{500, 502}, 3, []string{
+			models.WebhookDeliveryPending, models.WebhookDeliveryPending, models.WebhookDeliverySuccess,
+		}},
+		{"client errors retried", []int{404}, 3, []string{
+			models.WebhookDeliveryPending, models.WebhookDeliverySuccess,
+		}},
+		{"retries exhausted", []int{500, 500, 500, 500, 500}, 3, []string{
+			models.WebhookDeliveryPending, models.WebhookDeliveryPending, models.WebhookDeliveryPending, models.WebhookDeliveryFailed,
+		}},
+		{"no retries", []int{500}, 0, []string{models.WebhookDeliveryFailed}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := newReceiver(t, "s3cret", tc.codes...)
+			r := newTestRunner(Opt{RetryDelay: delay})
+
+			start := time.Now()
+			res := deliver(t, r, testJob(rc.URL, "s3cret", tc.maxRetries))
+			if len(res) != len(tc.statuses) {
+				t.Fatalf("expected %d attempts, got %d: %+v", len(tc.statuses), len(res), res)
+			}
+
+			for i, s := range res {
+				if s.status != tc.statuses[i] {
+					t.Errorf("attempt %d: expected %s, got %s", i+1, tc.statuses[i], s.status)
+				}
+
+				// Every attempt's response code is recorded.
+				exp := http.StatusOK
+				if i < len(tc.codes) {
+					exp = tc.codes[i]
+				}
+				if !s.code.Valid || s.code.Int != exp {
+					t.Errorf("attempt %d: expected code %d, got %v", i+1, exp, s.code)
+				}
+
+				if s.status == models.WebhookDeliverySuccess {
+					if s.err != "" {
+						t.Errorf("attempt %d: expected no error on success, got %q", i+1, s.err)
+					}
+					continue
+				}
+				if !strings.HasPrefix(s.err, "non-OK response: "+strconv.Itoa(exp)) {
+					t.Errorf("attempt %d: expected the response in the error, got %q", i+1, s.err)
+				}
+
+				// Pending deliveries are retried with exponential backoff. Failed ones aren't.
+				if s.status == models.WebhookDeliveryPending {
+					wait := delay << i
+					if d := s.nextAt.Sub(start); d < wait || d > wait+time.Minute {
+						t.Errorf("attempt %d: expected the next attempt after %v, got %v", i+1, wait, d)
+					}
+				} else if d := s.nextAt.Sub(start); d > time.Minute {
+					t.Errorf("attempt %d: expected no backoff on a failed delivery, got %v", i+1, d)
+				}
+			}
+
+			// Every attempt is signed.
+			for i, ok := range rc.valid {
+				if !ok {
+					t.Errorf("attempt %d: invalid signature", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryRetryAfter(t *testing.T) {
+	rc := newReceiver(t, "", http.StatusTooManyRequests, http.StatusTooManyRequests)
+	rc.retryAfter = "3600"
+	r := newTestRunner(Opt{RetryDelay: time.Second})
+
+	// The backoff is raised to the response's Retry-After.
+	start := time.Now()
+	res := deliver(t, r, testJob(rc.URL, "", 3))
+	if len(res) != 3 || res[2].status != models.WebhookDeliverySuccess {
+		t.Fatalf("expected success on the third attempt, got %+v", res)
+	}
+	if d := res[0].nextAt.Sub(start); d < time.Hour {
+		t.Errorf("expected the next attempt after Retry-After, got %v", d)
+	}
+}
+
+func TestDeliveryConnError(t *testing.T) {
+	rc := newReceiver(t, "")
+	rc.Close()
+	r := newTestRunner(Opt{})
+
+	res := deliver(t, r, testJob(rc.URL, "", 1))
+	if len(res) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(res))
+	}
+	for _, s := range res {
+		if s.code.Valid || s.err == "" {
+			t.Errorf("expected an error without a response code, got %+v", s)
+		}
+	}
+	if res[1].status != models.WebhookDeliveryFailed {
+		t.Errorf("expected the delivery to fail, got %s", res[1].status)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := newTestRunner(Opt{RetryDelay: time.Minute})
+
+	tests := []struct {
+		n   int
+		exp time.Duration
+	}{
+		{1, time.Minute},
+		{2, time.Minute * 2},
+		{3, time.Minute * 4},
+		{8, time.Minute * 128},
+		{9, time.Minute * 256},
+		{10, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+
+	for _, tc := range tests {
+		if got := r.backoff(tc.n); got != tc.exp {
+			t.Errorf("backoff(%d): expected %v, got %v", tc.n, tc.exp, got)
+		}
+	}
+}
+
+func TestResultErrLen(t *testing.T) {
+	r := newTestRunner(Opt{})
+
+	res := r.result(testJob("", "", 3), 500, 0, io.ErrUnexpectedEOF)
+	if res.err != io.ErrUnexpectedEOF.Error() {
+		t.Errorf("expected the error, got %q", res.err)
+	}
+
+	// Long errors are truncated.
+	res = r.result(testJob("", "", 3), 500, 0, &longErr{})
+	if len(res.err) != maxErrLen {
+		t.Errorf("expected the error to be truncated to %d, got %d", maxErrLen, len(res.err))
+	}
+}
+
+type longErr struct{}
+
+func (*longErr) Error() string {
+	return strings.Repeat("x", maxErrLen*2)
+}
@@ -0,0 +1,227 @@
+package workflows
+
+import (
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Duration for which due steps picked up by an instance are claimed. If the
+	// steps aren't sent by then, for instance, if the instance dies, they're retried.
+	claimTimeout = time.Minute * 15
+
+	// Max backoff between retries.
+	maxRetryDelay = time.Hour * 6
+)
+
+// Opt represents workflow runner options.
+type Opt struct {
+	// Interval is the frequency at which due workflow steps are picked up.
+	Interval  time.Duration
+	BatchSize int
+	FromEmail string
+
+	// MaxAttempts is the number of times a failing step is attempted before
+	// the subscriber's workflow is marked as failed. RetryDelay is the backoff
+	// after the first failed attempt that's doubled on every subsequent attempt.
+	MaxAttempts int
+	RetryDelay  time.Duration
+
+	// GetTpl returns a compiled tx template.
+	GetTpl func(id int) (*models.Template, error)
+
+	// TplFuncs returns the template functions available to tx templates.
+	TplFuncs func() template.FuncMap
+
+	// MatchSubscriber checks whether a subscriber matches a step's condition.
+	MatchSubscriber func(subID int, query string) (bool, error)
+
+	// PushMessage queues a message for sending.
+	PushMessage func(models.Message) error
+}
+
+// Queries contains the queries.
+type Queries struct {
+	CancelWorkflowSubscribers *sqlx.Stmt
+	NextWorkflowSubscribers   *sqlx.Stmt
+	UpdateWorkflowSubscriber  *sqlx.Stmt
+}
+
+// Runner runs the steps of automation workflows for enrolled subscribers
+// as they become due.
+type Runner struct {
+	opt     Opt
+	queries *Queries
+	log     *log.Logger
+}
+
+// New returns a new instance of the workflow runner.
+func New(opt Opt, q *Queries, lo *log.Logger) *Runner {
+	if opt.BatchSize < 1 {
+		opt.BatchSize = 1000
+	}
+	if opt.MaxAttempts < 1 {
+		opt.MaxAttempts = 5
+	}
+	if opt.RetryDelay <= 0 {
+		opt.RetryDelay = time.Minute
+	}
+
+	return &Runner{
+		opt:     opt,
+		queries: q,
+		log:     lo,
+	}
+}
+
+// Run is a blocking function that periodically processes due workflow steps.
+func (r *Runner) Run() {
+	t := time.NewTicker(r.opt.Interval)
+	defer t.Stop()
+
+	for range t.C {
+		// Cancel workflows of subscribers who have since unsubscribed or been blocklisted.
+		if _, err := r.queries.CancelWorkflowSubscribers.Exec(); err != nil {
+			r.log.Printf("error cancelling workflow subscribers: %v", err)
+		}
+
+		for {
+			var subs []models.WorkflowSubscriber
+			if err := r.queries.NextWorkflowSubscribers.Select(&subs, r.opt.BatchSize, claimTimeout.Seconds()); err != nil {
+				r.log.Printf("error fetching workflow subscribers: %v", err)
+				break
+			}
+
+			for _, s := range subs {
+				r.process(s)
+			}
+
+			if len(subs) < r.opt.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// process runs the due step of a subscriber's workflow and advances it to the next
+// step. If the step can't be run, it's retried later.
+func (r *Runner) process(s models.WorkflowSubscriber) {
+	// The workflow's steps may have been edited since the subscriber was enrolled.
+	if s.WorkflowStep >= len(s.Steps) {
+		r.advance(s)
+		return
+	}
+
+	step := s.Steps[s.WorkflowStep]
+
+	// If the step has a condition that the subscriber doesn't match, skip it.
+	if step.Condition != "" {
+		ok, err := r.opt.MatchSubscriber(s.ID, step.Condition)
+		if err != nil {
+			r.log.Printf("error matching condition for workflow %d step %d: %v", s.WorkflowID, s.WorkflowStep+1, err)
+			r.retry(s)
+			return
+		}
+		if !ok {
+			r.advance(s)
+			return
+		}
+	}
+
+	if err := r.send(s.Subscriber, step); err != nil {
+		r.log.Printf("error sending workflow %d step %d to %s: %v", s.WorkflowID, s.WorkflowStep+1, s.Email, err)
+		r.retry(s)
+		return
+	}
+
+	r.advance(s)
+}
+
+// send renders the step's tx template for the subscriber and queues it.
+func (r *Runner) send(sub models.Subscriber, step models.WorkflowStep) error {
+	tpl, err := r.opt.GetTpl(step.TemplateID)
+	if err != nil {
+		return err
+	}
+
+	m := models.TxMessage{
+		TemplateID:  step.TemplateID,
+		Subject:     step.Subject,
+		Messenger:   step.Messenger,
+		ContentType: models.CampaignContentTypeHTML,
+	}
+	if err := m.Render(sub, tpl, r.opt.TplFuncs()); err != nil {
+		return err
+	}
+
+	msg := models.Message{}
+	msg.Subscriber = sub
+	msg.To = []string{sub.Email}
+	msg.From = r.opt.FromEmail
+	msg.Subject = m.Subject
+	msg.ContentType = m.ContentType
+	msg.Messenger = m.Messenger
+	msg.Body = m.Body
+	msg.AltBody = []byte(m.AltBody)
+	msg.Attachments = append(msg.Attachments, tpl.Attachments...)
+
+	return r.opt.PushMessage(msg)
+}
+
+// advance moves the subscriber to the next step in the workflow, or marks
+// the workflow as finished if there are no more steps.
+func (r *Runner) advance(s models.WorkflowSubscriber) {
+	var (
+		next   = s.WorkflowStep + 1
+		status = models.WorkflowSubStatusActive
+		nextAt = time.Now()
+	)
+	if next >= len(s.Steps) {
+		status = models.WorkflowSubStatusFinished
+	} else {
+		d, _ := time.ParseDuration(s.Steps[next].Delay)
+		nextAt = nextAt.Add(d)
+	}
+
+	r.update(s, next, status, nextAt, 0)
+}
+
+// retry schedules the subscriber's current step to be retried with exponential
+// backoff, or marks the workflow as failed if the max attempts are exhausted.
+func (r *Runner) retry(s models.WorkflowSubscriber) {
+	var (
+		attempts = s.Attempts + 1
+		status   = models.WorkflowSubStatusActive
+		nextAt   = time.Now().Add(r.backoff(attempts))
+	)
+	if attempts >= r.opt.MaxAttempts {
+		status = models.WorkflowSubStatusFailed
+		r.log.Printf("giving up on workflow %d step %d for subscriber %d after %d attempts", s.WorkflowID, s.WorkflowStep+1, s.ID, attempts)
+	}
+
+	r.update(s, s.WorkflowStep, status, nextAt, attempts)
+}
+
+// update records a subscriber's progress in a workflow.
+func (r *Runner) update(s models.WorkflowSubscriber, step int, status string, nextAt time.Time, attempts int) {
+	if _, err := r.queries.UpdateWorkflowSubscriber.Exec(s.WorkflowID, s.ID, step, status, nextAt, attempts); err != nil {
+		r.log.Printf("error updating workflow %d subscriber %d: %v", s.WorkflowID, s.ID, err)
+	}
+}
+
+// backoff returns the wait before the next attempt after n failed attempts.
+func (r *Runner) backoff(n int) time.Duration {
+	d := r.opt.RetryDelay
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+
+	return d
+}
@@ -2,12 +2,14 @@ package models
 
 import (
 	"bytes"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"strings"
 	txttpl "text/template"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
@@ -29,6 +31,35 @@ const (
 	CampaignContentTypeMarkdown = "markdown"
 	CampaignContentTypePlain    = "plain"
 	CampaignContentTypeVisual   = "visual"
+
+	// A/B test phases of a campaign with variants.
+	CampaignVariantStatusNone    = ""
+	CampaignVariantStatusTesting = "testing"
+	CampaignVariantStatusWaiting = "waiting"
+	CampaignVariantStatusDecided = "decided"
+
+	CampaignVariantMetricViews  = "views"
+	CampaignVariantMetricClicks = "clicks"
+
+	// Delivery modes. Immediate campaigns go out in subscriber ID order as fast
+	// as possible. Optimized campaigns spread delivery over DeliveryWindow, sending
+	// to each subscriber at the hour (in their timezone) they have historically
+	// engaged the most.
+	// Local time campaigns are sent to each subscriber at DeliveryTime in their
+	// timezone (the `timezone` attribute, or DeliveryTimezone).
+	CampaignDeliveryImmediate = ""
+	CampaignDeliveryOptimized = "optimized"
+	CampaignDeliveryLocalTime = "local_time"
+
+	// CampaignTimezoneAttrib is the subscriber attribute that holds the
+	// subscriber's timezone for optimized and local time delivery.
+	CampaignTimezoneAttrib = "timezone"
+
+	// Status of a campaign message to a subscriber in the delivery log.
+	CampaignDeliveryQueued   = "queued"
+	CampaignDeliverySent     = "sent"
+	CampaignDeliveryFailed   = "failed"
+	CampaignDeliveryDeferred = "deferred"
 )
 
 // Campaigns represents a slice of Campaigns.
@@ -60,6 +91,47 @@ type Campaign struct {
 	ArchiveTemplateID null.Int        `db:"archive_template_id" json:"archive_template_id"`
 	ArchiveMeta       json.RawMessage `db:"archive_meta" json:"archive_meta"`
 
+	// A/B split test options. VariantSample is the % of the audience
+	// that's split across the variants. The remaining subscribers receive
+	// the winning variant once VariantWindow has elapsed.
+	VariantSample     int       `db:"variant_sample" json:"variant_sample"`
+	VariantWindow     string    `db:"variant_window" json:"variant_window"`
+	VariantMetric     string    `db:"variant_metric" json:"variant_metric"`
+	VariantStatus     string    `db:"variant_status" json:"variant_status"`
+	VariantWinnerID   null.Int  `db:"variant_winner_id" json:"variant_winner_id"`
+	VariantTestEndsAt null.Time `db:"variant_test_ends_at" json:"variant_test_ends_at"`
+
+	// Delivery scheduling. DeliveryNextAt is set when a running campaign has nothing
+	// to deliver until a later time, and is not processed until then.
+	DeliveryMode     string    `db:"delivery_mode" json:"delivery_mode"`
+	DeliveryWindow   string    `db:"delivery_window" json:"delivery_window"`
+	DeliveryTime     string    `db:"delivery_time" json:"delivery_time"`
+	DeliveryTimezone string    `db:"delivery_timezone" json:"delivery_timezone"`
+	DeliveryNextAt   null.Time `db:"delivery_next_at" json:"delivery_next_at"`
+
+	// Recurrence is a cron expression on which the campaign is cloned into a
+	// new scheduled campaign, starting from SendAt. RecurrenceCondition is an optional
+	// template that should render to a non-empty, non-false value for an occurrence
+	// to be sent.
+	Recurrence          string    `db:"recurrence" json:"recurrence"`
+	RecurrenceCondition string    `db:"recurrence_condition" json:"recurrence_condition"`
+	RecurrenceNextAt    null.Time `db:"recurrence_next_at" json:"recurrence_next_at"`
+	RecurrenceParentID  null.Int  `db:"recurrence_parent_id" json:"recurrence_parent_id"`
+
+	// RSS-to-email. A recurring campaign with a FeedURL polls the feed on every
+	// occurrence and is only sent if there are items whose GUIDs aren't in FeedGUIDs,
+	// that is, that haven't been sent before. FeedLastAt is the publish time of the
+	// latest item sent. The new items are stored in Feed of the cloned campaign and
+	// are available to templates as .Feed.Items.
+	FeedURL    string         `db:"feed_url" json:"feed_url"`
+	FeedLastAt null.Time      `db:"feed_last_at" json:"feed_last_at"`
+	FeedGUIDs  pq.StringArray `db:"feed_guids" json:"-"`
+	Feed       Feed           `db:"feed" json:"feed"`
+
+	// VariantID is set on in-memory copies of a campaign that carry
+	// a variant's subject and body while sending.
+	VariantID int `db:"-" json:"-"`
+
 	// TemplateBody is joined in from templates by the next-campaigns query.
 	TemplateBody        string             `db:"template_body" json:"-"`
 	ArchiveTemplateBody string             `db:"archive_template_body" json:"-"`
@@ -95,13 +167,116 @@ type CampaignMeta struct {
 	// campaign-list associations with a historical record of id + name that persist
 	// even after a list is deleted.
 	Lists types.JSONText `db:"lists" json:"lists"`
-	Media types.JSONText `db:"media" json:"media"`
+
+	// Like Lists, a list of {segment_id, name} pairs of the segments the campaign is sent to.
+	Segments types.JSONText `db:"segments" json:"segments"`
+	Media    types.JSONText `db:"media" json:"media"`
 
 	StartedAt null.Time `db:"started_at" json:"started_at"`
 	ToSend    int       `db:"to_send" json:"to_send"`
 	Sent      int       `db:"sent" json:"sent"`
 }
 
+// CampaignVariant represents an A/B test variant of a campaign's
+// subject and body.
+type CampaignVariant struct {
+	ID         int         `db:"id" json:"id"`
+	CampaignID int         `db:"campaign_id" json:"campaign_id"`
+	Name       string      `db:"name" json:"name"`
+	Subject    string      `db:"subject" json:"subject"`
+	Body       string      `db:"body" json:"body"`
+	AltBody    null.String `db:"altbody" json:"altbody"`
+	Views      int         `db:"views" json:"views"`
+	Clicks     int         `db:"clicks" json:"clicks"`
+	CreatedAt  null.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt  null.Time   `db:"updated_at" json:"updated_at"`
+}
+
+// CampaignDelivery represents the delivery status of a campaign message to a subscriber.
+type CampaignDelivery struct {
+	ID           int64     `db:"id" json:"id"`
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	SubscriberID int       `db:"subscriber_id" json:"subscriber_id"`
+	Email        string    `db:"email" json:"email"`
+	Status       string    `db:"status" json:"status"`
+	Messenger    string    `db:"messenger" json:"messenger"`
+	Error        string    `db:"error" json:"error"`
+	Attempts     int       `db:"attempts" json:"attempts"`
+	InstanceID   string    `db:"instance_id" json:"instance_id"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of results
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// Feed represents the items of an external RSS/Atom feed that a campaign is sent with.
+type Feed struct {
+	Items []FeedItem `json:"items"`
+}
+
+// FeedItem represents a single item (post) in a feed.
+type FeedItem struct {
+	GUID        string    `json:"guid"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Content     string    `json:"content"`
+	Author      string    `json:"author"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Scan unmarshals JSONB from the DB.
+func (f *Feed) Scan(src any) error {
+	if src == nil {
+		*f = Feed{}
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, f)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, f)
+}
+
+// Value implements the driver.Valuer interface.
+func (f Feed) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+// HasScheduledDelivery checks if the campaign's messages are delivered to subscribers
+// at scheduled times instead of right away.
+func (c *Campaign) HasScheduledDelivery() bool {
+	return c.DeliveryMode == CampaignDeliveryOptimized || c.DeliveryMode == CampaignDeliveryLocalTime
+}
+
+// HasVariants checks if the campaign is configured for an A/B split test.
+func (c *Campaign) HasVariants() bool {
+	return c.VariantSample > 0
+}
+
+// ApplyVariant returns a copy of the campaign with the variant's
+// subject and (optional) body applied.
+func (c Campaign) ApplyVariant(v CampaignVariant) *Campaign {
+	c.VariantID = v.ID
+	c.Subject = v.Subject
+	if v.Body != "" {
+		c.Body = v.Body
+	}
+	if v.AltBody.Valid {
+		c.AltBody = v.AltBody
+	}
+
+	// Compiled templates belong to the original campaign.
+	c.Tpl = nil
+	c.SubjectTpl = nil
+	c.AltBodyTpl = nil
+	c.HeaderTpls = nil
+
+	return &c
+}
+
 // GetIDs returns the list of campaign IDs.
 func (camps Campaigns) GetIDs() []int {
 	IDs := make([]int, len(camps))
@@ -126,6 +301,7 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 	for i, c := range meta {
 		if c.CampaignID == camps[i].ID {
 			camps[i].Lists = c.Lists
+			camps[i].Segments = c.Segments
 			camps[i].Views = c.Views
 			camps[i].Clicks = c.Clicks
 			camps[i].Bounces = c.Bounces
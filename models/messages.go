@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"net/textproto"
 	txttpl "text/template"
+	"time"
 )
 
 // Message is the message pushed to a Messenger.
@@ -28,6 +29,22 @@ type Message struct {
 	Messenger string
 }
 
+// DeferError is returned by a Messenger when a message can't be sent right
+// now but can be after RetryAfter, for instance, when the messenger has
+// reached a sending limit.
+type DeferError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *DeferError) Error() string {
+	return fmt.Sprintf("message deferred by %s: %v", e.RetryAfter.Round(time.Second), e.Err)
+}
+
+func (e *DeferError) Unwrap() error {
+	return e.Err
+}
+
 // Attachment represents a file or blob attachment that can be
 // sent along with a message by a Messenger.
 type Attachment struct {
@@ -37,12 +37,16 @@ type Queries struct {
 	UnsubscribeByCampaign           *sqlx.Stmt `query:"unsubscribe-by-campaign"`
 	ExportSubscriberData            *sqlx.Stmt `query:"export-subscriber-data"`
 	GetSubscriberActivity           *sqlx.Stmt `query:"get-subscriber-activity"`
+	UpdateSubscriberEngagement      *sqlx.Stmt `query:"update-subscriber-engagement"`
+	ResetInactiveSubscribers        *sqlx.Stmt `query:"reset-inactive-subscribers"`
+	UnsubscribeInactiveSubscribers  *sqlx.Stmt `query:"unsubscribe-inactive-subscribers"`
 
 	// Non-prepared arbitrary subscriber queries.
 	QuerySubscribers                       string     `query:"query-subscribers"`
 	QuerySubscribersCount                  string     `query:"query-subscribers-count"`
 	QuerySubscribersCountAll               *sqlx.Stmt `query:"query-subscribers-count-all"`
 	QuerySubscribersForExport              string     `query:"query-subscribers-for-export"`
+	MatchSubscriber                        string     `query:"match-subscriber"`
 	QuerySubscribersTpl                    string     `query:"query-subscribers-template"`
 	DeleteSubscribersByQuery               string     `query:"delete-subscribers-by-query"`
 	AddSubscribersToListsByQuery           string     `query:"add-subscribers-to-lists-by-query"`
@@ -80,17 +84,44 @@ type Queries struct {
 	ExportCampaignViews        *sqlx.Stmt `query:"export-campaign-views"`
 	ExportCampaignLinkClicks   *sqlx.Stmt `query:"export-campaign-link-clicks"`
 
-	NextCampaigns            *sqlx.Stmt `query:"next-campaigns"`
-	GetRunningCampaign       *sqlx.Stmt `query:"get-running-campaign"`
-	NextCampaignSubscribers  *sqlx.Stmt `query:"next-campaign-subscribers"`
-	GetOneCampaignSubscriber *sqlx.Stmt `query:"get-one-campaign-subscriber"`
-	UpdateCampaign           *sqlx.Stmt `query:"update-campaign"`
-	UpdateCampaignStatus     *sqlx.Stmt `query:"update-campaign-status"`
-	UpdateCampaignCounts     *sqlx.Stmt `query:"update-campaign-counts"`
-	UpdateCampaignArchive    *sqlx.Stmt `query:"update-campaign-archive"`
-	RegisterCampaignView     *sqlx.Stmt `query:"register-campaign-view"`
-	DeleteCampaign           *sqlx.Stmt `query:"delete-campaign"`
-	DeleteCampaigns          *sqlx.Stmt `query:"delete-campaigns"`
+	NextCampaigns                  *sqlx.Stmt `query:"next-campaigns"`
+	StartCampaigns                 *sqlx.Stmt `query:"start-campaigns"`
+	GetRunningCampaign             *sqlx.Stmt `query:"get-running-campaign"`
+	NextCampaignSubscribers        *sqlx.Stmt `query:"next-campaign-subscribers"`
+	NextCampaignSegmentSubscribers string     `query:"next-campaign-segment-subscribers"`
+	CountCampaignAudience          string     `query:"count-campaign-audience"`
+	UpdateCampaignAudience         *sqlx.Stmt `query:"update-campaign-audience"`
+	GetOneCampaignSubscriber       *sqlx.Stmt `query:"get-one-campaign-subscriber"`
+	UpdateCampaign                 *sqlx.Stmt `query:"update-campaign"`
+	UpdateCampaignStatus           *sqlx.Stmt `query:"update-campaign-status"`
+	UpdateCampaignCounts           *sqlx.Stmt `query:"update-campaign-counts"`
+	UpdateCampaignArchive          *sqlx.Stmt `query:"update-campaign-archive"`
+	RegisterCampaignView           *sqlx.Stmt `query:"register-campaign-view"`
+	DeleteCampaign                 *sqlx.Stmt `query:"delete-campaign"`
+	DeleteCampaigns                *sqlx.Stmt `query:"delete-campaigns"`
+
+	GetCampaignVariants         *sqlx.Stmt `query:"get-campaign-variants"`
+	UpdateCampaignVariants      *sqlx.Stmt `query:"update-campaign-variants"`
+	UpdateCampaignVariantStatus *sqlx.Stmt `query:"update-campaign-variant-status"`
+	GetVariantTestCampaigns     *sqlx.Stmt `query:"get-variant-test-campaigns"`
+	UpdateCampaignVariantWinner *sqlx.Stmt `query:"update-campaign-variant-winner"`
+
+	ScheduleCampaignSubscribers          *sqlx.Stmt `query:"schedule-campaign-subscribers"`
+	ScheduleCampaignSubscribersLocalTime *sqlx.Stmt `query:"schedule-campaign-subscribers-local-time"`
+	NextScheduledCampaignSubscribers     *sqlx.Stmt `query:"next-scheduled-campaign-subscribers"`
+	GetCampaignNextDelivery              *sqlx.Stmt `query:"get-campaign-next-delivery"`
+	UpdateCampaignDeliveryNextAt         *sqlx.Stmt `query:"update-campaign-delivery-next-at"`
+	GetRecurringCampaigns                *sqlx.Stmt `query:"get-recurring-campaigns"`
+	CloneRecurringCampaign               *sqlx.Stmt `query:"clone-recurring-campaign"`
+	UpdateCampaignRecurrenceNextAt       *sqlx.Stmt `query:"update-campaign-recurrence-next-at"`
+	RecordCampaignDeliveries             *sqlx.Stmt `query:"record-campaign-deliveries"`
+	QueryCampaignDeliveries              *sqlx.Stmt `query:"query-campaign-deliveries"`
+	GetCampaignDeliverySubscribers       *sqlx.Stmt `query:"get-campaign-delivery-subscribers"`
+	ClaimCampaignDeliveries              *sqlx.Stmt `query:"claim-campaign-deliveries"`
+	DeleteCampaignDeliveries             *sqlx.Stmt `query:"delete-campaign-deliveries"`
+	RenewCampaignLease                   *sqlx.Stmt `query:"renew-campaign-lease"`
+	ReleaseCampaignLease                 *sqlx.Stmt `query:"release-campaign-lease"`
+	CampaignHasOtherInstances            *sqlx.Stmt `query:"campaign-has-other-instances"`
 
 	InsertMedia *sqlx.Stmt `query:"insert-media"`
 	GetMedia    *sqlx.Stmt `query:"get-media"`
@@ -111,6 +142,10 @@ type Queries struct {
 	UpdateSettings      *sqlx.Stmt `query:"update-settings"`
 	UpdateSettingsByKey *sqlx.Stmt `query:"update-settings-by-key"`
 
+	LockSMTPVolume   *sqlx.Stmt `query:"lock-smtp-volume"`
+	UpdateSMTPVolume *sqlx.Stmt `query:"update-smtp-volume"`
+	GetSMTPVolume    *sqlx.Stmt `query:"get-smtp-volume"`
+
 	// GetStats *sqlx.Stmt `query:"get-stats"`
 	RecordBounce                *sqlx.Stmt `query:"record-bounce"`
 	QueryBounces                string     `query:"query-bounces"`
@@ -119,6 +154,51 @@ type Queries struct {
 	DeleteBouncesBySubscriber   *sqlx.Stmt `query:"delete-bounces-by-subscriber"`
 	GetDBInfo                   string     `query:"get-db-info"`
 
+	QuerySuppressions   string     `query:"query-suppressions"`
+	UpsertSuppression   *sqlx.Stmt `query:"upsert-suppression"`
+	ImportSuppressions  *sqlx.Stmt `query:"import-suppressions"`
+	UpdateSuppression   *sqlx.Stmt `query:"update-suppression"`
+	DeleteSuppressions  *sqlx.Stmt `query:"delete-suppressions"`
+	IsSuppressed        *sqlx.Stmt `query:"is-suppressed"`
+	GetSuppressedHashes *sqlx.Stmt `query:"get-suppressed-hashes"`
+
+	GetWorkflows              *sqlx.Stmt `query:"get-workflows"`
+	CreateWorkflow            *sqlx.Stmt `query:"create-workflow"`
+	UpdateWorkflow            *sqlx.Stmt `query:"update-workflow"`
+	DeleteWorkflow            *sqlx.Stmt `query:"delete-workflow"`
+	GetTriggerWorkflows       *sqlx.Stmt `query:"get-trigger-workflows"`
+	EnrollWorkflowSubscriber  *sqlx.Stmt `query:"enroll-workflow-subscriber"`
+	CancelWorkflowSubscribers *sqlx.Stmt `query:"cancel-workflow-subscribers"`
+	NextWorkflowSubscribers   *sqlx.Stmt `query:"next-workflow-subscribers"`
+	UpdateWorkflowSubscriber  *sqlx.Stmt `query:"update-workflow-subscriber"`
+
+	GetWebhooks             *sqlx.Stmt `query:"get-webhooks"`
+	CreateWebhook           *sqlx.Stmt `query:"create-webhook"`
+	UpdateWebhook           *sqlx.Stmt `query:"update-webhook"`
+	DeleteWebhook           *sqlx.Stmt `query:"delete-webhook"`
+	QueueWebhookEvent       *sqlx.Stmt `query:"queue-webhook-event"`
+	HasWebhooks             *sqlx.Stmt `query:"has-webhooks"`
+	NextWebhookDeliveries   *sqlx.Stmt `query:"next-webhook-deliveries"`
+	UpdateWebhookDelivery   *sqlx.Stmt `query:"update-webhook-delivery"`
+	QueryWebhookDeliveries  *sqlx.Stmt `query:"query-webhook-deliveries"`
+	RetryWebhookDeliveries  *sqlx.Stmt `query:"retry-webhook-deliveries"`
+	DeleteWebhookDeliveries *sqlx.Stmt `query:"delete-webhook-deliveries"`
+
+	GetSegments             *sqlx.Stmt `query:"get-segments"`
+	GetCampaignSegments     *sqlx.Stmt `query:"get-campaign-segments"`
+	CreateSegment           *sqlx.Stmt `query:"create-segment"`
+	UpdateSegment           *sqlx.Stmt `query:"update-segment"`
+	UpdateSegmentCount      *sqlx.Stmt `query:"update-segment-count"`
+	DeleteSegment           *sqlx.Stmt `query:"delete-segment"`
+	SegmentQueryTpl         string     `query:"segment-query-template"`
+	CountSegmentSubscribers string     `query:"count-segment-subscribers"`
+
+	LockEvents     *sqlx.Stmt `query:"lock-events"`
+	InsertEvent    *sqlx.Stmt `query:"insert-event"`
+	GetEvents      *sqlx.Stmt `query:"get-events"`
+	GetLastEventID *sqlx.Stmt `query:"get-last-event-id"`
+	DeleteEvents   *sqlx.Stmt `query:"delete-events"`
+
 	CreateUser         *sqlx.Stmt `query:"create-user"`
 	UpdateUser         *sqlx.Stmt `query:"update-user"`
 	UpdateUserProfile  *sqlx.Stmt `query:"update-user-profile"`
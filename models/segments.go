@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Segment represents a saved subscriber query that can be used as a
+// campaign audience alongside lists.
+type Segment struct {
+	Base
+
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+
+	// Query is an arbitrary SQL expression on the subscribers table,
+	// same as the advanced query in subscriber searches.
+	Query string `db:"query" json:"query"`
+
+	// Conditions is a JSON condition tree (internal/conditions) that's compiled
+	// into a parameterized SQL expression, and is combined with Query.
+	Conditions types.JSONText `db:"conditions" json:"conditions"`
+
+	// Subscribers in the segment should have an active subscription to
+	// any of these lists, or to any list if it's empty.
+	ListIDs pq.Int64Array `db:"list_ids" json:"list_ids"`
+
+	// Cached count of subscribers in the segment as of CountedAt.
+	SubscriberCount int       `db:"subscriber_count" json:"subscriber_count"`
+	CountedAt       null.Time `db:"counted_at" json:"counted_at"`
+
+	// CampaignID is only relevant when querying the segments of campaigns.
+	CampaignID int `db:"campaign_id" json:"-"`
+
+	// Pseudofield for getting the total number of segments
+	// in searches and queries.
+	Total int `db:"total" json:"-"`
+}
+
+// GetListIDs returns the segment's list IDs as ints.
+func (s Segment) GetListIDs() []int {
+	out := make([]int, len(s.ListIDs))
+	for i, id := range s.ListIDs {
+		out[i] = int(id)
+	}
+
+	return out
+}
@@ -29,6 +29,13 @@ type Settings struct {
 	AppMessageSlidingWindowDuration string `json:"app.message_sliding_window_duration"`
 	AppMessageSlidingWindowRate     int    `json:"app.message_sliding_window_rate"`
 
+	AppDomainThrottles []struct {
+		Domain      string `json:"domain"`
+		Rate        int    `json:"rate"`
+		Duration    string `json:"duration"`
+		Concurrency int    `json:"concurrency"`
+	} `json:"app.domain_throttles"`
+
 	PrivacyIndividualTracking bool     `json:"privacy.individual_tracking"`
 	PrivacyDisableTracking    bool     `json:"privacy.disable_tracking"`
 	PrivacyUnsubHeader        bool     `json:"privacy.unsubscribe_header"`
@@ -38,6 +45,7 @@ type Settings struct {
 	PrivacyAllowWipe          bool     `json:"privacy.allow_wipe"`
 	PrivacyExportable         []string `json:"privacy.exportable"`
 	PrivacyRecordOptinIP      bool     `json:"privacy.record_optin_ip"`
+	PrivacySuppressDeleted    bool     `json:"privacy.suppress_deleted"`
 	DomainBlocklist           []string `json:"privacy.domain_blocklist"`
 	DomainAllowlist           []string `json:"privacy.domain_allowlist"`
 
@@ -64,7 +72,8 @@ type Settings struct {
 		DefaultListRoleID null.Int `json:"default_list_role_id"`
 	} `json:"security.oidc"`
 
-	SecurityTrustedURLs []string `json:"security.trusted_urls"`
+	SecurityTrustedURLs       []string `json:"security.trusted_urls"`
+	SecurityAllowPrivateFeeds bool     `json:"security.allow_private_feeds"`
 
 	UploadProvider             string   `json:"upload.provider"`
 	UploadExtensions           []string `json:"upload.extensions"`
@@ -82,26 +91,39 @@ type Settings struct {
 	UploadS3Expiry             string   `json:"upload.s3.expiry"`
 
 	SMTP []struct {
-		Name          string              `json:"name"`
-		UUID          string              `json:"uuid"`
-		Enabled       bool                `json:"enabled"`
-		Host          string              `json:"host"`
-		HelloHostname string              `json:"hello_hostname"`
-		Port          int                 `json:"port"`
-		AuthProtocol  string              `json:"auth_protocol"`
-		Username      string              `json:"username"`
-		Password      string              `json:"password,omitempty"`
-		EmailHeaders  []map[string]string `json:"email_headers"`
-		MaxConns      int                 `json:"max_conns"`
-		MaxMsgRetries int                 `json:"max_msg_retries"`
-		MsgRetryDelay string              `json:"msg_retry_delay"`
-		IdleTimeout   string              `json:"idle_timeout"`
-		WaitTimeout   string              `json:"wait_timeout"`
-		TLSType       string              `json:"tls_type"`
-		TLSSkipVerify bool                `json:"tls_skip_verify"`
-		FromAddresses []string            `json:"from_addresses"`
+		Name           string              `json:"name"`
+		UUID           string              `json:"uuid"`
+		Enabled        bool                `json:"enabled"`
+		Host           string              `json:"host"`
+		HelloHostname  string              `json:"hello_hostname"`
+		Port           int                 `json:"port"`
+		AuthProtocol   string              `json:"auth_protocol"`
+		Username       string              `json:"username"`
+		Password       string              `json:"password,omitempty"`
+		EmailHeaders   []map[string]string `json:"email_headers"`
+		MaxConns       int                 `json:"max_conns"`
+		MaxMsgRetries  int                 `json:"max_msg_retries"`
+		MsgRetryDelay  string              `json:"msg_retry_delay"`
+		IdleTimeout    string              `json:"idle_timeout"`
+		WaitTimeout    string              `json:"wait_timeout"`
+		TLSType        string              `json:"tls_type"`
+		TLSSkipVerify  bool                `json:"tls_skip_verify"`
+		FromAddresses  []string            `json:"from_addresses"`
+		Weight         int                 `json:"weight"`
+		WarmupSchedule []int               `json:"warmup_schedule"`
+		WarmupStart    string              `json:"warmup_start"`
 	} `json:"smtp"`
 
+	DKIM []struct {
+		UUID             string   `json:"uuid"`
+		Enabled          bool     `json:"enabled"`
+		Domain           string   `json:"domain"`
+		Selector         string   `json:"selector"`
+		PrivateKey       string   `json:"private_key,omitempty"`
+		Headers          []string `json:"headers"`
+		Canonicalization string   `json:"canonicalization"`
+	} `json:"dkim"`
+
 	Messengers []struct {
 		UUID          string `json:"uuid"`
 		Enabled       bool   `json:"enabled"`
@@ -114,11 +136,36 @@ type Settings struct {
 		MaxMsgRetries int    `json:"max_msg_retries"`
 	} `json:"messengers"`
 
+	EmailAPIs []struct {
+		UUID          string              `json:"uuid"`
+		Enabled       bool                `json:"enabled"`
+		Name          string              `json:"name"`
+		Provider      string              `json:"provider"`
+		RootURL       string              `json:"root_url"`
+		Username      string              `json:"username"`
+		Password      string              `json:"password,omitempty"`
+		AuthHeader    string              `json:"auth_header"`
+		Region        string              `json:"region"`
+		ConfigSet     string              `json:"config_set"`
+		Template      string              `json:"template"`
+		EmailHeaders  []map[string]string `json:"email_headers"`
+		BatchSize     int                 `json:"batch_size"`
+		BatchWait     string              `json:"batch_wait"`
+		Rate          int                 `json:"rate"`
+		MaxConns      int                 `json:"max_conns"`
+		MaxMsgRetries int                 `json:"max_msg_retries"`
+		RetryDelay    string              `json:"retry_delay"`
+		Timeout       string              `json:"timeout"`
+	} `json:"email_apis"`
+
 	BounceEnabled        bool `json:"bounce.enabled"`
 	BounceEnableWebhooks bool `json:"bounce.webhooks_enabled"`
 	BounceActions        map[string]struct {
-		Count  int    `json:"count"`
-		Action string `json:"action"`
+		Count        int    `json:"count"`
+		Action       string `json:"action"`
+		Days         int    `json:"days"`
+		Campaigns    int    `json:"campaigns"`
+		SuppressDays int    `json:"suppress_days"`
 	} `json:"bounce.actions"`
 	SESEnabled      bool   `json:"bounce.ses_enabled"`
 	SendgridEnabled bool   `json:"bounce.sendgrid_enabled"`
@@ -141,6 +188,22 @@ type Settings struct {
 		Enabled bool   `json:"enabled"`
 		Key     string `json:"key"`
 	} `json:"bounce.lettermint"`
+	BounceWebhooks []struct {
+		UUID             string            `json:"uuid"`
+		Enabled          bool              `json:"enabled"`
+		Name             string            `json:"name"`
+		AuthType         string            `json:"auth_type"`
+		AuthHeader       string            `json:"auth_header"`
+		AuthUsername     string            `json:"auth_username"`
+		AuthSecret       string            `json:"auth_secret,omitempty"`
+		RecordsPath      string            `json:"records_path"`
+		EmailPath        string            `json:"email_path"`
+		TypePath         string            `json:"type_path"`
+		CampaignUUIDPath string            `json:"campaign_uuid_path"`
+		MetaPath         string            `json:"meta_path"`
+		TypeMap          map[string]string `json:"type_map"`
+	} `json:"bounce.custom_webhooks"`
+
 	BounceBoxes []struct {
 		UUID          string `json:"uuid"`
 		Enabled       bool   `json:"enabled"`
@@ -154,13 +217,29 @@ type Settings struct {
 		TLSEnabled    bool   `json:"tls_enabled"`
 		TLSSkipVerify bool   `json:"tls_skip_verify"`
 		ScanInterval  string `json:"scan_interval"`
+		Folder        string `json:"folder"`
+		IDLE          bool   `json:"idle"`
+		Action        string `json:"action"`
+		MoveFolder    string `json:"move_folder"`
 	} `json:"bounce.mailboxes"`
 
 	MaintenanceDB struct {
 		Vacuum         bool   `json:"vacuum"`
 		VacuumInterval string `json:"vacuum_cron_interval"`
+
+		// Delivery logs of finished campaigns are deleted after n days (0 retains them forever).
+		DeliveryRetentionDays int `json:"delivery_retention_days"`
 	} `json:"maintenance.db"`
 
+	MaintenanceEngagement struct {
+		Enabled         bool   `json:"enabled"`
+		CronInterval    string `json:"cron_interval"`
+		WindowDays      int    `json:"window_days"`
+		SunsetEnabled   bool   `json:"sunset_enabled"`
+		SunsetCampaigns int    `json:"sunset_campaigns"`
+		SunsetAction    string `json:"sunset_action"`
+	} `json:"maintenance.engagement"`
+
 	AdminCustomCSS  string `json:"appearance.admin.custom_css"`
 	AdminCustomJS   string `json:"appearance.admin.custom_js"`
 	PublicCustomCSS string `json:"appearance.public.custom_css"`
@@ -133,4 +133,8 @@ type SubscriberExportProfile struct {
 type SubscriberActivity struct {
 	CampaignViews json.RawMessage `db:"campaign_views" json:"campaign_views"`
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks"`
+
+	// Engagement is the subscriber's engagement score and sunset status. It's null
+	// until engagement scoring has run.
+	Engagement json.RawMessage `db:"engagement" json:"engagement"`
 }
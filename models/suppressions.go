@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	SuppressionTypeEmail  = "email"
+	SuppressionTypeDomain = "domain"
+)
+
+// Suppression represents a hashed e-mail or domain that can't be subscribed
+// or sent to irrespective of whether a subscriber exists for it.
+type Suppression struct {
+	ID        int       `db:"id" json:"id"`
+	Type      string    `db:"type" json:"type"`
+	Hash      string    `db:"hash" json:"hash"`
+	Reason    string    `db:"reason" json:"reason"`
+	Source    string    `db:"source" json:"source"`
+	ExpiresAt null.Time `db:"expires_at" json:"expires_at"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of suppressions
+	// in searches and queries.
+	Total int `db:"total" json:"-"`
+}
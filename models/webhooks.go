@@ -0,0 +1,145 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	WebhookStatusActive   = "active"
+	WebhookStatusDisabled = "disabled"
+
+	// Lifecycle events that are sent to webhooks.
+	WebhookEventSubscriberCreated        = "subscriber.created"
+	WebhookEventSubscriberUpdated        = "subscriber.updated"
+	WebhookEventSubscriberDeleted        = "subscriber.deleted"
+	WebhookEventSubscriptionConfirmed    = "subscription.confirmed"
+	WebhookEventSubscriptionUnsubscribed = "subscription.unsubscribed"
+	WebhookEventCampaignStarted          = "campaign.started"
+	WebhookEventCampaignFinished         = "campaign.finished"
+	WebhookEventBounceRecorded           = "bounce.recorded"
+	WebhookEventLinkClicked              = "link.clicked"
+
+	// Status of a webhook delivery.
+	WebhookDeliveryPending = "pending"
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+)
+
+// WebhookEvents is the list of all webhook events.
+var WebhookEvents = []string{
+	WebhookEventSubscriberCreated,
+	WebhookEventSubscriberUpdated,
+	WebhookEventSubscriberDeleted,
+	WebhookEventSubscriptionConfirmed,
+	WebhookEventSubscriptionUnsubscribed,
+	WebhookEventCampaignStarted,
+	WebhookEventCampaignFinished,
+	WebhookEventBounceRecorded,
+	WebhookEventLinkClicked,
+}
+
+// Webhook represents an outbound HTTP endpoint that lifecycle events are posted to.
+type Webhook struct {
+	Base
+
+	Name   string         `db:"name" json:"name"`
+	URL    string         `db:"url" json:"url"`
+	Status string         `db:"status" json:"status"`
+	Events pq.StringArray `db:"events" json:"events"`
+
+	// Secret is the key with which the payloads are signed (HMAC-SHA256).
+	// It's never returned in responses.
+	Secret string `db:"secret" json:"secret,omitempty"`
+
+	// MaxRetries is the number of times a failed delivery is retried
+	// with exponential backoff before it's marked as failed.
+	MaxRetries int `db:"max_retries" json:"max_retries"`
+
+	// Delivery counts.
+	Pending int `db:"pending" json:"pending"`
+	Failed  int `db:"failed" json:"failed"`
+}
+
+// WebhookEvent is the payload that's posted to webhooks.
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+// WebhookDelivery represents a queued or attempted delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID           int64           `db:"id" json:"id"`
+	WebhookID    int             `db:"webhook_id" json:"webhook_id"`
+	Event        string          `db:"event" json:"event"`
+	Payload      json.RawMessage `db:"payload" json:"payload"`
+	Status       string          `db:"status" json:"status"`
+	Attempts     int             `db:"attempts" json:"attempts"`
+	ResponseCode null.Int        `db:"response_code" json:"response_code"`
+	Error        string          `db:"error" json:"error"`
+	NextAt       time.Time       `db:"next_at" json:"next_at"`
+	CreatedAt    time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time       `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of results
+	// in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// WebhookDeliveryJob is a due delivery along with its webhook's endpoint.
+type WebhookDeliveryJob struct {
+	WebhookDelivery
+
+	URL        string `db:"url"`
+	Secret     string `db:"secret"`
+	MaxRetries int    `db:"max_retries"`
+}
+
+// WebhookSubscription is the data of subscription events.
+type WebhookSubscription struct {
+	Subscriber   Subscriber `json:"subscriber"`
+	ListIDs      []int      `json:"list_ids,omitempty"`
+	ListUUIDs    []string   `json:"list_uuids,omitempty"`
+	CampaignUUID string     `json:"campaign_uuid,omitempty"`
+	Blocklisted  bool       `json:"blocklisted,omitempty"`
+}
+
+// WebhookLinkClick is the data of link click events.
+type WebhookLinkClick struct {
+	URL            string `json:"url"`
+	LinkUUID       string `json:"link_uuid"`
+	CampaignUUID   string `json:"campaign_uuid"`
+	SubscriberUUID string `json:"subscriber_uuid"`
+}
+
+// WebhookCampaign is the data of campaign events.
+type WebhookCampaign struct {
+	ID        int            `json:"id"`
+	UUID      string         `json:"uuid"`
+	Name      string         `json:"name"`
+	Subject   string         `json:"subject"`
+	Status    string         `json:"status"`
+	Tags      pq.StringArray `json:"tags"`
+	ToSend    int            `json:"to_send"`
+	Sent      int            `json:"sent"`
+	StartedAt null.Time      `json:"started_at"`
+}
+
+// NewWebhookCampaign returns the webhook event data of a campaign.
+func NewWebhookCampaign(c Campaign) WebhookCampaign {
+	return WebhookCampaign{
+		ID:        c.ID,
+		UUID:      c.UUID,
+		Name:      c.Name,
+		Subject:   c.Subject,
+		Status:    c.Status,
+		Tags:      c.Tags,
+		ToSend:    c.ToSend,
+		Sent:      c.Sent,
+		StartedAt: c.StartedAt,
+	}
+}
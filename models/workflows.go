@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	WorkflowStatusActive   = "active"
+	WorkflowStatusDisabled = "disabled"
+
+	// Subscription events that start a workflow for a subscriber.
+	WorkflowTriggerSubscribe = "subscribe"
+	WorkflowTriggerConfirm   = "confirm"
+
+	// Progress of a subscriber in a workflow.
+	WorkflowSubStatusActive    = "active"
+	WorkflowSubStatusFinished  = "finished"
+	WorkflowSubStatusCancelled = "cancelled"
+	WorkflowSubStatusFailed    = "failed"
+)
+
+// Workflow represents an automated sequence of messages (eg: onboarding drip) that
+// is sent to a subscriber when they subscribe to or confirm a list.
+type Workflow struct {
+	Base
+
+	Name    string        `db:"name" json:"name"`
+	Status  string        `db:"status" json:"status"`
+	Trigger string        `db:"trigger" json:"trigger"`
+	ListID  int           `db:"list_id" json:"list_id"`
+	Steps   WorkflowSteps `db:"steps" json:"steps"`
+
+	// Subscriber progress counts.
+	Active   int `db:"active" json:"active"`
+	Finished int `db:"finished" json:"finished"`
+	Failed   int `db:"failed" json:"failed"`
+}
+
+// WorkflowStep represents a single message in a workflow.
+type WorkflowStep struct {
+	// Delay is the duration (eg: 72h) to wait after the previous step
+	// (or the trigger for the first step) before the step is run.
+	Delay string `json:"delay"`
+
+	// TemplateID is the tx template that's rendered and sent.
+	TemplateID int    `json:"template_id"`
+	Subject    string `json:"subject"`
+	Messenger  string `json:"messenger"`
+
+	// Condition is an optional SQL expression on the subscribers table (same as
+	// the advanced subscriber query) that should match for the step to be sent.
+	// eg: subscribers.attribs->>'plan' = 'pro'
+	// If it doesn't, the step is skipped.
+	Condition string `json:"condition"`
+}
+
+// WorkflowSteps represents a list of workflow steps stored as JSONB.
+type WorkflowSteps []WorkflowStep
+
+// WorkflowSubscriber represents a subscriber whose next step in a workflow is due.
+type WorkflowSubscriber struct {
+	Subscriber
+
+	WorkflowID   int           `db:"workflow_id"`
+	WorkflowStep int           `db:"workflow_step"`
+	Steps        WorkflowSteps `db:"workflow_steps"`
+
+	// Number of failed attempts at the current step.
+	Attempts int `db:"workflow_attempts"`
+}
+
+// Value implements the driver.Valuer interface.
+func (s WorkflowSteps) Value() (driver.Value, error) {
+	if s == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(s)
+}
+
+// Scan unmarshals JSONB from the DB.
+func (s *WorkflowSteps) Scan(src any) error {
+	if src == nil {
+		*s = WorkflowSteps{}
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, s)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, s)
+}
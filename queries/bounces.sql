@@ -1,7 +1,11 @@
 -- name: record-bounce
 -- Insert a bounce and count the bounces for the subscriber and either unsubscribe them,
+-- blocklist them, delete them, or suppress them from campaigns for a number of days ($12).
+-- If $10 > 0, only the bounces in the last $10 days are counted. If $11 > 0, the action
+-- only applies if the subscriber's last $11 campaigns have all bounced. If $13 is true,
+-- the hashed e-mail of a deleted subscriber is added to the suppression list.
 WITH sub AS (
-    SELECT id, status FROM subscribers WHERE CASE WHEN $1 != '' THEN uuid = $1::UUID ELSE email = $2 END
+    SELECT id, status, email FROM subscribers WHERE CASE WHEN $1 != '' THEN uuid = $1::UUID ELSE email = $2 END
 ),
 camp AS (
     SELECT id FROM campaigns WHERE $3 != '' AND uuid = $3::UUID
@@ -9,15 +13,48 @@ camp AS (
 num AS (
     -- Add a +1 to include the current insertion that is happening.
     SELECT COUNT(*) + 1 AS num FROM bounces WHERE subscriber_id = (SELECT id FROM sub) AND type = $4
+        AND ($10 < 1 OR created_at > NOW() - MAKE_INTERVAL(days => $10))
+),
+streak AS (
+    -- Campaigns that were last sent to the subscriber and whether they bounced.
+    -- The campaign of the current bounce counts as bounced.
+    SELECT COUNT(*) AS num, COALESCE(BOOL_AND(bounced), FALSE) AS bounced FROM (
+        SELECT (
+            d.campaign_id = (SELECT id FROM camp) OR EXISTS (
+                SELECT 1 FROM bounces WHERE subscriber_id = d.subscriber_id AND campaign_id = d.campaign_id AND type = $4
+            )
+        ) AS bounced
+        FROM campaign_deliveries d
+        WHERE $11 > 0 AND d.subscriber_id = (SELECT id FROM sub) AND d.status = 'sent'
+        ORDER BY d.updated_at DESC LIMIT $11
+    ) c
+),
+act AS (
+    SELECT (SELECT num FROM num) >= $8 AND ($11 < 1 OR ((SELECT num FROM streak) = $11 AND (SELECT bounced FROM streak))) AS ok
 ),
 -- block1 and block2 will run when $8 = 'blocklist' and the number of bounces exceed $8.
 block1 AS (
     UPDATE subscribers SET status='blocklisted'
-    WHERE $9 = 'blocklist' AND (SELECT num FROM num) >= $8 AND id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
+    WHERE $9 = 'blocklist' AND (SELECT ok FROM act) AND id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
 ),
 block2 AS (
     UPDATE subscriber_lists SET status='unsubscribed'
-    WHERE $9 = 'unsubscribe' AND (SELECT num FROM num) >= $8 AND subscriber_id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
+    WHERE $9 = 'unsubscribe' AND (SELECT ok FROM act) AND subscriber_id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
+),
+suppress AS (
+    -- Exclude the subscriber from campaigns for $12 days without changing their status.
+    INSERT INTO subscriber_suppressions (subscriber_id, suppressed_until)
+        SELECT id, NOW() + MAKE_INTERVAL(days => $12) FROM sub
+        WHERE $9 = 'suppress' AND (SELECT ok FROM act) AND status != 'blocklisted'
+    ON CONFLICT (subscriber_id) DO UPDATE
+        SET suppressed_until = GREATEST(subscriber_suppressions.suppressed_until, EXCLUDED.suppressed_until)
+),
+suppressDeleted AS (
+    -- The hash is the same as utils.HashSuppression().
+    INSERT INTO suppressions (type, hash, reason, source)
+        SELECT 'email', ENCODE(SHA256(LOWER(TRIM(email))::BYTEA), 'hex'), 'Deleted for bounces', 'bounces' FROM sub
+        WHERE $9 = 'delete' AND $13 = TRUE AND (SELECT ok FROM act)
+    ON CONFLICT (type, hash) DO NOTHING
 ),
 bounce AS (
     -- Record the bounce if the subscriber is not already blocklisted;
@@ -27,7 +64,7 @@ bounce AS (
 )
 -- This delete  will only run when $9 = 'delete' and the number of bounces exceed $8.
 DELETE FROM subscribers
-    WHERE $9 = 'delete' AND (SELECT num FROM num) >= $8 AND id = (SELECT id FROM sub);
+    WHERE $9 = 'delete' AND (SELECT ok FROM act) AND id = (SELECT id FROM sub);
 
 -- name: query-bounces
 SELECT COUNT(*) OVER () AS total,
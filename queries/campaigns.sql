@@ -25,7 +25,8 @@ WITH tpl AS (
 camp AS (
     INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody,
         content_type, send_at, headers, attribs, tags, messenger, template_id, to_send,
-        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source)
+        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source,
+        delivery_mode, delivery_window, delivery_time, delivery_timezone, recurrence, recurrence_condition, feed_url)
         SELECT $1, $2, $3, $4, $5,
             -- body
             COALESCE(NULLIF($6, ''), (SELECT body FROM tpl), ''),
@@ -40,7 +41,8 @@ camp AS (
             $18,
             $19,
             -- body_source
-            COALESCE($21, (SELECT body_source FROM tpl))
+            COALESCE($21, (SELECT body_source FROM tpl)),
+            $22, $23, $24, $25, $26, $27, $28
         RETURNING id
 ),
 med AS (
@@ -50,6 +52,10 @@ med AS (
 insLists AS (
     INSERT INTO campaign_lists (campaign_id, list_id, list_name)
         SELECT (SELECT id FROM camp), id, name FROM lists WHERE id=ANY($15::INT[])
+),
+insSegments AS (
+    INSERT INTO campaign_segments (campaign_id, segment_id, segment_name)
+        SELECT (SELECT id FROM camp), id, name FROM segments WHERE id=ANY($29::INT[])
 )
 SELECT id FROM camp;
 
@@ -78,6 +84,10 @@ WHERE ($1 = 0 OR id = $1)
     AND (
         $5 OR EXISTS (
             SELECT 1 FROM campaign_lists WHERE campaign_id = c.id AND list_id = ANY($6::INT[])
+        ) OR EXISTS (
+            -- Segments that are restricted to the permitted lists.
+            SELECT 1 FROM campaign_segments cs JOIN segments ON (segments.id = cs.segment_id)
+            WHERE cs.campaign_id = c.id AND CARDINALITY(segments.list_ids) > 0 AND segments.list_ids <@ $6::INT[]
         )
     )
 ORDER BY %order% OFFSET $7 LIMIT (CASE WHEN $8 < 1 THEN NULL ELSE $8 END);
@@ -116,6 +126,10 @@ WITH lists AS (
     SELECT campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', list_id, 'name', list_name)) AS lists FROM campaign_lists
     WHERE campaign_id = ANY($1) GROUP BY campaign_id
 ),
+segments AS (
+    SELECT campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', segment_id, 'name', segment_name)) AS segments FROM campaign_segments
+    WHERE campaign_id = ANY($1) GROUP BY campaign_id
+),
 media AS (
     SELECT campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', media_id, 'filename', filename)) AS media FROM campaign_media
     WHERE campaign_id = ANY($1) GROUP BY campaign_id
@@ -140,9 +154,11 @@ SELECT id as campaign_id,
     COALESCE(c.num, 0) AS clicks,
     COALESCE(b.num, 0) AS bounces,
     COALESCE(l.lists, '[]') AS lists,
+    COALESCE(sg.segments, '[]') AS segments,
     COALESCE(m.media, '[]') AS media
 FROM (SELECT id FROM UNNEST($1) AS id) x
 LEFT JOIN lists AS l ON (l.campaign_id = id)
+LEFT JOIN segments AS sg ON (sg.campaign_id = id)
 LEFT JOIN media AS m ON (m.campaign_id = id)
 LEFT JOIN views AS v ON (v.campaign_id = id)
 LEFT JOIN clicks AS c ON (c.campaign_id = id)
@@ -166,9 +182,13 @@ WHERE campaigns.id = $1;
 SELECT id, status, to_send, sent, started_at, updated_at FROM campaigns WHERE status=$1;
 
 -- name: campaign-has-lists
--- Returns TRUE if the campaign $1 has any of the lists given in $2.
+-- Returns TRUE if the campaign $1 has any of the lists given in $2, or any segment
+-- that's restricted to them.
 SELECT EXISTS (
     SELECT TRUE FROM campaign_lists WHERE campaign_id = $1 AND list_id = ANY($2::INT[])
+) OR EXISTS (
+    SELECT TRUE FROM campaign_segments cs JOIN segments ON (segments.id = cs.segment_id)
+    WHERE cs.campaign_id = $1 AND CARDINALITY(segments.list_ids) > 0 AND segments.list_ids <@ $2::INT[]
 );
 
 -- name: next-campaigns
@@ -185,6 +205,12 @@ WITH camps AS (
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
     AND NOT(campaigns.id = ANY($1::INT[]))
+    -- A/B tested campaigns whose sample has been sent wait for a winner to be picked.
+    AND campaigns.variant_status != 'waiting'
+    -- Campaigns with scheduled deliveries wait until the next delivery is due.
+    AND (campaigns.delivery_next_at IS NULL OR campaigns.delivery_next_at <= NOW())
+    -- Recurring campaigns are not sent themselves, but are cloned on every occurrence.
+    AND campaigns.recurrence = ''
 ),
 campLists AS (
     -- Get the list_ids and their optin statuses for the campaigns found in the previous step.
@@ -212,6 +238,14 @@ counts AS (
         )
     JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
     GROUP BY camps.id
+
+    UNION ALL
+
+    -- Campaigns that only target segments. Segments can't be evaluated here, and their
+    -- counts are updated by the application after the campaigns are picked up.
+    SELECT camps.id, 0, 0 FROM camps
+    WHERE NOT EXISTS (SELECT 1 FROM campLists WHERE campLists.campaign_id = camps.id)
+    AND EXISTS (SELECT 1 FROM campaign_segments WHERE campaign_segments.campaign_id = camps.id)
 ),
 updateCounts AS (
     WITH uc (campaign_id, sent_count) AS (SELECT * FROM unnest($1::INT[], $2::INT[]))
@@ -225,12 +259,18 @@ u AS (
     SET to_send = co.to_send,
         status = (CASE WHEN status != 'running' THEN 'running' ELSE status END),
         max_subscriber_id = co.max_subscriber_id,
-        started_at=(CASE WHEN ca.started_at IS NULL THEN NOW() ELSE ca.started_at END)
+        -- A/B tested campaigns start by sending the variants to the sample.
+        variant_status = (CASE WHEN ca.variant_sample > 0 AND ca.variant_status = '' THEN 'testing' ELSE ca.variant_status END)
     FROM (SELECT * FROM counts) co
     WHERE ca.id = co.campaign_id
 )
 SELECT camps.*, campMedia.media_id FROM camps LEFT JOIN campMedia ON (campMedia.campaign_id = camps.id);
 
+-- name: start-campaigns
+-- Sets the start time of the given campaigns ($1) that haven't been started yet and returns their IDs.
+-- When multiple instances pick up a campaign at the same time, only one of them starts it.
+UPDATE campaigns SET started_at=NOW() WHERE id = ANY($1::INT[]) AND started_at IS NULL RETURNING id;
+
 -- name: get-campaign-analytics-unique-counts
 WITH intval AS (
     -- For intervals < a week, aggregate counts hourly, otherwise daily.
@@ -308,17 +348,35 @@ SELECT link_clicks.campaign_id,
 
 -- name: get-running-campaign
 -- Returns the metadata for a running campaign that is required by next-campaign-subscribers to retrieve
--- a batch of campaign subscribers for processing.
-SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id, lists.id AS list_id
+-- a batch of campaign subscribers for processing. The campaign row is locked so that multiple instances
+-- processing the campaign in a transaction fetch distinct batches.
+-- Campaigns that only target segments return a single row with a 0 list_id.
+SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id,
+    variant_sample, variant_status, COALESCE(lists.id, 0) AS list_id
     FROM campaigns
-    JOIN campaign_lists ON (campaign_lists.campaign_id = campaigns.id)
-    JOIN lists ON (lists.id = campaign_lists.list_id)
-    WHERE campaigns.id = $1 AND campaigns.status='running';
+    LEFT JOIN campaign_lists ON (campaign_lists.campaign_id = campaigns.id)
+    LEFT JOIN lists ON (lists.id = campaign_lists.list_id)
+    WHERE campaigns.id = $1 AND campaigns.status='running'
+    FOR UPDATE OF campaigns;
+
+-- name: next-campaign-segment-subscribers
+-- raw: true
+-- Returns the IDs of the next batch ($2) of subscribers in a campaign's ($1) segments after its
+-- checkpoint. The injected condition matches subscribers in the segments. Segment conditions are
+-- arbitrary expressions, so this is run in a readonly transaction ahead of next-campaign-subscribers.
+SELECT subscribers.id FROM subscribers
+    WHERE subscribers.id > (SELECT last_subscriber_id FROM campaigns WHERE id = $1)
+    AND subscribers.id <= (SELECT max_subscriber_id FROM campaigns WHERE id = $1)
+    AND (%query%)
+    ORDER BY subscribers.id LIMIT $2;
 
 -- name: next-campaign-subscribers
 -- Returns a batch of subscribers in a given campaign starting from the last checkpoint
 -- (last_subscriber_id). Every fetch updates the checkpoint and the sent count, which means
 -- every fetch returns a new batch of subscribers until all rows are exhausted.
+-- Subscribers are marked as queued in the delivery log so that unacknowledged messages can be
+-- resumed after a crash. Subscribers who already have an entry in the log (sent, failed, or queued
+-- and yet to be claimed) are not returned, which keeps sends exactly-once without a lookup per row.
 --
 -- In previous versions, get-running-campaign + this was a single query spread across multiple
 -- CTEs, but despite numerous permutations and combinations, Postgres query planner simply would not use
@@ -327,49 +385,116 @@ SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscr
 -- the query planner works as expected. The difference is staggering. ~15 seconds on a subscribers table with 15m
 -- rows and a subscriber_lists table with 70 million rows when fetching subscribers for a campaign with a single list,
 -- vs. a few million seconds using this current approach.
+--
+-- Subscribers in the campaign's segments are resolved beforehand by next-campaign-segment-subscribers ($10).
+-- If that returned a full batch, the batch's upper bound ($4) is its last ID so that the subscribers in the
+-- segments beyond it aren't skipped. The checkpoint moves up to the bound even if every subscriber up to it
+-- was skipped, so that the next fetch moves on.
 WITH campLists AS (
     SELECT lists.id AS list_id, optin FROM lists
     LEFT JOIN campaign_lists ON campaign_lists.list_id = lists.id
     WHERE campaign_lists.campaign_id = $1
 ),
+excluded AS (
+    -- Subscribers in the batch's range who are temporarily suppressed by bounce rules, or inactive
+    -- as per the engagement sunset policy. Opt-in campaigns are sent to new subscribers and are
+    -- not affected by the sunset policy. They're resolved once and anti-joined.
+    SELECT subscriber_id FROM subscriber_suppressions
+        WHERE subscriber_id > $3 AND subscriber_id <= $4 AND suppressed_until > NOW()
+    UNION
+    SELECT subscriber_id FROM subscriber_engagement
+        WHERE subscriber_id > $3 AND subscriber_id <= $4 AND inactive = true AND $2 != 'optin'
+),
 subs AS (
     SELECT s.*
     FROM (
         SELECT DISTINCT s.id
-        FROM subscriber_lists sl
-        JOIN campLists ON sl.list_id = campLists.list_id
-        JOIN subscribers s ON s.id = sl.subscriber_id
+        FROM (
+            -- Subscribers in the campaign's lists.
+            SELECT sl.subscriber_id AS id
+            FROM subscriber_lists sl
+            JOIN campLists ON sl.list_id = campLists.list_id
+            WHERE
+                sl.list_id = ANY($5::INT[])
+                -- last_subscriber_id
+                AND sl.subscriber_id > $3
+                 -- max_subscriber_id, or the upper bound of the batch
+                AND sl.subscriber_id <= $4
+                AND (
+                    -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
+                    ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
+                    OR (
+                        -- It is a regular campaign.
+                        $2 != 'optin' AND (
+                            -- It is a double optin list. Only pick confirmed subscribers.
+                            (campLists.optin = 'double' AND sl.status = 'confirmed') OR
+
+                            -- It is a single optin list. Pick all non-unsubscribed subscribers.
+                            (campLists.optin != 'double' AND sl.status != 'unsubscribed')
+                        )
+                    )
+                )
+
+            UNION ALL
+
+            -- Subscribers in the campaign's segments.
+            SELECT id FROM UNNEST($10::INT[]) AS id WHERE id > $3 AND id <= $4
+        ) ids
+        JOIN subscribers s ON s.id = ids.id
+        LEFT JOIN excluded ON excluded.subscriber_id = s.id
         WHERE
-            sl.list_id = ANY($5::INT[])
-            -- last_subscriber_id
-            AND s.id > $3
-             -- max_subscriber_id
-            AND s.id <= $4
              -- Subscriber should not be blacklisted.
-            AND s.status != 'blocklisted'
+            s.status != 'blocklisted'
+            AND excluded.subscriber_id IS NULL
+            -- A/B split tests. The sample is picked by a hash of the campaign ID and the subscriber's
+            -- UUID (% 100) so that the remainder can be picked up after the test. The variants are
+            -- split by the next 32 bits of the hash in the app (pickVariant()).
             AND (
-                -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
-                ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
-                OR (
-                    -- It is a regular campaign.
-                    $2 != 'optin' AND (
-                        -- It is a double optin list. Only pick confirmed subscribers.
-                        (campLists.optin = 'double' AND sl.status = 'confirmed') OR
-
-                        -- It is a single optin list. Pick all non-unsubscribed subscribers.
-                        (campLists.optin != 'double' AND sl.status != 'unsubscribed')
-                    )
-                )
+                CASE
+                    WHEN $7 < 1 THEN TRUE
+                    WHEN $8 NOT IN ('testing', 'decided') THEN FALSE
+                    ELSE (MOD(('x' || SUBSTR(MD5($1::INT::TEXT || ':' || s.uuid::TEXT), 1, 8))::BIT(32)::BIGINT, 100) < $7) = ($8 = 'testing')
+                END
             )
         ORDER BY s.id LIMIT $6
     ) subIDs JOIN subscribers s ON (s.id = subIDs.id) ORDER BY s.id
 ),
 u AS (
     UPDATE campaigns
-    SET last_subscriber_id = (SELECT MAX(id) FROM subs), updated_at = NOW()
-    WHERE (SELECT COUNT(id) FROM subs) > 0 AND id=$1
+    SET last_subscriber_id = GREATEST(last_subscriber_id, COALESCE((SELECT MAX(id) FROM subs), $4)), updated_at = NOW()
+    WHERE id=$1 AND ((SELECT COUNT(id) FROM subs) > 0 OR $4 < max_subscriber_id)
+),
+queued AS (
+    -- Campaigns with scheduled delivery fetch subscribers to schedule them. Their messages
+    -- are marked as queued when they're due.
+    INSERT INTO campaign_deliveries (campaign_id, subscriber_id, status, messenger, instance_id)
+        SELECT $1, subs.id, 'queued', campaigns.messenger, $9 FROM subs
+        JOIN campaigns ON (campaigns.id = $1 AND campaigns.delivery_mode = '')
+    ON CONFLICT (campaign_id, subscriber_id) DO NOTHING
+    RETURNING subscriber_id
 )
-SELECT * FROM subs;
+SELECT subs.* FROM subs
+    WHERE subs.id IN (SELECT subscriber_id FROM queued)
+        OR (SELECT delivery_mode FROM campaigns WHERE id = $1) != ''
+    ORDER BY subs.id;
+
+-- name: count-campaign-audience
+-- raw: true
+-- Returns the number of subscribers across the lists and segments of a campaign ($1), and the
+-- highest subscriber ID among them. The injected condition matches subscribers in its segments.
+SELECT COUNT(*) AS to_send, COALESCE(MAX(subscribers.id), 0) AS max_subscriber_id FROM subscribers
+    WHERE subscribers.status != 'blocklisted' AND (
+        subscribers.id IN (
+            SELECT sl.subscriber_id FROM subscriber_lists sl
+            JOIN campaign_lists cl ON (cl.list_id = sl.list_id AND cl.campaign_id = $1)
+            JOIN lists ON (lists.id = sl.list_id)
+            WHERE (CASE WHEN lists.optin = 'double' THEN sl.status = 'confirmed' ELSE sl.status != 'unsubscribed' END)
+        )
+        OR (%query%)
+    );
+
+-- name: update-campaign-audience
+UPDATE campaigns SET to_send=$2, max_subscriber_id=$3 WHERE id = $1;
 
 -- name: delete-campaign-views
 DELETE FROM campaign_views WHERE created_at < $1;
@@ -412,6 +537,18 @@ WITH camp AS (
         archive_template_id=(CASE WHEN $7::content_type = 'visual' THEN NULL ELSE $17::INT END),
         archive_meta=$18,
         body_source=$20,
+        delivery_mode=$21,
+        delivery_window=$22,
+        delivery_time=$23,
+        delivery_timezone=$24,
+        -- Recompute the next occurrence if the recurrence or its start changes.
+        recurrence_next_at=(
+            CASE WHEN recurrence != $25 OR send_at IS DISTINCT FROM $8::TIMESTAMP WITH TIME ZONE THEN NULL
+            ELSE recurrence_next_at END
+        ),
+        recurrence=$25,
+        recurrence_condition=$26,
+        feed_url=$27,
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
@@ -419,6 +556,15 @@ clists AS (
     -- Reset list relationships
     DELETE FROM campaign_lists WHERE campaign_id = $1 AND NOT(list_id = ANY($14))
 ),
+csegments AS (
+    -- Reset segment relationships
+    DELETE FROM campaign_segments WHERE campaign_id = $1 AND NOT(segment_id = ANY($28::INT[]))
+),
+isegments AS (
+    INSERT INTO campaign_segments (campaign_id, segment_id, segment_name)
+        (SELECT $1 AS campaign_id, id, name FROM segments WHERE id=ANY($28::INT[]))
+        ON CONFLICT (campaign_id, segment_id) DO UPDATE SET segment_name = EXCLUDED.segment_name
+),
 med AS (
     DELETE FROM campaign_media WHERE campaign_id = $1
     AND ( media_id IS NULL or NOT(media_id = ANY($19))) RETURNING media_id
@@ -448,6 +594,9 @@ UPDATE campaigns SET
             ELSE $2::campaign_status
         END
     ),
+    -- A deferral only applies to the run it was made in. Pausing, cancelling, resuming
+    -- or finishing the campaign clears it.
+    delivery_next_at=(CASE WHEN status != $2::campaign_status THEN NULL ELSE delivery_next_at END),
     updated_at=NOW()
 WHERE id = $1;
 
@@ -475,6 +624,9 @@ WHERE (
 AND (
     $3 OR EXISTS (
         SELECT 1 FROM campaign_lists WHERE campaign_id = c.id AND list_id = ANY($4::INT[])
+    ) OR EXISTS (
+        SELECT 1 FROM campaign_segments cs JOIN segments ON (segments.id = cs.segment_id)
+        WHERE cs.campaign_id = c.id AND CARDINALITY(segments.list_ids) > 0 AND segments.list_ids <@ $4::INT[]
     )
 );
 
@@ -484,6 +636,341 @@ WITH view AS (
     LEFT JOIN subscribers ON (CASE WHEN $2::TEXT != '' THEN subscribers.uuid = $2::UUID ELSE FALSE END)
     WHERE campaigns.uuid = $1
 )
-INSERT INTO campaign_views (campaign_id, subscriber_id)
-    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view));
+INSERT INTO campaign_views (campaign_id, subscriber_id, variant_id)
+    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view),
+    (SELECT id FROM campaign_variants WHERE id = $3 AND campaign_id = (SELECT campaign_id FROM view)));
+
+
+-- name: get-campaign-variants
+SELECT v.*,
+    (SELECT COUNT(*) FROM campaign_views WHERE variant_id = v.id) AS views,
+    (SELECT COUNT(*) FROM link_clicks WHERE variant_id = v.id) AS clicks
+FROM campaign_variants v WHERE v.campaign_id = $1 ORDER BY v.id;
+
+-- name: update-campaign-variants
+-- Updates a campaign's A/B test options and replaces its variants with the
+-- JSON array in $5. Variants with an id are updated, ones without are inserted,
+-- and existing ones that are missing from the array are deleted.
+WITH camp AS (
+    UPDATE campaigns SET variant_sample=$2, variant_window=$3, variant_metric=$4, updated_at=NOW()
+    WHERE id = $1 RETURNING id
+),
+vars AS (
+    SELECT * FROM JSONB_TO_RECORDSET($5::JSONB) AS v(id INT, name TEXT, subject TEXT, body TEXT, altbody TEXT)
+),
+del AS (
+    DELETE FROM campaign_variants WHERE campaign_id = $1 AND id NOT IN (SELECT COALESCE(id, 0) FROM vars)
+),
+upd AS (
+    UPDATE campaign_variants cv SET name=vars.name, subject=vars.subject, body=COALESCE(vars.body, ''),
+        altbody=NULLIF(vars.altbody, ''), updated_at=NOW()
+    FROM vars WHERE cv.id = vars.id AND cv.campaign_id = $1
+)
+INSERT INTO campaign_variants (campaign_id, name, subject, body, altbody)
+    SELECT (SELECT id FROM camp), name, subject, COALESCE(body, ''), NULLIF(altbody, '') FROM vars
+    WHERE COALESCE(id, 0) = 0;
+
+-- name: update-campaign-variant-status
+-- On moving to 'waiting' (sample sent), the test end time is recorded and the
+-- subscriber cursor is rewound so that the remainder is processed from the start.
+UPDATE campaigns SET
+    variant_status=$2,
+    variant_test_ends_at=(CASE WHEN $2 = 'waiting' THEN $3::TIMESTAMP WITH TIME ZONE ELSE variant_test_ends_at END),
+    last_subscriber_id=(CASE WHEN $2 = 'waiting' THEN 0 ELSE last_subscriber_id END),
+    updated_at=NOW()
+WHERE id = $1;
+
+-- name: get-variant-test-campaigns
+-- Returns A/B tested campaigns whose sample has been sent and whose test window is over.
+SELECT id, name, variant_metric FROM campaigns
+    WHERE variant_status = 'waiting' AND variant_test_ends_at <= NOW();
+
+-- name: update-campaign-variant-winner
+-- Sets the winning variant ($2, NULL if there are no variants) of an A/B tested campaign
+-- so that the winner is sent to the rest of the campaign's subscribers.
+UPDATE campaigns SET variant_winner_id = $2, variant_status = 'decided', updated_at = NOW()
+    WHERE id = $1 AND variant_status = 'waiting';
+
+-- name: schedule-campaign-subscribers
+-- Schedules the given subscribers ($2) of a send-time optimized campaign at the hour of the day
+-- they have historically viewed or clicked campaigns the most. Each subscriber is scheduled at the
+-- next occurrence of that hour that falls within the delivery window ($3 seconds from now).
+-- Hours are local to the subscriber's timezone (the attribute $5), or the campaign's timezone ($4),
+-- so that the hour doesn't shift with daylight saving time.
+-- Subscribers with no history, or whose hour falls outside the window, are scheduled right away.
+WITH tznames AS MATERIALIZED (
+    SELECT name FROM pg_timezone_names
+),
+zones AS (
+    SELECT s.id AS subscriber_id, COALESCE(tz.name, $4) AS tz
+    FROM subscribers s
+    LEFT JOIN tznames tz ON (tz.name = s.attribs->>$5::TEXT)
+    WHERE s.id = ANY($2::INT[])
+),
+hist AS (
+    SELECT v.subscriber_id, EXTRACT(HOUR FROM v.created_at AT TIME ZONE zones.tz)::INT AS hour
+        FROM campaign_views v JOIN zones ON (zones.subscriber_id = v.subscriber_id)
+    UNION ALL
+    SELECT l.subscriber_id, EXTRACT(HOUR FROM l.created_at AT TIME ZONE zones.tz)::INT AS hour
+        FROM link_clicks l JOIN zones ON (zones.subscriber_id = l.subscriber_id)
+),
+hours AS (
+    -- The most frequent hour for every subscriber. Ties go to the earlier hour.
+    SELECT DISTINCT ON (subscriber_id) subscriber_id, hour FROM hist
+    GROUP BY subscriber_id, hour
+    ORDER BY subscriber_id, COUNT(*) DESC, hour
+),
+times AS (
+    SELECT zones.subscriber_id,
+        (DATE_TRUNC('hour', NOW() AT TIME ZONE zones.tz) +
+        MAKE_INTERVAL(hours => MOD(hours.hour - EXTRACT(HOUR FROM NOW() AT TIME ZONE zones.tz)::INT + 24, 24))) AT TIME ZONE zones.tz AS send_at
+    FROM zones
+    LEFT JOIN hours ON (hours.subscriber_id = zones.subscriber_id)
+)
+INSERT INTO campaign_schedules (campaign_id, subscriber_id, send_at)
+    SELECT $1, subscriber_id,
+        (CASE WHEN send_at IS NULL OR send_at > NOW() + MAKE_INTERVAL(secs => $3) THEN NOW() ELSE send_at END)
+    FROM times
+    ON CONFLICT (campaign_id, subscriber_id) DO NOTHING;
+
+-- name: schedule-campaign-subscribers-local-time
+-- Schedules the given subscribers ($2) of a local time campaign at the next occurrence of the
+-- delivery time ($3, HH:MM) in their timezone (the attribute $5). Subscribers without a timezone,
+-- or with an unknown one, fall back to the campaign's timezone ($4). Subscribers for whom the delivery
+-- hour is currently on are scheduled right away, and those for whom it has passed, the next day.
+WITH tznames AS MATERIALIZED (
+    -- pg_timezone_names is built on every scan. Build it once and join instead of scanning it per subscriber.
+    SELECT name FROM pg_timezone_names
+),
+zones AS (
+    SELECT s.id AS subscriber_id, COALESCE(tz.name, $4) AS tz
+    FROM subscribers s
+    LEFT JOIN tznames tz ON (tz.name = s.attribs->>$5::TEXT)
+    WHERE s.id = ANY($2::INT[])
+),
+times AS (
+    SELECT subscriber_id, ((DATE(NOW() AT TIME ZONE tz) + $3::TIME) AT TIME ZONE tz) AS send_at FROM zones
+)
+INSERT INTO campaign_schedules (campaign_id, subscriber_id, send_at)
+    SELECT $1, subscriber_id,
+        (CASE WHEN send_at + INTERVAL '1 hour' > NOW() THEN send_at ELSE send_at + INTERVAL '1 day' END)
+    FROM times
+    ON CONFLICT (campaign_id, subscriber_id) DO NOTHING;
+
+-- name: next-scheduled-campaign-subscribers
+-- Returns (and removes from the schedule) a batch of subscribers of a running campaign
+-- whose scheduled delivery time is due, marking them as queued by the instance ($3).
+WITH due AS (
+    DELETE FROM campaign_schedules WHERE campaign_id = $1 AND subscriber_id = ANY(
+        SELECT subscriber_id FROM campaign_schedules
+        WHERE campaign_id = $1 AND send_at <= NOW()
+        AND (SELECT status FROM campaigns WHERE id = $1) = 'running'
+        ORDER BY send_at LIMIT $2
+    )
+    RETURNING subscriber_id
+),
+excluded AS (
+    -- Subscribers who are temporarily suppressed, or inactive as per the sunset policy.
+    SELECT subscriber_id FROM subscriber_suppressions
+        WHERE subscriber_id IN (SELECT subscriber_id FROM due) AND suppressed_until > NOW()
+    UNION
+    SELECT subscriber_id FROM subscriber_engagement
+        WHERE subscriber_id IN (SELECT subscriber_id FROM due) AND inactive = true
+        AND (SELECT type FROM campaigns WHERE id = $1) != 'optin'
+),
+subs AS (
+    SELECT s.* FROM subscribers s JOIN due ON (due.subscriber_id = s.id)
+        LEFT JOIN excluded ON (excluded.subscriber_id = s.id)
+        WHERE s.status != 'blocklisted' AND excluded.subscriber_id IS NULL
+),
+queued AS (
+    -- Subscribers who already have an entry in the delivery log aren't sent the campaign again.
+    INSERT INTO campaign_deliveries (campaign_id, subscriber_id, status, messenger, instance_id)
+        SELECT $1, subs.id, 'queued', (SELECT messenger FROM campaigns WHERE id = $1), $3 FROM subs
+    ON CONFLICT (campaign_id, subscriber_id) DO NOTHING
+    RETURNING subscriber_id
+)
+SELECT subs.* FROM subs JOIN queued ON (queued.subscriber_id = subs.id) ORDER BY subs.id;
+
+-- name: get-campaign-next-delivery
+-- Returns the earliest pending scheduled delivery time of a campaign, if any.
+SELECT MIN(send_at) FROM campaign_schedules WHERE campaign_id = $1;
+
+-- name: update-campaign-delivery-next-at
+UPDATE campaigns SET delivery_next_at=$2, updated_at=NOW() WHERE id = $1;
+
+-- name: get-recurring-campaigns
+-- Get scheduled recurring campaigns whose next occurrence is due or hasn't been computed yet.
+SELECT * FROM campaigns WHERE status = 'scheduled' AND recurrence != ''
+    AND (recurrence_next_at IS NULL OR recurrence_next_at <= NOW());
+
+-- name: clone-recurring-campaign
+-- Clone a recurring campaign ($1) into a new campaign ($2 = uuid, $3 = name) scheduled at $4
+-- with the feed items ($6) along with its lists, segments, media, and A/B variants, and set the next
+-- occurrence ($5), the time of the latest feed item ($7), and the GUIDs of the items in the feed ($9).
+-- The campaign is only cloned if its occurrence is still the one that was picked up ($8) so that
+-- an occurrence is cloned only once, even if multiple instances pick it up.
+WITH u AS (
+    UPDATE campaigns SET recurrence_next_at=$5, feed_last_at=COALESCE($7, feed_last_at),
+        feed_guids=(CASE WHEN CARDINALITY($9::TEXT[]) > 0 THEN $9 ELSE feed_guids END)
+    WHERE id = $1 AND recurrence_next_at = $8
+    RETURNING id
+),
+camp AS (
+    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody, content_type, send_at, status,
+        headers, attribs, tags, messenger, template_id, archive, archive_slug, archive_template_id, archive_meta, body_source,
+        variant_sample, variant_window, variant_metric,
+        delivery_mode, delivery_window, delivery_time, delivery_timezone, recurrence_parent_id, feed)
+    SELECT $2, type, $3, subject, from_email, body, altbody, content_type, $4, 'scheduled',
+        headers, attribs, tags, messenger, template_id, archive,
+        (CASE WHEN archive_slug IS NOT NULL THEN archive_slug || '-' || TO_CHAR($4::TIMESTAMP WITH TIME ZONE, 'YYYY-MM-DD-HH24MI') END),
+        archive_template_id, archive_meta, body_source,
+        variant_sample, variant_window, variant_metric,
+        delivery_mode, delivery_window, delivery_time, delivery_timezone, id, $6
+    FROM campaigns WHERE id = (SELECT id FROM u)
+    RETURNING id
+),
+lists AS (
+    INSERT INTO campaign_lists (campaign_id, list_id, list_name)
+        SELECT camp.id, list_id, list_name FROM campaign_lists, camp WHERE campaign_id = $1 AND list_id IS NOT NULL
+),
+segments AS (
+    INSERT INTO campaign_segments (campaign_id, segment_id, segment_name)
+        SELECT camp.id, segment_id, segment_name FROM campaign_segments, camp WHERE campaign_id = $1 AND segment_id IS NOT NULL
+),
+med AS (
+    INSERT INTO campaign_media (campaign_id, media_id, filename)
+        SELECT camp.id, media_id, filename FROM campaign_media, camp WHERE campaign_id = $1
+),
+variants AS (
+    INSERT INTO campaign_variants (campaign_id, name, subject, body, altbody)
+        SELECT camp.id, name, subject, body, altbody FROM campaign_variants, camp WHERE campaign_id = $1 ORDER BY campaign_variants.id
+)
+SELECT id FROM camp;
+
+-- name: update-campaign-recurrence-next-at
+-- Moves a recurring campaign from its occurrence that was picked up ($3) to the next one ($2).
+-- If another instance has already moved it, it's left alone.
+UPDATE campaigns SET recurrence_next_at=$2 WHERE id = $1 AND recurrence_next_at IS NOT DISTINCT FROM $3;
+
+-- name: record-campaign-deliveries
+-- Upsert the latest delivery status of campaign messages to subscribers. Sent and failed
+-- pushes count as delivery attempts. Campaigns or subscribers deleted in the meantime are skipped.
+INSERT INTO campaign_deliveries (campaign_id, subscriber_id, status, messenger, error, attempts, instance_id, created_at, updated_at)
+    SELECT d.campaign_id, d.subscriber_id, d.status, d.messenger, d.error,
+        (CASE WHEN d.status IN ('sent', 'failed') THEN 1 ELSE 0 END), d.instance_id, d.at, d.at
+    FROM UNNEST($1::INT[], $2::INT[], $3::TEXT[], $4::TEXT[], $5::TEXT[], $6::TEXT[], $7::TIMESTAMP WITH TIME ZONE[])
+        AS d(campaign_id, subscriber_id, status, messenger, error, instance_id, at)
+    WHERE EXISTS (SELECT 1 FROM campaigns WHERE id = d.campaign_id)
+        AND EXISTS (SELECT 1 FROM subscribers WHERE id = d.subscriber_id)
+ON CONFLICT (campaign_id, subscriber_id) DO UPDATE
+    SET status=EXCLUDED.status, messenger=EXCLUDED.messenger, error=EXCLUDED.error,
+        attempts=campaign_deliveries.attempts + EXCLUDED.attempts, instance_id=EXCLUDED.instance_id,
+        updated_at=EXCLUDED.updated_at
+    -- Statuses are written asynchronously. A stale status shouldn't overwrite a newer one.
+    WHERE campaign_deliveries.status = 'queued' OR campaign_deliveries.updated_at <= EXCLUDED.updated_at;
+
+-- name: query-campaign-deliveries
+SELECT COUNT(*) OVER () AS total, campaign_deliveries.*, subscribers.email
+    FROM campaign_deliveries
+    JOIN subscribers ON (subscribers.id = campaign_deliveries.subscriber_id)
+    WHERE campaign_deliveries.campaign_id = $1 AND ($2 = '' OR campaign_deliveries.status = $2)
+    ORDER BY campaign_deliveries.updated_at DESC, campaign_deliveries.id DESC
+    OFFSET $3 LIMIT (CASE WHEN $4 < 1 THEN NULL ELSE $4 END);
+
+-- name: claim-campaign-deliveries
+-- Claims a batch ($3) of unacknowledged (queued or deferred) messages of a running campaign that
+-- were queued by instances that no longer hold a live lease on the campaign, for instance, after
+-- a crash or a pause, for the instance ($2), and returns their subscribers. Messages to subscribers
+-- who have since been blocklisted or have unsubscribed are marked as failed instead.
+WITH pending AS (
+    SELECT d.id, (
+        s.status != 'blocklisted' AND EXISTS (
+            SELECT 1 FROM subscriber_lists
+            WHERE subscriber_lists.subscriber_id = s.id AND subscriber_lists.status != 'unsubscribed'
+                AND (
+                    subscriber_lists.list_id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+                    -- Subscribers picked by segments should still be subscribed to the segment's lists, if any.
+                    OR EXISTS (
+                        SELECT 1 FROM campaign_segments cs JOIN segments ON (segments.id = cs.segment_id)
+                        WHERE cs.campaign_id = $1
+                            AND (CARDINALITY(segments.list_ids) = 0 OR subscriber_lists.list_id = ANY(segments.list_ids))
+                    )
+                )
+        )
+    ) AS ok
+    FROM campaign_deliveries d
+    JOIN subscribers s ON (s.id = d.subscriber_id)
+    WHERE d.campaign_id = $1 AND d.status IN ('queued', 'deferred')
+        AND (SELECT status FROM campaigns WHERE id = $1) = 'running'
+        AND NOT EXISTS (
+            SELECT 1 FROM campaign_leases l
+            WHERE l.campaign_id = $1 AND l.instance_id = d.instance_id AND l.expires_at > NOW()
+        )
+    ORDER BY d.subscriber_id LIMIT $3
+    FOR UPDATE OF d SKIP LOCKED
+),
+claimed AS (
+    UPDATE campaign_deliveries SET
+        instance_id = $2,
+        status = (CASE WHEN pending.ok THEN campaign_deliveries.status ELSE 'failed' END),
+        error = (CASE WHEN pending.ok THEN campaign_deliveries.error ELSE 'subscriber is no longer subscribed' END),
+        updated_at = NOW()
+    FROM pending WHERE campaign_deliveries.id = pending.id
+    RETURNING campaign_deliveries.subscriber_id, pending.ok
+)
+SELECT subscribers.* FROM subscribers
+    JOIN claimed ON (claimed.subscriber_id = subscribers.id AND claimed.ok)
+    ORDER BY subscribers.id;
+
+-- name: renew-campaign-lease
+-- Acquires or extends the lease of an instance ($2) on a campaign ($1) for $3 seconds
+-- and clears expired leases.
+WITH expired AS (
+    DELETE FROM campaign_leases WHERE expires_at <= NOW()
+)
+INSERT INTO campaign_leases (campaign_id, instance_id, expires_at)
+    SELECT id, $2, NOW() + MAKE_INTERVAL(secs => $3) FROM campaigns WHERE id = $1
+ON CONFLICT (campaign_id, instance_id) DO UPDATE SET expires_at = EXCLUDED.expires_at;
+
+-- name: release-campaign-lease
+DELETE FROM campaign_leases WHERE campaign_id = $1 AND instance_id = $2;
+
+-- name: campaign-has-other-instances
+-- Checks whether instances other than the given one ($2) are processing a campaign, that is,
+-- hold a live lease on it, or whether there are unacknowledged messages of other instances
+-- that are yet to be claimed.
+SELECT EXISTS (
+    SELECT 1 FROM campaign_leases
+    WHERE campaign_id = $1 AND instance_id != $2 AND expires_at > NOW()
+) OR EXISTS (
+    SELECT 1 FROM campaign_deliveries
+    WHERE campaign_id = $1 AND instance_id != $2 AND status IN ('queued', 'deferred')
+);
+
+-- name: get-campaign-delivery-subscribers
+-- Get the subscribers of a campaign with the given delivery status ($2) who are not
+-- blocklisted and haven't since unsubscribed from all of the campaign's lists (or its segments' lists).
+SELECT subscribers.* FROM campaign_deliveries
+    JOIN subscribers ON (subscribers.id = campaign_deliveries.subscriber_id)
+    WHERE campaign_deliveries.campaign_id = $1 AND campaign_deliveries.status = $2
+    AND subscribers.status != 'blocklisted'
+    AND EXISTS (
+        SELECT 1 FROM subscriber_lists
+        WHERE subscriber_lists.subscriber_id = subscribers.id AND subscriber_lists.status != 'unsubscribed'
+            AND (
+                subscriber_lists.list_id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+                OR EXISTS (
+                    SELECT 1 FROM campaign_segments cs JOIN segments ON (segments.id = cs.segment_id)
+                    WHERE cs.campaign_id = $1
+                        AND (CARDINALITY(segments.list_ids) = 0 OR subscriber_lists.list_id = ANY(segments.list_ids))
+                )
+            )
+    )
+    ORDER BY subscribers.id;
 
+-- name: delete-campaign-deliveries
+-- Deletes the delivery logs of finished and cancelled campaigns that haven't been updated
+-- since the given date. Logs of campaigns that may still send are retained.
+DELETE FROM campaign_deliveries d USING campaigns c
+    WHERE c.id = d.campaign_id AND c.status IN ('finished', 'cancelled') AND c.updated_at < $1;
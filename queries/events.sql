@@ -0,0 +1,21 @@
+-- events
+-- name: lock-events
+-- Serializes event inserts (in a transaction) so that they're committed in the order of their IDs.
+SELECT PG_ADVISORY_XACT_LOCK(HASHTEXT('listmonk_events'));
+
+-- name: insert-event
+-- Inserts an event and notifies its ID on the listmonk_events channel to all instances.
+WITH e AS (
+    INSERT INTO events (type, message, data) VALUES($1, $2, COALESCE($3::JSONB, 'null')) RETURNING id
+)
+SELECT PG_NOTIFY('listmonk_events', id::TEXT) FROM e;
+
+-- name: get-events
+-- Get events after the given ID ($1), optionally of a type ($2).
+SELECT * FROM events WHERE id > $1 AND ($2 = '' OR type = $2) ORDER BY id LIMIT $3;
+
+-- name: get-last-event-id
+SELECT COALESCE(MAX(id), 0) FROM events;
+
+-- name: delete-events
+DELETE FROM events WHERE created_at < $1;
@@ -9,10 +9,11 @@ SELECT url FROM links WHERE uuid = $1;
 WITH link AS(
     SELECT id, url FROM links WHERE uuid = $1
 )
-INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES(
+INSERT INTO link_clicks (campaign_id, subscriber_id, link_id, variant_id) VALUES(
     (SELECT id FROM campaigns WHERE uuid = $2),
     (SELECT id FROM subscribers WHERE
         (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
     ),
-    (SELECT id FROM link)
+    (SELECT id FROM link),
+    (SELECT id FROM campaign_variants WHERE id = $4 AND campaign_id = (SELECT id FROM campaigns WHERE uuid = $2))
 ) RETURNING (SELECT url FROM link);
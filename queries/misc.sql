@@ -18,3 +18,19 @@ UPDATE settings SET value = $2, updated_at = NOW() WHERE key = $1;
 -- name: get-db-info
 SELECT JSON_BUILD_OBJECT('version', (SELECT VERSION()),
                         'size_mb', (SELECT ROUND(pg_database_size((SELECT CURRENT_DATABASE()))/(1024^2)))) AS info;
+
+-- name: lock-smtp-volume
+-- Returns the number of messages reserved from the volume of an SMTP server ($1) on a day ($2),
+-- locking the row until the end of the transaction, and clears the counts of days before the previous one.
+WITH old AS (
+    DELETE FROM smtp_volumes WHERE day < $2::DATE - 1
+)
+INSERT INTO smtp_volumes (server, day) VALUES ($1, $2::DATE)
+    ON CONFLICT (server, day) DO UPDATE SET sent = smtp_volumes.sent
+    RETURNING sent;
+
+-- name: update-smtp-volume
+UPDATE smtp_volumes SET sent = sent + $3 WHERE server = $1 AND day = $2::DATE;
+
+-- name: get-smtp-volume
+SELECT COALESCE((SELECT sent FROM smtp_volumes WHERE server = $1 AND day = $2::DATE), 0);
@@ -0,0 +1,45 @@
+-- segments
+-- name: get-segments
+-- Get a segment ($1) or all segments. Users without permissions on all lists ($2) only get the
+-- segments that are restricted to their permitted lists ($3).
+SELECT * FROM segments
+    WHERE ($1 = 0 OR id = $1)
+    AND ($2 OR (CARDINALITY(list_ids) > 0 AND list_ids <@ $3::INT[]))
+    ORDER BY created_at;
+
+-- name: get-campaign-segments
+SELECT cs.campaign_id, segments.* FROM campaign_segments cs
+    JOIN segments ON (segments.id = cs.segment_id)
+    WHERE cs.campaign_id = ANY($1::INT[])
+    ORDER BY cs.campaign_id, segments.id;
+
+-- name: create-segment
+INSERT INTO segments (name, description, query, conditions, list_ids) VALUES($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: update-segment
+UPDATE segments SET name=$2, description=$3, query=$4, conditions=$5, list_ids=$6, updated_at=NOW() WHERE id = $1;
+
+-- name: update-segment-count
+UPDATE segments SET subscriber_count=$2, counted_at=NOW() WHERE id = $1;
+
+-- name: delete-segment
+DELETE FROM segments WHERE id = $1;
+
+-- name: segment-query-template
+-- raw: true
+-- Condition that matches subscribers in a segment, which is injected into other raw queries
+-- on the subscribers table. The segment's query expression is injected into %query% and its list
+-- IDs into %list_ids% as an array literal. Subscribers should have an active subscription to any
+-- of the lists, or to any list if there are none.
+(
+    (%query%) AND EXISTS (
+        SELECT 1 FROM subscriber_lists sl JOIN lists ON (lists.id = sl.list_id)
+        WHERE sl.subscriber_id = subscribers.id
+            AND (CARDINALITY('%list_ids%'::INT[]) = 0 OR sl.list_id = ANY('%list_ids%'::INT[]))
+            AND (CASE WHEN lists.optin = 'double' THEN sl.status = 'confirmed' ELSE sl.status != 'unsubscribed' END)
+    )
+)
+
+-- name: count-segment-subscribers
+-- raw: true
+SELECT COUNT(*) AS total FROM subscribers WHERE subscribers.status != 'blocklisted' AND %query%;
@@ -201,8 +201,9 @@ INSERT INTO subscriber_lists (subscriber_id, list_id, status)
     );
 
 -- name: delete-subscribers
--- Delete one or more subscribers by ID or UUID.
-DELETE FROM subscribers WHERE CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END;
+-- Delete one or more subscribers by ID or UUID and return them.
+DELETE FROM subscribers WHERE CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END
+    RETURNING *;
 
 -- name: delete-blocklisted-subscribers
 DELETE FROM subscribers WHERE status = 'blocklisted';
@@ -317,6 +318,11 @@ SELECT COALESCE(SUM(subscriber_count), 0) AS total FROM mat_list_subscriber_stat
     WHERE list_id = ANY(CASE WHEN CARDINALITY($1::INT[]) > 0 THEN $1 ELSE '{0}' END)
     AND ($2 = '' OR status = $2::subscription_status);
 
+-- name: match-subscriber
+-- raw: true
+-- Unprepared statement for checking whether a subscriber matches an arbitrary condition.
+SELECT EXISTS(SELECT 1 FROM subscribers WHERE subscribers.id = $1 AND %query%);
+
 -- name: query-subscribers-for-export
 -- raw: true
 -- Unprepared statement for issuring arbitrary WHERE conditions for
@@ -468,4 +474,79 @@ clicks AS (
 )
 SELECT
     COALESCE((SELECT JSON_AGG(v) FROM views v), '[]') as campaign_views,
-    COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks;
+    COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks,
+    (SELECT ROW_TO_JSON(e) FROM (
+        SELECT score, last_engaged_at, unengaged_campaigns, inactive, inactive_since, updated_at
+        FROM subscriber_engagement WHERE subscriber_id = $1
+    ) e) as engagement;
+
+-- name: update-subscriber-engagement
+-- Recomputes the engagement of all subscribers. The score (0 - 100) is the weighted sum of campaign
+-- views (1), link clicks (3), soft bounces (-2), and hard bounces and complaints (-10) in the last $1 days.
+-- Unengaged campaigns are the regular campaigns sent to the subscriber's lists since they last viewed or
+-- clicked (or subscribed). Subscribers are marked inactive after $2 unengaged campaigns. 0 disables it.
+-- Returns the number of inactive subscribers.
+WITH views AS (
+    SELECT subscriber_id, COUNT(*) FILTER (WHERE created_at > NOW() - MAKE_INTERVAL(days => $1::INT)) AS num,
+        MAX(created_at) AS last_at
+    FROM campaign_views WHERE subscriber_id IS NOT NULL GROUP BY subscriber_id
+),
+clicks AS (
+    SELECT subscriber_id, COUNT(*) FILTER (WHERE created_at > NOW() - MAKE_INTERVAL(days => $1::INT)) AS num,
+        MAX(created_at) AS last_at
+    FROM link_clicks WHERE subscriber_id IS NOT NULL GROUP BY subscriber_id
+),
+bnc AS (
+    SELECT subscriber_id, COUNT(*) FILTER (WHERE type = 'soft') AS soft, COUNT(*) FILTER (WHERE type != 'soft') AS hard
+    FROM bounces WHERE created_at > NOW() - MAKE_INTERVAL(days => $1::INT) GROUP BY subscriber_id
+),
+eng AS (
+    SELECT s.id AS subscriber_id, s.created_at,
+        GREATEST(0, LEAST(100, COALESCE(v.num, 0) + COALESCE(c.num, 0) * 3 - COALESCE(b.soft, 0) * 2 - COALESCE(b.hard, 0) * 10)) AS score,
+        -- GREATEST() ignores NULLs.
+        GREATEST(v.last_at, c.last_at) AS last_engaged_at
+    FROM subscribers s
+    LEFT JOIN views v ON (v.subscriber_id = s.id)
+    LEFT JOIN clicks c ON (c.subscriber_id = s.id)
+    LEFT JOIN bnc b ON (b.subscriber_id = s.id)
+),
+unengaged AS (
+    SELECT eng.subscriber_id, COUNT(DISTINCT camps.id) AS num FROM eng
+    JOIN subscriber_lists sl ON (sl.subscriber_id = eng.subscriber_id)
+    JOIN lists ON (lists.id = sl.list_id)
+    JOIN campaign_lists cl ON (cl.list_id = sl.list_id)
+    JOIN campaigns camps ON (camps.id = cl.campaign_id)
+    WHERE $2 > 0
+        AND camps.type = 'regular' AND camps.status IN ('running', 'finished')
+        AND camps.started_at > COALESCE(eng.last_engaged_at, eng.created_at)
+        AND camps.started_at > sl.created_at
+        AND (CASE WHEN lists.optin = 'double' THEN sl.status = 'confirmed' ELSE sl.status != 'unsubscribed' END)
+    GROUP BY eng.subscriber_id
+),
+upd AS (
+    INSERT INTO subscriber_engagement (subscriber_id, score, last_engaged_at, unengaged_campaigns, inactive, inactive_since, updated_at)
+        SELECT eng.subscriber_id, eng.score, eng.last_engaged_at, COALESCE(u.num, 0),
+            $2 > 0 AND COALESCE(u.num, 0) >= $2,
+            (CASE WHEN $2 > 0 AND COALESCE(u.num, 0) >= $2 THEN NOW() END),
+            NOW()
+        FROM eng LEFT JOIN unengaged u ON (u.subscriber_id = eng.subscriber_id)
+    ON CONFLICT (subscriber_id) DO UPDATE SET
+        score = EXCLUDED.score,
+        last_engaged_at = EXCLUDED.last_engaged_at,
+        unengaged_campaigns = EXCLUDED.unengaged_campaigns,
+        inactive = EXCLUDED.inactive,
+        inactive_since = (CASE WHEN NOT EXCLUDED.inactive THEN NULL
+            ELSE COALESCE(subscriber_engagement.inactive_since, EXCLUDED.inactive_since) END),
+        updated_at = NOW()
+    RETURNING inactive
+)
+SELECT COUNT(*) FILTER (WHERE inactive) FROM upd;
+
+-- name: reset-inactive-subscribers
+-- Clears the inactive status of all subscribers, for instance, when the sunset policy is turned off.
+UPDATE subscriber_engagement SET inactive=false, inactive_since=NULL WHERE inactive = true;
+
+-- name: unsubscribe-inactive-subscribers
+-- Unsubscribes inactive subscribers from all their lists.
+UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+    WHERE status != 'unsubscribed' AND subscriber_id IN (SELECT subscriber_id FROM subscriber_engagement WHERE inactive = true);
@@ -0,0 +1,45 @@
+-- suppressions
+-- name: query-suppressions
+-- If $2 is not empty, only the suppressions with those hashes are returned.
+SELECT COUNT(*) OVER () AS total, * FROM suppressions
+    WHERE ($1 = 0 OR id = $1)
+    AND (CARDINALITY($2::TEXT[]) = 0 OR hash = ANY($2::TEXT[]))
+    AND ($3 = '' OR type = $3)
+    AND ($4 = '' OR source = $4)
+    ORDER BY %order% OFFSET $5 LIMIT (CASE WHEN $6 < 1 THEN NULL ELSE $6 END);
+
+-- name: upsert-suppression
+INSERT INTO suppressions (type, hash, reason, source, expires_at)
+    VALUES($1, $2, $3, $4, $5)
+    ON CONFLICT (type, hash) DO UPDATE
+        SET reason=$3, source=$4, expires_at=$5, updated_at=NOW()
+    RETURNING id;
+
+-- name: import-suppressions
+-- Upserts suppressions from arrays of types, hashes, reasons, sources, and expiry dates ('' for none).
+INSERT INTO suppressions (type, hash, reason, source, expires_at)
+    SELECT DISTINCT ON (r.type, r.hash) r.type, r.hash, r.reason, r.source, NULLIF(r.expires_at, '')::TIMESTAMP WITH TIME ZONE
+    FROM UNNEST($1::TEXT[], $2::TEXT[], $3::TEXT[], $4::TEXT[], $5::TEXT[]) AS r(type, hash, reason, source, expires_at)
+    ON CONFLICT (type, hash) DO UPDATE
+        SET reason=EXCLUDED.reason, source=EXCLUDED.source, expires_at=EXCLUDED.expires_at, updated_at=NOW();
+
+-- name: update-suppression
+UPDATE suppressions SET reason=$2, source=$3, expires_at=$4, updated_at=NOW()
+    WHERE id = $1;
+
+-- name: delete-suppressions
+DELETE FROM suppressions WHERE $2 = TRUE OR id = ANY($1);
+
+-- name: is-suppressed
+-- Checks whether an e-mail ($1) or any of its domains ($2) have an active suppression.
+SELECT EXISTS (
+    SELECT 1 FROM suppressions
+    WHERE ((type = 'email' AND hash = $1) OR (type = 'domain' AND hash = ANY($2::TEXT[])))
+    AND (expires_at IS NULL OR expires_at > NOW())
+);
+
+-- name: get-suppressed-hashes
+-- Returns the hashes among the given e-mail ($1) and domain ($2) hashes that have an active suppression.
+SELECT hash FROM suppressions
+    WHERE ((type = 'email' AND hash = ANY($1::TEXT[])) OR (type = 'domain' AND hash = ANY($2::TEXT[])))
+    AND (expires_at IS NULL OR expires_at > NOW());
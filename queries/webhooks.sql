@@ -0,0 +1,77 @@
+-- webhooks
+-- name: get-webhooks
+SELECT w.id, w.name, w.url, w.status, w.events, w.max_retries, w.created_at, w.updated_at,
+    COALESCE(d.pending, 0) AS pending, COALESCE(d.failed, 0) AS failed
+    FROM webhooks w
+    LEFT JOIN (
+        SELECT webhook_id,
+            COUNT(*) FILTER (WHERE status = 'pending') AS pending,
+            COUNT(*) FILTER (WHERE status = 'failed') AS failed
+        FROM webhook_deliveries WHERE status != 'success' GROUP BY webhook_id
+    ) d ON (d.webhook_id = w.id)
+    WHERE ($1 = 0 OR w.id = $1)
+    ORDER BY w.created_at;
+
+-- name: create-webhook
+INSERT INTO webhooks (name, url, status, events, secret, max_retries) VALUES($1, $2, $3, $4, $5, $6) RETURNING id;
+
+-- name: update-webhook
+-- An empty secret ($6) retains the existing secret.
+UPDATE webhooks SET
+    name=$2,
+    url=$3,
+    status=$4,
+    events=$5,
+    secret=(CASE WHEN $6 = '' THEN secret ELSE $6 END),
+    max_retries=$7,
+    updated_at=NOW()
+WHERE id = $1;
+
+-- name: delete-webhook
+DELETE FROM webhooks WHERE id = $1;
+
+-- name: queue-webhook-event
+-- Queues the delivery of an event ($1) with the payload ($2) to all active webhooks that are subscribed to it.
+INSERT INTO webhook_deliveries (webhook_id, event, payload)
+    SELECT id, $1, $2::JSONB FROM webhooks WHERE status = 'active' AND $1 = ANY(events);
+
+-- name: has-webhooks
+-- Checks whether any active webhook is subscribed to an event ($1).
+SELECT EXISTS (SELECT 1 FROM webhooks WHERE status = 'active' AND $1 = ANY(events));
+
+-- name: next-webhook-deliveries
+-- Picks up a batch ($1) of due deliveries of active webhooks and pushes their next_at
+-- forward by $2 seconds so that they aren't picked up again (by this or other instances)
+-- while they're being sent. If an instance dies while sending, they are retried after that.
+WITH d AS (
+    SELECT wd.id FROM webhook_deliveries wd
+    JOIN webhooks w ON (w.id = wd.webhook_id AND w.status = 'active')
+    WHERE wd.status = 'pending' AND wd.next_at <= NOW()
+    ORDER BY wd.next_at LIMIT $1
+    FOR UPDATE OF wd SKIP LOCKED
+)
+UPDATE webhook_deliveries wd SET next_at = NOW() + MAKE_INTERVAL(secs => $2)
+    FROM d, webhooks w
+    WHERE wd.id = d.id AND w.id = wd.webhook_id
+    RETURNING wd.*, w.url, w.secret, w.max_retries;
+
+-- name: update-webhook-delivery
+-- Records an attempt of a delivery.
+UPDATE webhook_deliveries SET status=$2, attempts=attempts+1, response_code=$3, error=$4, next_at=$5, updated_at=NOW()
+    WHERE id = $1;
+
+-- name: query-webhook-deliveries
+SELECT COUNT(*) OVER () AS total, * FROM webhook_deliveries
+    WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+    ORDER BY created_at DESC, id DESC
+    OFFSET $3 LIMIT (CASE WHEN $4 < 1 THEN NULL ELSE $4 END);
+
+-- name: retry-webhook-deliveries
+-- Re-queues the given deliveries ($2) of a webhook, or all its failed deliveries if none are given.
+UPDATE webhook_deliveries SET status='pending', attempts=0, next_at=NOW(), updated_at=NOW()
+    WHERE webhook_id = $1
+    AND (CASE WHEN CARDINALITY($2::BIGINT[]) > 0 THEN id = ANY($2::BIGINT[]) ELSE status = 'failed' END);
+
+-- name: delete-webhook-deliveries
+-- Deletes delivered and failed deliveries older than the given date.
+DELETE FROM webhook_deliveries WHERE status != 'pending' AND updated_at < $1;
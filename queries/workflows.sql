@@ -0,0 +1,88 @@
+-- workflows
+-- name: get-workflows
+SELECT w.id, w.name, w.status, w.trigger, w.list_id, w.steps, w.created_at, w.updated_at,
+    COALESCE(s.active, 0) AS active, COALESCE(s.finished, 0) AS finished, COALESCE(s.failed, 0) AS failed
+    FROM workflows w
+    LEFT JOIN (
+        SELECT workflow_id,
+            COUNT(*) FILTER (WHERE status = 'active') AS active,
+            COUNT(*) FILTER (WHERE status = 'finished') AS finished,
+            COUNT(*) FILTER (WHERE status = 'failed') AS failed
+        FROM workflow_subscribers GROUP BY workflow_id
+    ) s ON (s.workflow_id = w.id)
+    WHERE ($1 = 0 OR w.id = $1)
+    ORDER BY w.created_at;
+
+-- name: create-workflow
+INSERT INTO workflows (name, status, trigger, list_id, steps) VALUES($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: update-workflow
+UPDATE workflows SET
+    name=$2,
+    status=$3,
+    trigger=$4,
+    list_id=$5,
+    steps=$6,
+    updated_at=NOW()
+WHERE id = $1;
+
+-- name: delete-workflow
+DELETE FROM workflows WHERE id = $1;
+
+-- name: get-trigger-workflows
+-- Get active workflows with the given trigger ($2) on the lists the subscriber ($1) is subscribed to.
+-- For the 'confirm' trigger, the subscription has to be confirmed. The lists can optionally
+-- be restricted to the given list IDs ($3) or UUIDs ($4).
+SELECT w.id, w.steps FROM workflows w
+    JOIN subscriber_lists sl ON (sl.list_id = w.list_id AND sl.subscriber_id = $1)
+    WHERE w.status = 'active' AND w.trigger = $2
+    AND (CASE WHEN $2 = 'confirm' THEN sl.status = 'confirmed' ELSE sl.status != 'unsubscribed' END)
+    AND (
+        (CARDINALITY($3::INT[]) = 0 AND CARDINALITY($4::UUID[]) = 0)
+        OR w.list_id = ANY($3::INT[])
+        OR w.list_id IN (SELECT id FROM lists WHERE uuid = ANY($4::UUID[]))
+    );
+
+-- name: enroll-workflow-subscriber
+-- A subscriber goes through a workflow only once.
+INSERT INTO workflow_subscribers (workflow_id, subscriber_id, next_at) VALUES($1, $2, $3)
+    ON CONFLICT (workflow_id, subscriber_id) DO NOTHING;
+
+-- name: cancel-workflow-subscribers
+-- Cancel due workflows of subscribers who have been blocklisted or have unsubscribed from the workflow's list.
+UPDATE workflow_subscribers ws SET status='cancelled', updated_at=NOW()
+    FROM workflows w, subscribers s
+    WHERE w.id = ws.workflow_id AND s.id = ws.subscriber_id
+    AND ws.status = 'active' AND ws.next_at <= NOW()
+    AND (
+        s.status = 'blocklisted'
+        OR NOT EXISTS (
+            SELECT 1 FROM subscriber_lists sl WHERE sl.subscriber_id = s.id
+            AND sl.list_id = w.list_id AND sl.status != 'unsubscribed'
+        )
+    );
+
+-- name: next-workflow-subscribers
+-- Claims a batch ($1) of subscribers whose next step in an active workflow is due and pushes
+-- their next_at forward by $2 seconds so that they aren't picked up again (by this or other
+-- instances) while the steps are being sent. If an instance dies while sending, they are retried after that.
+WITH due AS (
+    SELECT ws.workflow_id, ws.subscriber_id FROM workflow_subscribers ws
+    JOIN workflows w ON (w.id = ws.workflow_id AND w.status = 'active')
+    WHERE ws.status = 'active' AND ws.next_at <= NOW()
+    ORDER BY ws.next_at LIMIT $1
+    FOR UPDATE OF ws SKIP LOCKED
+),
+claimed AS (
+    UPDATE workflow_subscribers ws SET next_at = NOW() + MAKE_INTERVAL(secs => $2)
+    FROM due WHERE ws.workflow_id = due.workflow_id AND ws.subscriber_id = due.subscriber_id
+    RETURNING ws.workflow_id, ws.subscriber_id, ws.step, ws.attempts
+)
+SELECT s.*, c.workflow_id, c.step AS workflow_step, c.attempts AS workflow_attempts, w.steps AS workflow_steps
+    FROM claimed c
+    JOIN workflows w ON (w.id = c.workflow_id)
+    JOIN subscribers s ON (s.id = c.subscriber_id);
+
+-- name: update-workflow-subscriber
+UPDATE workflow_subscribers SET step=$3, status=$4, next_at=$5, attempts=$6, updated_at=NOW()
+    WHERE workflow_id = $1 AND subscriber_id = $2;
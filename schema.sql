@@ -73,6 +73,33 @@ DROP INDEX IF EXISTS idx_sub_lists_sub_id; CREATE INDEX idx_sub_lists_sub_id ON
 DROP INDEX IF EXISTS idx_sub_lists_list_id; CREATE INDEX idx_sub_lists_list_id ON subscriber_lists(list_id);
 DROP INDEX IF EXISTS idx_sub_lists_status; CREATE INDEX idx_sub_lists_status ON subscriber_lists(status);
 
+-- segments
+-- Saved subscriber queries that can be used as campaign audiences.
+DROP TABLE IF EXISTS segments CASCADE;
+CREATE TABLE segments (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    description      TEXT NOT NULL DEFAULT '',
+
+    -- Arbitrary SQL expression on the subscribers table.
+    query            TEXT NOT NULL DEFAULT '',
+
+    -- JSON condition tree that's compiled into an SQL expression.
+    conditions       JSONB NOT NULL DEFAULT '{}',
+
+    -- Subscribers should have an active subscription to any of these lists,
+    -- or any list if it's empty.
+    list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+
+    -- Cached count of subscribers in the segment.
+    subscriber_count INT NOT NULL DEFAULT 0,
+    counted_at       TIMESTAMP WITH TIME ZONE NULL,
+
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_segments_name; CREATE INDEX idx_segments_name ON segments(name);
+
 -- templates
 DROP TABLE IF EXISTS templates CASCADE;
 CREATE TABLE templates (
@@ -122,6 +149,40 @@ CREATE TABLE campaigns (
     max_subscriber_id  INT NOT NULL DEFAULT 0,
     last_subscriber_id INT NOT NULL DEFAULT 0,
 
+    -- A/B split testing. variant_sample is the % of the audience that receives
+    -- the variants. The rest get the winning variant after variant_window.
+    variant_sample       INT NOT NULL DEFAULT 0,
+    variant_window       TEXT NOT NULL DEFAULT '4h',
+    variant_metric       TEXT NOT NULL DEFAULT 'views',
+    variant_status       TEXT NOT NULL DEFAULT '',
+    variant_winner_id    INT NULL,
+    variant_test_ends_at TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Delivery scheduling. 'optimized' spreads delivery over delivery_window
+    -- based on subscribers' engagement history. 'local_time' delivers at delivery_time
+    -- in every subscriber's timezone (attribs.timezone), falling back to delivery_timezone.
+    delivery_mode        TEXT NOT NULL DEFAULT '',
+    delivery_window      TEXT NOT NULL DEFAULT '24h',
+    delivery_time        TEXT NOT NULL DEFAULT '09:00',
+    delivery_timezone    TEXT NOT NULL DEFAULT 'UTC',
+    delivery_next_at     TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Recurrence. A cron expression on which the campaign is cloned into a new
+    -- scheduled campaign. recurrence_condition is an optional template that has to render
+    -- to a non-empty, non-false value for an occurrence to be sent.
+    recurrence           TEXT NOT NULL DEFAULT '',
+    recurrence_condition TEXT NOT NULL DEFAULT '',
+    recurrence_next_at   TIMESTAMP WITH TIME ZONE NULL,
+    recurrence_parent_id INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- RSS-to-email. Recurring campaigns with a feed_url are only sent when the feed has items
+    -- whose GUIDs aren't in feed_guids (the items in the feed at the last send). feed_last_at is
+    -- the publish time of the latest item sent. The items are stored in the cloned campaign's feed.
+    feed_url             TEXT NOT NULL DEFAULT '',
+    feed_last_at         TIMESTAMP WITH TIME ZONE NULL,
+    feed_guids           TEXT[] NOT NULL DEFAULT '{}',
+    feed                 JSONB NOT NULL DEFAULT '{}',
+
     -- Publishing.
     archive             BOOLEAN NOT NULL DEFAULT false,
     archive_slug        TEXT NULL UNIQUE,
@@ -152,10 +213,95 @@ CREATE UNIQUE INDEX ON campaign_lists (campaign_id, list_id);
 DROP INDEX IF EXISTS idx_camp_lists_camp_id; CREATE INDEX idx_camp_lists_camp_id ON campaign_lists(campaign_id);
 DROP INDEX IF EXISTS idx_camp_lists_list_id; CREATE INDEX idx_camp_lists_list_id ON campaign_lists(list_id);
 
+DROP TABLE IF EXISTS campaign_segments CASCADE;
+CREATE TABLE campaign_segments (
+    id           BIGSERIAL PRIMARY KEY,
+    campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Like campaign_lists, a copy of the name of a segment that may be deleted is maintained here.
+    segment_id   INTEGER NULL REFERENCES segments(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    segment_name TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX ON campaign_segments (campaign_id, segment_id);
+DROP INDEX IF EXISTS idx_camp_segments_segment_id; CREATE INDEX idx_camp_segments_segment_id ON campaign_segments(segment_id);
+
+-- A/B test variants of a campaign's subject and body.
+DROP TABLE IF EXISTS campaign_variants CASCADE;
+CREATE TABLE campaign_variants (
+    id           SERIAL PRIMARY KEY,
+    campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name         TEXT NOT NULL,
+    subject      TEXT NOT NULL,
+
+    -- If the body is empty, the campaign's body is used.
+    body         TEXT NOT NULL DEFAULT '',
+    altbody      TEXT NULL,
+
+    created_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_camp_variants_camp_id; CREATE INDEX idx_camp_variants_camp_id ON campaign_variants(campaign_id);
+
+-- Per-subscriber delivery times of campaigns with scheduled delivery (eg: send-time optimization).
+-- Rows are removed as messages are sent out.
+DROP TABLE IF EXISTS campaign_schedules CASCADE;
+CREATE TABLE campaign_schedules (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    send_at          TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY (campaign_id, subscriber_id)
+);
+DROP INDEX IF EXISTS idx_camp_schedules_send_at; CREATE INDEX idx_camp_schedules_send_at ON campaign_schedules(campaign_id, send_at);
+
+-- Delivery log of campaign messages with the latest status of the message to each subscriber.
+DROP TABLE IF EXISTS campaign_deliveries CASCADE;
+CREATE TABLE campaign_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    status           TEXT NOT NULL DEFAULT 'queued',
+    messenger        TEXT NOT NULL DEFAULT '',
+    error            TEXT NOT NULL DEFAULT '',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+
+    -- The instance that queued the message. Unacknowledged messages of instances that no
+    -- longer hold a lease on the campaign are picked up by other instances.
+    instance_id      TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    UNIQUE (campaign_id, subscriber_id)
+);
+DROP INDEX IF EXISTS idx_camp_deliveries_status; CREATE INDEX idx_camp_deliveries_status ON campaign_deliveries(campaign_id, status);
+DROP INDEX IF EXISTS idx_camp_deliveries_subscriber_id; CREATE INDEX idx_camp_deliveries_subscriber_id ON campaign_deliveries(subscriber_id);
+
+-- Leases of the instances processing a running campaign. An instance renews its lease
+-- while it's processing the campaign. A lease that isn't renewed expires.
+DROP TABLE IF EXISTS campaign_leases CASCADE;
+CREATE TABLE campaign_leases (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    instance_id      TEXT NOT NULL,
+    expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY (campaign_id, instance_id)
+);
+
+-- Number of messages sent by SMTP servers with warm-up schedules every day.
+DROP TABLE IF EXISTS smtp_volumes CASCADE;
+CREATE TABLE smtp_volumes (
+    server           TEXT NOT NULL,
+    day              DATE NOT NULL,
+    sent             INT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (server, day)
+);
+
 DROP TABLE IF EXISTS campaign_views CASCADE;
 CREATE TABLE campaign_views (
     id               BIGSERIAL PRIMARY KEY,
     campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    variant_id       INTEGER NULL REFERENCES campaign_variants(id) ON DELETE SET NULL ON UPDATE CASCADE,
 
     -- Subscribers may be deleted, but the view counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
@@ -164,6 +310,7 @@ CREATE TABLE campaign_views (
 DROP INDEX IF EXISTS idx_views_camp_id; CREATE INDEX idx_views_camp_id ON campaign_views(campaign_id);
 DROP INDEX IF EXISTS idx_views_subscriber_id; CREATE INDEX idx_views_subscriber_id ON campaign_views(subscriber_id);
 DROP INDEX IF EXISTS idx_views_date; CREATE INDEX idx_views_date ON campaign_views(created_at);
+DROP INDEX IF EXISTS idx_views_variant_id; CREATE INDEX idx_views_variant_id ON campaign_views(variant_id);
 
 -- media
 DROP TABLE IF EXISTS media CASCADE;
@@ -208,6 +355,7 @@ CREATE TABLE link_clicks (
     id               BIGSERIAL PRIMARY KEY,
     campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
     link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    variant_id       INTEGER NULL REFERENCES campaign_variants(id) ON DELETE SET NULL ON UPDATE CASCADE,
 
     -- Subscribers may be deleted, but the link counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
@@ -217,6 +365,7 @@ DROP INDEX IF EXISTS idx_clicks_camp_id; CREATE INDEX idx_clicks_camp_id ON link
 DROP INDEX IF EXISTS idx_clicks_link_id; CREATE INDEX idx_clicks_link_id ON link_clicks(link_id);
 DROP INDEX IF EXISTS idx_clicks_sub_id; CREATE INDEX idx_clicks_sub_id ON link_clicks(subscriber_id);
 DROP INDEX IF EXISTS idx_clicks_date; CREATE INDEX idx_clicks_date ON link_clicks(created_at);
+DROP INDEX IF EXISTS idx_clicks_variant_id; CREATE INDEX idx_clicks_variant_id ON link_clicks(variant_id);
 
 -- settings
 DROP TABLE IF EXISTS settings CASCADE;
@@ -239,6 +388,7 @@ INSERT INTO settings (key, value) VALUES
     ('app.message_sliding_window', 'false'),
     ('app.message_sliding_window_duration', '"1h"'),
     ('app.message_sliding_window_rate', '10000'),
+    ('app.domain_throttles', '[]'),
     ('app.cache_slow_queries', 'false'),
     ('app.cache_slow_queries_interval', '"0 3 * * *"'),
     ('app.enable_public_archive', 'true'),
@@ -260,9 +410,11 @@ INSERT INTO settings (key, value) VALUES
     ('privacy.domain_blocklist', '[]'),
     ('privacy.domain_allowlist', '[]'),
     ('privacy.record_optin_ip', 'false'),
+    ('privacy.suppress_deleted', 'false'),
     ('security.captcha', '{"altcha": {"enabled": false, "complexity": 300000}, "hcaptcha": {"enabled": false, "key": "", "secret": ""}}'),
     ('security.oidc', '{"enabled": false, "provider_url": "", "provider_name": "", "client_id": "", "client_secret": "", "auto_create_users": false, "default_user_role_id": null, "default_list_role_id": null}'),
     ('security.trusted_urls', '[]'),
+    ('security.allow_private_feeds', 'false'),
     ('upload.provider', '"filesystem"'),
     ('upload.max_file_size', '5000'),
     ('upload.extensions', '["jpg","jpeg","png","gif","svg","*"]'),
@@ -279,12 +431,14 @@ INSERT INTO settings (key, value) VALUES
     ('upload.s3.bucket_type', '"public"'),
     ('upload.s3.expiry', '"167h"'),
     ('smtp',
-        '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"msg_retry_delay":"10ms","tls_type":"STARTTLS","tls_skip_verify":false,"email_headers":[], "from_addresses":[]},
-          {"enabled":false, "host":"smtp.gmail.com","port":465,"auth_protocol":"login","username":"username@gmail.com","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"msg_retry_delay":"10ms","tls_type":"TLS","tls_skip_verify":false,"email_headers":[], "from_addresses":[]}]'),
+        '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"msg_retry_delay":"10ms","tls_type":"STARTTLS","tls_skip_verify":false,"email_headers":[], "from_addresses":[], "weight":1, "warmup_schedule":[], "warmup_start":""},
+          {"enabled":false, "host":"smtp.gmail.com","port":465,"auth_protocol":"login","username":"username@gmail.com","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"msg_retry_delay":"10ms","tls_type":"TLS","tls_skip_verify":false,"email_headers":[], "from_addresses":[], "weight":1, "warmup_schedule":[], "warmup_start":""}]'),
+    ('dkim', '[]'),
     ('messengers', '[]'),
+    ('email_apis', '[]'),
     ('bounce.enabled', 'false'),
     ('bounce.webhooks_enabled', 'false'),
-    ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "complaint" : {"count": 1, "action": "blocklist"}}'),
+    ('bounce.actions', '{"soft": {"count": 2, "action": "none", "days": 0, "campaigns": 0, "suppress_days": 0}, "hard": {"count": 1, "action": "blocklist", "days": 0, "campaigns": 0, "suppress_days": 0}, "complaint" : {"count": 1, "action": "blocklist", "days": 0, "campaigns": 0, "suppress_days": 0}}'),
     ('bounce.ses_enabled', 'false'),
     ('bounce.azure', '{"enabled": false, "shared_secret": "", "shared_secret_header": ""}'),
     ('bounce.sendgrid_enabled', 'false'),
@@ -292,13 +446,15 @@ INSERT INTO settings (key, value) VALUES
     ('bounce.postmark', '{"enabled": false, "username": "", "password": ""}'),
     ('bounce.forwardemail', '{"enabled": false, "key": ""}'),
     ('bounce.lettermint', '{"enabled": false, "key": ""}'),
+    ('bounce.custom_webhooks', '[]'),
     ('bounce.mailboxes',
         '[{"enabled":false, "type": "pop", "host":"pop.yoursite.com","port":995,"auth_protocol":"userpass","username":"username","password":"password","return_path": "bounce@listmonk.yoursite.com","scan_interval":"15m","tls_enabled":true,"tls_skip_verify":false}]'),
     ('appearance.admin.custom_css', '""'),
     ('appearance.admin.custom_js', '""'),
     ('appearance.public.custom_css', '""'),
     ('appearance.public.custom_js', '""'),
-    ('maintenance.db', '{"vacuum": false, "vacuum_cron_interval": "0 2 * * *"}');
+    ('maintenance.db', '{"vacuum": false, "vacuum_cron_interval": "0 2 * * *", "delivery_retention_days": 90}'),
+    ('maintenance.engagement', '{"enabled": false, "cron_interval": "0 4 * * *", "window_days": 180, "sunset_enabled": false, "sunset_campaigns": 10, "sunset_action": "exclude"}');
 
 -- bounces
 DROP TABLE IF EXISTS bounces CASCADE;
@@ -316,6 +472,134 @@ DROP INDEX IF EXISTS idx_bounces_camp_id; CREATE INDEX idx_bounces_camp_id ON bo
 DROP INDEX IF EXISTS idx_bounces_source; CREATE INDEX idx_bounces_source ON bounces(source);
 DROP INDEX IF EXISTS idx_bounces_date; CREATE INDEX idx_bounces_date ON bounces(created_at);
 
+-- e-mails and domains that can't be subscribed or sent to
+DROP TABLE IF EXISTS suppressions CASCADE;
+CREATE TABLE suppressions (
+    id               SERIAL PRIMARY KEY,
+
+    -- SHA-256 hash of the lowercased e-mail or domain.
+    type             TEXT NOT NULL CHECK (type IN ('email', 'domain')),
+    hash             TEXT NOT NULL,
+    reason           TEXT NOT NULL DEFAULT '',
+    source           TEXT NOT NULL DEFAULT '',
+    expires_at       TIMESTAMP WITH TIME ZONE NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    UNIQUE (type, hash)
+);
+
+-- subscribers temporarily excluded from campaigns by bounce rules
+DROP TABLE IF EXISTS subscriber_suppressions CASCADE;
+CREATE TABLE subscriber_suppressions (
+    subscriber_id    INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    suppressed_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- subscriber engagement periodically computed from views, clicks, and bounces
+DROP TABLE IF EXISTS subscriber_engagement CASCADE;
+CREATE TABLE subscriber_engagement (
+    subscriber_id       INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    score               INT NOT NULL DEFAULT 0,
+    last_engaged_at     TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Number of campaigns sent to the subscriber's lists since they last engaged.
+    unengaged_campaigns INT NOT NULL DEFAULT 0,
+
+    -- Inactive subscribers are excluded from campaigns as per the sunset policy.
+    inactive            BOOLEAN NOT NULL DEFAULT false,
+    inactive_since      TIMESTAMP WITH TIME ZONE NULL,
+    updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sub_engagement_inactive; CREATE INDEX idx_sub_engagement_inactive ON subscriber_engagement(subscriber_id) WHERE inactive = true;
+
+-- workflows
+DROP TABLE IF EXISTS workflows CASCADE;
+CREATE TABLE workflows (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    status           TEXT NOT NULL DEFAULT 'active',
+
+    -- Subscription event on list_id that starts the workflow for a subscriber: subscribe | confirm
+    trigger          TEXT NOT NULL DEFAULT 'subscribe',
+    list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    steps            JSONB NOT NULL DEFAULT '[]',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_workflows_list_id; CREATE INDEX idx_workflows_list_id ON workflows(list_id);
+
+-- Progress of subscribers in workflows.
+DROP TABLE IF EXISTS workflow_subscribers CASCADE;
+CREATE TABLE workflow_subscribers (
+    workflow_id      INTEGER NOT NULL REFERENCES workflows(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Index of the next step to run and when: active | finished | cancelled | failed
+    step             INT NOT NULL DEFAULT 0,
+    status           TEXT NOT NULL DEFAULT 'active',
+    next_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    -- Number of failed attempts at the current step.
+    attempts         INT NOT NULL DEFAULT 0,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    PRIMARY KEY (workflow_id, subscriber_id)
+);
+DROP INDEX IF EXISTS idx_workflow_subs_next_at; CREATE INDEX idx_workflow_subs_next_at ON workflow_subscribers(next_at) WHERE status = 'active';
+
+-- outbound webhooks
+DROP TABLE IF EXISTS webhooks CASCADE;
+CREATE TABLE webhooks (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    url              TEXT NOT NULL,
+    status           TEXT NOT NULL DEFAULT 'active',
+
+    -- Lifecycle events (eg: subscriber.created) that are posted to the webhook.
+    events           TEXT[] NOT NULL DEFAULT '{}',
+
+    -- Key with which payloads are signed (HMAC-SHA256).
+    secret           TEXT NOT NULL DEFAULT '',
+    max_retries      INT NOT NULL DEFAULT 5,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Queue and log of event deliveries to webhooks.
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+CREATE TABLE webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    webhook_id       INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    event            TEXT NOT NULL,
+    payload          JSONB NOT NULL DEFAULT '{}',
+
+    -- pending | success | failed. Pending deliveries are (re)tried at next_at.
+    status           TEXT NOT NULL DEFAULT 'pending',
+    attempts         INT NOT NULL DEFAULT 0,
+    response_code    INT NULL,
+    error            TEXT NOT NULL DEFAULT '',
+    next_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_webhook_deliveries_webhook_id; CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, status);
+DROP INDEX IF EXISTS idx_webhook_deliveries_next_at; CREATE INDEX idx_webhook_deliveries_next_at ON webhook_deliveries(next_at) WHERE status = 'pending';
+
+-- Outbox of events (eg: error logs) that are streamed to clients.
+DROP TABLE IF EXISTS events CASCADE;
+CREATE TABLE events (
+    id               BIGSERIAL PRIMARY KEY,
+    type             TEXT NOT NULL,
+    message          TEXT NOT NULL DEFAULT '',
+    data             JSONB NOT NULL DEFAULT 'null',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_events_type; CREATE INDEX idx_events_type ON events(type, id);
+DROP INDEX IF EXISTS idx_events_created_at; CREATE INDEX idx_events_created_at ON events(created_at);
+
 -- roles
 DROP TABLE IF EXISTS roles CASCADE;
 CREATE TABLE roles (